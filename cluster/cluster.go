@@ -8,10 +8,12 @@
 package cluster
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -44,10 +46,58 @@ var (
 type node struct {
 	*docker.Client
 	addr string
+	ctx  context.Context
 }
 
 func (n *node) setPersistentClient() {
 	n.HTTPClient = clientWithTimeout(defaultDialTimeout, 0, n.TLSConfig)
+	n.applyContext()
+}
+
+// applyContext makes the requests sent to the node be canceled along with
+// the node context.
+func (n *node) applyContext() {
+	if n.ctx == nil || n.ctx.Done() == nil {
+		return
+	}
+	n.HTTPClient.Transport = &contextTransport{ctx: n.ctx, base: n.HTTPClient.Transport}
+}
+
+// contextTransport cancels the requests when ctx is done, in addition to the
+// context of the request itself, which the docker client and the HTTP client
+// timeout set.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	go func() {
+		select {
+		case <-t.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 // ContainerStorage provides methods to store and retrieve information about
@@ -56,47 +106,77 @@ func (n *node) setPersistentClient() {
 //
 // The relevant information is: in which host the given container is running?
 type ContainerStorage interface {
-	StoreContainer(container, host string) error
-	RetrieveContainer(container string) (host string, err error)
-	RemoveContainer(container string) error
-	RetrieveContainers() ([]Container, error)
+	StoreContainer(ctx context.Context, container, host string) error
+	RetrieveContainer(ctx context.Context, container string) (host string, err error)
+	RemoveContainer(ctx context.Context, container string) error
+	RetrieveContainers(ctx context.Context) ([]Container, error)
 }
 
 // ExecStorage works like ContainerStorage, but stores information about
 // execID and containerID.
 type ExecStorage interface {
-	StoreExec(execID, containerID string) error
-	RetrieveExec(execID string) (host string, err error)
+	StoreExec(ctx context.Context, execID, containerID string) error
+	RetrieveExec(ctx context.Context, execID string) (host string, err error)
+	RetrieveExecs(ctx context.Context) ([]Exec, error)
 }
 
 // ImageStorage works like ContainerStorage, but stores information about
 // images and hosts.
+//
+// The History of the retrieved images must list the entries in the order
+// they were first stored, as it tells the newest image IDs apart. Storing an
+// entry again doesn't move it.
 type ImageStorage interface {
-	StoreImage(repo, id, host string) error
-	RetrieveImage(repo string) (Image, error)
-	RemoveImage(repo, id, host string) error
-	RetrieveImages() ([]Image, error)
-	SetImageDigest(repo, digest string) error
+	StoreImage(ctx context.Context, repo, id, host string) error
+	RetrieveImage(ctx context.Context, repo string) (Image, error)
+	RemoveImage(ctx context.Context, repo, id, host string) error
+	RetrieveImages(ctx context.Context) ([]Image, error)
+	SetImageDigest(ctx context.Context, repo, digest string) error
 }
 
 type NodeStorage interface {
-	StoreNode(node Node) error
-	RetrieveNodesByMetadata(metadata map[string]string) ([]Node, error)
-	RetrieveNodes() ([]Node, error)
-	RetrieveNode(address string) (Node, error)
-	UpdateNode(node Node) error
-	RemoveNode(address string) error
-	RemoveNodes(addresses []string) error
-	LockNodeForHealing(address string, isFailure bool, timeout time.Duration) (bool, error)
-	ExtendNodeLock(address string, timeout time.Duration) error
-	UnlockNode(address string) error
-}
-
+	StoreNode(ctx context.Context, node Node) error
+	RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]Node, error)
+	RetrieveNodes(ctx context.Context) ([]Node, error)
+	RetrieveNode(ctx context.Context, address string) (Node, error)
+	UpdateNode(ctx context.Context, node Node) error
+	RemoveNode(ctx context.Context, address string) error
+	RemoveNodes(ctx context.Context, addresses []string) error
+	LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error)
+	ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error
+	UnlockNode(ctx context.Context, address string) error
+}
+
+// NetworkStorage stores the definition of the networks created in the
+// cluster and the nodes where each of them exists, with the ID of the network
+// in the node.
+type NetworkStorage interface {
+	StoreNetwork(ctx context.Context, network Network) error
+	RetrieveNetwork(ctx context.Context, name string) (Network, error)
+	RetrieveNetworks(ctx context.Context) ([]Network, error)
+	RemoveNetwork(ctx context.Context, name string) error
+	AddNetworkNode(ctx context.Context, name, id, host string) error
+	RemoveNetworkNode(ctx context.Context, name, host string) error
+}
+
+// VolumeStorage stores the named volumes created in the cluster and the node
+// that holds each of them.
+type VolumeStorage interface {
+	StoreVolume(ctx context.Context, volume Volume) error
+	RetrieveVolume(ctx context.Context, name string) (Volume, error)
+	RetrieveVolumes(ctx context.Context) ([]Volume, error)
+	RemoveVolume(ctx context.Context, name string) error
+}
+
+// Storage is the storage used by the cluster. Every method takes a context,
+// which cancels the call in backends that support it.
 type Storage interface {
 	ContainerStorage
 	ImageStorage
 	NodeStorage
 	ExecStorage
+	NetworkStorage
+	VolumeStorage
 }
 
 type HookEvent int
@@ -105,23 +185,97 @@ const (
 	HookEventBeforeContainerCreate = iota
 	HookEventBeforeNodeRegister
 	HookEventBeforeNodeUnregister
+	HookEventAfterContainerCreate
+	HookEventAfterContainerRemove
+	HookEventAfterImagePull
+	HookEventAfterNodeHealed
+	HookEventNodeDisabled
 )
 
 type Hook interface {
 	RunClusterHook(evt HookEvent, node *Node) error
 }
 
+// HookPayload holds the data of the operation that triggered a hook. Node is
+// always set, the other fields only in the events they're related to.
+type HookPayload struct {
+	Node *Node
+
+	// CreateOptions are the options of the container being created, in
+	// HookEventBeforeContainerCreate and HookEventAfterContainerCreate.
+	// Hooks must not change them.
+	CreateOptions *docker.CreateContainerOptions
+
+	// ContainerID is the ID of the container, in
+	// HookEventAfterContainerCreate and HookEventAfterContainerRemove.
+	ContainerID string
+
+	// Image is the pulled image, in HookEventAfterImagePull.
+	Image string
+}
+
+// PayloadHook is a Hook that receives the payload of the event. For hooks
+// implementing it, RunClusterHookWithPayload is called instead of
+// RunClusterHook.
+type PayloadHook interface {
+	Hook
+	RunClusterHookWithPayload(evt HookEvent, payload *HookPayload) error
+}
+
+// HookFunc is a PayloadHook implemented by a function.
+type HookFunc func(evt HookEvent, payload *HookPayload) error
+
+func (f HookFunc) RunClusterHook(evt HookEvent, node *Node) error {
+	return f(evt, &HookPayload{Node: node})
+}
+
+func (f HookFunc) RunClusterHookWithPayload(evt HookEvent, payload *HookPayload) error {
+	return f(evt, payload)
+}
+
+// HookFailurePolicy defines what happens to the operation that triggered a
+// hook when the hook fails.
+type HookFailurePolicy int
+
+const (
+	// HookFailureAbort makes the operation fail with the error of the hook.
+	// After a HookEventAfterContainerCreate failure, the new container is
+	// removed. Other after events only return the error, as the operation
+	// has already finished. It's the policy of hooks added with AddHook.
+	HookFailureAbort HookFailurePolicy = iota
+
+	// HookFailureLog only logs the error of the hook, and the operation
+	// goes on.
+	HookFailureLog
+)
+
+type registeredHook struct {
+	hook   Hook
+	policy HookFailurePolicy
+}
+
 // Cluster is the basic type of the package. It manages internal nodes, and
 // provide methods for interaction with those nodes, like CreateContainer,
 // which creates a container in one node of the cluster.
 type Cluster struct {
-	Healer         Healer
+	Healer  Healer
+	Metrics Metrics
+
+	// PinImageDigests makes container creation use the image digest
+	// stored by the last pull of the image, instead of its tag, so every
+	// node runs the same image even if the tag is moved in the registry.
+	PinImageDigests bool
+
 	scheduler      Scheduler
 	stor           Storage
 	monitoringDone chan bool
+	reconcileDone  chan bool
+	imageGCDone    chan bool
 	dryServer      *testing.DockerServer
-	hooks          map[HookEvent][]Hook
+	hooks          map[HookEvent][]registeredHook
 	tlsConfig      *tls.Config
+	reservations   reservationCache
+	events         eventsMonitor
 }
 
 type DockerNodeError struct {
@@ -148,6 +302,15 @@ func wrapError(n node, err error) error {
 	return nil
 }
 
+// optsContext returns the context given in the options of an operation,
+// defaulting to the background context.
+func optsContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
 func wrapErrorWithCmd(n node, err error, cmd string) error {
 	if err != nil {
 		return DockerNodeError{node: n, err: err, cmd: cmd}
@@ -178,6 +341,7 @@ func New(scheduler Scheduler, storage Storage, caPath string, nodes ...Node) (*C
 		c.tlsConfig = tlsConfig
 	}
 	c.Healer = DefaultHealer{}
+	c.Metrics = NoopMetrics{}
 	if scheduler == nil {
 		c.scheduler = &roundRobin{lastUsed: -1}
 	}
@@ -221,34 +385,54 @@ func readTLSConfig(caPath string) (*tls.Config, error) {
 
 // Register adds new nodes to the cluster.
 func (c *Cluster) Register(node Node) error {
+	return c.RegisterWithContext(context.Background(), node)
+}
+
+// RegisterWithContext is like Register, but using ctx to cancel the operation.
+func (c *Cluster) RegisterWithContext(ctx context.Context, node Node) error {
 	if node.Address == "" {
 		return errors.New("Invalid address")
 	}
 	node.defTLSConfig = c.tlsConfig
-	err := c.runHooks(HookEventBeforeNodeRegister, &node)
+	err := c.runHooks(HookEventBeforeNodeRegister, &HookPayload{Node: &node})
 	if err != nil {
 		return err
 	}
-	return c.storage().StoreNode(node)
+	err = c.storage().StoreNode(ctx, node)
+	if err != nil {
+		return err
+	}
+	c.nodeRegistered(node.Address)
+	return nil
 }
 
 func (c *Cluster) UpdateNode(node Node) (Node, error) {
-	return c.AtomicUpdateNode(node.Address, func(_ Node) (Node, error) {
+	return c.UpdateNodeWithContext(context.Background(), node)
+}
+
+// UpdateNodeWithContext is like UpdateNode, but using ctx to cancel the operation.
+func (c *Cluster) UpdateNodeWithContext(ctx context.Context, node Node) (Node, error) {
+	return c.AtomicUpdateNodeWithContext(ctx, node.Address, func(_ Node) (Node, error) {
 		return node, nil
 	})
 }
 
 func (c *Cluster) AtomicUpdateNode(address string, updateFunc func(Node) (Node, error)) (Node, error) {
-	_, err := c.storage().RetrieveNode(address)
+	return c.AtomicUpdateNodeWithContext(context.Background(), address, updateFunc)
+}
+
+// AtomicUpdateNodeWithContext is like AtomicUpdateNode, but using ctx to cancel the operation.
+func (c *Cluster) AtomicUpdateNodeWithContext(ctx context.Context, address string, updateFunc func(Node) (Node, error)) (Node, error) {
+	_, err := c.storage().RetrieveNode(ctx, address)
 	if err != nil {
 		return Node{}, err
 	}
-	unlock, err := c.lockWithTimeout(address, false)
+	unlock, err := c.lockWithTimeout(ctx, address, false)
 	if err != nil {
 		return Node{}, err
 	}
 	defer unlock()
-	dbNode, err := c.storage().RetrieveNode(address)
+	dbNode, err := c.storage().RetrieveNode(ctx, address)
 	if err != nil {
 		return Node{}, err
 	}
@@ -259,6 +443,9 @@ func (c *Cluster) AtomicUpdateNode(address string, updateFunc func(Node) (Node,
 	if node.CreationStatus != "" && node.CreationStatus != dbNode.CreationStatus {
 		dbNode.CreationStatus = node.CreationStatus
 	}
+	if dbNode.Metadata == nil {
+		dbNode.Metadata = make(map[string]string)
+	}
 	for k, v := range node.Metadata {
 		if v == "" {
 			delete(dbNode.Metadata, k)
@@ -267,30 +454,57 @@ func (c *Cluster) AtomicUpdateNode(address string, updateFunc func(Node) (Node,
 		}
 	}
 	dbNode.defTLSConfig = c.tlsConfig
-	return dbNode, c.storage().UpdateNode(dbNode)
+	return dbNode, c.storage().UpdateNode(ctx, dbNode)
 }
 
 // Unregister removes nodes from the cluster.
 func (c *Cluster) Unregister(address string) error {
-	err := c.runHookForAddr(HookEventBeforeNodeUnregister, address)
+	return c.UnregisterWithContext(context.Background(), address)
+}
+
+// UnregisterWithContext is like Unregister, but using ctx to cancel the operation.
+func (c *Cluster) UnregisterWithContext(ctx context.Context, address string) error {
+	err := c.runHookForAddr(ctx, HookEventBeforeNodeUnregister, address, HookPayload{})
 	if err != nil {
 		return err
 	}
-	return c.storage().RemoveNode(address)
+	err = c.storage().RemoveNode(ctx, address)
+	if err != nil {
+		return err
+	}
+	c.nodeUnregistered(address)
+	return nil
 }
 
 func (c *Cluster) UnregisterNodes(addresses ...string) error {
+	return c.UnregisterNodesWithContext(context.Background(), addresses...)
+}
+
+// UnregisterNodesWithContext is like UnregisterNodes, but using ctx to cancel the operation.
+func (c *Cluster) UnregisterNodesWithContext(ctx context.Context, addresses ...string) error {
 	for _, address := range addresses {
-		err := c.runHookForAddr(HookEventBeforeNodeUnregister, address)
+		err := c.runHookForAddr(ctx, HookEventBeforeNodeUnregister, address, HookPayload{})
 		if err != nil {
 			return err
 		}
 	}
-	return c.storage().RemoveNodes(addresses)
+	err := c.storage().RemoveNodes(ctx, addresses)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		c.nodeUnregistered(address)
+	}
+	return nil
 }
 
 func (c *Cluster) UnfilteredNodes() ([]Node, error) {
-	nodes, err := c.storage().RetrieveNodes()
+	return c.UnfilteredNodesWithContext(context.Background())
+}
+
+// UnfilteredNodesWithContext is like UnfilteredNodes, but using ctx to cancel the operation.
+func (c *Cluster) UnfilteredNodesWithContext(ctx context.Context) ([]Node, error) {
+	nodes, err := c.storage().RetrieveNodes(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -298,15 +512,40 @@ func (c *Cluster) UnfilteredNodes() ([]Node, error) {
 }
 
 func (c *Cluster) Nodes() ([]Node, error) {
-	nodes, err := c.storage().RetrieveNodes()
+	return c.NodesWithContext(context.Background())
+}
+
+// NodesWithContext is like Nodes, but using ctx to cancel the operation.
+func (c *Cluster) NodesWithContext(ctx context.Context) ([]Node, error) {
+	nodes, err := c.storage().RetrieveNodes(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return NodeList(c.setTLSConfigInNodes(nodes)).filterDisabled(), nil
 }
 
+// SchedulableNodes returns the enabled nodes that are not cordoned, where new
+// containers may be placed.
+func (c *Cluster) SchedulableNodes() ([]Node, error) {
+	return c.SchedulableNodesWithContext(context.Background())
+}
+
+// SchedulableNodesWithContext is like SchedulableNodes, but using ctx to cancel the operation.
+func (c *Cluster) SchedulableNodesWithContext(ctx context.Context) ([]Node, error) {
+	nodes, err := c.NodesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NodeList(nodes).filterCordoned(), nil
+}
+
 func (c *Cluster) NodesForMetadata(metadata map[string]string) ([]Node, error) {
-	nodes, err := c.storage().RetrieveNodesByMetadata(metadata)
+	return c.NodesForMetadataWithContext(context.Background(), metadata)
+}
+
+// NodesForMetadataWithContext is like NodesForMetadata, but using ctx to cancel the operation.
+func (c *Cluster) NodesForMetadataWithContext(ctx context.Context, metadata map[string]string) ([]Node, error) {
+	nodes, err := c.storage().RetrieveNodesByMetadata(ctx, metadata)
 	if err != nil {
 		return nil, err
 	}
@@ -314,7 +553,12 @@ func (c *Cluster) NodesForMetadata(metadata map[string]string) ([]Node, error) {
 }
 
 func (c *Cluster) GetNode(address string) (Node, error) {
-	n, err := c.storage().RetrieveNode(address)
+	return c.GetNodeWithContext(context.Background(), address)
+}
+
+// GetNodeWithContext is like GetNode, but using ctx to cancel the operation.
+func (c *Cluster) GetNodeWithContext(ctx context.Context, address string) (Node, error) {
+	n, err := c.storage().RetrieveNode(ctx, address)
 	if err != nil {
 		return Node{}, err
 	}
@@ -330,13 +574,56 @@ func (c *Cluster) setTLSConfigInNodes(nodes []Node) []Node {
 }
 
 func (c *Cluster) UnfilteredNodesForMetadata(metadata map[string]string) ([]Node, error) {
-	nodes, err := c.storage().RetrieveNodesByMetadata(metadata)
+	return c.UnfilteredNodesForMetadataWithContext(context.Background(), metadata)
+}
+
+// UnfilteredNodesForMetadataWithContext is like UnfilteredNodesForMetadata, but using ctx to cancel the operation.
+func (c *Cluster) UnfilteredNodesForMetadataWithContext(ctx context.Context, metadata map[string]string) ([]Node, error) {
+	nodes, err := c.storage().RetrieveNodesByMetadata(ctx, metadata)
 	if err != nil {
 		return nil, err
 	}
 	return c.setTLSConfigInNodes(nodes), nil
 }
 
+// InfoResult holds the docker info of each node returned by InfoPerNode.
+type InfoResult struct {
+	// Info maps the address of each node to its docker info.
+	Info map[string]*docker.DockerInfo
+
+	NodeFailures
+}
+
+// Err returns a *MultiNodeError with the nodes where getting the info failed,
+// or nil if it didn't fail in any node.
+func (r *InfoResult) Err() error {
+	return r.err("get info", len(r.Info))
+}
+
+// InfoPerNode returns the docker info of every enabled node, along with the
+// nodes that failed or were skipped.
+func (c *Cluster) InfoPerNode() (*InfoResult, error) {
+	return c.InfoPerNodeWithContext(context.Background())
+}
+
+// InfoPerNodeWithContext is like InfoPerNode, but using ctx to cancel the operation.
+func (c *Cluster) InfoPerNodeWithContext(ctx context.Context) (*InfoResult, error) {
+	values, failures, err := c.runOnEachNode(ctx, func(n node) (interface{}, error) {
+		return n.Info()
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	result := InfoResult{
+		Info:         make(map[string]*docker.DockerInfo, len(values)),
+		NodeFailures: failures,
+	}
+	for addr, value := range values {
+		result.Info[addr] = value.(*docker.DockerInfo)
+	}
+	return &result, nil
+}
+
 func (c *Cluster) StartActiveMonitoring(updateInterval time.Duration) {
 	c.monitoringDone = make(chan bool)
 	go c.runActiveMonitoring(updateInterval)
@@ -350,9 +637,9 @@ func (c *Cluster) StopActiveMonitoring() {
 
 func (c *Cluster) runPingForHost(addr string, wg *sync.WaitGroup) {
 	defer wg.Done()
-	client, err := c.getNodeByAddr(addr)
+	client, err := c.getNodeByAddr(context.Background(), addr)
 	if err != nil {
-		log.Errorf("[active-monitoring]: error creating client: %s", err.Error())
+		log.Error("[active-monitoring]: error creating client", log.Fields{"node": addr, "error": err})
 		return
 	}
 	client.HTTPClient = clientWithTimeout(shortDialTimeout, shortTimeout, client.TLSConfig)
@@ -360,19 +647,19 @@ func (c *Cluster) runPingForHost(addr string, wg *sync.WaitGroup) {
 	if err == nil {
 		c.handleNodeSuccess(addr)
 	} else {
-		log.Errorf("[active-monitoring]: error in ping: %s", err.Error())
+		log.Error("[active-monitoring]: error in ping", log.Fields{"node": addr, "error": err})
 		c.handleNodeError(addr, err, true)
 	}
 }
 
 func (c *Cluster) runActiveMonitoring(updateInterval time.Duration) {
-	log.Debugf("[active-monitoring]: active monitoring enabled, pinging hosts every %d seconds", updateInterval/time.Second)
+	log.Debug("[active-monitoring]: active monitoring enabled", log.Fields{"interval": updateInterval})
 	for {
 		var nodes []Node
 		var err error
 		nodes, err = c.UnfilteredNodes()
 		if err != nil {
-			log.Errorf("[active-monitoring]: error in UnfilteredNodes: %s", err.Error())
+			log.Error("[active-monitoring]: error in UnfilteredNodes", log.Fields{"error": err})
 		}
 		wg := sync.WaitGroup{}
 		for _, node := range nodes {
@@ -388,13 +675,16 @@ func (c *Cluster) runActiveMonitoring(updateInterval time.Duration) {
 	}
 }
 
-func (c *Cluster) lockWithTimeout(addr string, isFailure bool) (func(), error) {
+// lockWithTimeout locks the node for healing, using ctx only for acquiring
+// the lock, which is kept alive until the returned function is called.
+func (c *Cluster) lockWithTimeout(ctx context.Context, addr string, isFailure bool) (func(), error) {
 	lockTimeout := 3 * time.Minute
-	locked, err := c.storage().LockNodeForHealing(addr, isFailure, lockTimeout)
+	locked, err := c.storage().LockNodeForHealing(ctx, addr, isFailure, lockTimeout)
 	if err != nil {
 		return nil, err
 	}
 	if !locked {
+		c.metrics().HealerLockContention(addr)
 		return nil, errHealerInProgress
 	}
 	doneKeepAlive := make(chan bool)
@@ -405,23 +695,24 @@ func (c *Cluster) lockWithTimeout(addr string, isFailure bool) (func(), error) {
 				return
 			case <-time.After(30 * time.Second):
 			}
-			c.storage().ExtendNodeLock(addr, lockTimeout)
+			c.storage().ExtendNodeLock(context.Background(), addr, lockTimeout)
 		}
 	}()
 	return func() {
 		doneKeepAlive <- true
-		c.storage().UnlockNode(addr)
+		c.storage().UnlockNode(context.Background(), addr)
 	}, nil
 }
 
 func (c *Cluster) handleNodeError(addr string, lastErr error, incrementFailures bool) error {
-	unlock, err := c.lockWithTimeout(addr, true)
+	ctx := context.Background()
+	unlock, err := c.lockWithTimeout(ctx, addr, true)
 	if err != nil {
 		return err
 	}
 	go func() {
 		defer unlock()
-		node, err := c.storage().RetrieveNode(addr)
+		node, err := c.storage().RetrieveNode(ctx, addr)
 		if err != nil {
 			return
 		}
@@ -430,7 +721,14 @@ func (c *Cluster) handleNodeError(addr string, lastErr error, incrementFailures
 		if duration > 0 {
 			node.updateDisabled(time.Now().Add(duration))
 		}
-		c.storage().UpdateNode(node)
+		err = c.storage().UpdateNode(ctx, node)
+		if err == nil {
+			c.metrics().NodeFailure(addr, duration > 0)
+			if duration > 0 {
+				c.emitNodeEvent(EventNodeDisabled, addr)
+				c.runHealingHooks(HookEventNodeDisabled, &node)
+			}
+		}
 		if fn := nodeUpdatedOnError.Val(); fn != nil {
 			fn()
 		}
@@ -453,17 +751,29 @@ func (v *nodeUpdatedHook) Val() func() {
 }
 
 func (c *Cluster) handleNodeSuccess(addr string) error {
-	unlock, err := c.lockWithTimeout(addr, false)
+	ctx := context.Background()
+	unlock, err := c.lockWithTimeout(ctx, addr, false)
 	if err != nil {
 		return err
 	}
 	defer unlock()
-	node, err := c.storage().RetrieveNode(addr)
+	node, err := c.storage().RetrieveNode(ctx, addr)
 	if err != nil {
 		return err
 	}
+	_, wasDisabled := node.Metadata["DisabledUntil"]
+	wasFailing := node.FailureCount() > 0 || wasDisabled
 	node.updateSuccess()
-	return c.storage().UpdateNode(node)
+	err = c.storage().UpdateNode(ctx, node)
+	if err != nil {
+		return err
+	}
+	c.metrics().NodeSuccess(addr, wasFailing)
+	if wasFailing {
+		c.emitNodeEvent(EventNodeHealed, addr)
+		c.runHealingHooks(HookEventAfterNodeHealed, &node)
+	}
+	return nil
 }
 
 func (c *Cluster) storage() Storage {
@@ -472,9 +782,12 @@ func (c *Cluster) storage() Storage {
 
 type nodeFunc func(node) (interface{}, error)
 
-func (c *Cluster) runOnNodes(fn nodeFunc, errNotFound error, wait bool, nodeAddresses ...string) (interface{}, error) {
+// runOnNodes runs fn in the given nodes, or in all enabled nodes, in
+// parallel. Cancelling ctx aborts the calls to the nodes in progress and
+// prevents new ones from being started.
+func (c *Cluster) runOnNodes(ctx context.Context, fn nodeFunc, errNotFound error, wait bool, nodeAddresses ...string) (interface{}, error) {
 	if len(nodeAddresses) == 0 {
-		nodes, err := c.Nodes()
+		nodes, err := c.NodesWithContext(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -483,16 +796,22 @@ func (c *Cluster) runOnNodes(fn nodeFunc, errNotFound error, wait bool, nodeAddr
 			nodeAddresses[i] = node.Address
 		}
 	}
+	defer func(start time.Time) {
+		c.metrics().RunOnNodesDuration(len(nodeAddresses), time.Since(start))
+	}(time.Now())
 	var wg sync.WaitGroup
 	finish := make(chan int8, len(nodeAddresses))
 	errChan := make(chan error, len(nodeAddresses))
 	result := make(chan interface{}, len(nodeAddresses))
 	for _, addr := range nodeAddresses {
-		wg.Add(1)
-		client, err := c.getNodeByAddr(addr)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		client, err := c.getNodeByAddr(ctx, addr)
 		if err != nil {
 			return nil, err
 		}
+		wg.Add(1)
 		go func(n node) {
 			defer wg.Done()
 			value, err := fn(n)
@@ -559,6 +878,7 @@ func (c *Cluster) StopDryMode() {
 }
 
 func (c *Cluster) DryMode() error {
+	ctx := context.Background()
 	var err error
 	c.dryServer, err = testing.NewServer("127.0.0.1:0", nil, nil)
 	if err != nil {
@@ -566,17 +886,11 @@ func (c *Cluster) DryMode() error {
 	}
 	oldStor := c.stor
 	c.stor = &MapStorage{}
-	nodes, err := oldStor.RetrieveNodes()
+	_, err = copyNodes(ctx, oldStor, c.storage())
 	if err != nil {
 		return err
 	}
-	for _, node := range nodes {
-		err = c.storage().StoreNode(node)
-		if err != nil {
-			return err
-		}
-	}
-	images, err := oldStor.RetrieveImages()
+	images, err := oldStor.RetrieveImages(ctx)
 	if err != nil {
 		return err
 	}
@@ -598,24 +912,17 @@ func (c *Cluster) DryMode() error {
 			return err
 		}
 	}
-	containers, err := oldStor.RetrieveContainers()
-	if err != nil {
-		return err
-	}
-	for _, container := range containers {
-		err = c.storage().StoreContainer(container.Id, container.Host)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	_, err = copyContainers(ctx, oldStor, c.storage())
+	return err
 }
 
-func (c *Cluster) getNodeByAddr(address string) (node, error) {
+// getNodeByAddr returns a client for the node with the given address. Calls
+// to the node are aborted when ctx is cancelled.
+func (c *Cluster) getNodeByAddr(ctx context.Context, address string) (node, error) {
 	if c.dryServer != nil {
 		address = c.dryServer.URL()
 	}
-	n, err := c.GetNode(address)
+	n, err := c.GetNodeWithContext(ctx, address)
 	if err != nil {
 		n = Node{Address: address, defTLSConfig: c.tlsConfig}
 	}
@@ -623,44 +930,80 @@ func (c *Cluster) getNodeByAddr(address string) (node, error) {
 	if err != nil {
 		return node{}, err
 	}
-	return node{addr: address, Client: client}, nil
+	nd := node{addr: address, Client: client, ctx: ctx}
+	nd.applyContext()
+	return nd, nil
 }
 
+// AddHook adds a hook to be run in the given event. If the hook fails, the
+// operation that triggered it is aborted.
 func (c *Cluster) AddHook(evt HookEvent, h Hook) {
+	c.AddHookWithPolicy(evt, h, HookFailureAbort)
+}
+
+// AddHookWithPolicy adds a hook to be run in the given event, with the given
+// failure policy. HookEventAfterNodeHealed and HookEventNodeDisabled are
+// triggered by the healing of nodes, not by an operation, so their failures
+// are always only logged.
+func (c *Cluster) AddHookWithPolicy(evt HookEvent, h Hook, policy HookFailurePolicy) {
 	if c.hooks == nil {
-		c.hooks = map[HookEvent][]Hook{}
+		c.hooks = map[HookEvent][]registeredHook{}
 	}
-	c.hooks[evt] = append(c.hooks[evt], h)
+	c.hooks[evt] = append(c.hooks[evt], registeredHook{hook: h, policy: policy})
 }
 
 func (c *Cluster) Hooks(evt HookEvent) []Hook {
-	if c.hooks == nil {
+	if c.hooks == nil || len(c.hooks[evt]) == 0 {
 		return nil
 	}
-	return c.hooks[evt]
+	hooks := make([]Hook, len(c.hooks[evt]))
+	for i, h := range c.hooks[evt] {
+		hooks[i] = h.hook
+	}
+	return hooks
 }
 
-func (c *Cluster) runHookForAddr(evt HookEvent, address string) error {
+func (c *Cluster) runHookForAddr(ctx context.Context, evt HookEvent, address string, payload HookPayload) error {
 	if c.hooks == nil || len(c.hooks[evt]) == 0 {
 		return nil
 	}
-	node, err := c.storage().RetrieveNode(address)
+	node, err := c.storage().RetrieveNode(ctx, address)
 	if err != nil {
 		return err
 	}
 	node.defTLSConfig = c.tlsConfig
-	return c.runHooks(evt, &node)
+	payload.Node = &node
+	return c.runHooks(evt, &payload)
 }
 
-func (c *Cluster) runHooks(evt HookEvent, n *Node) error {
+func (c *Cluster) runHooks(evt HookEvent, payload *HookPayload) error {
 	if c.hooks == nil {
 		return nil
 	}
 	for _, h := range c.hooks[evt] {
-		err := h.RunClusterHook(evt, n)
-		if err != nil {
-			return err
+		var err error
+		if ph, ok := h.hook.(PayloadHook); ok {
+			err = ph.RunClusterHookWithPayload(evt, payload)
+		} else {
+			err = h.hook.RunClusterHook(evt, payload.Node)
+		}
+		if err == nil {
+			continue
 		}
+		if h.policy == HookFailureLog {
+			log.Warn("[hooks]: ignoring hook error", log.Fields{"event": evt, "node": payload.Node.Address, "error": err})
+			continue
+		}
+		return err
 	}
 	return nil
 }
+
+// runHealingHooks runs the hooks of events triggered by the healing of a
+// node, which only log failures, as there's no operation to abort.
+func (c *Cluster) runHealingHooks(evt HookEvent, n *Node) {
+	err := c.runHooks(evt, &HookPayload{Node: n})
+	if err != nil {
+		log.Error("[hooks]: error in node healing hook", log.Fields{"event": evt, "node": n.Address, "error": err})
+	}
+}
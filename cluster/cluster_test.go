@@ -5,6 +5,7 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -23,6 +24,20 @@ import (
 	"github.com/tsuru/docker-cluster/storage"
 )
 
+// newMapCluster returns a cluster using a MapStorage, with a node for each of
+// the given addresses.
+func newMapCluster(t *testing.T, addrs ...string) *Cluster {
+	nodes := make([]Node, len(addrs))
+	for i, addr := range addrs {
+		nodes[i] = Node{Address: addr}
+	}
+	c, err := New(nil, &MapStorage{}, "", nodes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
 func TestNewCluster(t *testing.T) {
 	var tests = []struct {
 		input []Node
@@ -393,7 +408,7 @@ func TestNodesShouldGetClusterNodesWithoutDisabledNodes(t *testing.T) {
 	go func() {
 		stopChan <- true
 		for {
-			node, err := cluster.storage().RetrieveNode("http://server1:4243")
+			node, err := cluster.storage().RetrieveNode(context.Background(), "http://server1:4243")
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -538,6 +553,46 @@ func TestUnfilteredNodesForMetadataShouldGetClusterNodesWithMetadata(t *testing.
 	}
 }
 
+func TestInfoPerNode(t *testing.T) {
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Containers":2,"NCPU":4}`))
+	}))
+	defer server1.Close()
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal failure", http.StatusInternalServerError)
+	}))
+	defer server2.Close()
+	disabled := Node{Address: "http://localhost:1", CreationStatus: NodeCreationStatusDisabled}
+	cluster, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL},
+		Node{Address: server2.URL},
+		disabled,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := cluster.InfoPerNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Info) != 1 || result.Info[server1.URL] == nil {
+		t.Fatalf("InfoPerNode: wrong info: %#v", result.Info)
+	}
+	if info := result.Info[server1.URL]; info.Containers != 2 || info.NCPU != 4 {
+		t.Errorf("InfoPerNode: wrong info for %s: %#v", server1.URL, info)
+	}
+	if len(result.Errors) != 1 || result.Errors[server2.URL] == nil {
+		t.Errorf("InfoPerNode: wrong errors: %#v", result.Errors)
+	}
+	if !reflect.DeepEqual(result.Skipped, []string{disabled.Address}) {
+		t.Errorf("InfoPerNode: wrong skipped nodes: %#v", result.Skipped)
+	}
+	if _, ok := result.Err().(*MultiNodeError); !ok {
+		t.Errorf("InfoPerNode: expected *MultiNodeError, got %#v", result.Err())
+	}
+}
+
 func TestRunOnNodesWhenReceiveingNodeShouldntLoadStorage(t *testing.T) {
 	id := "e90302"
 	body := fmt.Sprintf(`{"Id":"%s","Path":"date","Args":[]}`, id)
@@ -549,7 +604,7 @@ func TestRunOnNodesWhenReceiveingNodeShouldntLoadStorage(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	result, err := cluster.runOnNodes(func(n node) (interface{}, error) {
+	result, err := cluster.runOnNodes(context.Background(), func(n node) (interface{}, error) {
 		return n.InspectContainer(id)
 	}, &docker.NoSuchContainer{ID: id}, false, server.URL)
 	if err != nil {
@@ -579,7 +634,7 @@ func TestRunOnNodesStress(t *testing.T) {
 		t.Fatal(err)
 	}
 	for i := 0; i < rand.Intn(10)+n; i++ {
-		result, err := cluster.runOnNodes(func(n node) (interface{}, error) {
+		result, err := cluster.runOnNodes(context.Background(), func(n node) (interface{}, error) {
 			return n.InspectContainer(id)
 		}, &docker.NoSuchContainer{ID: id}, false)
 		if err != nil {
@@ -595,6 +650,72 @@ func TestRunOnNodesStress(t *testing.T) {
 	}
 }
 
+func TestRunOnNodesCanceledContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id":"e90302"}`))
+	}))
+	defer server.Close()
+	cluster, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls int32
+	_, err = cluster.runOnNodes(ctx, func(n node) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return n.InspectContainer("e90302")
+	}, &docker.NoSuchContainer{ID: "e90302"}, true, server.URL)
+	if err != context.Canceled {
+		t.Errorf("runOnNodes: want %#v, got %#v", context.Canceled, err)
+	}
+	if calls != 0 {
+		t.Errorf("runOnNodes: expected no calls to the nodes, got %d", calls)
+	}
+}
+
+// blockingServer returns a server that only answers requests after release
+// is closed or the request is canceled.
+func blockingServer() (*httptest.Server, chan struct{}) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	return server, release
+}
+
+func TestGetNodeByAddrContext(t *testing.T) {
+	server, release := blockingServer()
+	defer server.Close()
+	defer close(release)
+	cluster, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, persistent := range []bool{false, true} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		n, err := cluster.getNodeByAddr(ctx, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if persistent {
+			n.setPersistentClient()
+		}
+		start := time.Now()
+		_, err = n.InspectContainer("e90302")
+		cancel()
+		if err == nil {
+			t.Errorf("InspectContainer (persistent=%v): expected error, got <nil>", persistent)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("InspectContainer (persistent=%v): call not canceled, took %s", persistent, elapsed)
+		}
+	}
+}
+
 func TestClusterNodes(t *testing.T) {
 	c, err := New(&roundRobin{}, &MapStorage{}, "")
 	if err != nil {
@@ -682,7 +803,7 @@ func TestClusterHandleNodeErrorStress(t *testing.T) {
 	go func() {
 		stopChan <- true
 		for {
-			node, err := c.storage().RetrieveNode("stress-addr-1")
+			node, err := c.storage().RetrieveNode(context.Background(), "stress-addr-1")
 			if err != nil {
 				continue
 			}
@@ -711,7 +832,7 @@ func TestClusterHandleNodeErrorStress(t *testing.T) {
 	go func() {
 		stopChan <- true
 		for {
-			node, err := c.storage().RetrieveNode("stress-addr-1")
+			node, err := c.storage().RetrieveNode(context.Background(), "stress-addr-1")
 			if err != nil {
 				continue
 			}
@@ -737,7 +858,7 @@ func TestClusterHandleNodeErrorStress(t *testing.T) {
 	if !isDateSameMinute(disabledStr, now) {
 		t.Errorf("Expected DisabledUntil to be like %s, got: %s", now, disabledStr)
 	}
-	nodes, err := c.storage().RetrieveNodes()
+	nodes, err := c.storage().RetrieveNodes(context.Background())
 	node := nodes[0]
 	if err != nil {
 		t.Fatal(err)
@@ -766,7 +887,7 @@ func TestClusterHandleNodeSuccess(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	node, err := c.storage().RetrieveNode("addr-1")
+	node, err := c.storage().RetrieveNode(context.Background(), "addr-1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -778,6 +899,56 @@ func TestClusterHandleNodeSuccess(t *testing.T) {
 	}
 }
 
+func TestClusterHandleNodeSuccessRunsHealedHook(t *testing.T) {
+	c, err := New(&roundRobin{}, &MapStorage{}, "", Node{
+		Address:  "addr-1",
+		Metadata: map[string]string{"Failures": "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var healed []string
+	c.AddHook(HookEventAfterNodeHealed, HookFunc(func(evt HookEvent, payload *HookPayload) error {
+		healed = append(healed, payload.Node.Address)
+		return errors.New("hook error")
+	}))
+	err = c.handleNodeSuccess("addr-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.handleNodeSuccess("addr-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(healed, []string{"addr-1"}) {
+		t.Errorf("Expected healed hook to run once for addr-1, got: %v", healed)
+	}
+}
+
+func TestClusterHandleNodeErrorRunsDisabledHook(t *testing.T) {
+	c, err := New(&roundRobin{}, &MapStorage{}, "", Node{Address: "addr-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload *HookPayload
+	c.AddHook(HookEventNodeDisabled, HookFunc(func(evt HookEvent, p *HookPayload) error {
+		payload = p
+		return nil
+	}))
+	wait := registerErrorWait()
+	err = c.handleNodeError("addr-1", errors.New("some err"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	if payload == nil || payload.Node.Address != "addr-1" {
+		t.Fatalf("Expected disabled hook to run for addr-1, got: %#v", payload)
+	}
+	if payload.Node.FailureCount() != 1 {
+		t.Errorf("Expected FailureCount to be 1, got: %d", payload.Node.FailureCount())
+	}
+}
+
 func TestClusterHandleNodeSuccessStressShouldntBlockNodes(t *testing.T) {
 	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(10))
 	c, err := New(&roundRobin{}, &MapStorage{}, "")
@@ -904,7 +1075,7 @@ func TestClusterGetNodeByAddr(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	node, err := cluster.getNodeByAddr("http://199.222.111.10")
+	node, err := cluster.getNodeByAddr(context.Background(), "http://199.222.111.10")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -922,7 +1093,7 @@ func TestClusterGetNodeByAddrWithTLS(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	node, err := cluster.getNodeByAddr("https://199.222.111.10")
+	node, err := cluster.getNodeByAddr(context.Background(), "https://199.222.111.10")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -940,7 +1111,7 @@ func TestNodeSetPersistentClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	node, err := cluster.getNodeByAddr("http://199.222.111.10")
+	node, err := cluster.getNodeByAddr(context.Background(), "http://199.222.111.10")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -959,7 +1130,7 @@ func TestNodeSetPersistentClientWithTLS(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	node, err := cluster.getNodeByAddr("https://199.222.111.10")
+	node, err := cluster.getNodeByAddr(context.Background(), "https://199.222.111.10")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,165 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ConstraintOperator is the comparison applied by a Constraint between the
+// metadata value of a node and the constraint values.
+type ConstraintOperator string
+
+const (
+	// ConstraintEqual matches nodes where the key has the given value.
+	ConstraintEqual ConstraintOperator = "=="
+	// ConstraintNotEqual matches nodes where the key doesn't have the given
+	// value, including nodes without the key.
+	ConstraintNotEqual ConstraintOperator = "!="
+	// ConstraintIn matches nodes where the key has one of the given values.
+	ConstraintIn ConstraintOperator = "in"
+	// ConstraintNotIn matches nodes where the key has none of the given
+	// values, including nodes without the key.
+	ConstraintNotIn ConstraintOperator = "notin"
+)
+
+// Constraint is a rule on the metadata of a node, like pool==prod,
+// zone!=us-east-1a or disk in (ssd,nvme).
+type Constraint struct {
+	Key      string
+	Operator ConstraintOperator
+	Values   []string
+}
+
+var (
+	constraintCompareRegexp = regexp.MustCompile(`^\s*([^=!\s]+)\s*(==|!=)\s*(\S*)\s*$`)
+	constraintSetRegexp     = regexp.MustCompile(`^\s*(\S+)\s+(in|notin|not in)\s*\(([^)]*)\)\s*$`)
+)
+
+// ParseConstraint parses a constraint expression. The accepted grammar is:
+//
+//	key==value
+//	key!=value
+//	key in (value1,value2,...)
+//	key notin (value1,value2,...)
+//	key not in (value1,value2,...)
+//
+// Whitespace around keys, operators and values is ignored. Keys can't contain
+// "=" or "!", values can't be empty nor start with "=" and sets must have at
+// least one value.
+func ParseConstraint(expr string) (Constraint, error) {
+	if parts := constraintCompareRegexp.FindStringSubmatch(expr); parts != nil {
+		if parts[3] == "" || strings.HasPrefix(parts[3], "=") {
+			return Constraint{}, fmt.Errorf("invalid constraint expression %q: invalid value", expr)
+		}
+		return Constraint{
+			Key:      parts[1],
+			Operator: ConstraintOperator(parts[2]),
+			Values:   []string{parts[3]},
+		}, nil
+	}
+	if parts := constraintSetRegexp.FindStringSubmatch(expr); parts != nil {
+		op := ConstraintIn
+		if parts[2] != string(ConstraintIn) {
+			op = ConstraintNotIn
+		}
+		var values []string
+		for _, v := range strings.Split(parts[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return Constraint{}, fmt.Errorf("invalid constraint expression %q: empty set of values", expr)
+		}
+		return Constraint{Key: parts[1], Operator: op, Values: values}, nil
+	}
+	return Constraint{}, fmt.Errorf("invalid constraint expression %q", expr)
+}
+
+// ParseConstraints parses a list of constraint expressions, failing on the
+// first invalid one.
+func ParseConstraints(exprs ...string) ([]Constraint, error) {
+	constraints := make([]Constraint, len(exprs))
+	for i, expr := range exprs {
+		var err error
+		constraints[i], err = ParseConstraint(expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return constraints, nil
+}
+
+// Match checks whether the given metadata satisfies the constraint. A
+// missing key is handled as an empty value.
+func (c Constraint) Match(metadata map[string]string) bool {
+	value := metadata[c.Key]
+	switch c.Operator {
+	case ConstraintEqual:
+		return len(c.Values) > 0 && value == c.Values[0]
+	case ConstraintNotEqual:
+		return len(c.Values) == 0 || value != c.Values[0]
+	case ConstraintIn:
+		return containsString(c.Values, value)
+	case ConstraintNotIn:
+		return !containsString(c.Values, value)
+	}
+	return false
+}
+
+func (c Constraint) String() string {
+	switch c.Operator {
+	case ConstraintIn, ConstraintNotIn:
+		return fmt.Sprintf("%s %s (%s)", c.Key, c.Operator, strings.Join(c.Values, ","))
+	}
+	return fmt.Sprintf("%s%s%s", c.Key, c.Operator, strings.Join(c.Values, ""))
+}
+
+func matchAllConstraints(metadata map[string]string, constraints []Constraint) bool {
+	for _, c := range constraints {
+		if !c.Match(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// NodesForConstraints returns the enabled nodes whose metadata satisfies all
+// the given constraints.
+func (c *Cluster) NodesForConstraints(constraints ...Constraint) ([]Node, error) {
+	return c.NodesForConstraintsWithContext(context.Background(), constraints...)
+}
+
+// NodesForConstraintsWithContext is like NodesForConstraints, but using ctx to cancel the operation.
+func (c *Cluster) NodesForConstraintsWithContext(ctx context.Context, constraints ...Constraint) ([]Node, error) {
+	nodes, err := c.NodesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterNodesByConstraints(nodes, constraints), nil
+}
+
+func filterNodesByConstraints(nodes []Node, constraints []Constraint) []Node {
+	filtered := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		if matchAllConstraints(n.Metadata, constraints) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
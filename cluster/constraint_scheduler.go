@@ -0,0 +1,192 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+// ContainerAffinity describes the relation between the container being
+// scheduled and other containers in the cluster, selected by their labels.
+//
+// Affinity attracts the new container to nodes running containers that match
+// the labels, while anti-affinity (Anti: true) repels it. Hard affinities
+// are requirements, nodes not satisfying them are discarded; soft affinities
+// are only used to rank the nodes.
+type ContainerAffinity struct {
+	Labels map[string]string
+	Anti   bool
+	Hard   bool
+}
+
+// ConstraintSchedulerOptions are the options understood by
+// ConstraintScheduler. They may be given either as a value or as a pointer.
+type ConstraintSchedulerOptions struct {
+	Constraints []Constraint
+	Affinities  []ContainerAffinity
+}
+
+// ConstraintScheduler is a Scheduler that filters nodes by metadata
+// constraints and ranks them using container affinities. Nodes with the same
+// rank are used in a round robin fashion.
+type ConstraintScheduler struct {
+	lastUsed int64
+}
+
+var _ Scheduler = &ConstraintScheduler{}
+
+var errNoNodeMatchesConstraints = errors.New("No nodes available matching the constraints")
+
+type nodeScore struct {
+	node  Node
+	score int
+}
+
+func (s *ConstraintScheduler) Schedule(c *Cluster, opts *docker.CreateContainerOptions, schedulerOpts SchedulerOptions) (Node, error) {
+	ctx := optsContext(opts.Context)
+	options, err := constraintOptions(schedulerOpts)
+	if err != nil {
+		return Node{}, err
+	}
+	nodes, err := c.SchedulableNodesWithContext(ctx)
+	if err != nil {
+		return Node{}, err
+	}
+	if len(nodes) == 0 {
+		return Node{}, errors.New("No nodes available")
+	}
+	nodes = filterNodesByConstraints(nodes, options.Constraints)
+	if len(nodes) == 0 {
+		return Node{}, errNoNodeMatchesConstraints
+	}
+	scores, err := c.scoreNodesByAffinity(ctx, nodes, options.Affinities)
+	if err != nil {
+		return Node{}, err
+	}
+	if len(scores) == 0 {
+		return Node{}, errNoNodeMatchesConstraints
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].score > scores[j].score
+	})
+	best := 1
+	for best < len(scores) && scores[best].score == scores[0].score {
+		best++
+	}
+	value := atomic.AddInt64(&s.lastUsed, 1) - 1
+	return scores[value%int64(best)].node, nil
+}
+
+func constraintOptions(schedulerOpts SchedulerOptions) (ConstraintSchedulerOptions, error) {
+	switch opts := schedulerOpts.(type) {
+	case nil:
+		return ConstraintSchedulerOptions{}, nil
+	case ConstraintSchedulerOptions:
+		return validateConstraintOptions(opts)
+	case *ConstraintSchedulerOptions:
+		if opts == nil {
+			return ConstraintSchedulerOptions{}, nil
+		}
+		return validateConstraintOptions(*opts)
+	}
+	return ConstraintSchedulerOptions{}, fmt.Errorf("Invalid scheduler options type %T", schedulerOpts)
+}
+
+func validateConstraintOptions(opts ConstraintSchedulerOptions) (ConstraintSchedulerOptions, error) {
+	for _, affinity := range opts.Affinities {
+		if len(affinity.Labels) == 0 {
+			return ConstraintSchedulerOptions{}, errors.New("Invalid container affinity: labels must not be empty")
+		}
+	}
+	return opts, nil
+}
+
+// scoreNodesByAffinity discards nodes that don't satisfy hard affinities and
+// scores the remaining ones by the number of matching containers. Nodes that
+// can't be reached are ignored, unless none of them could be.
+func (c *Cluster) scoreNodesByAffinity(ctx context.Context, nodes []Node, affinities []ContainerAffinity) ([]nodeScore, error) {
+	if len(affinities) == 0 {
+		scores := make([]nodeScore, len(nodes))
+		for i, n := range nodes {
+			scores[i] = nodeScore{node: n}
+		}
+		return scores, nil
+	}
+	var wg sync.WaitGroup
+	scoreChan := make(chan *nodeScore, len(nodes))
+	errChan := make(chan error, len(nodes))
+	for i := range nodes {
+		wg.Add(1)
+		go func(n Node) {
+			defer wg.Done()
+			score, err := c.scoreNodeByAffinity(ctx, n, affinities)
+			if err != nil {
+				log.Warn("Ignoring node when checking container affinities", log.Fields{"node": n.Address, "error": err})
+				errChan <- err
+				return
+			}
+			scoreChan <- score
+		}(nodes[i])
+	}
+	wg.Wait()
+	close(scoreChan)
+	close(errChan)
+	var scores []nodeScore
+	for score := range scoreChan {
+		if score != nil {
+			scores = append(scores, *score)
+		}
+	}
+	if len(errChan) == len(nodes) {
+		var msgs []string
+		for err := range errChan {
+			msgs = append(msgs, err.Error())
+		}
+		return nil, fmt.Errorf("Unable to check container affinities in any node: %s", strings.Join(msgs, "; "))
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].node.Address < scores[j].node.Address
+	})
+	return scores, nil
+}
+
+func (c *Cluster) scoreNodeByAffinity(ctx context.Context, n Node, affinities []ContainerAffinity) (*nodeScore, error) {
+	client, err := c.getNodeByAddr(ctx, n.Address)
+	if err != nil {
+		return nil, err
+	}
+	score := nodeScore{node: n}
+	for _, affinity := range affinities {
+		labels := make([]string, 0, len(affinity.Labels))
+		for k, v := range affinity.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		containers, err := client.ListContainers(docker.ListContainersOptions{
+			Filters: map[string][]string{"label": labels},
+		})
+		if err != nil {
+			return nil, wrapError(client, err)
+		}
+		count := len(containers)
+		if affinity.Hard && (affinity.Anti == (count > 0)) {
+			return nil, nil
+		}
+		if affinity.Anti {
+			score.score -= count
+		} else {
+			score.score += count
+		}
+	}
+	return &score, nil
+}
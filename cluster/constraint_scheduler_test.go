@@ -0,0 +1,159 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func labeledContainersServer(label string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if label != "" && strings.Contains(r.URL.Query().Get("filters"), label) {
+			w.Write([]byte(`[{"Id":"c1"}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+}
+
+func TestConstraintSchedulerConstraints(t *testing.T) {
+	scheduler := &ConstraintScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "",
+		Node{Address: "http://n1:4243", Metadata: map[string]string{"pool": "prod"}},
+		Node{Address: "http://n2:4243", Metadata: map[string]string{"pool": "dev"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constraints, _ := ParseConstraints("pool!=prod")
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	for i := 0; i < 3; i++ {
+		node, err := scheduler.Schedule(c, &opts, ConstraintSchedulerOptions{Constraints: constraints})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Address != "http://n2:4243" {
+			t.Errorf("ConstraintScheduler.Schedule(): wrong node. Want %q. Got %q.", "http://n2:4243", node.Address)
+		}
+	}
+	constraints, _ = ParseConstraints("pool==staging")
+	_, err = scheduler.Schedule(c, &opts, &ConstraintSchedulerOptions{Constraints: constraints})
+	if err != errNoNodeMatchesConstraints {
+		t.Errorf("ConstraintScheduler.Schedule(): expected errNoNodeMatchesConstraints, got %#v", err)
+	}
+}
+
+func TestConstraintSchedulerRoundRobinWithoutOptions(t *testing.T) {
+	scheduler := &ConstraintScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: "url1"}, Node{Address: "url2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	for _, expected := range []string{"url1", "url2", "url1"} {
+		node, err := scheduler.Schedule(c, &opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Address != expected {
+			t.Errorf("ConstraintScheduler.Schedule(): wrong node. Want %q. Got %q.", expected, node.Address)
+		}
+	}
+	_, err = scheduler.Schedule(c, &opts, "invalid")
+	if err == nil {
+		t.Error("ConstraintScheduler.Schedule(): expected error for invalid options, got <nil>")
+	}
+}
+
+func TestConstraintSchedulerAffinity(t *testing.T) {
+	server1 := labeledContainersServer("")
+	defer server1.Close()
+	server2 := labeledContainersServer("app=db")
+	defer server2.Close()
+	scheduler := &ConstraintScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL}, Node{Address: server2.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	var tests = []struct {
+		affinity ContainerAffinity
+		expected string
+	}{
+		{ContainerAffinity{Labels: map[string]string{"app": "db"}}, server2.URL},
+		{ContainerAffinity{Labels: map[string]string{"app": "db"}, Hard: true}, server2.URL},
+		{ContainerAffinity{Labels: map[string]string{"app": "db"}, Anti: true}, server1.URL},
+		{ContainerAffinity{Labels: map[string]string{"app": "db"}, Anti: true, Hard: true}, server1.URL},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 2; i++ {
+			node, err := scheduler.Schedule(c, &opts, ConstraintSchedulerOptions{Affinities: []ContainerAffinity{tt.affinity}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if node.Address != tt.expected {
+				t.Errorf("ConstraintScheduler.Schedule() with %#v: wrong node. Want %q. Got %q.", tt.affinity, tt.expected, node.Address)
+			}
+		}
+	}
+	_, err = scheduler.Schedule(c, &opts, ConstraintSchedulerOptions{Affinities: []ContainerAffinity{
+		{Labels: map[string]string{"app": "web"}, Hard: true},
+	}})
+	if err != errNoNodeMatchesConstraints {
+		t.Errorf("ConstraintScheduler.Schedule(): expected errNoNodeMatchesConstraints, got %#v", err)
+	}
+}
+
+func TestConstraintSchedulerSkipsUnreachableNodes(t *testing.T) {
+	server1 := labeledContainersServer("app=db")
+	defer server1.Close()
+	scheduler := &ConstraintScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL}, Node{Address: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	schedOpts := ConstraintSchedulerOptions{Affinities: []ContainerAffinity{
+		{Labels: map[string]string{"app": "db"}, Anti: true},
+	}}
+	for i := 0; i < 2; i++ {
+		node, err := scheduler.Schedule(c, &opts, schedOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Address != server1.URL {
+			t.Errorf("ConstraintScheduler.Schedule(): wrong node. Want %q. Got %q.", server1.URL, node.Address)
+		}
+	}
+	c.Unregister(server1.URL)
+	_, err = scheduler.Schedule(c, &opts, schedOpts)
+	if err == nil || !strings.Contains(err.Error(), "Unable to check container affinities in any node") {
+		t.Errorf("ConstraintScheduler.Schedule(): expected unreachable nodes error, got %#v", err)
+	}
+}
+
+func TestConstraintSchedulerEmptyAffinityLabels(t *testing.T) {
+	scheduler := &ConstraintScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: "url1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	for _, schedOpts := range []SchedulerOptions{
+		ConstraintSchedulerOptions{Affinities: []ContainerAffinity{{Anti: true, Hard: true}}},
+		&ConstraintSchedulerOptions{Affinities: []ContainerAffinity{{Labels: map[string]string{}}}},
+	} {
+		_, err = scheduler.Schedule(c, &opts, schedOpts)
+		if err == nil || !strings.Contains(err.Error(), "labels must not be empty") {
+			t.Errorf("ConstraintScheduler.Schedule(): expected empty labels error, got %#v", err)
+		}
+	}
+}
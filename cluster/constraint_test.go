@@ -0,0 +1,97 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConstraint(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected Constraint
+		fail     bool
+	}{
+		{"pool==prod", Constraint{Key: "pool", Operator: ConstraintEqual, Values: []string{"prod"}}, false},
+		{" zone != us-east-1a ", Constraint{Key: "zone", Operator: ConstraintNotEqual, Values: []string{"us-east-1a"}}, false},
+		{"disk in (ssd,nvme)", Constraint{Key: "disk", Operator: ConstraintIn, Values: []string{"ssd", "nvme"}}, false},
+		{"disk notin (ssd, nvme)", Constraint{Key: "disk", Operator: ConstraintNotIn, Values: []string{"ssd", "nvme"}}, false},
+		{"disk not in (hdd)", Constraint{Key: "disk", Operator: ConstraintNotIn, Values: []string{"hdd"}}, false},
+		{"pool=prod", Constraint{}, true},
+		{"pool==", Constraint{}, true},
+		{"pool!= ", Constraint{}, true},
+		{"a===b", Constraint{}, true},
+		{"a!==b", Constraint{}, true},
+		{"disk in ()", Constraint{}, true},
+		{"", Constraint{}, true},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.input)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("ParseConstraint(%q): expected error, got <nil>", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): unexpected error: %s", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, tt.expected) {
+			t.Errorf("ParseConstraint(%q): want %#v, got %#v", tt.input, tt.expected, c)
+		}
+	}
+}
+
+func TestConstraintMatch(t *testing.T) {
+	metadata := map[string]string{"pool": "prod", "zone": "us-east-1b", "disk": "ssd"}
+	var tests = []struct {
+		expr     string
+		expected bool
+	}{
+		{"pool==prod", true},
+		{"pool==dev", false},
+		{"zone!=us-east-1a", true},
+		{"zone!=us-east-1b", false},
+		{"disk in (ssd,nvme)", true},
+		{"disk in (hdd)", false},
+		{"disk notin (hdd)", true},
+		{"disk notin (ssd)", false},
+		{"missing!=x", true},
+		{"missing==x", false},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Match(metadata) != tt.expected {
+			t.Errorf("Constraint %q: expected match to be %v", tt.expr, tt.expected)
+		}
+	}
+}
+
+func TestNodesForConstraints(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "",
+		Node{Address: "http://n1:4243", Metadata: map[string]string{"pool": "prod", "disk": "ssd"}},
+		Node{Address: "http://n2:4243", Metadata: map[string]string{"pool": "prod", "disk": "hdd"}},
+		Node{Address: "http://n3:4243", Metadata: map[string]string{"pool": "dev", "disk": "ssd"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constraints, err := ParseConstraints("pool==prod", "disk in (ssd,nvme)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := c.NodesForConstraints(constraints...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Address != "http://n1:4243" {
+		t.Errorf("NodesForConstraints: expected only n1, got %#v", nodes)
+	}
+}
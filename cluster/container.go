@@ -5,12 +5,13 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/url"
-	"sync"
+	"sort"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
@@ -22,6 +23,13 @@ type Container struct {
 	Host string
 }
 
+// Exec is the association between an exec instance and the container where it
+// runs, as kept by the storage.
+type Exec struct {
+	Id        string `bson:"_id"`
+	Container string
+}
+
 // CreateContainer creates a container in the specified node. If no node is
 // specified, it will create the container in a node selected by the scheduler.
 //
@@ -48,15 +56,25 @@ func (c *Cluster) CreateContainerPullOptsSchedulerOpts(opts docker.CreateContain
 		container *docker.Container
 		err       error
 	)
+	ctx := optsContext(opts.Context)
+	volumeAddr, err := c.volumeNode(ctx, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	if volumeAddr != "" {
+		if len(nodes) > 0 && !containsString(nodes, volumeAddr) {
+			return "", nil, fmt.Errorf("Container volumes are in node %s, which is not one of the given nodes", volumeAddr)
+		}
+		nodes = []string{volumeAddr}
+	}
 	useScheduler := len(nodes) == 0
+	if reserving, ok := c.scheduler.(ReservingScheduler); ok && useScheduler {
+		defer reserving.Release(&opts)
+	}
 	maxTries := 5
-	for ; maxTries > 0; maxTries-- {
-		if opts.Context != nil {
-			select {
-			case <-opts.Context.Done():
-				return "", nil, opts.Context.Err()
-			default:
-			}
+	for attempt := 1; attempt <= maxTries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return "", nil, err
 		}
 		if useScheduler {
 			node, scheduleErr := c.scheduler.Schedule(c, &opts, schedulerOpts)
@@ -73,17 +91,17 @@ func (c *Cluster) CreateContainerPullOptsSchedulerOpts(opts docker.CreateContain
 		if addr == "" {
 			return addr, nil, errors.New("CreateContainer needs a non empty node addr")
 		}
-		err = c.runHookForAddr(HookEventBeforeContainerCreate, addr)
+		err = c.runHookForAddr(ctx, HookEventBeforeContainerCreate, addr, HookPayload{CreateOptions: &opts})
 		if err != nil {
-			log.Errorf("Error in before create container hook in node %q: %s. Trying again in another node...", addr, err)
+			log.Error("Error in before create container hook. Trying again in another node...", log.Fields{"node": addr, "attempt": attempt, "error": err})
 		}
 		if err == nil {
-			container, err = c.createContainerInNode(opts, pullOpts, pullAuth, addr)
+			container, err = c.createContainerInNode(ctx, opts, pullOpts, pullAuth, addr)
 			if err == nil {
 				c.handleNodeSuccess(addr)
 				break
 			}
-			log.Errorf("Error trying to create container in node %q: %s. Trying again in another node...", addr, err.Error())
+			log.Error("Error trying to create container. Trying again in another node...", log.Fields{"node": addr, "image": opts.Config.Image, "attempt": attempt, "error": err})
 		}
 		shouldIncrementFailures := false
 		isCreateContainerErr := false
@@ -103,23 +121,62 @@ func (c *Cluster) CreateContainerPullOptsSchedulerOpts(opts docker.CreateContain
 		if !useScheduler {
 			return addr, nil, err
 		}
+		if attempt < maxTries {
+			c.metrics().CreateContainerRetry(addr)
+		}
 	}
 	if err != nil {
 		return addr, nil, fmt.Errorf("CreateContainer: maximum number of tries exceeded, last error: %s", err.Error())
 	}
-	err = c.storage().StoreContainer(container.ID, addr)
+	err = c.runHookForAddr(ctx, HookEventAfterContainerCreate, addr, HookPayload{CreateOptions: &opts, ContainerID: container.ID})
+	if err != nil {
+		c.removeCreatedContainer(addr, container.ID)
+		return addr, nil, err
+	}
+	err = c.storage().StoreContainer(ctx, container.ID, addr)
 	return addr, container, err
 }
 
-func (c *Cluster) createContainerInNode(opts docker.CreateContainerOptions, pullOpts docker.PullImageOptions, pullAuth docker.AuthConfiguration, nodeAddress string) (*docker.Container, error) {
-	registryServer, _ := parseImageRegistry(opts.Config.Image)
-	err := c.PullImage(pullOpts, pullAuth, nodeAddress)
+// removeCreatedContainer removes a container that failed an after create
+// hook. It uses a new client, as the one of the creation fails after its
+// context is cancelled.
+func (c *Cluster) removeCreatedContainer(addr, id string) {
+	node, err := c.getNodeByAddr(context.Background(), addr)
+	if err == nil {
+		err = node.RemoveContainer(docker.RemoveContainerOptions{ID: id, Force: true})
+	}
 	if err != nil {
-		if registryServer != "" {
+		log.Error("[hooks]: error removing container after hook failure", log.Fields{"container": id, "node": addr, "error": err})
+	}
+}
+
+func (c *Cluster) createContainerInNode(ctx context.Context, opts docker.CreateContainerOptions, pullOpts docker.PullImageOptions, pullAuth docker.AuthConfiguration, nodeAddress string) (*docker.Container, error) {
+	pinned, err := c.pinnedImage(ctx, pullOpts)
+	if err != nil {
+		return nil, err
+	}
+	if pinned != "" {
+		err = c.pullPinnedImage(ctx, pullOpts, pullAuth, pinned, nodeAddress)
+		if err != nil {
 			return nil, err
 		}
+		config := *opts.Config
+		config.Image = pinned
+		opts.Config = &config
+	} else {
+		registryServer, _ := parseImageRegistry(opts.Config.Image)
+		err = c.PullImage(pullOpts, pullAuth, nodeAddress)
+		if err != nil {
+			if registryServer != "" {
+				return nil, err
+			}
+		}
 	}
-	node, err := c.getNodeByAddr(nodeAddress)
+	err = c.ensureNetworksInNode(ctx, opts, nodeAddress)
+	if err != nil {
+		return nil, err
+	}
+	node, err := c.getNodeByAddr(ctx, nodeAddress)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +187,12 @@ func (c *Cluster) createContainerInNode(opts docker.CreateContainerOptions, pull
 // InspectContainer returns information about a container by its ID, getting
 // the information from the right node.
 func (c *Cluster) InspectContainer(id string) (*docker.Container, error) {
-	node, err := c.getNodeForContainer(id)
+	return c.InspectContainerWithContext(context.Background(), id)
+}
+
+// InspectContainerWithContext is like InspectContainer, but using ctx to cancel the operation.
+func (c *Cluster) InspectContainerWithContext(ctx context.Context, id string) (*docker.Container, error) {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -140,46 +202,77 @@ func (c *Cluster) InspectContainer(id string) (*docker.Container, error) {
 
 // KillContainer kills a container, returning an error in case of failure.
 func (c *Cluster) KillContainer(opts docker.KillContainerOptions) error {
-	node, err := c.getNodeForContainer(opts.ID)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, opts.ID)
 	if err != nil {
 		return err
 	}
 	return wrapError(node, node.KillContainer(opts))
 }
 
+// ListContainersResult holds the containers listed in each node by
+// ListContainersPerNode.
+type ListContainersResult struct {
+	// Containers maps the address of each node listed successfully to its
+	// containers.
+	Containers map[string][]docker.APIContainers
+
+	NodeFailures
+}
+
+// All returns the containers of all nodes, ordered by node address.
+func (r *ListContainersResult) All() []docker.APIContainers {
+	addrs := make([]string, 0, len(r.Containers))
+	for addr := range r.Containers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	var all []docker.APIContainers
+	for _, addr := range addrs {
+		all = append(all, r.Containers[addr]...)
+	}
+	return all
+}
+
+// Err returns a *MultiNodeError with the nodes where the listing failed, or
+// nil if it didn't fail in any node.
+func (r *ListContainersResult) Err() error {
+	return r.err("list containers", len(r.Containers))
+}
+
 // ListContainers returns a slice of all containers in the cluster matching the
-// given criteria.
+// given criteria. Nodes that fail don't hide the containers of the other
+// ones: the containers found are returned along with a *MultiNodeError.
 func (c *Cluster) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
-	nodes, err := c.Nodes()
+	result, err := c.ListContainersPerNode(opts)
 	if err != nil {
 		return nil, err
 	}
-	var wg sync.WaitGroup
-	result := make(chan []docker.APIContainers, len(nodes))
-	errs := make(chan error, len(nodes))
-	for _, n := range nodes {
-		wg.Add(1)
-		client, _ := c.getNodeByAddr(n.Address)
-		go func(n node) {
-			defer wg.Done()
-			if containers, err := n.ListContainers(opts); err != nil {
-				errs <- wrapError(n, err)
-			} else {
-				result <- containers
-			}
-		}(client)
-	}
-	wg.Wait()
-	var group []docker.APIContainers
-	for {
-		select {
-		case containers := <-result:
-			group = append(group, containers...)
-		case err = <-errs:
-		default:
-			return group, err
-		}
+	if err = optsContext(opts.Context).Err(); err != nil {
+		return result.All(), err
 	}
+	return result.All(), result.Err()
+}
+
+// ListContainersPerNode lists the containers matching the given criteria in
+// every enabled node, reporting the containers and the errors of each node,
+// and the nodes that were skipped. The returned error is only set when the
+// nodes can't be retrieved from the storage.
+func (c *Cluster) ListContainersPerNode(opts docker.ListContainersOptions) (*ListContainersResult, error) {
+	values, failures, err := c.runOnEachNode(optsContext(opts.Context), func(n node) (interface{}, error) {
+		return n.ListContainers(opts)
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	result := ListContainersResult{
+		Containers:   make(map[string][]docker.APIContainers, len(values)),
+		NodeFailures: failures,
+	}
+	for addr, value := range values {
+		result.Containers[addr] = value.([]docker.APIContainers)
+	}
+	return &result, nil
 }
 
 // RemoveContainer removes a container from the cluster.
@@ -188,7 +281,8 @@ func (c *Cluster) RemoveContainer(opts docker.RemoveContainerOptions) error {
 }
 
 func (c *Cluster) removeFromStorage(opts docker.RemoveContainerOptions) error {
-	node, err := c.getNodeForContainer(opts.ID)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, opts.ID)
 	if err != nil {
 		return err
 	}
@@ -199,11 +293,20 @@ func (c *Cluster) removeFromStorage(opts docker.RemoveContainerOptions) error {
 			return wrapError(node, err)
 		}
 	}
-	return c.storage().RemoveContainer(opts.ID)
+	err = c.storage().RemoveContainer(ctx, opts.ID)
+	if err != nil {
+		return err
+	}
+	return c.runHookForAddr(ctx, HookEventAfterContainerRemove, node.addr, HookPayload{ContainerID: opts.ID})
 }
 
 func (c *Cluster) StartContainer(id string, hostConfig *docker.HostConfig) error {
-	node, err := c.getNodeForContainer(id)
+	return c.StartContainerWithContext(context.Background(), id, hostConfig)
+}
+
+// StartContainerWithContext is like StartContainer, but using ctx to cancel the operation.
+func (c *Cluster) StartContainerWithContext(ctx context.Context, id string, hostConfig *docker.HostConfig) error {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -222,7 +325,12 @@ func (c *Cluster) StartContainer(id string, hostConfig *docker.HostConfig) error
 // StopContainer stops a container, killing it after the given timeout, if it
 // fails to stop nicely.
 func (c *Cluster) StopContainer(id string, timeout uint) error {
-	node, err := c.getNodeForContainer(id)
+	return c.StopContainerWithContext(context.Background(), id, timeout)
+}
+
+// StopContainerWithContext is like StopContainer, but using ctx to cancel the operation.
+func (c *Cluster) StopContainerWithContext(ctx context.Context, id string, timeout uint) error {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -232,7 +340,12 @@ func (c *Cluster) StopContainer(id string, timeout uint) error {
 // RestartContainer restarts a container, killing it after the given timeout,
 // if it fails to stop nicely.
 func (c *Cluster) RestartContainer(id string, timeout uint) error {
-	node, err := c.getNodeForContainer(id)
+	return c.RestartContainerWithContext(context.Background(), id, timeout)
+}
+
+// RestartContainerWithContext is like RestartContainer, but using ctx to cancel the operation.
+func (c *Cluster) RestartContainerWithContext(ctx context.Context, id string, timeout uint) error {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -241,7 +354,12 @@ func (c *Cluster) RestartContainer(id string, timeout uint) error {
 
 // PauseContainer changes the container to the paused state.
 func (c *Cluster) PauseContainer(id string) error {
-	node, err := c.getNodeForContainer(id)
+	return c.PauseContainerWithContext(context.Background(), id)
+}
+
+// PauseContainerWithContext is like PauseContainer, but using ctx to cancel the operation.
+func (c *Cluster) PauseContainerWithContext(ctx context.Context, id string) error {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -250,7 +368,12 @@ func (c *Cluster) PauseContainer(id string) error {
 
 // UnpauseContainer removes the container from the paused state.
 func (c *Cluster) UnpauseContainer(id string) error {
-	node, err := c.getNodeForContainer(id)
+	return c.UnpauseContainerWithContext(context.Background(), id)
+}
+
+// UnpauseContainerWithContext is like UnpauseContainer, but using ctx to cancel the operation.
+func (c *Cluster) UnpauseContainerWithContext(ctx context.Context, id string) error {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -260,7 +383,12 @@ func (c *Cluster) UnpauseContainer(id string) error {
 // WaitContainer blocks until the given container stops, returning the exit
 // code of the container command.
 func (c *Cluster) WaitContainer(id string) (int, error) {
-	node, err := c.getNodeForContainer(id)
+	return c.WaitContainerWithContext(context.Background(), id)
+}
+
+// WaitContainerWithContext is like WaitContainer, but using ctx to cancel the operation.
+func (c *Cluster) WaitContainerWithContext(ctx context.Context, id string) (int, error) {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return -1, err
 	}
@@ -271,7 +399,7 @@ func (c *Cluster) WaitContainer(id string) (int, error) {
 
 // AttachToContainer attaches to a container, using the given options.
 func (c *Cluster) AttachToContainer(opts docker.AttachToContainerOptions) error {
-	node, err := c.getNodeForContainer(opts.Container)
+	node, err := c.getNodeForContainer(context.Background(), opts.Container)
 	if err != nil {
 		return err
 	}
@@ -281,7 +409,7 @@ func (c *Cluster) AttachToContainer(opts docker.AttachToContainerOptions) error
 
 // AttachToContainerNonBlocking attaches to a container and returns a docker.CloseWaiter, using given options.
 func (c *Cluster) AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
-	node, err := c.getNodeForContainer(opts.Container)
+	node, err := c.getNodeForContainer(context.Background(), opts.Container)
 	if err != nil {
 		return nil, err
 	}
@@ -291,7 +419,8 @@ func (c *Cluster) AttachToContainerNonBlocking(opts docker.AttachToContainerOpti
 
 // Logs retrieves the logs of the specified container.
 func (c *Cluster) Logs(opts docker.LogsOptions) error {
-	node, err := c.getNodeForContainer(opts.Container)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, opts.Container)
 	if err != nil {
 		return err
 	}
@@ -300,7 +429,8 @@ func (c *Cluster) Logs(opts docker.LogsOptions) error {
 
 // CommitContainer commits a container and returns the image id.
 func (c *Cluster) CommitContainer(opts docker.CommitContainerOptions) (*docker.Image, error) {
-	node, err := c.getNodeForContainer(opts.Container)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, opts.Container)
 	if err != nil {
 		return nil, err
 	}
@@ -311,7 +441,7 @@ func (c *Cluster) CommitContainer(opts docker.CommitContainerOptions) (*docker.I
 	}
 	key := imageKey(opts.Repository, opts.Tag)
 	if key != "" {
-		err = c.storage().StoreImage(key, image.ID, node.addr)
+		err = c.storage().StoreImage(ctx, key, image.ID, node.addr)
 		if err != nil {
 			return nil, err
 		}
@@ -322,7 +452,8 @@ func (c *Cluster) CommitContainer(opts docker.CommitContainerOptions) (*docker.I
 // ExportContainer exports a container as a tar and writes
 // the result in out.
 func (c *Cluster) ExportContainer(opts docker.ExportContainerOptions) error {
-	node, err := c.getNodeForContainer(opts.ID)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, opts.ID)
 	if err != nil {
 		return err
 	}
@@ -332,7 +463,12 @@ func (c *Cluster) ExportContainer(opts docker.ExportContainerOptions) error {
 // TopContainer returns information about running processes inside a container
 // by its ID, getting the information from the right node.
 func (c *Cluster) TopContainer(id string, psArgs string) (docker.TopResult, error) {
-	node, err := c.getNodeForContainer(id)
+	return c.TopContainerWithContext(context.Background(), id, psArgs)
+}
+
+// TopContainerWithContext is like TopContainer, but using ctx to cancel the operation.
+func (c *Cluster) TopContainerWithContext(ctx context.Context, id string, psArgs string) (docker.TopResult, error) {
+	node, err := c.getNodeForContainer(ctx, id)
 	if err != nil {
 		return docker.TopResult{}, err
 	}
@@ -340,24 +476,25 @@ func (c *Cluster) TopContainer(id string, psArgs string) (docker.TopResult, erro
 	return result, wrapError(node, err)
 }
 
-func (c *Cluster) getNodeForContainer(container string) (node, error) {
-	addr, err := c.storage().RetrieveContainer(container)
+func (c *Cluster) getNodeForContainer(ctx context.Context, container string) (node, error) {
+	addr, err := c.storage().RetrieveContainer(ctx, container)
 	if err != nil {
 		return node{}, err
 	}
-	return c.getNodeByAddr(addr)
+	return c.getNodeByAddr(ctx, addr)
 }
 
-func (c *Cluster) getNodeForExec(execID string) (node, error) {
-	containerID, err := c.storage().RetrieveExec(execID)
+func (c *Cluster) getNodeForExec(ctx context.Context, execID string) (node, error) {
+	containerID, err := c.storage().RetrieveExec(ctx, execID)
 	if err != nil {
 		return node{}, err
 	}
-	return c.getNodeForContainer(containerID)
+	return c.getNodeForContainer(ctx, containerID)
 }
 
 func (c *Cluster) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
-	node, err := c.getNodeForContainer(opts.Container)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, opts.Container)
 	if err != nil {
 		return nil, err
 	}
@@ -365,12 +502,13 @@ func (c *Cluster) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error
 	if err != nil {
 		return nil, wrapError(node, err)
 	}
-	err = c.storage().StoreExec(exec.ID, opts.Container)
+	err = c.storage().StoreExec(ctx, exec.ID, opts.Container)
 	return exec, err
 }
 
 func (c *Cluster) StartExec(execId string, opts docker.StartExecOptions) error {
-	node, err := c.getNodeForExec(execId)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForExec(ctx, execId)
 	if err != nil {
 		return err
 	}
@@ -379,7 +517,12 @@ func (c *Cluster) StartExec(execId string, opts docker.StartExecOptions) error {
 }
 
 func (c *Cluster) ResizeExecTTY(execId string, height, width int) error {
-	node, err := c.getNodeForExec(execId)
+	return c.ResizeExecTTYWithContext(context.Background(), execId, height, width)
+}
+
+// ResizeExecTTYWithContext is like ResizeExecTTY, but using ctx to cancel the operation.
+func (c *Cluster) ResizeExecTTYWithContext(ctx context.Context, execId string, height, width int) error {
+	node, err := c.getNodeForExec(ctx, execId)
 	if err != nil {
 		return err
 	}
@@ -387,7 +530,12 @@ func (c *Cluster) ResizeExecTTY(execId string, height, width int) error {
 }
 
 func (c *Cluster) InspectExec(execId string) (*docker.ExecInspect, error) {
-	node, err := c.getNodeForExec(execId)
+	return c.InspectExecWithContext(context.Background(), execId)
+}
+
+// InspectExecWithContext is like InspectExec, but using ctx to cancel the operation.
+func (c *Cluster) InspectExecWithContext(ctx context.Context, execId string) (*docker.ExecInspect, error) {
+	node, err := c.getNodeForExec(ctx, execId)
 	if err != nil {
 		return nil, err
 	}
@@ -399,7 +547,8 @@ func (c *Cluster) InspectExec(execId string) (*docker.ExecInspect, error) {
 }
 
 func (c *Cluster) UploadToContainer(containerId string, opts docker.UploadToContainerOptions) error {
-	node, err := c.getNodeForContainer(containerId)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, containerId)
 	if err != nil {
 		return err
 	}
@@ -407,7 +556,8 @@ func (c *Cluster) UploadToContainer(containerId string, opts docker.UploadToCont
 }
 
 func (c *Cluster) DownloadFromContainer(containerId string, opts docker.DownloadFromContainerOptions) error {
-	node, err := c.getNodeForContainer(containerId)
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, containerId)
 	if err != nil {
 		return err
 	}
@@ -415,7 +565,12 @@ func (c *Cluster) DownloadFromContainer(containerId string, opts docker.Download
 }
 
 func (c *Cluster) ResizeContainerTTY(containerId string, height, width int) error {
-	node, err := c.getNodeForContainer(containerId)
+	return c.ResizeContainerTTYWithContext(context.Background(), containerId, height, width)
+}
+
+// ResizeContainerTTYWithContext is like ResizeContainerTTY, but using ctx to cancel the operation.
+func (c *Cluster) ResizeContainerTTYWithContext(ctx context.Context, containerId string, height, width int) error {
+	node, err := c.getNodeForContainer(ctx, containerId)
 	if err != nil {
 		return err
 	}
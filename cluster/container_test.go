@@ -55,7 +55,7 @@ func TestCreateContainer(t *testing.T) {
 	if container.ID != "e90302" {
 		t.Errorf("CreateContainer: wrong container ID. Want %q. Got %q.", "e90302", container.ID)
 	}
-	img, err := cluster.storage().RetrieveImage("myhost/somwhere/myimg")
+	img, err := cluster.storage().RetrieveImage(context.Background(), "myhost/somwhere/myimg")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -392,7 +392,7 @@ func TestCreateContainerSpecifyNode(t *testing.T) {
 	if container.ID != "e90303" {
 		t.Errorf("CreateContainer: wrong container ID. Want %q. Got %q.", "e90303", container.ID)
 	}
-	host, _ := storage.RetrieveContainer("e90303")
+	host, _ := storage.RetrieveContainer(context.Background(), "e90303")
 	if host != server2.URL {
 		t.Errorf("Cluster.CreateContainer() with storage: wrong data. Want %#v. Got %#v.", server2.URL, host)
 	}
@@ -504,7 +504,7 @@ func TestCreateContainerWithStorage(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	host, _ := storage.RetrieveContainer("e90302")
+	host, _ := storage.RetrieveContainer(context.Background(), "e90302")
 	if host != server1.URL {
 		t.Errorf("Cluster.CreateContainer() with storage: wrong data. Want %#v. Got %#v.", server1.URL, host)
 	}
@@ -674,6 +674,115 @@ func TestCreateContainerNetworkFailureInHook(t *testing.T) {
 	}
 }
 
+func TestCreateContainerHookPayload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Id":"e90301"}`))
+	}))
+	defer server.Close()
+	cluster, err := New(firstNodeScheduler{}, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []HookEvent
+	var payloads []HookPayload
+	hook := HookFunc(func(evt HookEvent, payload *HookPayload) error {
+		events = append(events, evt)
+		payloads = append(payloads, *payload)
+		return nil
+	})
+	cluster.AddHook(HookEventBeforeContainerCreate, hook)
+	cluster.AddHook(HookEventAfterContainerCreate, hook)
+	opts := docker.CreateContainerOptions{Name: "mycont", Config: &docker.Config{Image: "myimg"}}
+	_, _, err = cluster.CreateContainer(opts, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEvents := []HookEvent{HookEventBeforeContainerCreate, HookEventAfterContainerCreate}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Fatalf("CreateContainer: wrong hook events. Want %v. Got %v.", expectedEvents, events)
+	}
+	for i, payload := range payloads {
+		if payload.Node == nil || payload.Node.Address != server.URL {
+			t.Errorf("CreateContainer: wrong node in payload %d: %#v", i, payload.Node)
+		}
+		if payload.CreateOptions == nil || payload.CreateOptions.Name != "mycont" {
+			t.Errorf("CreateContainer: wrong create options in payload %d: %#v", i, payload.CreateOptions)
+		}
+	}
+	if payloads[0].ContainerID != "" {
+		t.Errorf("CreateContainer: unexpected container ID before create: %q", payloads[0].ContainerID)
+	}
+	if payloads[1].ContainerID != "e90301" {
+		t.Errorf("CreateContainer: wrong container ID after create. Want %q. Got %q.", "e90301", payloads[1].ContainerID)
+	}
+}
+
+func TestCreateContainerAfterHookFailureRemovesContainer(t *testing.T) {
+	var removed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/containers/e90301") {
+			removed = r.URL.Query().Get("force") == "1"
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Id":"e90301"}`))
+	}))
+	defer server.Close()
+	storage := &MapStorage{}
+	cluster, err := New(firstNodeScheduler{}, storage, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.AddHook(HookEventAfterContainerCreate, HookFunc(func(evt HookEvent, payload *HookPayload) error {
+		return fmt.Errorf("my hook err")
+	}))
+	_, container, err := cluster.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "myimg"}}, time.Minute)
+	if err == nil || err.Error() != "my hook err" {
+		t.Fatalf("CreateContainer: expected hook error. Got %v.", err)
+	}
+	if container != nil {
+		t.Errorf("CreateContainer: expected nil container. Got %#v.", container)
+	}
+	if !removed {
+		t.Error("CreateContainer: container should have been removed after the hook failure")
+	}
+	_, err = storage.RetrieveContainer(context.Background(), "e90301")
+	if err != cstorage.ErrNoSuchContainer {
+		t.Errorf("CreateContainer: container shouldn't be stored. Got %v.", err)
+	}
+}
+
+func TestCreateContainerHookFailureLogged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Id":"e90301"}`))
+	}))
+	defer server.Close()
+	cluster, err := New(firstNodeScheduler{}, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	hook := HookFunc(func(evt HookEvent, payload *HookPayload) error {
+		calls++
+		return fmt.Errorf("my hook err")
+	})
+	cluster.AddHookWithPolicy(HookEventBeforeContainerCreate, hook, HookFailureLog)
+	cluster.AddHookWithPolicy(HookEventAfterContainerCreate, hook, HookFailureLog)
+	addr, container, err := cluster.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "myimg"}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("CreateContainer: expected hooks to be called twice. Got %d.", calls)
+	}
+	if addr != server.URL || container.ID != "e90301" {
+		t.Errorf("CreateContainer: wrong result. Got %q and %#v.", addr, container)
+	}
+}
+
 func TestCreateContainerContextDone(t *testing.T) {
 	body := `{"Id":"e90302"}`
 	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -710,7 +819,7 @@ func TestInspectContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "e90302"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -811,7 +920,7 @@ func TestKillContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -844,7 +953,7 @@ func TestKillContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -877,6 +986,33 @@ func TestKillContainerNotFoundWithStorage(t *testing.T) {
 	}
 }
 
+func TestInspectContainerWithContext(t *testing.T) {
+	server, release := blockingServer()
+	defer server.Close()
+	defer close(release)
+	cluster, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := "a2033"
+	cluster.storage().StoreContainer(context.Background(), id, server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := cluster.InspectContainerWithContext(ctx, id)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Errorf("InspectContainerWithContext(%q): Expected non-nil error, got <nil>", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("InspectContainerWithContext(%q): call not canceled", id)
+	}
+}
+
 func TestListContainers(t *testing.T) {
 	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body := `[
@@ -982,6 +1118,71 @@ func TestListContainersFailure(t *testing.T) {
 	}
 }
 
+func TestListContainersCanceledContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"Id":"8dfafdbc3a40"}]`))
+	}))
+	defer server.Close()
+	cluster, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	containers, err := cluster.ListContainers(docker.ListContainersOptions{Context: ctx})
+	if err != context.Canceled {
+		t.Errorf("ListContainers: Want %#v. Got %#v.", context.Canceled, err)
+	}
+	if len(containers) != 0 {
+		t.Errorf("ListContainers: Expected no containers, got %#v.", containers)
+	}
+}
+
+func TestListContainersPerNode(t *testing.T) {
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"Id":"8dfafdbc3a40"}]`))
+	}))
+	defer server1.Close()
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal failure", http.StatusInternalServerError)
+	}))
+	defer server2.Close()
+	disabled := Node{Address: "http://disabled:4243", Metadata: map[string]string{
+		"DisabledUntil": time.Now().Add(time.Minute).Format(time.RFC3339),
+	}}
+	cluster, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL},
+		Node{Address: server2.URL},
+		disabled,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := cluster.ListContainersPerNode(docker.ListContainersOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]docker.APIContainers{server1.URL: {{ID: "8dfafdbc3a40"}}}
+	if !reflect.DeepEqual(result.Containers, expected) {
+		t.Errorf("ListContainersPerNode: Want %#v. Got %#v.", expected, result.Containers)
+	}
+	if len(result.Errors) != 1 || result.Errors[server2.URL] == nil {
+		t.Errorf("ListContainersPerNode: wrong errors: %#v", result.Errors)
+	}
+	if !reflect.DeepEqual(result.Skipped, []string{disabled.Address}) {
+		t.Errorf("ListContainersPerNode: wrong skipped nodes: %#v", result.Skipped)
+	}
+	multiErr, ok := result.Err().(*MultiNodeError)
+	if !ok || multiErr.Nodes != 2 || len(multiErr.Errors) != 1 {
+		t.Errorf("ListContainersPerNode: wrong error: %#v", result.Err())
+	}
+	if !strings.HasPrefix(result.Err().Error(), "Unable to list containers in 1 of 2 nodes: "+server2.URL) {
+		t.Errorf("ListContainersPerNode: wrong error message: %q", result.Err())
+	}
+}
+
 func TestListContainersSchedulerFailure(t *testing.T) {
 	cluster, err := New(nil, &failingStorage{}, "")
 	if err != nil {
@@ -1010,7 +1211,7 @@ func TestRemoveContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1030,6 +1231,38 @@ func TestRemoveContainer(t *testing.T) {
 	}
 }
 
+func TestRemoveContainerAfterHook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	storage := &MapStorage{}
+	err := storage.StoreContainer(context.Background(), "abc123", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := New(nil, storage, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload *HookPayload
+	cluster.AddHook(HookEventAfterContainerRemove, HookFunc(func(evt HookEvent, p *HookPayload) error {
+		payload = p
+		return fmt.Errorf("my hook err")
+	}))
+	err = cluster.RemoveContainer(docker.RemoveContainerOptions{ID: "abc123"})
+	if err == nil || err.Error() != "my hook err" {
+		t.Errorf("RemoveContainer: expected hook error. Got %v.", err)
+	}
+	if payload == nil || payload.ContainerID != "abc123" || payload.Node.Address != server.URL {
+		t.Fatalf("RemoveContainer: wrong hook payload: %#v", payload)
+	}
+	_, err = storage.RetrieveContainer(context.Background(), "abc123")
+	if err != cstorage.ErrNoSuchContainer {
+		t.Errorf("RemoveContainer: container should be removed from storage. Got %v.", err)
+	}
+}
+
 func TestRemoveContainerWithStorage(t *testing.T) {
 	var called bool
 	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1043,7 +1276,7 @@ func TestRemoveContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1061,7 +1294,7 @@ func TestRemoveContainerWithStorage(t *testing.T) {
 	if called {
 		t.Errorf("RemoveContainer(%q): should not call the node server", id)
 	}
-	_, err = storage.RetrieveContainer(id)
+	_, err = storage.RetrieveContainer(context.Background(), id)
 	if err == nil {
 		t.Errorf("RemoveContainer(%q): should remove the container from the storage", id)
 	}
@@ -1089,7 +1322,7 @@ func TestRemoveContainerNotFoundInServer(t *testing.T) {
 	defer server1.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server1.URL)
+	err := storage.StoreContainer(context.Background(), id, server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1106,7 +1339,7 @@ func TestRemoveContainerNotFoundInServer(t *testing.T) {
 	if !called {
 		t.Errorf("RemoveContainer(%q): Did not call node HTTP server", id)
 	}
-	_, err = storage.RetrieveContainer(id)
+	_, err = storage.RetrieveContainer(context.Background(), id)
 	if err == nil {
 		t.Errorf("RemoveContainer(%q): should remove the container from the storage", id)
 	}
@@ -1121,7 +1354,7 @@ func TestRemoveContainerServerError(t *testing.T) {
 	defer server1.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server1.URL)
+	err := storage.StoreContainer(context.Background(), id, server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1138,7 +1371,7 @@ func TestRemoveContainerServerError(t *testing.T) {
 	if !called {
 		t.Errorf("RemoveContainer(%q): Did not call node HTTP server", id)
 	}
-	addr, err := storage.RetrieveContainer(id)
+	addr, err := storage.RetrieveContainer(context.Background(), id)
 	if err != nil || addr != server1.URL {
 		t.Errorf("RemoveContainer(%q): should not remove the container from the storage", id)
 	}
@@ -1157,7 +1390,7 @@ func TestStartContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1202,7 +1435,7 @@ func TestStartContainerErrorNotFound(t *testing.T) {
 	defer server1.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server1.URL)
+	err := storage.StoreContainer(context.Background(), id, server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1240,7 +1473,7 @@ func TestStartContainerErrorAlreadyRunning(t *testing.T) {
 	defer server1.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server1.URL)
+	err := storage.StoreContainer(context.Background(), id, server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1278,7 +1511,7 @@ func TestStartContainerErrorOther(t *testing.T) {
 	defer server1.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server1.URL)
+	err := storage.StoreContainer(context.Background(), id, server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1320,7 +1553,7 @@ func TestStartContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1366,7 +1599,7 @@ func TestStopContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1399,7 +1632,7 @@ func TestStopContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1445,7 +1678,7 @@ func TestRestartContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1478,7 +1711,7 @@ func TestRestartContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1528,7 +1761,7 @@ func TestPauseContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1565,7 +1798,7 @@ func TestPauseContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1615,7 +1848,7 @@ func TestUnpauseContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1652,7 +1885,7 @@ func TestUnpauseContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1697,7 +1930,7 @@ func TestWaitContainer(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1759,7 +1992,7 @@ func TestWaitContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1813,7 +2046,7 @@ func TestAttachToContainer(t *testing.T) {
 	}))
 	defer server2.Close()
 	storage := &MapStorage{}
-	err := storage.StoreContainer("abcdef", server2.URL)
+	err := storage.StoreContainer(context.Background(), "abcdef", server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1852,7 +2085,7 @@ func TestAttachToContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abcdef"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1906,7 +2139,7 @@ func TestAttachToContainerNonBlocking(t *testing.T) {
 	}))
 	defer server.Close()
 	storage := MapStorage{}
-	err := storage.StoreContainer("abc", server.URL)
+	err := storage.StoreContainer(context.Background(), "abc", server.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1944,7 +2177,7 @@ func TestLogs(t *testing.T) {
 	}))
 	defer server2.Close()
 	storage := &MapStorage{}
-	err := storage.StoreContainer("abcdef", server2.URL)
+	err := storage.StoreContainer(context.Background(), "abcdef", server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1983,7 +2216,7 @@ func TestLogsWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abcdef"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2040,7 +2273,7 @@ func TestCommitContainer(t *testing.T) {
 	}))
 	defer server2.Close()
 	storage := &MapStorage{}
-	err := storage.StoreContainer("abcdef", server2.URL)
+	err := storage.StoreContainer(context.Background(), "abcdef", server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2102,7 +2335,7 @@ func TestCommitContainerWithStorage(t *testing.T) {
 	defer server2.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server2.URL)
+	err := storage.StoreContainer(context.Background(), id, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2124,7 +2357,7 @@ func TestCommitContainerWithStorage(t *testing.T) {
 	if called {
 		t.Errorf("CommitContainer(%q): should not call the all node servers.", id)
 	}
-	img, _ := storage.RetrieveImage("tsuru/python")
+	img, _ := storage.RetrieveImage(context.Background(), "tsuru/python")
 	if img.LastNode != server2.URL {
 		t.Errorf("CommitContainer(%q): wrong image last node in the storage. Want %q. Got %q", id, server2.URL, img.LastNode)
 	}
@@ -2137,7 +2370,7 @@ func TestCommitContainerWithStorageAndImageID(t *testing.T) {
 	defer server.Close()
 	id := "abc123"
 	stor := MapStorage{}
-	err := stor.StoreContainer(id, server.URL)
+	err := stor.StoreContainer(context.Background(), id, server.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2150,7 +2383,7 @@ func TestCommitContainerWithStorageAndImageID(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = stor.RetrieveImage(image.ID)
+	_, err = stor.RetrieveImage(context.Background(), image.ID)
 	if err != cstorage.ErrNoSuchImage {
 		t.Errorf("CommitContainer(%q): Expected no such image error, got: %s", id, err)
 	}
@@ -2179,11 +2412,11 @@ func TestCommitContainerTagShouldIgnoreRemoveImageErrors(t *testing.T) {
 	defer server.Close()
 	containerId := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(containerId, server.URL)
+	err := storage.StoreContainer(context.Background(), containerId, server.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = storage.StoreImage(imgTag, "id1", "http://invalid.invalid")
+	err = storage.StoreImage(context.Background(), imgTag, "id1", "http://invalid.invalid")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2199,7 +2432,7 @@ func TestCommitContainerTagShouldIgnoreRemoveImageErrors(t *testing.T) {
 	if image.ID != expectedImageId {
 		t.Fatalf("Expected image id to be %q, got: %q", expectedImageId, image.ID)
 	}
-	img, _ := storage.RetrieveImage(imgTag)
+	img, _ := storage.RetrieveImage(context.Background(), imgTag)
 	if img.LastNode != server.URL {
 		t.Errorf("CommitContainer(%q): wrong image last node in the storage. Want %q. Got %q", containerId, server.URL, img.LastNode)
 	}
@@ -2212,7 +2445,7 @@ func TestCommitContainerWithRepositoryAndTag(t *testing.T) {
 	defer server1.Close()
 	id := "abc123"
 	storage := MapStorage{}
-	err := storage.StoreContainer(id, server1.URL)
+	err := storage.StoreContainer(context.Background(), id, server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2230,7 +2463,7 @@ func TestCommitContainerWithRepositoryAndTag(t *testing.T) {
 	if image.ID != "596069db4bf5" {
 		t.Errorf("CommitContainer: the image container is %s, expected: '596069db4bf5'", image.ID)
 	}
-	img, err := storage.RetrieveImage("tsuru/python:v1")
+	img, err := storage.RetrieveImage(context.Background(), "tsuru/python:v1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2247,7 +2480,7 @@ func TestExportContainer(t *testing.T) {
 	defer server.Close()
 	containerID := "3e2f21a89f"
 	storage := &MapStorage{}
-	err := storage.StoreContainer(containerID, server.URL)
+	err := storage.StoreContainer(context.Background(), containerID, server.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2302,10 +2535,10 @@ func TestExportContainerNoStorage(t *testing.T) {
 
 func TestGetNodeForContainer(t *testing.T) {
 	var storage MapStorage
-	storage.StoreContainer("e90301", "http://localhost:4242")
-	storage.StoreContainer("e90304", "http://localhost:4242")
-	storage.StoreContainer("e90303", "http://localhost:4241")
-	storage.StoreContainer("e90302", "http://another")
+	storage.StoreContainer(context.Background(), "e90301", "http://localhost:4242")
+	storage.StoreContainer(context.Background(), "e90304", "http://localhost:4242")
+	storage.StoreContainer(context.Background(), "e90303", "http://localhost:4241")
+	storage.StoreContainer(context.Background(), "e90302", "http://another")
 	cluster, err := New(nil, &storage, "",
 		Node{Address: "http://localhost:4243"},
 		Node{Address: "http://localhost:4242"},
@@ -2314,21 +2547,21 @@ func TestGetNodeForContainer(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	node, err := cluster.getNodeForContainer("e90302")
+	node, err := cluster.getNodeForContainer(context.Background(), "e90302")
 	if err != nil {
 		t.Error(err)
 	}
 	if node.addr != "http://another" {
 		t.Errorf("cluster.getNode(%q): wrong node. Want %q. Got %q.", "e90302", "http://another", node.addr)
 	}
-	node, err = cluster.getNodeForContainer("e90301")
+	node, err = cluster.getNodeForContainer(context.Background(), "e90301")
 	if err != nil {
 		t.Error(err)
 	}
 	if node.addr != "http://localhost:4242" {
 		t.Errorf("cluster.getNode(%q): wrong node. Want %q. Got %q.", "e90301", "http://localhost:4242", node.addr)
 	}
-	_, err = cluster.getNodeForContainer("e90305")
+	_, err = cluster.getNodeForContainer(context.Background(), "e90305")
 	expected := cstorage.ErrNoSuchContainer
 	if !reflect.DeepEqual(err, expected) {
 		t.Errorf("cluster.getNode(%q): wrong error. Want %#v. Got %#v.", "e90305", expected, err)
@@ -2337,7 +2570,7 @@ func TestGetNodeForContainer(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = cluster.getNodeForContainer("e90301")
+	_, err = cluster.getNodeForContainer(context.Background(), "e90301")
 	expectedMsg := "storage error"
 	if err.Error() != expectedMsg {
 		t.Errorf("cluster.getNode(%q): wrong error. Want %q. Got %q.", "e90301", expectedMsg, err.Error())
@@ -2447,7 +2680,7 @@ func TestInspectExec(t *testing.T) {
 	defer server2.Close()
 	contId := "e90302"
 	storage := MapStorage{}
-	err := storage.StoreContainer(contId, server2.URL)
+	err := storage.StoreContainer(context.Background(), contId, server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,157 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Cordon marks the node as unschedulable. Cordoned nodes are not returned by
+// SchedulableNodes, so the schedulers won't place new containers in them, and
+// they're not used by default when creating volumes or distributing images.
+// They're still enabled, so their containers and images are listed as usual,
+// and they stay cordoned until Uncordon is called.
+func (c *Cluster) Cordon(address string) error {
+	return c.CordonWithContext(context.Background(), address)
+}
+
+// CordonWithContext is like Cordon, but using ctx to cancel the operation.
+func (c *Cluster) CordonWithContext(ctx context.Context, address string) error {
+	return c.setCordoned(ctx, address, true)
+}
+
+// Uncordon makes a cordoned node schedulable again.
+func (c *Cluster) Uncordon(address string) error {
+	return c.UncordonWithContext(context.Background(), address)
+}
+
+// UncordonWithContext is like Uncordon, but using ctx to cancel the operation.
+func (c *Cluster) UncordonWithContext(ctx context.Context, address string) error {
+	return c.setCordoned(ctx, address, false)
+}
+
+func (c *Cluster) setCordoned(ctx context.Context, address string, cordoned bool) error {
+	value, event := "", EventNodeUncordoned
+	if cordoned {
+		value, event = "true", EventNodeCordoned
+	}
+	_, err := c.AtomicUpdateNodeWithContext(ctx, address, func(n Node) (Node, error) {
+		return Node{Metadata: map[string]string{cordonedMetadataKey: value}}, nil
+	})
+	if err != nil {
+		return err
+	}
+	c.emitNodeEvent(event, address)
+	return nil
+}
+
+const (
+	DrainActionMigrated = "migrated"
+	DrainActionStopped  = "stopped"
+)
+
+// DrainOptions are the options for Drain.
+type DrainOptions struct {
+	// Stop makes Drain stop the containers in the node, instead of migrating
+	// them to other nodes.
+	Stop bool
+
+	// StopTimeout is the number of seconds to wait for each container to
+	// stop before killing it.
+	StopTimeout uint
+
+	// SchedulerOpts are given to the scheduler when choosing the node for
+	// each migrated container.
+	SchedulerOpts SchedulerOptions
+
+	// Progress, if set, is called after each container is handled.
+	Progress func(DrainResult)
+
+	// Context, if set, stops the drain when cancelled, leaving the
+	// remaining containers in the node.
+	Context context.Context
+}
+
+// DrainResult is the outcome of draining one container.
+type DrainResult struct {
+	// Container is the ID of the container in the drained node.
+	Container string
+
+	// Action is DrainActionMigrated or DrainActionStopped.
+	Action string
+
+	// NewContainer and Node are the ID and the node of the migrated
+	// container.
+	NewContainer string
+	Node         string
+
+	Err error
+}
+
+// Drain cordons the node and then migrates, or stops, every container the
+// cluster tracks in it, one at a time. Failing containers don't stop the
+// drain: every container is handled and reported in the results, and an
+// error is returned at the end if any of them failed. The node is kept
+// cordoned.
+func (c *Cluster) Drain(address string, opts DrainOptions) ([]DrainResult, error) {
+	ctx := optsContext(opts.Context)
+	err := c.CordonWithContext(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := c.storage().RetrieveContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []DrainResult
+	var failures int
+	for _, cont := range containers {
+		if cont.Host != address {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return results, err
+		}
+		result := DrainResult{Container: cont.Id}
+		if opts.Stop {
+			result.Action = DrainActionStopped
+			result.Err = c.StopContainerWithContext(ctx, cont.Id, opts.StopTimeout)
+			if result.Err != nil {
+				if nodeErr, ok := result.Err.(DockerNodeError); ok {
+					if _, notRunning := nodeErr.BaseError().(*docker.ContainerNotRunning); notRunning {
+						result.Err = nil
+					}
+				}
+			}
+		} else {
+			result.Action = DrainActionMigrated
+			var newCont *docker.Container
+			newCont, result.Err = c.MigrateContainerOpts(MigrateContainerOptions{
+				ID:            cont.Id,
+				SchedulerOpts: opts.SchedulerOpts,
+				StopTimeout:   opts.StopTimeout,
+				Context:       ctx,
+			})
+			if newCont != nil {
+				result.NewContainer = newCont.ID
+				result.Node, _ = c.storage().RetrieveContainer(ctx, newCont.ID)
+			}
+		}
+		if result.Err != nil {
+			failures++
+		}
+		results = append(results, result)
+		if opts.Progress != nil {
+			opts.Progress(result)
+		}
+	}
+	if failures > 0 {
+		return results, fmt.Errorf("Unable to drain %d of %d containers in node %q", failures, len(results), address)
+	}
+	return results, nil
+}
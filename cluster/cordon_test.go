@@ -0,0 +1,291 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+)
+
+func TestCordonAndUncordon(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "",
+		Node{Address: "http://n1:4243", Metadata: map[string]string{"pool": "prod"}},
+		Node{Address: "http://n2:4243"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	events := c.Events(done)
+	err = c.Cordon("http://n1:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, events, EventNodeCordoned)
+	nodes, err := c.SchedulableNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Address != "http://n2:4243" {
+		t.Errorf("Cordon: expected only n2 to be schedulable, got %#v", nodes)
+	}
+	nodes, err = c.Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Errorf("Cordon: expected cordoned node to stay enabled, got %#v", nodes)
+	}
+	node, err := c.GetNode("http://n1:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !node.IsCordoned() || node.Status() != NodeStatusCordoned {
+		t.Errorf("Cordon: expected node to be cordoned, got status %q", node.Status())
+	}
+	if meta := node.CleanMetadata(); !reflect.DeepEqual(meta, map[string]string{"pool": "prod"}) {
+		t.Errorf("Cordon: wrong clean metadata %#v", meta)
+	}
+	err = c.handleNodeSuccess("http://n1:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ = c.GetNode("http://n1:4243")
+	if !node.IsCordoned() {
+		t.Error("Cordon: expected node to stay cordoned after a success")
+	}
+	err = c.Uncordon("http://n1:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, events, EventNodeUncordoned)
+	nodes, err = c.SchedulableNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Errorf("Uncordon: expected 2 nodes, got %#v", nodes)
+	}
+	node, _ = c.GetNode("http://n1:4243")
+	if node.IsCordoned() || node.Status() != NodeStatusReady {
+		t.Errorf("Uncordon: expected node to be ready, got status %q", node.Status())
+	}
+}
+
+func TestCordonKeepsContainersListed(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}}
+	_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Config: &config}, time.Minute, server1.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StartContainer(cont.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Cordon(server1.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers, err := c.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containers[0].ID != cont.ID {
+		t.Errorf("ListContainers: expected container in cordoned node to be listed, got %#v", containers)
+	}
+	for i := 0; i < 2; i++ {
+		addr, _, err := c.CreateContainer(docker.CreateContainerOptions{Config: &config}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != server2.URL() {
+			t.Errorf("CreateContainer: expected container in %s, got %s", server2.URL(), addr)
+		}
+	}
+}
+
+func TestCordonUnknownNode(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Cordon("http://unknown:4243")
+	if err == nil {
+		t.Error("Cordon: expected error for unknown node, got <nil>")
+	}
+}
+
+func TestDrainMigrate(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	var ids []string
+	for i := 0; i < 2; i++ {
+		config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}}
+		_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Config: &config}, time.Minute, server1.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.StartContainer(cont.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, cont.ID)
+	}
+	var progress []DrainResult
+	results, err := c.Drain(server1.URL(), DrainOptions{
+		Progress: func(r DrainResult) { progress = append(progress, r) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Drain: expected 2 results, got %#v", results)
+	}
+	if !reflect.DeepEqual(progress, results) {
+		t.Errorf("Drain: progress and results differ.\nProgress: %#v.\nResults:  %#v.", progress, results)
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Action != DrainActionMigrated || r.Node != server2.URL() || r.NewContainer == "" {
+			t.Errorf("Drain: unexpected result %#v", r)
+		}
+		if r.Container != ids[0] && r.Container != ids[1] {
+			t.Errorf("Drain: unexpected container %q", r.Container)
+		}
+	}
+	containers, err := c.storage().RetrieveContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cont := range containers {
+		if cont.Host != server2.URL() {
+			t.Errorf("Drain: container %q still in the drained node", cont.Id)
+		}
+	}
+	node, _ := c.GetNode(server1.URL())
+	if !node.IsCordoned() {
+		t.Error("Drain: expected node to be cordoned")
+	}
+}
+
+func TestDrainStop(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	var ids []string
+	for i := 0; i < 2; i++ {
+		config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}}
+		_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Config: &config}, time.Minute, server1.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.StartContainer(cont.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, cont.ID)
+	}
+	results, err := c.Drain(server1.URL(), DrainOptions{Stop: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Drain: expected 2 results, got %#v", results)
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Action != DrainActionStopped {
+			t.Errorf("Drain: unexpected result %#v", r)
+		}
+	}
+	for _, id := range ids {
+		cont, err := c.InspectContainer(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cont.State.Running {
+			t.Errorf("Drain: expected container %q to be stopped", id)
+		}
+	}
+}
+
+func TestDrainReportsFailures(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	for i := 0; i < 2; i++ {
+		config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}}
+		_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Config: &config}, time.Minute, server1.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.StartContainer(cont.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server2.PrepareFailure("create-error", "/containers/create")
+	results, err := c.Drain(server1.URL(), DrainOptions{})
+	if err == nil {
+		t.Fatal("Drain: expected error, got <nil>")
+	}
+	if len(results) != 2 {
+		t.Fatalf("Drain: expected 2 results, got %#v", results)
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			t.Errorf("Drain: expected error in result %#v", r)
+		}
+	}
+	containers, err := c.storage().RetrieveContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cont := range containers {
+		if cont.Host != server1.URL() {
+			t.Errorf("Drain: container %q should have stayed in the drained node", cont.Id)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+// ErrImageDigestMismatch is the error returned, wrapped in a DockerNodeError,
+// when the image in a node doesn't match the pinned digest.
+var ErrImageDigestMismatch = errors.New("Image in node doesn't match the pinned digest")
+
+// pinnedImage returns the reference to the image being pulled by its stored
+// digest, or an empty string when digest pinning is disabled, the image is
+// already referenced by digest or there is no stored digest for it.
+func (c *Cluster) pinnedImage(ctx context.Context, pullOpts docker.PullImageOptions) (string, error) {
+	if !c.PinImageDigests || strings.Contains(pullOpts.Repository, "@") {
+		return "", nil
+	}
+	key := imageKey(pullOpts.Repository, pullOpts.Tag)
+	img, err := c.storage().RetrieveImage(ctx, key)
+	if err == storage.ErrNoSuchImage {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if img.LastDigest == "" {
+		return "", nil
+	}
+	return imageRepository(key) + "@" + img.LastDigest, nil
+}
+
+// pullPinnedImage pulls the image by its pinned reference in the node and
+// checks that the node has the image with the pinned digest, recording it in
+// the storage under the tagged name.
+func (c *Cluster) pullPinnedImage(ctx context.Context, pullOpts docker.PullImageOptions, auth docker.AuthConfiguration, pinned, nodeAddress string) error {
+	n, err := c.getNodeByAddr(ctx, nodeAddress)
+	if err != nil {
+		return err
+	}
+	n.setPersistentClient()
+	key := imageKey(pullOpts.Repository, pullOpts.Tag)
+	pullOpts.Repository = pinned
+	pullOpts.Tag = ""
+	start := time.Now()
+	pullErr := n.PullImage(pullOpts, auth)
+	c.metrics().PullImageDuration(n.addr, time.Since(start), pullErr)
+	if pullErr != nil {
+		// As in createContainerInNode, images without a registry may
+		// exist only in the nodes.
+		if registryServer, _ := parseImageRegistry(pinned); registryServer != "" {
+			return wrapError(n, pullErr)
+		}
+	}
+	img, err := n.InspectImage(pinned)
+	if err == docker.ErrNoSuchImage {
+		return wrapError(n, ErrImageDigestMismatch)
+	}
+	if err != nil {
+		return wrapError(n, err)
+	}
+	if len(img.RepoDigests) > 0 && !containsString(img.RepoDigests, pinned) {
+		return wrapError(n, ErrImageDigestMismatch)
+	}
+	err = c.storage().StoreImage(ctx, key, img.ID, n.addr)
+	if err != nil || pullErr != nil {
+		// Images found in the node without being pulled don't
+		// trigger HookEventAfterImagePull.
+		return err
+	}
+	return c.runHookForAddr(ctx, HookEventAfterImagePull, n.addr, HookPayload{Image: pinned})
+}
+
+// imageRepository returns the image name without its tag.
+func imageRepository(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	pinnedRepo   = "localhost:5000/tsuru/python"
+	pinnedDigest = "sha256:4a2b1e"
+)
+
+// digestNode is a fake docker node that has the image pulled by digest when
+// hasDigest is set, recording the pulled images and the image of the created
+// containers.
+type digestNode struct {
+	sync.Mutex
+	hasDigest bool
+	pulled    []string
+	created   []string
+}
+
+func (n *digestNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.Lock()
+	defer n.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/images/create":
+		from := r.URL.Query().Get("fromImage")
+		if tag := r.URL.Query().Get("tag"); strings.HasPrefix(tag, "sha256:") {
+			from += "@" + tag
+		} else if tag != "" {
+			from += ":" + tag
+		}
+		n.pulled = append(n.pulled, from)
+		w.Write([]byte(`{"status":"Digest: sha256:99ff00"}`))
+	case r.URL.Path == "/images/"+pinnedRepo+"@"+pinnedDigest+"/json":
+		if !n.hasDigest {
+			http.Error(w, "no such image", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Id":          "id1",
+			"RepoDigests": []string{pinnedRepo + "@" + pinnedDigest},
+		})
+	case strings.HasPrefix(r.URL.Path, "/images/"):
+		w.Write([]byte(`{"Id":"id2"}`))
+	case r.URL.Path == "/containers/create":
+		var config docker.Config
+		json.NewDecoder(r.Body).Decode(&config)
+		n.created = append(n.created, config.Image)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"c1"}`))
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestCreateContainerPinnedDigest(t *testing.T) {
+	fake := &digestNode{hasDigest: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	c.PinImageDigests = true
+	err := c.storage().StoreImage(context.Background(), pinnedRepo+":v1", "id1", "http://other:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.storage().SetImageDigest(context.Background(), pinnedRepo+":v1", pinnedDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := docker.Config{Image: pinnedRepo + ":v1"}
+	_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Config: &config}, 0, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cont.ID != "c1" {
+		t.Errorf("CreateContainer: wrong container %#v", cont)
+	}
+	pinned := pinnedRepo + "@" + pinnedDigest
+	if !reflect.DeepEqual(fake.pulled, []string{pinned}) {
+		t.Errorf("CreateContainer: wrong pulls. Want %#v. Got %#v.", []string{pinned}, fake.pulled)
+	}
+	if !reflect.DeepEqual(fake.created, []string{pinned}) {
+		t.Errorf("CreateContainer: wrong container image. Want %#v. Got %#v.", []string{pinned}, fake.created)
+	}
+	if config.Image != pinnedRepo+":v1" {
+		t.Errorf("CreateContainer: the given config should not be changed, got image %q", config.Image)
+	}
+	img, err := c.storage().RetrieveImage(context.Background(), pinnedRepo+":v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.LastDigest != pinnedDigest || img.LastNode != server.URL || img.LastId != "id1" {
+		t.Errorf("CreateContainer: wrong stored image %#v", img)
+	}
+}
+
+func TestCreateContainerPinnedDigestMismatch(t *testing.T) {
+	fake := &digestNode{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	c.PinImageDigests = true
+	err := c.storage().StoreImage(context.Background(), pinnedRepo+":v1", "id1", "http://other:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.storage().SetImageDigest(context.Background(), pinnedRepo+":v1", pinnedDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := docker.Config{Image: pinnedRepo + ":v1"}
+	_, _, err = c.CreateContainer(docker.CreateContainerOptions{Config: &config}, 0, server.URL)
+	nodeErr, ok := err.(DockerNodeError)
+	if !ok || nodeErr.BaseError() != ErrImageDigestMismatch {
+		t.Fatalf("CreateContainer: want digest mismatch error, got %#v", err)
+	}
+	if len(fake.created) != 0 {
+		t.Errorf("CreateContainer: expected no containers created, got %#v", fake.created)
+	}
+}
+
+func TestCreateContainerPinningDisabled(t *testing.T) {
+	fake := &digestNode{hasDigest: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	err := c.storage().StoreImage(context.Background(), pinnedRepo+":v1", "id1", "http://other:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.storage().SetImageDigest(context.Background(), pinnedRepo+":v1", pinnedDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := docker.Config{Image: pinnedRepo + ":v1"}
+	_, _, err = c.CreateContainer(docker.CreateContainerOptions{Config: &config}, 0, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fake.created, []string{pinnedRepo + ":v1"}) {
+		t.Errorf("CreateContainer: wrong container image %#v", fake.created)
+	}
+}
+
+func TestCreateContainerPinningWithoutStoredDigest(t *testing.T) {
+	fake := &digestNode{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PinImageDigests = true
+	config := docker.Config{Image: pinnedRepo + ":v1"}
+	_, _, err = c.CreateContainer(docker.CreateContainerOptions{Config: &config}, 0, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fake.created, []string{pinnedRepo + ":v1"}) {
+		t.Errorf("CreateContainer: wrong container image %#v", fake.created)
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	tests := map[string]string{
+		"tsuru/python":                  "tsuru/python",
+		"tsuru/python:v1":               "tsuru/python",
+		"localhost:5000/tsuru/python":   "localhost:5000/tsuru/python",
+		"localhost:5000/tsuru/python:3": "localhost:5000/tsuru/python",
+	}
+	for image, expected := range tests {
+		if repo := imageRepository(image); repo != expected {
+			t.Errorf("imageRepository(%q): want %q, got %q", image, expected, repo)
+		}
+	}
+}
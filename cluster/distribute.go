@@ -0,0 +1,166 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/provision/docker/fix"
+)
+
+const defaultDistributeConcurrency = 5
+
+// DistributeImageOptions are the options for DistributeImage.
+type DistributeImageOptions struct {
+	// Repository, Tag and Registry identify the image, as in
+	// docker.PullImageOptions.
+	Repository string
+	Tag        string
+	Registry   string
+
+	Auth docker.AuthConfiguration
+
+	// Metadata restricts the pull to the nodes matching all the given
+	// metadata. When empty, the image is pulled in every enabled node.
+	Metadata map[string]string
+
+	// Concurrency is the maximum number of nodes pulling the image at the
+	// same time. Defaults to 5.
+	Concurrency int
+
+	// Progress, if set, is called after the pull finishes in each node.
+	// Calls are never concurrent.
+	Progress func(DistributeImageResult)
+
+	// Context, if set, cancels the pulls in progress when cancelled. The
+	// nodes that didn't finish the pull are reported with the context
+	// error.
+	Context context.Context
+}
+
+// DistributeImageResult is the outcome of pulling the image in one node.
+type DistributeImageResult struct {
+	Node string
+
+	// ImageId is the ID of the pulled image in the node.
+	ImageId string
+
+	Duration time.Duration
+	Err      error
+}
+
+// DistributeImage pulls an image in all schedulable nodes, or in the
+// schedulable nodes matching opts.Metadata, so containers created from it
+// later don't have to wait for the pull. Each successful pull is recorded in
+// the storage.
+//
+// Failing nodes don't stop the distribution: every node is reported in the
+// results, in the order of the nodes in the storage, and an error is returned
+// at the end if the pull failed in any of them.
+func (c *Cluster) DistributeImage(opts DistributeImageOptions) ([]DistributeImageResult, error) {
+	ctx := optsContext(opts.Context)
+	var nodes []Node
+	var err error
+	if len(opts.Metadata) > 0 {
+		nodes, err = c.NodesForMetadataWithContext(ctx, opts.Metadata)
+	} else {
+		nodes, err = c.NodesWithContext(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes = NodeList(nodes).filterCordoned()
+	if len(nodes) == 0 {
+		return nil, errors.New("No nodes available")
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultDistributeConcurrency
+	}
+	key := imageKey(opts.Repository, opts.Tag)
+	results := make([]DistributeImageResult, len(nodes))
+	var (
+		wg       sync.WaitGroup
+		mut      sync.Mutex
+		digest   string
+		failures int
+	)
+	sem := make(chan struct{}, concurrency)
+	for i, n := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, addr string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result, output := c.distributeImageToNode(ctx, addr, key, opts)
+			mut.Lock()
+			defer mut.Unlock()
+			results[i] = result
+			if result.Err != nil {
+				failures++
+			} else if digest == "" {
+				digest, _ = fix.GetImageDigest(output)
+			}
+			if opts.Progress != nil {
+				opts.Progress(result)
+			}
+		}(i, n.Address)
+	}
+	wg.Wait()
+	if failures < len(results) {
+		err = c.storage().SetImageDigest(ctx, key, digest)
+		if err != nil {
+			return results, err
+		}
+	}
+	if failures > 0 {
+		return results, fmt.Errorf("Unable to pull image %q in %d of %d nodes", key, failures, len(results))
+	}
+	return results, nil
+}
+
+func (c *Cluster) distributeImageToNode(ctx context.Context, addr, key string, opts DistributeImageOptions) (DistributeImageResult, string) {
+	result := DistributeImageResult{Node: addr}
+	if result.Err = ctx.Err(); result.Err != nil {
+		return result, ""
+	}
+	n, err := c.getNodeByAddr(ctx, addr)
+	if err != nil {
+		result.Err = err
+		return result, ""
+	}
+	n.setPersistentClient()
+	var w bytes.Buffer
+	start := time.Now()
+	err = n.PullImage(docker.PullImageOptions{
+		Repository:   opts.Repository,
+		Tag:          opts.Tag,
+		Registry:     opts.Registry,
+		OutputStream: &w,
+		Context:      ctx,
+	}, opts.Auth)
+	result.Duration = time.Since(start)
+	c.metrics().PullImageDuration(n.addr, result.Duration, err)
+	if err != nil {
+		result.Err = wrapError(n, err)
+		return result, ""
+	}
+	img, err := n.InspectImage(key)
+	if err != nil {
+		result.Err = wrapError(n, err)
+		return result, ""
+	}
+	result.ImageId = img.ID
+	result.Err = c.storage().StoreImage(ctx, key, img.ID, n.addr)
+	return result, w.String()
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pullServer(id string, onPull func()) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/images/tsuru/python/json" {
+			w.Write([]byte(`{"Id": "` + id + `"}`))
+			return
+		}
+		if onPull != nil {
+			onPull()
+		}
+		w.Write([]byte(`{"status":"Pulling ` + id + `"}` + "\n" + `{"status":"Digest: sha256:e2ab55"}`))
+	}))
+}
+
+func TestDistributeImage(t *testing.T) {
+	server1 := pullServer("id1", nil)
+	defer server1.Close()
+	server2 := pullServer("id2", nil)
+	defer server2.Close()
+	server3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "registry unavailable", http.StatusInternalServerError)
+	}))
+	defer server3.Close()
+	cluster, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL},
+		Node{Address: server2.URL},
+		Node{Address: server3.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress []string
+	results, err := cluster.DistributeImage(DistributeImageOptions{
+		Repository: "tsuru/python",
+		Progress: func(r DistributeImageResult) {
+			progress = append(progress, r.Node)
+		},
+	})
+	if err == nil {
+		t.Fatal("DistributeImage: expected non-nil error, got <nil>")
+	}
+	if len(results) != 3 {
+		t.Fatalf("DistributeImage: want 3 results, got %#v", results)
+	}
+	for i, expected := range []struct {
+		node, id string
+		failed   bool
+	}{{server1.URL, "id1", false}, {server2.URL, "id2", false}, {server3.URL, "", true}} {
+		r := results[i]
+		if r.Node != expected.node || r.ImageId != expected.id || (r.Err != nil) != expected.failed {
+			t.Errorf("DistributeImage: wrong result %d: %#v", i, r)
+		}
+	}
+	sort.Strings(progress)
+	expectedProgress := []string{server1.URL, server2.URL, server3.URL}
+	sort.Strings(expectedProgress)
+	if !reflect.DeepEqual(progress, expectedProgress) {
+		t.Errorf("DistributeImage: wrong progress. Want %#v. Got %#v.", expectedProgress, progress)
+	}
+	img, err := cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := img.History
+	sort.Slice(history, func(i, j int) bool { return history[i].ImageId < history[j].ImageId })
+	expectedHistory := []ImageHistory{
+		{Node: server1.URL, ImageId: "id1"},
+		{Node: server2.URL, ImageId: "id2"},
+	}
+	if !reflect.DeepEqual(history, expectedHistory) {
+		t.Errorf("DistributeImage: wrong history. Want %#v. Got %#v.", expectedHistory, history)
+	}
+	if img.LastDigest != "sha256:e2ab55" {
+		t.Errorf("DistributeImage: wrong digest. Want %q. Got %q.", "sha256:e2ab55", img.LastDigest)
+	}
+}
+
+func TestDistributeImageForMetadata(t *testing.T) {
+	var pulled int32
+	server1 := pullServer("id1", func() { atomic.AddInt32(&pulled, 1) })
+	defer server1.Close()
+	server2 := pullServer("id1", func() { atomic.AddInt32(&pulled, 1) })
+	defer server2.Close()
+	cluster, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL, Metadata: map[string]string{"pool": "p1"}},
+		Node{Address: server2.URL, Metadata: map[string]string{"pool": "p2"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := cluster.DistributeImage(DistributeImageOptions{
+		Repository: "tsuru/python",
+		Metadata:   map[string]string{"pool": "p2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DistributeImageResult{{Node: server2.URL, ImageId: "id1"}}
+	results[0].Duration = 0
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("DistributeImage: want %#v, got %#v", expected, results)
+	}
+	if n := atomic.LoadInt32(&pulled); n != 1 {
+		t.Errorf("DistributeImage: expected 1 pull, got %d", n)
+	}
+}
+
+func TestDistributeImageConcurrency(t *testing.T) {
+	var running, maxRunning int32
+	onPull := func() {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	var nodes []Node
+	for i := 0; i < 5; i++ {
+		server := pullServer("id1", onPull)
+		defer server.Close()
+		nodes = append(nodes, Node{Address: server.URL})
+	}
+	cluster, err := New(nil, &MapStorage{}, "", nodes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := cluster.DistributeImage(DistributeImageOptions{
+		Repository:  "tsuru/python",
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Errorf("DistributeImage: want 5 results, got %d", len(results))
+	}
+	if max := atomic.LoadInt32(&maxRunning); max > 2 {
+		t.Errorf("DistributeImage: expected at most 2 concurrent pulls, got %d", max)
+	}
+}
+
+func TestDistributeImageNoNodes(t *testing.T) {
+	cluster, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.DistributeImage(DistributeImageOptions{Repository: "tsuru/python"})
+	if err == nil {
+		t.Fatal("DistributeImage: expected non-nil error, got <nil>")
+	}
+	_, err = cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
+	if err == nil {
+		t.Error("DistributeImage: expected no image in storage")
+	}
+}
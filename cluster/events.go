@@ -0,0 +1,277 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+// ClusterEventType is the type of synthetic events generated by the cluster
+// itself, as opposed to events coming from the docker daemon in each node.
+const ClusterEventType = "cluster"
+
+const (
+	EventNodeRegistered   = "node-registered"
+	EventNodeUnregistered = "node-unregistered"
+	EventNodeDisabled     = "node-disabled"
+	EventNodeHealed       = "node-healed"
+	EventNodeCordoned     = "node-cordoned"
+	EventNodeUncordoned   = "node-uncordoned"
+	// EventsDropped is sent to a subscriber that fell behind, before the
+	// next event it receives. The "count" attribute of the actor holds the
+	// number of events the subscriber missed.
+	EventsDropped = "events-dropped"
+)
+
+var (
+	eventsSyncInterval = 30 * time.Second
+
+	// Modified by tests, eventsReconnectInterval must be accessed atomically
+	eventsReconnectInterval = int64(5 * time.Second)
+	eventsBufferSize        = 256
+)
+
+// Event represents an event in the cluster. Node is the address of the node
+// where the event happened.
+type Event struct {
+	Node string
+	docker.APIEvents
+}
+
+type eventsMonitor struct {
+	sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	nodes       map[string]chan struct{}
+	done        chan struct{}
+}
+
+type eventSubscriber struct {
+	ch      chan *Event
+	dropped int
+}
+
+// send never blocks. When the subscriber buffer is full the event is
+// dropped and counted, and the count is reported in an EventsDropped event
+// as soon as there's room in the buffer for it and the next event. It must
+// be called with the events lock held, so there's a single sender.
+func (s *eventSubscriber) send(ev *Event) {
+	if s.dropped > 0 {
+		if cap(s.ch)-len(s.ch) < 2 {
+			s.dropped++
+			return
+		}
+		s.ch <- droppedEvent(s.dropped)
+		s.dropped = 0
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		s.dropped++
+	}
+}
+
+// Events subscribes to the events of the whole cluster, until done is
+// closed, at which point the returned channel is closed.
+//
+// Events include the ones from the docker daemon in every registered node,
+// tagged with the node address, and synthetic events generated by the
+// cluster, with type ClusterEventType. The first subscriber starts listening
+// to events in each node, reconnecting whenever the connection to a node is
+// lost, and the subscriptions are stopped when there are no subscribers
+// left.
+//
+// Event delivery never blocks the cluster: each subscriber has a buffer of
+// eventsBufferSize events and events arriving while the buffer is full are
+// LOST. Subscribers are told about lost events by an EventsDropped event,
+// and must treat it as a signal to resynchronize their state, by calling
+// ListContainers for instance. Events happening while a node is unreachable
+// are lost as well.
+func (c *Cluster) Events(done <-chan struct{}) <-chan *Event {
+	sub := &eventSubscriber{ch: make(chan *Event, eventsBufferSize)}
+	c.events.Lock()
+	if c.events.subscribers == nil {
+		c.events.subscribers = make(map[*eventSubscriber]struct{})
+	}
+	c.events.subscribers[sub] = struct{}{}
+	if c.events.done == nil {
+		c.events.done = make(chan struct{})
+		c.events.nodes = make(map[string]chan struct{})
+		go c.runEventsSync(c.events.done)
+	}
+	c.events.Unlock()
+	go func() {
+		<-done
+		c.unsubscribeEvents(sub)
+	}()
+	return sub.ch
+}
+
+func (c *Cluster) unsubscribeEvents(sub *eventSubscriber) {
+	c.events.Lock()
+	defer c.events.Unlock()
+	delete(c.events.subscribers, sub)
+	close(sub.ch)
+	if len(c.events.subscribers) == 0 && c.events.done != nil {
+		close(c.events.done)
+		for addr, stop := range c.events.nodes {
+			close(stop)
+			delete(c.events.nodes, addr)
+		}
+		c.events.done = nil
+	}
+}
+
+func (c *Cluster) runEventsSync(done chan struct{}) {
+	for {
+		c.syncEventNodes(done)
+		select {
+		case <-done:
+			return
+		case <-time.After(eventsSyncInterval):
+		}
+	}
+}
+
+// syncEventNodes subscribes to events in new nodes and stops the
+// subscription in nodes no longer registered. Nodes may be added or removed
+// by other instances sharing the same storage.
+func (c *Cluster) syncEventNodes(done chan struct{}) {
+	nodes, err := c.UnfilteredNodes()
+	if err != nil {
+		log.Error("[events]: error retrieving nodes", log.Fields{"error": err})
+		return
+	}
+	c.events.Lock()
+	defer c.events.Unlock()
+	if c.events.done != done {
+		return
+	}
+	current := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		current[n.Address] = struct{}{}
+		c.watchNodeEventsLocked(n.Address)
+	}
+	for addr := range c.events.nodes {
+		if _, ok := current[addr]; !ok {
+			c.unwatchNodeEventsLocked(addr)
+		}
+	}
+}
+
+func (c *Cluster) watchNodeEventsLocked(addr string) {
+	if c.events.done == nil {
+		return
+	}
+	if _, ok := c.events.nodes[addr]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	c.events.nodes[addr] = stop
+	go c.watchNodeEvents(addr, stop)
+}
+
+func (c *Cluster) unwatchNodeEventsLocked(addr string) {
+	if stop, ok := c.events.nodes[addr]; ok {
+		close(stop)
+		delete(c.events.nodes, addr)
+	}
+}
+
+func (c *Cluster) watchNodeEvents(addr string, stop chan struct{}) {
+	for {
+		c.streamNodeEvents(addr, stop)
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(atomic.LoadInt64(&eventsReconnectInterval))):
+		}
+		log.Debug("[events]: reconnecting to events", log.Fields{"node": addr})
+	}
+}
+
+// streamNodeEvents forwards the events from the given node until the
+// connection is lost, when the docker client closes the listener channel, or
+// the subscription is stopped.
+func (c *Cluster) streamNodeEvents(addr string, stop chan struct{}) {
+	n, err := c.getNodeByAddr(context.Background(), addr)
+	if err != nil {
+		log.Error("[events]: error creating client", log.Fields{"node": addr, "error": err})
+		return
+	}
+	n.setPersistentClient()
+	nodeEvents := make(chan *docker.APIEvents, 10)
+	err = n.AddEventListener(nodeEvents)
+	if err != nil {
+		log.Error("[events]: error listening to events", log.Fields{"node": addr, "error": err})
+		return
+	}
+	defer n.RemoveEventListener(nodeEvents)
+	for {
+		select {
+		case <-stop:
+			return
+		case ev, ok := <-nodeEvents:
+			if !ok {
+				return
+			}
+			c.emitEvent(&Event{Node: addr, APIEvents: *ev})
+		}
+	}
+}
+
+func (c *Cluster) emitEvent(ev *Event) {
+	c.events.Lock()
+	defer c.events.Unlock()
+	for sub := range c.events.subscribers {
+		sub.send(ev)
+	}
+}
+
+func clusterEvent(action, addr string, attributes map[string]string) *Event {
+	now := time.Now()
+	return &Event{
+		Node: addr,
+		APIEvents: docker.APIEvents{
+			Type:     ClusterEventType,
+			Action:   action,
+			Status:   action,
+			Time:     now.Unix(),
+			TimeNano: now.UnixNano(),
+			Actor: docker.APIActor{
+				ID:         addr,
+				Attributes: attributes,
+			},
+		},
+	}
+}
+
+func droppedEvent(count int) *Event {
+	return clusterEvent(EventsDropped, "", map[string]string{"count": strconv.Itoa(count)})
+}
+
+func (c *Cluster) emitNodeEvent(action, addr string) {
+	c.emitEvent(clusterEvent(action, addr, nil))
+}
+
+func (c *Cluster) nodeRegistered(addr string) {
+	c.events.Lock()
+	c.watchNodeEventsLocked(addr)
+	c.events.Unlock()
+	c.emitNodeEvent(EventNodeRegistered, addr)
+}
+
+func (c *Cluster) nodeUnregistered(addr string) {
+	c.events.Lock()
+	c.unwatchNodeEventsLocked(addr)
+	c.events.Unlock()
+	c.emitNodeEvent(EventNodeUnregistered, addr)
+}
@@ -0,0 +1,238 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// eventsServer sends one event per connection to /events, named after the
+// connection number. The first dropConnections connections are closed right
+// after the event is sent, the other ones are kept open until the server is
+// closed.
+func eventsServer(dropConnections int32) (*httptest.Server, func()) {
+	var connections int32
+	closing := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		conn := atomic.AddInt32(&connections, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"Type":"container","Action":"start-%d","id":"abc123","time":%d}`+"\n", conn, time.Now().Unix())
+		w.(http.Flusher).Flush()
+		if conn <= dropConnections {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-closing:
+		}
+	}))
+	return server, func() {
+		close(closing)
+		server.Close()
+	}
+}
+
+func waitEvent(t *testing.T, events <-chan *Event, action string) *Event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Action == action {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for event %q", action)
+		}
+	}
+}
+
+func TestClusterEventsFromNodes(t *testing.T) {
+	server, closeServer := eventsServer(0)
+	defer closeServer()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	events := c.Events(done)
+	ev := waitEvent(t, events, "start-1")
+	if ev.Node != server.URL {
+		t.Errorf("Events: wrong node. Want %q. Got %q.", server.URL, ev.Node)
+	}
+	if ev.ID != "abc123" {
+		t.Errorf("Events: wrong container ID. Want %q. Got %q.", "abc123", ev.ID)
+	}
+}
+
+func TestClusterEventsReconnect(t *testing.T) {
+	old := atomic.SwapInt64(&eventsReconnectInterval, int64(10*time.Millisecond))
+	defer atomic.StoreInt64(&eventsReconnectInterval, old)
+	server, closeServer := eventsServer(1)
+	defer closeServer()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	events := c.Events(done)
+	waitEvent(t, events, "start-1")
+	ev := waitEvent(t, events, "start-2")
+	if ev.Node != server.URL {
+		t.Errorf("Events: wrong node. Want %q. Got %q.", server.URL, ev.Node)
+	}
+}
+
+func TestClusterEventsNodeRegisteredAndUnregistered(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	events := c.Events(done)
+	err = c.Register(Node{Address: "http://localhost:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := waitEvent(t, events, EventNodeRegistered)
+	if ev.Type != ClusterEventType || ev.Node != "http://localhost:1" {
+		t.Errorf("Events: unexpected event %#v", ev)
+	}
+	err = c.Unregister("http://localhost:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = waitEvent(t, events, EventNodeUnregistered)
+	if ev.Type != ClusterEventType || ev.Node != "http://localhost:1" {
+		t.Errorf("Events: unexpected event %#v", ev)
+	}
+	c.events.Lock()
+	defer c.events.Unlock()
+	if len(c.events.nodes) != 0 {
+		t.Errorf("Events: expected no watched nodes, got %#v", c.events.nodes)
+	}
+}
+
+func TestClusterEventsNodeHealed(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "", Node{Address: "http://localhost:1", Metadata: map[string]string{"Failures": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	events := c.Events(done)
+	err = c.handleNodeSuccess("http://localhost:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, events, EventNodeHealed)
+}
+
+func TestClusterEventsNodeDisabled(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "", Node{Address: "http://localhost:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	events := c.Events(done)
+	err = c.handleNodeError("http://localhost:1", errors.New("some error"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := waitEvent(t, events, EventNodeDisabled)
+	if ev.Node != "http://localhost:1" {
+		t.Errorf("Events: wrong node. Want %q. Got %q.", "http://localhost:1", ev.Node)
+	}
+}
+
+type failingUpdateStorage struct {
+	MapStorage
+}
+
+func (s *failingUpdateStorage) UpdateNode(ctx context.Context, node Node) error {
+	return errors.New("update error")
+}
+
+func TestClusterEventsNodeDisabledNotSentOnStorageError(t *testing.T) {
+	c, err := New(nil, &failingUpdateStorage{}, "", Node{Address: "http://localhost:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := make(chan struct{})
+	nodeUpdatedOnError.Store(func() { close(updated) })
+	defer nodeUpdatedOnError.Store(func() {})
+	done := make(chan struct{})
+	defer close(done)
+	events := c.Events(done)
+	err = c.handleNodeError("http://localhost:1", errors.New("some error"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-updated
+	select {
+	case ev := <-events:
+		if ev.Action == EventNodeDisabled {
+			t.Errorf("Events: unexpected event %#v", ev)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClusterEventsDropped(t *testing.T) {
+	defer func(old int) {
+		eventsBufferSize = old
+	}(eventsBufferSize)
+	eventsBufferSize = 2
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	events := c.Events(done)
+	for _, n := range []string{"n1", "n2", "n3", "n4"} {
+		c.emitNodeEvent(EventNodeHealed, n)
+	}
+	ev := <-events
+	if ev.Node != "n1" {
+		t.Errorf("Events: wrong node. Want %q. Got %q.", "n1", ev.Node)
+	}
+	c.emitNodeEvent(EventNodeHealed, "n5")
+	ev = <-events
+	if ev.Node != "n2" {
+		t.Errorf("Events: wrong node. Want %q. Got %q.", "n2", ev.Node)
+	}
+	c.emitNodeEvent(EventNodeHealed, "n6")
+	ev = <-events
+	if ev.Action != EventsDropped || ev.Actor.Attributes["count"] != "3" {
+		t.Errorf("Events: expected dropped event with count 3, got %#v", ev)
+	}
+	ev = <-events
+	if ev.Node != "n6" {
+		t.Errorf("Events: wrong node. Want %q. Got %q.", "n6", ev.Node)
+	}
+	close(done)
+	for range events {
+	}
+	c.events.Lock()
+	defer c.events.Unlock()
+	if c.events.done != nil {
+		t.Error("Expected events monitoring to be stopped")
+	}
+}
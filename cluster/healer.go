@@ -8,7 +8,10 @@
 
 package cluster
 
-import "time"
+import (
+	"math/rand"
+	"time"
+)
 
 type Healer interface {
 	HandleError(node *Node) time.Duration
@@ -19,3 +22,84 @@ type DefaultHealer struct{}
 func (DefaultHealer) HandleError(node *Node) time.Duration {
 	return 1 * time.Minute
 }
+
+const (
+	defaultBackoffBaseDelay = 1 * time.Minute
+	defaultBackoffMaxDelay  = 30 * time.Minute
+)
+
+// BackoffHealer is a Healer that disables failing nodes for a period that
+// grows exponentially with the number of consecutive failures of the node:
+// BaseDelay for the first failure, twice that for the second one, and so on,
+// up to MaxDelay.
+//
+// When the number of failures reaches MaxFailures, the node is marked as
+// permanently unhealthy, by setting its CreationStatus to
+// NodeCreationStatusDisabled, and OnUnhealthy is called. The node is not used
+// again until its CreationStatus is changed.
+type BackoffHealer struct {
+	// BaseDelay is the period the node is disabled after the first failure.
+	// Defaults to one minute.
+	BaseDelay time.Duration
+
+	// MaxDelay is the maximum period a node is disabled. Defaults to 30
+	// minutes.
+	MaxDelay time.Duration
+
+	// Jitter is the fraction of the delay, between 0 and 1, that is
+	// randomly subtracted from it, so nodes that failed together aren't
+	// retried together.
+	Jitter float64
+
+	// MaxFailures is the number of failures after which the node is marked
+	// as permanently unhealthy. Zero means nodes are never marked.
+	MaxFailures int
+
+	// OnUnhealthy, if set, is called when a node is marked as permanently
+	// unhealthy, for example to replace the machine. It's called while the
+	// node is locked for healing, so long operations should run in their own
+	// goroutine.
+	OnUnhealthy func(node Node)
+}
+
+func (h *BackoffHealer) HandleError(node *Node) time.Duration {
+	failures := node.FailureCount()
+	if h.MaxFailures > 0 && failures >= h.MaxFailures {
+		alreadyUnhealthy := node.CreationStatus == NodeCreationStatusDisabled
+		node.CreationStatus = NodeCreationStatusDisabled
+		if h.OnUnhealthy != nil && !alreadyUnhealthy {
+			h.OnUnhealthy(*node)
+		}
+		return h.maxDelay()
+	}
+	return h.delay(failures)
+}
+
+func (h *BackoffHealer) delay(failures int) time.Duration {
+	delay := h.BaseDelay
+	if delay <= 0 {
+		delay = defaultBackoffBaseDelay
+	}
+	maxDelay := h.maxDelay()
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if h.Jitter > 0 {
+		jitter := h.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+	return delay
+}
+
+func (h *BackoffHealer) maxDelay() time.Duration {
+	if h.MaxDelay <= 0 {
+		return defaultBackoffMaxDelay
+	}
+	return h.MaxDelay
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBackoffHealerDelay(t *testing.T) {
+	healer := &BackoffHealer{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	var tests = []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		node := Node{Address: "http://n1:4243", Metadata: map[string]string{"Failures": strconv.Itoa(tt.failures)}}
+		if d := healer.HandleError(&node); d != tt.expected {
+			t.Errorf("HandleError with %d failures: want %s, got %s", tt.failures, tt.expected, d)
+		}
+	}
+}
+
+func TestBackoffHealerDefaults(t *testing.T) {
+	healer := &BackoffHealer{}
+	node := Node{Address: "http://n1:4243", Metadata: map[string]string{"Failures": "1"}}
+	if d := healer.HandleError(&node); d != defaultBackoffBaseDelay {
+		t.Errorf("HandleError: want %s, got %s", defaultBackoffBaseDelay, d)
+	}
+	node.Metadata["Failures"] = "50"
+	if d := healer.HandleError(&node); d != defaultBackoffMaxDelay {
+		t.Errorf("HandleError: want %s, got %s", defaultBackoffMaxDelay, d)
+	}
+}
+
+func TestBackoffHealerJitter(t *testing.T) {
+	healer := &BackoffHealer{BaseDelay: time.Second, Jitter: 0.5}
+	node := Node{Address: "http://n1:4243", Metadata: map[string]string{"Failures": "3"}}
+	for i := 0; i < 50; i++ {
+		d := healer.HandleError(&node)
+		if d <= 2*time.Second || d > 4*time.Second {
+			t.Fatalf("HandleError: expected delay in (2s, 4s], got %s", d)
+		}
+	}
+}
+
+func TestBackoffHealerMaxFailures(t *testing.T) {
+	var unhealthy []string
+	healer := &BackoffHealer{
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		MaxFailures: 3,
+		OnUnhealthy: func(n Node) { unhealthy = append(unhealthy, n.Address) },
+	}
+	c, err := New(nil, &MapStorage{}, "", Node{Address: "http://n1:4243"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Healer = healer
+	for i := 0; i < 4; i++ {
+		updated := make(chan struct{})
+		nodeUpdatedOnError.Store(func() { close(updated) })
+		err = c.handleNodeError("http://n1:4243", errors.New("some error"), true)
+		for err == errHealerInProgress {
+			time.Sleep(10 * time.Millisecond)
+			err = c.handleNodeError("http://n1:4243", errors.New("some error"), true)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-updated
+	}
+	nodeUpdatedOnError.Store(func() {})
+	err = c.handleNodeSuccess("http://n1:4243")
+	for err == errHealerInProgress {
+		time.Sleep(10 * time.Millisecond)
+		err = c.handleNodeSuccess("http://n1:4243")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := c.GetNode("http://n1:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.CreationStatus != NodeCreationStatusDisabled || node.Status() != NodeCreationStatusDisabled {
+		t.Errorf("HandleError: expected node to be permanently disabled, got %q", node.Status())
+	}
+	if len(unhealthy) != 1 || unhealthy[0] != "http://n1:4243" {
+		t.Errorf("HandleError: expected OnUnhealthy to be called once, got %#v", unhealthy)
+	}
+	nodes, err := c.Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 0 {
+		t.Errorf("HandleError: expected unhealthy node to stay disabled after a success, got %#v", nodes)
+	}
+}
@@ -5,13 +5,16 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/tsuru/provision/docker/fix"
@@ -35,8 +38,13 @@ type Image struct {
 // returning an error in case of failure. Will wait for the image to be
 // removed on all nodes.
 func (c *Cluster) RemoveImage(name string) error {
+	return c.RemoveImageWithContext(context.Background(), name)
+}
+
+// RemoveImageWithContext is like RemoveImage, but using ctx to cancel the operation.
+func (c *Cluster) RemoveImageWithContext(ctx context.Context, name string) error {
 	stor := c.storage()
-	image, err := stor.RetrieveImage(name)
+	image, err := stor.RetrieveImage(ctx, name)
 	if err != nil {
 		return err
 	}
@@ -49,7 +57,7 @@ func (c *Cluster) RemoveImage(name string) error {
 			hosts = append(hosts, entry.Node)
 		}
 	}
-	_, err = c.runOnNodes(func(n node) (interface{}, error) {
+	_, err = c.runOnNodes(ctx, func(n node) (interface{}, error) {
 		imgIds, _ := idMap[n.addr]
 		err = n.RemoveImage(name)
 		_, isNetErr := err.(net.Error)
@@ -64,7 +72,7 @@ func (c *Cluster) RemoveImage(name string) error {
 			// remaining data that wasn't removed when calling remove with the
 			// image name is removed now, no big deal if there're errors here.
 			n.RemoveImage(imgId)
-			stor.RemoveImage(name, imgId, n.addr)
+			stor.RemoveImage(ctx, name, imgId, n.addr)
 		}
 		return nil, nil
 	}, docker.ErrNoSuchImage, true, hosts...)
@@ -88,6 +96,7 @@ func parseImageRegistry(imageId string) (string, string) {
 // It will pull all images in parallel, so users need to make sure that the
 // given buffer is safe.
 func (c *Cluster) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration, nodes ...string) error {
+	ctx := optsContext(opts.Context)
 	var w safe.Buffer
 	if opts.OutputStream != nil {
 		mw := io.MultiWriter(&w, opts.OutputStream)
@@ -96,9 +105,11 @@ func (c *Cluster) PullImage(opts docker.PullImageOptions, auth docker.AuthConfig
 		opts.OutputStream = &w
 	}
 	key := imageKey(opts.Repository, opts.Tag)
-	_, err := c.runOnNodes(func(n node) (interface{}, error) {
+	_, err := c.runOnNodes(ctx, func(n node) (interface{}, error) {
 		n.setPersistentClient()
+		start := time.Now()
 		err := n.PullImage(opts, auth)
+		c.metrics().PullImageDuration(n.addr, time.Since(start), err)
 		if err != nil {
 			return nil, err
 		}
@@ -106,23 +117,150 @@ func (c *Cluster) PullImage(opts docker.PullImageOptions, auth docker.AuthConfig
 		if err != nil {
 			return nil, err
 		}
-		return nil, c.storage().StoreImage(key, img.ID, n.addr)
+		err = c.storage().StoreImage(ctx, key, img.ID, n.addr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.runHookForAddr(ctx, HookEventAfterImagePull, n.addr, HookPayload{Image: key})
 	}, docker.ErrNoSuchImage, true, nodes...)
 	if err != nil {
 		return err
 	}
 	digest, _ := fix.GetImageDigest(w.String())
-	return c.storage().SetImageDigest(key, digest)
+	return c.storage().SetImageDigest(ctx, key, digest)
+}
+
+// CopyImage copies an image from one node to others without going through a
+// registry, streaming the output of docker save in fromNode into docker load
+// in each of the given nodes, and records the image in the target nodes in the
+// storage.
+//
+// A failing target node doesn't interrupt the copy to the other ones. The
+// returned error lists the nodes where the copy failed.
+func (c *Cluster) CopyImage(name, fromNode string, toNodes ...string) error {
+	return c.CopyImageWithContext(context.Background(), name, fromNode, toNodes...)
+}
+
+// CopyImageWithContext is like CopyImage, but using ctx to cancel the operation.
+func (c *Cluster) CopyImageWithContext(ctx context.Context, name, fromNode string, toNodes ...string) error {
+	if len(toNodes) == 0 {
+		return errors.New("No target nodes given")
+	}
+	src, err := c.getNodeByAddr(ctx, fromNode)
+	if err != nil {
+		return err
+	}
+	src.setPersistentClient()
+	_, err = src.InspectImage(name)
+	if err != nil {
+		return wrapError(src, err)
+	}
+	var wg sync.WaitGroup
+	out := &fanOutWriter{}
+	errs := make([]error, len(toNodes))
+	for i, addr := range toNodes {
+		target, err := c.getNodeByAddr(ctx, addr)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		target.setPersistentClient()
+		r, w := io.Pipe()
+		out.writers = append(out.writers, w)
+		wg.Add(1)
+		go func(i int, target node, r *io.PipeReader) {
+			defer wg.Done()
+			err := target.LoadImage(docker.LoadImageOptions{InputStream: r})
+			// Unblocks the export if the load finished before reading
+			// the whole image.
+			r.CloseWithError(errors.New("image load finished"))
+			if err != nil {
+				errs[i] = wrapError(target, err)
+				return
+			}
+			img, err := target.InspectImage(name)
+			if err != nil {
+				errs[i] = wrapError(target, err)
+				return
+			}
+			errs[i] = c.storage().StoreImage(ctx, name, img.ID, target.addr)
+		}(i, target, r)
+	}
+	err = src.ExportImage(docker.ExportImageOptions{Name: name, OutputStream: out})
+	if err != nil {
+		err = wrapError(src, err)
+	}
+	for _, w := range out.writers {
+		w.CloseWithError(err)
+	}
+	wg.Wait()
+	if err != nil && !out.allFailed() {
+		return err
+	}
+	var msgs []string
+	for i, nodeErr := range errs {
+		if nodeErr != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", toNodes[i], nodeErr))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("Unable to copy image %q to %d of %d nodes: %s", name, len(msgs), len(toNodes), strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// fanOutWriter writes to all its writers, dropping the ones that fail. It
+// only fails when all writers have failed.
+type fanOutWriter struct {
+	writers []*io.PipeWriter
+	failed  []bool
+}
+
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	if w.failed == nil {
+		w.failed = make([]bool, len(w.writers))
+	}
+	var lastErr error
+	alive := 0
+	for i, writer := range w.writers {
+		if w.failed[i] {
+			continue
+		}
+		_, err := writer.Write(p)
+		if err != nil {
+			w.failed[i] = true
+			lastErr = err
+			continue
+		}
+		alive++
+	}
+	if alive == 0 {
+		if lastErr == nil {
+			lastErr = io.ErrClosedPipe
+		}
+		return 0, lastErr
+	}
+	return len(p), nil
+}
+
+func (w *fanOutWriter) allFailed() bool {
+	for i := range w.writers {
+		if w.failed == nil || !w.failed[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // TagImage adds a tag to the given image, returning an error in case of
 // failure.
 func (c *Cluster) TagImage(name string, opts docker.TagImageOptions) error {
-	img, err := c.storage().RetrieveImage(name)
+	ctx := optsContext(opts.Context)
+	img, err := c.storage().RetrieveImage(ctx, name)
 	if err != nil {
 		return err
 	}
-	node, err := c.getNodeByAddr(img.LastNode)
+	node, err := c.getNodeByAddr(ctx, img.LastNode)
 	if err != nil {
 		return err
 	}
@@ -131,18 +269,19 @@ func (c *Cluster) TagImage(name string, opts docker.TagImageOptions) error {
 		return wrapError(node, err)
 	}
 	key := imageKey(opts.Repo, opts.Tag)
-	return c.storage().StoreImage(key, img.LastId, node.addr)
+	return c.storage().StoreImage(ctx, key, img.LastId, node.addr)
 }
 
 // PushImage pushes an image to a remote registry server, returning an error in
 // case of failure.
 func (c *Cluster) PushImage(opts docker.PushImageOptions, auth docker.AuthConfiguration) error {
+	ctx := optsContext(opts.Context)
 	key := imageKey(opts.Name, opts.Tag)
-	img, err := c.storage().RetrieveImage(key)
+	img, err := c.storage().RetrieveImage(ctx, key)
 	if err != nil {
 		return err
 	}
-	node, err := c.getNodeByAddr(img.LastNode)
+	node, err := c.getNodeByAddr(ctx, img.LastNode)
 	if err != nil {
 		return err
 	}
@@ -152,11 +291,16 @@ func (c *Cluster) PushImage(opts docker.PushImageOptions, auth docker.AuthConfig
 
 // InspectImage inspects an image based on its repo name
 func (c *Cluster) InspectImage(repo string) (*docker.Image, error) {
-	img, err := c.storage().RetrieveImage(repo)
+	return c.InspectImageWithContext(context.Background(), repo)
+}
+
+// InspectImageWithContext is like InspectImage, but using ctx to cancel the operation.
+func (c *Cluster) InspectImageWithContext(ctx context.Context, repo string) (*docker.Image, error) {
+	img, err := c.storage().RetrieveImage(ctx, repo)
 	if err != nil {
 		return nil, err
 	}
-	node, err := c.getNodeByAddr(img.LastNode)
+	node, err := c.getNodeByAddr(ctx, img.LastNode)
 	if err != nil {
 		return nil, err
 	}
@@ -166,11 +310,16 @@ func (c *Cluster) InspectImage(repo string) (*docker.Image, error) {
 
 // ImageHistory returns the history of a given image
 func (c *Cluster) ImageHistory(repo string) ([]docker.ImageHistory, error) {
-	img, err := c.storage().RetrieveImage(repo)
+	return c.ImageHistoryWithContext(context.Background(), repo)
+}
+
+// ImageHistoryWithContext is like ImageHistory, but using ctx to cancel the operation.
+func (c *Cluster) ImageHistoryWithContext(ctx context.Context, repo string) ([]docker.ImageHistory, error) {
+	img, err := c.storage().RetrieveImage(ctx, repo)
 	if err != nil {
 		return nil, err
 	}
-	node, err := c.getNodeByAddr(img.LastNode)
+	node, err := c.getNodeByAddr(ctx, img.LastNode)
 	if err != nil {
 		return nil, err
 	}
@@ -178,48 +327,75 @@ func (c *Cluster) ImageHistory(repo string) ([]docker.ImageHistory, error) {
 	return imgHistory, wrapError(node, err)
 }
 
-// ListImages lists images existing in each cluster node
+// ListImagesResult holds the images listed in each node by
+// ListImagesPerNode.
+type ListImagesResult struct {
+	// Images maps the address of each node listed successfully to its
+	// images.
+	Images map[string][]docker.APIImages
+
+	NodeFailures
+}
+
+// All returns the images of all nodes, ordered by node address.
+func (r *ListImagesResult) All() []docker.APIImages {
+	addrs := make([]string, 0, len(r.Images))
+	for addr := range r.Images {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	var all []docker.APIImages
+	for _, addr := range addrs {
+		all = append(all, r.Images[addr]...)
+	}
+	return all
+}
+
+// Err returns a *MultiNodeError with the nodes where the listing failed, or
+// nil if it didn't fail in any node.
+func (r *ListImagesResult) Err() error {
+	return r.err("list images", len(r.Images))
+}
+
+// ListImages lists images existing in each cluster node. Nodes that fail
+// don't hide the images of the other ones: the images found are returned
+// along with a *MultiNodeError.
 func (c *Cluster) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
-	nodes, err := c.UnfilteredNodes()
+	result, err := c.ListImagesPerNode(opts)
 	if err != nil {
 		return nil, err
 	}
-	resultChan := make(chan []docker.APIImages, len(nodes))
-	errChan := make(chan error, len(nodes))
-	var wg sync.WaitGroup
-	for _, node := range nodes {
-		wg.Add(1)
-		go func(addr string) {
-			defer wg.Done()
-			client, err := c.getNodeByAddr(addr)
-			if err != nil {
-				errChan <- err
-			}
-			nodeImages, err := client.ListImages(opts)
-			if err != nil {
-				errChan <- wrapError(client, err)
-			}
-			resultChan <- nodeImages
-		}(node.Address)
+	if err = optsContext(opts.Context).Err(); err != nil {
+		return result.All(), err
 	}
-	wg.Wait()
-	close(resultChan)
-	close(errChan)
-	var allImages []docker.APIImages
-	for images := range resultChan {
-		allImages = append(allImages, images...)
-	}
-	select {
-	case err := <-errChan:
-		return allImages, err
-	default:
-	}
-	return allImages, nil
+	return result.All(), result.Err()
+}
+
+// ListImagesPerNode lists the images in every registered node, reporting the
+// images and the errors of each node, and the nodes that were skipped. The
+// returned error is only set when the nodes can't be retrieved from the
+// storage.
+func (c *Cluster) ListImagesPerNode(opts docker.ListImagesOptions) (*ListImagesResult, error) {
+	values, failures, err := c.runOnEachNode(optsContext(opts.Context), func(n node) (interface{}, error) {
+		return n.ListImages(opts)
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	result := ListImagesResult{
+		Images:       make(map[string][]docker.APIImages, len(values)),
+		NodeFailures: failures,
+	}
+	for addr, value := range values {
+		result.Images[addr] = value.([]docker.APIImages)
+	}
+	return &result, nil
 }
 
 // ImportImage imports an image from a url or stdin
 func (c *Cluster) ImportImage(opts docker.ImportImageOptions) error {
-	_, err := c.runOnNodes(func(n node) (interface{}, error) {
+	ctx := optsContext(opts.Context)
+	_, err := c.runOnNodes(ctx, func(n node) (interface{}, error) {
 		return nil, n.ImportImage(opts)
 	}, docker.ErrNoSuchImage, false)
 	return err
@@ -227,7 +403,8 @@ func (c *Cluster) ImportImage(opts docker.ImportImageOptions) error {
 
 //BuildImage build an image and pushes it to registry
 func (c *Cluster) BuildImage(buildOptions docker.BuildImageOptions) error {
-	nodes, err := c.Nodes()
+	ctx := optsContext(buildOptions.Context)
+	nodes, err := c.NodesWithContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -235,7 +412,7 @@ func (c *Cluster) BuildImage(buildOptions docker.BuildImageOptions) error {
 		return errors.New("There is no docker node. Please list one in tsuru.conf or add one with `tsuru node-add`.")
 	}
 	nodeAddress := nodes[rand.Intn(len(nodes))].Address
-	node, err := c.getNodeByAddr(nodeAddress)
+	node, err := c.getNodeByAddr(ctx, nodeAddress)
 	if err != nil {
 		return err
 	}
@@ -248,7 +425,7 @@ func (c *Cluster) BuildImage(buildOptions docker.BuildImageOptions) error {
 	if err != nil {
 		return wrapError(node, err)
 	}
-	return c.storage().StoreImage(buildOptions.Name, img.ID, nodeAddress)
+	return c.storage().StoreImage(ctx, buildOptions.Name, img.ID, nodeAddress)
 }
 
 func imageKey(repo, tag string) string {
@@ -0,0 +1,260 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+// ImageGCOptions are the rules used by CollectImages to choose the images
+// that are removed from the nodes.
+type ImageGCOptions struct {
+	// KeepLast is the number of image IDs kept in the nodes for each
+	// repository, counting from the last stored one. The last image ID of a
+	// repository is always kept, so values lower than 1 are the same as 1.
+	KeepLast int
+
+	// MinAge keeps images created less than MinAge ago.
+	MinAge time.Duration
+
+	// DryRun makes CollectImages only report the images that would be
+	// removed, without removing them.
+	DryRun bool
+
+	// Context, if set, cancels the collection. Nodes not handled before the
+	// cancellation are reported as unreachable.
+	Context context.Context
+}
+
+// ImageGCEntry is an image ID of a repository in one node.
+type ImageGCEntry struct {
+	Repository string
+	Node       string
+	ImageId    string
+	Err        error
+}
+
+// ImageGCReport lists the images handled by CollectImages.
+type ImageGCReport struct {
+	// Removed are the images removed from the nodes and from the storage,
+	// including the ones that were already missing in their nodes.
+	Removed []ImageGCEntry
+
+	// Failed are the images whose removal failed, with the error.
+	Failed []ImageGCEntry
+
+	// UnreachableNodes are the nodes whose containers couldn't be listed.
+	// Images in these nodes are left untouched.
+	UnreachableNodes []string
+}
+
+// CollectImages removes old image IDs from the nodes, keeping the stored
+// history of the images in sync. For each repository, the last opts.KeepLast
+// image IDs are kept, along with the images used by containers, running or
+// not, in each node and the images newer than opts.MinAge.
+//
+// Image IDs are ordered by their first appearance in the image history, which
+// the storage keeps in insertion order, with the last stored ID being the
+// newest one.
+func (c *Cluster) CollectImages(opts ImageGCOptions) (*ImageGCReport, error) {
+	ctx := optsContext(opts.Context)
+	images, err := c.storage().RetrieveImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	candidates, kept := imageGCCandidates(images, opts.KeepLast)
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	var report ImageGCReport
+	for addr, entries := range candidates {
+		wg.Add(1)
+		go func(addr string, entries []ImageGCEntry) {
+			defer wg.Done()
+			removed, failed, err := c.collectImagesInNode(ctx, addr, entries, kept[addr], opts)
+			mut.Lock()
+			defer mut.Unlock()
+			if err != nil {
+				log.Warn("[image-gc]: error listing containers, skipping node", log.Fields{"node": addr, "error": err})
+				report.UnreachableNodes = append(report.UnreachableNodes, addr)
+				return
+			}
+			report.Removed = append(report.Removed, removed...)
+			report.Failed = append(report.Failed, failed...)
+		}(addr, entries)
+	}
+	wg.Wait()
+	sortImageGCEntries(report.Removed)
+	sortImageGCEntries(report.Failed)
+	sort.Strings(report.UnreachableNodes)
+	return &report, nil
+}
+
+// imageGCCandidates returns the history entries that may be removed, grouped
+// by node, and the image IDs that must be kept in each node because they're
+// among the last IDs of some repository.
+func imageGCCandidates(images []Image, keepLast int) (map[string][]ImageGCEntry, map[string]map[string]bool) {
+	if keepLast < 1 {
+		keepLast = 1
+	}
+	candidates := make(map[string][]ImageGCEntry)
+	kept := make(map[string]map[string]bool)
+	for _, img := range images {
+		var ids []string
+		seen := make(map[string]bool)
+		for _, entry := range img.History {
+			if !seen[entry.ImageId] && entry.ImageId != img.LastId {
+				seen[entry.ImageId] = true
+				ids = append(ids, entry.ImageId)
+			}
+		}
+		if img.LastId != "" {
+			ids = append(ids, img.LastId)
+		}
+		keep := make(map[string]bool)
+		for i := len(ids) - 1; i >= 0 && i >= len(ids)-keepLast; i-- {
+			keep[ids[i]] = true
+		}
+		for _, entry := range img.History {
+			if keep[entry.ImageId] {
+				if kept[entry.Node] == nil {
+					kept[entry.Node] = make(map[string]bool)
+				}
+				kept[entry.Node][entry.ImageId] = true
+				continue
+			}
+			candidates[entry.Node] = append(candidates[entry.Node], ImageGCEntry{
+				Repository: img.Repository,
+				Node:       entry.Node,
+				ImageId:    entry.ImageId,
+			})
+		}
+	}
+	return candidates, kept
+}
+
+func (c *Cluster) collectImagesInNode(ctx context.Context, addr string, entries []ImageGCEntry, kept map[string]bool, opts ImageGCOptions) ([]ImageGCEntry, []ImageGCEntry, error) {
+	n, err := c.getNodeByAddr(ctx, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	used, err := imagesInUse(n)
+	if err != nil {
+		return nil, nil, wrapError(n, err)
+	}
+	var removed, failed []ImageGCEntry
+	for _, entry := range entries {
+		if kept[entry.ImageId] || used[entry.ImageId] {
+			continue
+		}
+		missing := false
+		if opts.MinAge > 0 {
+			img, err := n.InspectImage(entry.ImageId)
+			if err == docker.ErrNoSuchImage {
+				missing = true
+			} else if err != nil {
+				entry.Err = wrapError(n, err)
+				failed = append(failed, entry)
+				continue
+			} else if time.Since(img.Created) < opts.MinAge {
+				continue
+			}
+		}
+		if opts.DryRun {
+			removed = append(removed, entry)
+			continue
+		}
+		if !missing {
+			err = n.RemoveImage(entry.ImageId)
+			if err != nil && err != docker.ErrNoSuchImage {
+				entry.Err = wrapError(n, err)
+				failed = append(failed, entry)
+				continue
+			}
+		}
+		err = c.storage().RemoveImage(ctx, entry.Repository, entry.ImageId, addr)
+		if err != nil {
+			entry.Err = err
+			failed = append(failed, entry)
+			continue
+		}
+		removed = append(removed, entry)
+	}
+	return removed, failed, nil
+}
+
+// imagesInUse returns the IDs of the images used by the containers in the
+// node.
+func imagesInUse(n node) (map[string]bool, error) {
+	containers, err := n.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool, len(containers))
+	for _, apiCont := range containers {
+		cont, err := n.InspectContainer(apiCont.ID)
+		if err != nil {
+			if _, ok := err.(*docker.NoSuchContainer); ok {
+				continue
+			}
+			return nil, err
+		}
+		used[cont.Image] = true
+	}
+	return used, nil
+}
+
+func sortImageGCEntries(entries []ImageGCEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Repository != entries[j].Repository {
+			return entries[i].Repository < entries[j].Repository
+		}
+		if entries[i].Node != entries[j].Node {
+			return entries[i].Node < entries[j].Node
+		}
+		return entries[i].ImageId < entries[j].ImageId
+	})
+}
+
+// StartImageGC runs CollectImages with the given options every interval,
+// logging the removed images, until StopImageGC is called.
+func (c *Cluster) StartImageGC(interval time.Duration, opts ImageGCOptions) {
+	c.imageGCDone = make(chan bool)
+	go c.runImageGC(interval, opts)
+}
+
+func (c *Cluster) StopImageGC() {
+	if c.imageGCDone != nil {
+		c.imageGCDone <- true
+	}
+}
+
+func (c *Cluster) runImageGC(interval time.Duration, opts ImageGCOptions) {
+	log.Debug("[image-gc]: periodic image collection enabled", log.Fields{"interval": interval})
+	for {
+		report, err := c.CollectImages(opts)
+		if err != nil {
+			log.Error("[image-gc]: error collecting images", log.Fields{"error": err})
+		}
+		if report != nil {
+			for _, entry := range report.Removed {
+				log.Info("[image-gc]: image removed", log.Fields{"repository": entry.Repository, "node": entry.Node, "image": entry.ImageId, "dry-run": opts.DryRun})
+			}
+			for _, entry := range report.Failed {
+				log.Error("[image-gc]: error removing image", log.Fields{"repository": entry.Repository, "node": entry.Node, "image": entry.ImageId, "error": entry.Err})
+			}
+		}
+		select {
+		case <-c.imageGCDone:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gcNode is a fake docker node with the given images, mapped to their
+// creation time, and containers, mapped to their image IDs.
+type gcNode struct {
+	sync.Mutex
+	images     map[string]time.Time
+	containers map[string]string
+	conflicts  map[string]bool
+	removed    []string
+}
+
+func (n *gcNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.Lock()
+	defer n.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/containers/json":
+		var list []map[string]string
+		for id := range n.containers {
+			list = append(list, map[string]string{"Id": id})
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == "GET" && parts[0] == "containers" && len(parts) == 3:
+		image, ok := n.containers[parts[1]]
+		if !ok {
+			http.Error(w, "no such container", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Id": parts[1], "Image": image})
+	case r.Method == "GET" && parts[0] == "images" && len(parts) == 3:
+		created, ok := n.images[parts[1]]
+		if !ok {
+			http.Error(w, "no such image", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Id": parts[1], "Created": created})
+	case r.Method == "DELETE" && parts[0] == "images" && len(parts) == 2:
+		if _, ok := n.images[parts[1]]; !ok {
+			http.Error(w, "no such image", http.StatusNotFound)
+			return
+		}
+		if n.conflicts[parts[1]] {
+			http.Error(w, "image is being used", http.StatusConflict)
+			return
+		}
+		delete(n.images, parts[1])
+		n.removed = append(n.removed, parts[1])
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func (n *gcNode) removedImages() []string {
+	n.Lock()
+	defer n.Unlock()
+	removed := append([]string(nil), n.removed...)
+	sort.Strings(removed)
+	return removed
+}
+
+// newGCNode returns a gcNode with two old images, id0 and id1, two recent
+// images, id2 and id3, and a container using id0.
+func newGCNode() *gcNode {
+	old := time.Now().Add(-48 * time.Hour)
+	return &gcNode{
+		images: map[string]time.Time{
+			"id0": old,
+			"id1": old,
+			"id2": time.Now(),
+			"id3": time.Now(),
+		},
+		containers: map[string]string{"c1": "id0"},
+	}
+}
+
+func storedHistory(t *testing.T, c *Cluster, repo string) []string {
+	img, err := c.storage().RetrieveImage(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, entry := range img.History {
+		ids = append(ids, entry.ImageId)
+	}
+	return ids
+}
+
+func TestCollectImages(t *testing.T) {
+	fake := newGCNode()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	for _, id := range []string{"id0", "id1", "id2", "id3"} {
+		err := c.storage().StoreImage(context.Background(), "tsuru/python", id, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := c.CollectImages(ImageGCOptions{KeepLast: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ImageGCReport{
+		Removed: []ImageGCEntry{
+			{Repository: "tsuru/python", Node: server.URL, ImageId: "id1"},
+			{Repository: "tsuru/python", Node: server.URL, ImageId: "id2"},
+		},
+	}
+	if !reflect.DeepEqual(*report, expected) {
+		t.Errorf("CollectImages: want %#v, got %#v", expected, *report)
+	}
+	if removed := fake.removedImages(); !reflect.DeepEqual(removed, []string{"id1", "id2"}) {
+		t.Errorf("CollectImages: wrong images removed from node: %#v", removed)
+	}
+	if ids := storedHistory(t, c, "tsuru/python"); !reflect.DeepEqual(ids, []string{"id0", "id3"}) {
+		t.Errorf("CollectImages: wrong history: %#v", ids)
+	}
+}
+
+func TestCollectImagesKeepLast(t *testing.T) {
+	fake := newGCNode()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	for _, id := range []string{"id0", "id1", "id2", "id3"} {
+		err := c.storage().StoreImage(context.Background(), "tsuru/python", id, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := c.CollectImages(ImageGCOptions{KeepLast: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed := fake.removedImages(); !reflect.DeepEqual(removed, []string{"id1"}) {
+		t.Errorf("CollectImages: wrong images removed from node: %#v", removed)
+	}
+	if ids := storedHistory(t, c, "tsuru/python"); !reflect.DeepEqual(ids, []string{"id0", "id2", "id3"}) {
+		t.Errorf("CollectImages: wrong history: %#v", ids)
+	}
+}
+
+func TestCollectImagesMinAge(t *testing.T) {
+	fake := newGCNode()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	for _, id := range []string{"id0", "id1", "id2", "id3"} {
+		err := c.storage().StoreImage(context.Background(), "tsuru/python", id, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	delete(fake.images, "id1")
+	report, err := c.CollectImages(ImageGCOptions{MinAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ImageGCEntry{{Repository: "tsuru/python", Node: server.URL, ImageId: "id1"}}
+	if !reflect.DeepEqual(report.Removed, expected) {
+		t.Errorf("CollectImages: want %#v, got %#v", expected, report.Removed)
+	}
+	if removed := fake.removedImages(); len(removed) != 0 {
+		t.Errorf("CollectImages: expected no images removed from node, got %#v", removed)
+	}
+	if ids := storedHistory(t, c, "tsuru/python"); !reflect.DeepEqual(ids, []string{"id0", "id2", "id3"}) {
+		t.Errorf("CollectImages: wrong history: %#v", ids)
+	}
+}
+
+func TestCollectImagesDryRun(t *testing.T) {
+	fake := newGCNode()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	for _, id := range []string{"id0", "id1", "id2", "id3"} {
+		err := c.storage().StoreImage(context.Background(), "tsuru/python", id, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := c.CollectImages(ImageGCOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Removed) != 2 {
+		t.Errorf("CollectImages: expected 2 images in report, got %#v", report.Removed)
+	}
+	if removed := fake.removedImages(); len(removed) != 0 {
+		t.Errorf("CollectImages: expected no images removed from node, got %#v", removed)
+	}
+	if ids := storedHistory(t, c, "tsuru/python"); len(ids) != 4 {
+		t.Errorf("CollectImages: expected untouched history, got %#v", ids)
+	}
+}
+
+func TestCollectImagesSharedImage(t *testing.T) {
+	fake := newGCNode()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	for _, id := range []string{"id0", "id1", "id2", "id3"} {
+		err := c.storage().StoreImage(context.Background(), "tsuru/python", id, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := c.storage().StoreImage(context.Background(), "tsuru/python:v1", "id1", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CollectImages(ImageGCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed := fake.removedImages(); !reflect.DeepEqual(removed, []string{"id2"}) {
+		t.Errorf("CollectImages: wrong images removed from node: %#v", removed)
+	}
+}
+
+func TestCollectImagesRemoveFailure(t *testing.T) {
+	fake := newGCNode()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	for _, id := range []string{"id0", "id1", "id2", "id3"} {
+		err := c.storage().StoreImage(context.Background(), "tsuru/python", id, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.conflicts = map[string]bool{"id1": true}
+	report, err := c.CollectImages(ImageGCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].ImageId != "id1" || report.Failed[0].Err == nil {
+		t.Errorf("CollectImages: wrong failures: %#v", report.Failed)
+	}
+	if ids := storedHistory(t, c, "tsuru/python"); !reflect.DeepEqual(ids, []string{"id0", "id1", "id3"}) {
+		t.Errorf("CollectImages: wrong history: %#v", ids)
+	}
+}
+
+func TestCollectImagesUnreachableNode(t *testing.T) {
+	c := newMapCluster(t, "http://127.0.0.1:1")
+	c.storage().StoreImage(context.Background(), "tsuru/python", "id1", "http://127.0.0.1:1")
+	c.storage().StoreImage(context.Background(), "tsuru/python", "id2", "http://127.0.0.1:1")
+	report, err := c.CollectImages(ImageGCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ImageGCReport{UnreachableNodes: []string{"http://127.0.0.1:1"}}
+	if !reflect.DeepEqual(*report, expected) {
+		t.Errorf("CollectImages: want %#v, got %#v", expected, *report)
+	}
+	if ids := storedHistory(t, c, "tsuru/python"); len(ids) != 2 {
+		t.Errorf("CollectImages: expected untouched history, got %#v", ids)
+	}
+}
+
+func TestStartImageGC(t *testing.T) {
+	fake := newGCNode()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	c := newMapCluster(t, server.URL)
+	for _, id := range []string{"id0", "id1", "id2", "id3"} {
+		err := c.storage().StoreImage(context.Background(), "tsuru/python", id, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.StartImageGC(10*time.Millisecond, ImageGCOptions{})
+	defer c.StopImageGC()
+	timeout := time.After(5 * time.Second)
+	for len(fake.removedImages()) != 2 {
+		select {
+		case <-timeout:
+			t.Fatal("timeout waiting for images to be collected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
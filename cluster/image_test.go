@@ -6,11 +6,15 @@ package cluster
 
 import (
 	"bytes"
+	"context"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/fsouza/go-dockerclient"
@@ -33,7 +37,7 @@ func TestRemoveImage(t *testing.T) {
 		t.Fatal(err)
 	}
 	name := "tsuru/python"
-	err = cluster.storage().StoreImage(name, "id1", server1.URL)
+	err = cluster.storage().StoreImage(context.Background(), name, "id1", server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -44,7 +48,7 @@ func TestRemoveImage(t *testing.T) {
 	if !called {
 		t.Errorf("RemoveImage(%q): Did not call node HTTP server", name)
 	}
-	_, err = cluster.storage().RetrieveImage(name)
+	_, err = cluster.storage().RetrieveImage(context.Background(), name)
 	if err != storage.ErrNoSuchImage {
 		t.Errorf("RemoveImage(%q): wrong error. Want %#v. Got %#v.", name, storage.ErrNoSuchImage, err)
 	}
@@ -78,7 +82,7 @@ func TestRemoveImageNotFoundInServer(t *testing.T) {
 	defer server1.Close()
 	name := "tsuru/python"
 	stor := &MapStorage{}
-	err := stor.StoreImage(name, "id1", server1.URL)
+	err := stor.StoreImage(context.Background(), name, "id1", server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -92,7 +96,7 @@ func TestRemoveImageNotFoundInServer(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = cluster.storage().RetrieveImage(name)
+	_, err = cluster.storage().RetrieveImage(context.Background(), name)
 	if err != storage.ErrNoSuchImage {
 		t.Errorf("RemoveImage(%q): wrong error. Want %#v. Got %#v.", name, storage.ErrNoSuchImage, err)
 	}
@@ -102,7 +106,7 @@ func TestRemoveImageServerUnavailable(t *testing.T) {
 	addr := "http://invalid-server.nowhere.none"
 	name := "tsuru/python"
 	stor := &MapStorage{}
-	err := stor.StoreImage(name, "id1", addr)
+	err := stor.StoreImage(context.Background(), name, "id1", addr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -116,7 +120,7 @@ func TestRemoveImageServerUnavailable(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = cluster.storage().RetrieveImage(name)
+	_, err = cluster.storage().RetrieveImage(context.Background(), name)
 	if err != storage.ErrNoSuchImage {
 		t.Errorf("RemoveImage(%q): wrong error. Want %#v. Got %#v.", name, storage.ErrNoSuchImage, err)
 	}
@@ -126,7 +130,7 @@ func TestRemoveImageServerConnectionRefused(t *testing.T) {
 	addr := "http://localhost:61117"
 	name := "tsuru/python"
 	stor := &MapStorage{}
-	err := stor.StoreImage(name, "id1", addr)
+	err := stor.StoreImage(context.Background(), name, "id1", addr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -140,7 +144,7 @@ func TestRemoveImageServerConnectionRefused(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = cluster.storage().RetrieveImage(name)
+	_, err = cluster.storage().RetrieveImage(context.Background(), name)
 	if err != storage.ErrNoSuchImage {
 		t.Errorf("RemoveImage(%q): wrong error. Want %#v. Got %#v.", name, storage.ErrNoSuchImage, err)
 	}
@@ -155,7 +159,7 @@ func TestRemoveImageNodeNotInStorage(t *testing.T) {
 	defer server1.Close()
 	name := "tsuru/python"
 	stor := &MapStorage{}
-	err := stor.StoreImage(name, "id1", server1.URL)
+	err := stor.StoreImage(context.Background(), name, "id1", server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -170,7 +174,7 @@ func TestRemoveImageNodeNotInStorage(t *testing.T) {
 	if !called {
 		t.Errorf("RemoveImage(%q): Did not call node HTTP server", name)
 	}
-	_, err = cluster.storage().RetrieveImage(name)
+	_, err = cluster.storage().RetrieveImage(context.Background(), name)
 	if err != storage.ErrNoSuchImage {
 		t.Errorf("RemoveImage(%q): wrong error. Want %#v. Got %#v.", name, storage.ErrNoSuchImage, err)
 	}
@@ -213,7 +217,7 @@ func TestPullImage(t *testing.T) {
 	if r := buf.String(); r != alternatives[0] && r != alternatives[1] {
 		t.Errorf("Wrong output: Want %q. Got %q.", "Pulling from 1!Pulling from 2!", buf.String())
 	}
-	img, err := cluster.storage().RetrieveImage("tsuru/python")
+	img, err := cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -229,6 +233,42 @@ func TestPullImage(t *testing.T) {
 	}
 }
 
+func TestPullImageAfterHook(t *testing.T) {
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id": "id1"}`))
+	}))
+	defer server1.Close()
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id": "id1"}`))
+	}))
+	defer server2.Close()
+	cluster, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL},
+		Node{Address: server2.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mut sync.Mutex
+	var pulled []string
+	cluster.AddHook(HookEventAfterImagePull, HookFunc(func(evt HookEvent, payload *HookPayload) error {
+		mut.Lock()
+		defer mut.Unlock()
+		pulled = append(pulled, payload.Node.Address+" "+payload.Image)
+		return nil
+	}))
+	err = cluster.PullImage(docker.PullImageOptions{Repository: "tsuru/python", Tag: "latest"}, docker.AuthConfiguration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(pulled)
+	expected := []string{server1.URL + " tsuru/python:latest", server2.URL + " tsuru/python:latest"}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(pulled, expected) {
+		t.Errorf("PullImage: wrong hook calls. Want %v. Got %v.", expected, pulled)
+	}
+}
+
 func TestPullImageNotFound(t *testing.T) {
 	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No such image", http.StatusNotFound)
@@ -354,7 +394,7 @@ func TestPullImageSaveDigest(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	img, err := cluster.storage().RetrieveImage("tsuru/python")
+	img, err := cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -383,7 +423,7 @@ func TestPullImageSaveDigestWithNilOutputStream(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	img, err := cluster.storage().RetrieveImage("tsuru/python")
+	img, err := cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -403,7 +443,7 @@ func TestPushImage(t *testing.T) {
 	defer server2.Close()
 	var buf safe.Buffer
 	stor := &MapStorage{}
-	err := stor.StoreImage("tsuru/ruby", "id1", server1.URL)
+	err := stor.StoreImage(context.Background(), "tsuru/ruby", "id1", server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -460,7 +500,7 @@ func TestPushImageWithStorage(t *testing.T) {
 	}))
 	defer server2.Close()
 	stor := MapStorage{}
-	err := stor.StoreImage("tsuru/python", "id1", server2.URL)
+	err := stor.StoreImage(context.Background(), "tsuru/python", "id1", server2.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -493,7 +533,7 @@ func TestTagImage(t *testing.T) {
 	}))
 	defer server2.Close()
 	stor := &MapStorage{}
-	err := stor.StoreImage("tsuru/ruby", "id1", server1.URL)
+	err := stor.StoreImage(context.Background(), "tsuru/ruby", "id1", server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -512,7 +552,7 @@ func TestTagImage(t *testing.T) {
 	if call != "server1" {
 		t.Errorf("Wrong call: Want %q. Got %q.", "server1", call)
 	}
-	img, err := cluster.storage().RetrieveImage("myregistry.com/tsuru/ruby")
+	img, err := cluster.storage().RetrieveImage(context.Background(), "myregistry.com/tsuru/ruby")
 	if err != nil {
 		t.Error(err)
 	}
@@ -627,7 +667,7 @@ func TestBuildImage(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	_, err = cluster.storage().RetrieveImage("tsuru/python")
+	_, err = cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
 	if err != nil {
 		t.Error(err)
 	}
@@ -785,6 +825,46 @@ func TestListImagesErrors(t *testing.T) {
 	}
 }
 
+func TestListImagesPerNode(t *testing.T) {
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"Id":"id1","RepoTags":["tsuru/python1"]}]`))
+	}))
+	defer server1.Close()
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal failure", http.StatusInternalServerError)
+	}))
+	defer server2.Close()
+	cluster, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL},
+		Node{Address: server2.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := cluster.ListImagesPerNode(docker.ListImagesOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]docker.APIImages{server1.URL: {{ID: "id1", RepoTags: []string{"tsuru/python1"}}}}
+	if !reflect.DeepEqual(result.Images, expected) {
+		t.Errorf("ListImagesPerNode: Want %#v. Got %#v.", expected, result.Images)
+	}
+	if len(result.Errors) != 1 || result.Errors[server2.URL] == nil {
+		t.Errorf("ListImagesPerNode: wrong errors: %#v", result.Errors)
+	}
+	if len(result.Skipped) != 0 {
+		t.Errorf("ListImagesPerNode: expected no skipped nodes, got %#v", result.Skipped)
+	}
+	images, err := cluster.ListImages(docker.ListImagesOptions{All: true})
+	if _, ok := err.(*MultiNodeError); !ok {
+		t.Errorf("ListImages: expected *MultiNodeError, got %#v", err)
+	}
+	if !reflect.DeepEqual(images, expected[server1.URL]) {
+		t.Errorf("ListImages: Want %#v. Got %#v.", expected[server1.URL], images)
+	}
+}
+
 func TestInspectImage(t *testing.T) {
 	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"id": "id1"}`))
@@ -795,7 +875,7 @@ func TestInspectImage(t *testing.T) {
 	}))
 	defer server2.Close()
 	stor := &MapStorage{}
-	err := stor.StoreImage("tsuru/ruby", "id1", server1.URL)
+	err := stor.StoreImage(context.Background(), "tsuru/ruby", "id1", server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -842,7 +922,7 @@ func TestImageHistory(t *testing.T) {
 	}))
 	defer server2.Close()
 	stor := &MapStorage{}
-	err := stor.StoreImage("tsuru/ruby", "id1", server1.URL)
+	err := stor.StoreImage(context.Background(), "tsuru/ruby", "id1", server1.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -890,3 +970,136 @@ func TestParseImageRegistry(t *testing.T) {
 		}
 	}
 }
+
+func imageSourceServer(payload []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/images/tsuru/python/json":
+			w.Write([]byte(`{"Id": "id1"}`))
+		case "/images/tsuru/python/get":
+			w.Write(payload)
+		default:
+			http.Error(w, "No such image", http.StatusNotFound)
+		}
+	}))
+}
+
+type imageLoadServer struct {
+	sync.Mutex
+	loaded []byte
+}
+
+func (s *imageLoadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/images/load":
+		data, _ := ioutil.ReadAll(r.Body)
+		s.Lock()
+		s.loaded = data
+		s.Unlock()
+	case "/images/tsuru/python/json":
+		w.Write([]byte(`{"Id": "id1"}`))
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	payload := bytes.Repeat([]byte("layer"), 200000)
+	source := imageSourceServer(payload)
+	defer source.Close()
+	load1, load2 := &imageLoadServer{}, &imageLoadServer{}
+	target1 := httptest.NewServer(load1)
+	defer target1.Close()
+	target2 := httptest.NewServer(load2)
+	defer target2.Close()
+	cluster, err := New(nil, &MapStorage{}, "",
+		Node{Address: source.URL},
+		Node{Address: target1.URL},
+		Node{Address: target2.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cluster.CopyImage("tsuru/python", source.URL, target1.URL, target2.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*imageLoadServer{load1, load2} {
+		if !bytes.Equal(s.loaded, payload) {
+			t.Errorf("CopyImage: wrong image loaded, got %d bytes, want %d", len(s.loaded), len(payload))
+		}
+	}
+	img, err := cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := img.History
+	sort.Slice(history, func(i, j int) bool { return history[i].Node < history[j].Node })
+	expected := []ImageHistory{{Node: target1.URL, ImageId: "id1"}, {Node: target2.URL, ImageId: "id1"}}
+	sort.Slice(expected, func(i, j int) bool { return expected[i].Node < expected[j].Node })
+	if !reflect.DeepEqual(history, expected) {
+		t.Errorf("CopyImage: wrong history. Want %#v. Got %#v.", expected, history)
+	}
+}
+
+func TestCopyImageFailingTarget(t *testing.T) {
+	payload := bytes.Repeat([]byte("layer"), 200000)
+	source := imageSourceServer(payload)
+	defer source.Close()
+	load := &imageLoadServer{}
+	target1 := httptest.NewServer(load)
+	defer target1.Close()
+	target2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no space left on device", http.StatusInternalServerError)
+	}))
+	defer target2.Close()
+	cluster, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cluster.CopyImage("tsuru/python", source.URL, target1.URL, target2.URL)
+	if err == nil || !strings.Contains(err.Error(), target2.URL) {
+		t.Fatalf("CopyImage: expected error for %s, got %v", target2.URL, err)
+	}
+	if !bytes.Equal(load.loaded, payload) {
+		t.Errorf("CopyImage: wrong image loaded, got %d bytes, want %d", len(load.loaded), len(payload))
+	}
+	img, err := cluster.storage().RetrieveImage(context.Background(), "tsuru/python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ImageHistory{{Node: target1.URL, ImageId: "id1"}}
+	if !reflect.DeepEqual(img.History, expected) {
+		t.Errorf("CopyImage: wrong history. Want %#v. Got %#v.", expected, img.History)
+	}
+}
+
+func TestCopyImageNotFoundInSource(t *testing.T) {
+	source := imageSourceServer(nil)
+	defer source.Close()
+	load := &imageLoadServer{}
+	target := httptest.NewServer(load)
+	defer target.Close()
+	cluster, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cluster.CopyImage("tsuru/ruby", source.URL, target.URL)
+	if err == nil {
+		t.Fatal("CopyImage: expected non-nil error, got <nil>")
+	}
+	if load.loaded != nil {
+		t.Errorf("CopyImage: expected no image loaded, got %d bytes", len(load.loaded))
+	}
+}
+
+func TestCopyImageNoTargets(t *testing.T) {
+	cluster, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cluster.CopyImage("tsuru/python", "http://localhost:4243")
+	if err == nil {
+		t.Fatal("CopyImage: expected non-nil error, got <nil>")
+	}
+}
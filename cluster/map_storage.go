@@ -5,6 +5,7 @@
 package cluster
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -17,15 +18,19 @@ type MapStorage struct {
 	iMap    map[string]*Image
 	nodes   []Node
 	nodeMap map[string]*Node
+	nwMap   map[string]*Network
+	vMap    map[string]Volume
 	cMut    sync.Mutex
 	iMut    sync.Mutex
 	nMut    sync.Mutex
 	eMut    sync.Mutex
+	nwMut   sync.Mutex
+	vMut    sync.Mutex
 }
 
 var _ Storage = &MapStorage{}
 
-func (s *MapStorage) StoreContainer(containerID, hostID string) error {
+func (s *MapStorage) StoreContainer(ctx context.Context, containerID, hostID string) error {
 	s.cMut.Lock()
 	defer s.cMut.Unlock()
 	if s.cMap == nil {
@@ -35,7 +40,7 @@ func (s *MapStorage) StoreContainer(containerID, hostID string) error {
 	return nil
 }
 
-func (s *MapStorage) RetrieveContainer(containerID string) (string, error) {
+func (s *MapStorage) RetrieveContainer(ctx context.Context, containerID string) (string, error) {
 	s.cMut.Lock()
 	defer s.cMut.Unlock()
 	host, ok := s.cMap[containerID]
@@ -45,7 +50,7 @@ func (s *MapStorage) RetrieveContainer(containerID string) (string, error) {
 	return host, nil
 }
 
-func (s *MapStorage) RemoveContainer(containerID string) error {
+func (s *MapStorage) RemoveContainer(ctx context.Context, containerID string) error {
 	s.cMut.Lock()
 	defer s.cMut.Unlock()
 	delete(s.cMap, containerID)
@@ -59,7 +64,7 @@ func (s *MapStorage) RemoveContainer(containerID string) error {
 	return nil
 }
 
-func (s *MapStorage) RetrieveContainers() ([]Container, error) {
+func (s *MapStorage) RetrieveContainers(ctx context.Context) ([]Container, error) {
 	s.cMut.Lock()
 	defer s.cMut.Unlock()
 	entries := make([]Container, 0, len(s.cMap))
@@ -69,7 +74,7 @@ func (s *MapStorage) RetrieveContainers() ([]Container, error) {
 	return entries, nil
 }
 
-func (s *MapStorage) StoreImage(repo, id, host string) error {
+func (s *MapStorage) StoreImage(ctx context.Context, repo, id, host string) error {
 	s.iMut.Lock()
 	defer s.iMut.Unlock()
 	if s.iMap == nil {
@@ -95,7 +100,7 @@ func (s *MapStorage) StoreImage(repo, id, host string) error {
 	return nil
 }
 
-func (s *MapStorage) SetImageDigest(repo, digest string) error {
+func (s *MapStorage) SetImageDigest(ctx context.Context, repo, digest string) error {
 	s.iMut.Lock()
 	defer s.iMut.Unlock()
 	img, _ := s.iMap[repo]
@@ -108,7 +113,7 @@ func (s *MapStorage) SetImageDigest(repo, digest string) error {
 
 }
 
-func (s *MapStorage) RetrieveImage(repo string) (Image, error) {
+func (s *MapStorage) RetrieveImage(ctx context.Context, repo string) (Image, error) {
 	s.iMut.Lock()
 	defer s.iMut.Unlock()
 	image, ok := s.iMap[repo]
@@ -121,7 +126,7 @@ func (s *MapStorage) RetrieveImage(repo string) (Image, error) {
 	return *image, nil
 }
 
-func (s *MapStorage) RemoveImage(repo, id, host string) error {
+func (s *MapStorage) RemoveImage(ctx context.Context, repo, id, host string) error {
 	s.iMut.Lock()
 	defer s.iMut.Unlock()
 	image, ok := s.iMap[repo]
@@ -138,7 +143,7 @@ func (s *MapStorage) RemoveImage(repo, id, host string) error {
 	return nil
 }
 
-func (s *MapStorage) RetrieveImages() ([]Image, error) {
+func (s *MapStorage) RetrieveImages(ctx context.Context) ([]Image, error) {
 	s.iMut.Lock()
 	defer s.iMut.Unlock()
 	images := make([]Image, 0, len(s.iMap))
@@ -155,7 +160,7 @@ func (s *MapStorage) updateNodeMap() {
 	}
 }
 
-func (s *MapStorage) StoreNode(node Node) error {
+func (s *MapStorage) StoreNode(ctx context.Context, node Node) error {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	for _, n := range s.nodes {
@@ -180,7 +185,7 @@ func deepCopyNode(n Node) Node {
 	return n
 }
 
-func (s *MapStorage) RetrieveNodes() ([]Node, error) {
+func (s *MapStorage) RetrieveNodes(ctx context.Context) ([]Node, error) {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	dst := make([]Node, len(s.nodes))
@@ -190,7 +195,7 @@ func (s *MapStorage) RetrieveNodes() ([]Node, error) {
 	return dst, nil
 }
 
-func (s *MapStorage) RetrieveNode(address string) (Node, error) {
+func (s *MapStorage) RetrieveNode(ctx context.Context, address string) (Node, error) {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	if s.nodeMap == nil {
@@ -203,7 +208,7 @@ func (s *MapStorage) RetrieveNode(address string) (Node, error) {
 	return deepCopyNode(*node), nil
 }
 
-func (s *MapStorage) UpdateNode(node Node) error {
+func (s *MapStorage) UpdateNode(ctx context.Context, node Node) error {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	if s.nodeMap == nil {
@@ -227,7 +232,7 @@ func hasAllMetadata(base, wanted map[string]string) bool {
 	return true
 }
 
-func (s *MapStorage) RetrieveNodesByMetadata(metadata map[string]string) ([]Node, error) {
+func (s *MapStorage) RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]Node, error) {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	filteredNodes := []Node{}
@@ -239,7 +244,7 @@ func (s *MapStorage) RetrieveNodesByMetadata(metadata map[string]string) ([]Node
 	return filteredNodes, nil
 }
 
-func (s *MapStorage) RemoveNode(addr string) error {
+func (s *MapStorage) RemoveNode(ctx context.Context, addr string) error {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	index := -1
@@ -257,7 +262,7 @@ func (s *MapStorage) RemoveNode(addr string) error {
 	return nil
 }
 
-func (s *MapStorage) RemoveNodes(addresses []string) error {
+func (s *MapStorage) RemoveNodes(ctx context.Context, addresses []string) error {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	addrMap := map[string]struct{}{}
@@ -278,7 +283,7 @@ func (s *MapStorage) RemoveNodes(addresses []string) error {
 	return nil
 }
 
-func (s *MapStorage) LockNodeForHealing(address string, isFailure bool, timeout time.Duration) (bool, error) {
+func (s *MapStorage) LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error) {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	n, present := s.nodeMap[address]
@@ -294,7 +299,7 @@ func (s *MapStorage) LockNodeForHealing(address string, isFailure bool, timeout
 	return true, nil
 }
 
-func (s *MapStorage) ExtendNodeLock(address string, timeout time.Duration) error {
+func (s *MapStorage) ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	n, present := s.nodeMap[address]
@@ -306,7 +311,7 @@ func (s *MapStorage) ExtendNodeLock(address string, timeout time.Duration) error
 	return nil
 }
 
-func (s *MapStorage) UnlockNode(address string) error {
+func (s *MapStorage) UnlockNode(ctx context.Context, address string) error {
 	s.nMut.Lock()
 	defer s.nMut.Unlock()
 	n, present := s.nodeMap[address]
@@ -317,7 +322,7 @@ func (s *MapStorage) UnlockNode(address string) error {
 	return nil
 }
 
-func (s *MapStorage) StoreExec(execID, containerID string) error {
+func (s *MapStorage) StoreExec(ctx context.Context, execID, containerID string) error {
 	s.eMut.Lock()
 	defer s.eMut.Unlock()
 	if s.eMap == nil {
@@ -327,7 +332,7 @@ func (s *MapStorage) StoreExec(execID, containerID string) error {
 	return nil
 }
 
-func (s *MapStorage) RetrieveExec(execID string) (containerID string, err error) {
+func (s *MapStorage) RetrieveExec(ctx context.Context, execID string) (containerID string, err error) {
 	s.eMut.Lock()
 	defer s.eMut.Unlock()
 	containerID, ok := s.eMap[execID]
@@ -336,3 +341,141 @@ func (s *MapStorage) RetrieveExec(execID string) (containerID string, err error)
 	}
 	return containerID, nil
 }
+
+func (s *MapStorage) RetrieveExecs(ctx context.Context) ([]Exec, error) {
+	s.eMut.Lock()
+	defer s.eMut.Unlock()
+	entries := make([]Exec, 0, len(s.eMap))
+	for k, v := range s.eMap {
+		entries = append(entries, Exec{Id: k, Container: v})
+	}
+	return entries, nil
+}
+
+func copyNetwork(n Network) Network {
+	if n.Nodes != nil {
+		n.Nodes = append([]NetworkNode{}, n.Nodes...)
+	}
+	return n
+}
+
+func (s *MapStorage) StoreNetwork(ctx context.Context, network Network) error {
+	s.nwMut.Lock()
+	defer s.nwMut.Unlock()
+	if s.nwMap == nil {
+		s.nwMap = make(map[string]*Network)
+	}
+	if _, ok := s.nwMap[network.Name]; ok {
+		return storage.ErrDuplicatedNetwork
+	}
+	network = copyNetwork(network)
+	s.nwMap[network.Name] = &network
+	return nil
+}
+
+func (s *MapStorage) RetrieveNetwork(ctx context.Context, name string) (Network, error) {
+	s.nwMut.Lock()
+	defer s.nwMut.Unlock()
+	network, ok := s.nwMap[name]
+	if !ok {
+		return Network{}, storage.ErrNoSuchNetwork
+	}
+	return copyNetwork(*network), nil
+}
+
+func (s *MapStorage) RetrieveNetworks(ctx context.Context) ([]Network, error) {
+	s.nwMut.Lock()
+	defer s.nwMut.Unlock()
+	networks := make([]Network, 0, len(s.nwMap))
+	for _, network := range s.nwMap {
+		networks = append(networks, copyNetwork(*network))
+	}
+	return networks, nil
+}
+
+func (s *MapStorage) RemoveNetwork(ctx context.Context, name string) error {
+	s.nwMut.Lock()
+	defer s.nwMut.Unlock()
+	if _, ok := s.nwMap[name]; !ok {
+		return storage.ErrNoSuchNetwork
+	}
+	delete(s.nwMap, name)
+	return nil
+}
+
+func (s *MapStorage) AddNetworkNode(ctx context.Context, name, id, host string) error {
+	s.nwMut.Lock()
+	defer s.nwMut.Unlock()
+	network, ok := s.nwMap[name]
+	if !ok {
+		return storage.ErrNoSuchNetwork
+	}
+	for i := range network.Nodes {
+		if network.Nodes[i].Node == host {
+			network.Nodes[i].ID = id
+			return nil
+		}
+	}
+	network.Nodes = append(network.Nodes, NetworkNode{Node: host, ID: id})
+	return nil
+}
+
+func (s *MapStorage) RemoveNetworkNode(ctx context.Context, name, host string) error {
+	s.nwMut.Lock()
+	defer s.nwMut.Unlock()
+	network, ok := s.nwMap[name]
+	if !ok {
+		return storage.ErrNoSuchNetwork
+	}
+	nodes := []NetworkNode{}
+	for _, nn := range network.Nodes {
+		if nn.Node != host {
+			nodes = append(nodes, nn)
+		}
+	}
+	network.Nodes = nodes
+	return nil
+}
+
+func (s *MapStorage) StoreVolume(ctx context.Context, volume Volume) error {
+	s.vMut.Lock()
+	defer s.vMut.Unlock()
+	if s.vMap == nil {
+		s.vMap = make(map[string]Volume)
+	}
+	if _, ok := s.vMap[volume.Name]; ok {
+		return storage.ErrDuplicatedVolume
+	}
+	s.vMap[volume.Name] = volume
+	return nil
+}
+
+func (s *MapStorage) RetrieveVolume(ctx context.Context, name string) (Volume, error) {
+	s.vMut.Lock()
+	defer s.vMut.Unlock()
+	volume, ok := s.vMap[name]
+	if !ok {
+		return Volume{}, storage.ErrNoSuchVolume
+	}
+	return volume, nil
+}
+
+func (s *MapStorage) RetrieveVolumes(ctx context.Context) ([]Volume, error) {
+	s.vMut.Lock()
+	defer s.vMut.Unlock()
+	volumes := make([]Volume, 0, len(s.vMap))
+	for _, volume := range s.vMap {
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+func (s *MapStorage) RemoveVolume(ctx context.Context, name string) error {
+	s.vMut.Lock()
+	defer s.vMut.Unlock()
+	if _, ok := s.vMap[name]; !ok {
+		return storage.ErrNoSuchVolume
+	}
+	delete(s.vMap, name)
+	return nil
+}
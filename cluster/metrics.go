@@ -0,0 +1,57 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import "time"
+
+// Metrics receives measurements of the operations performed by the cluster.
+// Implementations must be safe for concurrent use, and should be fast, as
+// they're called inline by the cluster operations.
+//
+// The metrics package provides an implementation that can be registered in
+// a Prometheus registry.
+type Metrics interface {
+	// CreateContainerRetry is called when creating a container in the given
+	// node fails and CreateContainer is going to try again.
+	CreateContainerRetry(node string)
+
+	// PullImageDuration is called after pulling an image in the given node,
+	// with the duration of the pull and its error, if any.
+	PullImageDuration(node string, duration time.Duration, err error)
+
+	// NodeFailure is called after an error is registered in the given node.
+	// disabled tells whether the healer disabled the node because of it.
+	NodeFailure(node string, disabled bool)
+
+	// NodeSuccess is called after a successful operation in the given node.
+	// healed tells whether the node was failing or disabled before it.
+	NodeSuccess(node string, healed bool)
+
+	// HealerLockContention is called when the healing lock of the given node
+	// can't be acquired because another operation is holding it.
+	HealerLockContention(node string)
+
+	// RunOnNodesDuration is called after running an operation in a set of
+	// nodes, with the number of nodes and the time spent waiting for them.
+	RunOnNodesDuration(nodes int, duration time.Duration)
+}
+
+// NoopMetrics is the default implementation of Metrics, and discards all
+// measurements.
+type NoopMetrics struct{}
+
+func (NoopMetrics) CreateContainerRetry(node string)                                 {}
+func (NoopMetrics) PullImageDuration(node string, duration time.Duration, err error) {}
+func (NoopMetrics) NodeFailure(node string, disabled bool)                           {}
+func (NoopMetrics) NodeSuccess(node string, healed bool)                             {}
+func (NoopMetrics) HealerLockContention(node string)                                 {}
+func (NoopMetrics) RunOnNodesDuration(nodes int, duration time.Duration)             {}
+
+func (c *Cluster) metrics() Metrics {
+	if c.Metrics == nil {
+		return NoopMetrics{}
+	}
+	return c.Metrics
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type recordingMetrics struct {
+	sync.Mutex
+	calls []string
+}
+
+func (m *recordingMetrics) record(call string) {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, call)
+}
+
+func (m *recordingMetrics) recorded() []string {
+	m.Lock()
+	defer m.Unlock()
+	calls := make([]string, len(m.calls))
+	copy(calls, m.calls)
+	sort.Strings(calls)
+	return calls
+}
+
+func (m *recordingMetrics) CreateContainerRetry(node string) {
+	m.record("retry " + node)
+}
+
+func (m *recordingMetrics) PullImageDuration(node string, duration time.Duration, err error) {
+	if err != nil {
+		m.record("pull-error " + node)
+	} else {
+		m.record("pull " + node)
+	}
+}
+
+func (m *recordingMetrics) NodeFailure(node string, disabled bool) {
+	if disabled {
+		m.record("failure-disabled " + node)
+	} else {
+		m.record("failure " + node)
+	}
+}
+
+func (m *recordingMetrics) NodeSuccess(node string, healed bool) {
+	if healed {
+		m.record("success-healed " + node)
+	} else {
+		m.record("success " + node)
+	}
+}
+
+func (m *recordingMetrics) HealerLockContention(node string) {
+	m.record("contention " + node)
+}
+
+func (m *recordingMetrics) RunOnNodesDuration(nodes int, duration time.Duration) {
+	m.record("run-on-nodes")
+}
+
+func TestNewUsesNoopMetrics(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Metrics.(NoopMetrics); !ok {
+		t.Errorf("New: expected NoopMetrics, got %#v", c.Metrics)
+	}
+	c.Metrics = nil
+	if _, ok := c.metrics().(NoopMetrics); !ok {
+		t.Errorf("metrics: expected NoopMetrics, got %#v", c.metrics())
+	}
+}
+
+func TestMetricsCreateContainerRetry(t *testing.T) {
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "NoSuchImage", http.StatusNotFound)
+	}))
+	defer server1.Close()
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Id":"e90302"}`))
+	}))
+	defer server2.Close()
+	c, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL},
+		Node{Address: server2.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := &recordingMetrics{}
+	c.Metrics = metrics
+	updated := make(chan struct{})
+	nodeUpdatedOnError.Store(func() { close(updated) })
+	defer nodeUpdatedOnError.Store(func() {})
+	config := docker.Config{Image: "myimg"}
+	_, _, err = c.CreateContainer(docker.CreateContainerOptions{Config: &config}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-updated
+	expected := []string{
+		"failure-disabled " + server1.URL,
+		"pull " + server2.URL,
+		"pull-error " + server1.URL,
+		"retry " + server1.URL,
+		"run-on-nodes",
+		"run-on-nodes",
+		"success " + server2.URL,
+	}
+	if calls := metrics.recorded(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Metrics: wrong calls.\nWant %#v.\nGot  %#v.", expected, calls)
+	}
+}
+
+func TestMetricsNodeHealed(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "", Node{Address: "http://localhost:1", Metadata: map[string]string{"Failures": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := &recordingMetrics{}
+	c.Metrics = metrics
+	err = c.handleNodeSuccess("http://localhost:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.handleNodeSuccess("http://localhost:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"success http://localhost:1", "success-healed http://localhost:1"}
+	if calls := metrics.recorded(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Metrics: wrong calls.\nWant %#v.\nGot  %#v.", expected, calls)
+	}
+}
+
+func TestMetricsHealerLockContention(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "", Node{Address: "http://localhost:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := &recordingMetrics{}
+	c.Metrics = metrics
+	unlock, err := c.lockWithTimeout(context.Background(), "http://localhost:1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	err = c.handleNodeSuccess("http://localhost:1")
+	if err != errHealerInProgress {
+		t.Fatalf("handleNodeSuccess: expected errHealerInProgress, got %#v", err)
+	}
+	expected := []string{"contention http://localhost:1"}
+	if calls := metrics.recorded(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Metrics: wrong calls.\nWant %#v.\nGot  %#v.", expected, calls)
+	}
+}
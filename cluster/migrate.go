@@ -0,0 +1,217 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+var (
+	errMigrateSameNode = errors.New("Container is already in the target node")
+	errMigrateVolume   = errors.New("Container mounts a named volume of the cluster and can't leave its node")
+)
+
+// MigrateContainerOptions are the options for MigrateContainerOpts.
+type MigrateContainerOptions struct {
+	// ID of the container to migrate.
+	ID string
+
+	// Node is the address of the node that will run the container. When
+	// it's empty, the node is chosen by the scheduler of the cluster, with
+	// the given SchedulerOpts.
+	Node          string
+	SchedulerOpts SchedulerOptions
+
+	// CommitRepository, when set, makes the container be committed to an
+	// image in this repository, which is pushed and then pulled in the
+	// target node, so the changes in the container filesystem are kept.
+	// Otherwise the container is recreated from its original image.
+	CommitRepository string
+	CommitTag        string
+
+	// RegistryAuth is used to push and pull the images.
+	RegistryAuth docker.AuthConfiguration
+
+	// StopTimeout is the number of seconds to wait for the container to
+	// stop before killing it.
+	StopTimeout uint
+
+	// Context, if set, cancels the migration. The rollback runs even if
+	// the context is cancelled.
+	Context context.Context
+}
+
+// MigrationCleanupError is returned by MigrateContainerOpts, along with the
+// new container, when the container was migrated but the original one
+// couldn't be removed. The original container is still tracked by the
+// cluster, stopped if it was running, and must be removed by the caller.
+type MigrationCleanupError struct {
+	Container string
+	Node      string
+	Err       error
+}
+
+func (e *MigrationCleanupError) Error() string {
+	return fmt.Sprintf("Container migrated, but the original container %q couldn't be removed from node %q: %s", e.Container, e.Node, e.Err)
+}
+
+// MigrateContainer recreates the given container in the target node, with
+// the same name and configuration, and removes the original one. It's a
+// shortcut for MigrateContainerOpts without committing the container.
+func (c *Cluster) MigrateContainer(id, targetNode string) (*docker.Container, error) {
+	return c.MigrateContainerOpts(MigrateContainerOptions{ID: id, Node: targetNode})
+}
+
+// MigrateContainerOpts recreates a container in another node, returning the
+// new container.
+//
+// Containers that mount named volumes of the cluster can't be migrated, as the
+// volumes are local to their node.
+//
+// The original container is stopped before the new one is started, if it was
+// running, and removed only after the new container is stored. If any step
+// before that fails, the new container is removed and the original one is
+// started again, leaving the cluster as it was. If removing the original
+// container fails, the new container is returned along with a
+// *MigrationCleanupError.
+func (c *Cluster) MigrateContainerOpts(opts MigrateContainerOptions) (*docker.Container, error) {
+	ctx := optsContext(opts.Context)
+	source, err := c.getNodeForContainer(ctx, opts.ID)
+	if err != nil {
+		return nil, err
+	}
+	cont, err := source.InspectContainer(opts.ID)
+	if err != nil {
+		return nil, wrapError(source, err)
+	}
+	config := *cont.Config
+	createOpts := docker.CreateContainerOptions{
+		Name:       strings.TrimPrefix(cont.Name, "/"),
+		Config:     &config,
+		HostConfig: cont.HostConfig,
+	}
+	volumeAddr, err := c.volumeNode(ctx, createOpts)
+	if err != nil {
+		return nil, err
+	}
+	if volumeAddr != "" {
+		return nil, errMigrateVolume
+	}
+	if opts.Node == "" {
+		if reserving, ok := c.scheduler.(ReservingScheduler); ok {
+			defer reserving.Release(&createOpts)
+		}
+		node, scheduleErr := c.scheduler.Schedule(c, &createOpts, opts.SchedulerOpts)
+		if scheduleErr != nil {
+			return nil, scheduleErr
+		}
+		opts.Node = node.Address
+	}
+	if source.addr == opts.Node {
+		return nil, errMigrateSameNode
+	}
+	_, err = c.storage().RetrieveNode(ctx, opts.Node)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CommitRepository != "" {
+		config.Image, err = c.commitForMigration(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.runHookForAddr(ctx, HookEventBeforeContainerCreate, opts.Node, HookPayload{CreateOptions: &createOpts})
+	if err != nil {
+		return nil, err
+	}
+	pullOpts := docker.PullImageOptions{Repository: config.Image, Context: ctx}
+	newCont, err := c.createContainerInNode(ctx, createOpts, pullOpts, opts.RegistryAuth, opts.Node)
+	if err != nil {
+		return nil, err
+	}
+	target, err := c.getNodeByAddr(ctx, opts.Node)
+	if err != nil {
+		return nil, err
+	}
+	rollback := func(cause error) error {
+		// The rollback uses new clients, as the ones of the migration
+		// fail after ctx is cancelled.
+		source, target := source, target
+		if n, nodeErr := c.getNodeByAddr(context.Background(), source.addr); nodeErr == nil {
+			source = n
+		}
+		if n, nodeErr := c.getNodeByAddr(context.Background(), target.addr); nodeErr == nil {
+			target = n
+		}
+		rmErr := target.RemoveContainer(docker.RemoveContainerOptions{ID: newCont.ID, Force: true})
+		if rmErr != nil {
+			log.Error("[migrate]: error removing new container during rollback", log.Fields{"container": newCont.ID, "node": target.addr, "error": rmErr})
+		}
+		if cont.State.Running {
+			startErr := source.StartContainer(opts.ID, nil)
+			if startErr != nil {
+				log.Error("[migrate]: error restarting container during rollback", log.Fields{"container": opts.ID, "node": source.addr, "error": startErr})
+			}
+		}
+		return cause
+	}
+	if cont.State.Running {
+		err = source.StopContainer(opts.ID, opts.StopTimeout)
+		if err != nil {
+			if _, ok := err.(*docker.ContainerNotRunning); !ok {
+				return nil, rollback(wrapError(source, err))
+			}
+		}
+		err = target.StartContainer(newCont.ID, nil)
+		if err != nil {
+			return nil, rollback(wrapError(target, err))
+		}
+	}
+	err = c.runHookForAddr(ctx, HookEventAfterContainerCreate, opts.Node, HookPayload{CreateOptions: &createOpts, ContainerID: newCont.ID})
+	if err != nil {
+		return nil, rollback(err)
+	}
+	err = c.storage().StoreContainer(ctx, newCont.ID, opts.Node)
+	if err != nil {
+		return nil, rollback(err)
+	}
+	var cleanupErr error
+	err = c.removeFromStorage(docker.RemoveContainerOptions{ID: opts.ID, Force: true})
+	if err != nil {
+		log.Error("[migrate]: error removing migrated container", log.Fields{"container": opts.ID, "node": source.addr, "error": err})
+		cleanupErr = &MigrationCleanupError{Container: opts.ID, Node: source.addr, Err: err}
+	}
+	if inspected, inspectErr := target.InspectContainer(newCont.ID); inspectErr == nil {
+		newCont = inspected
+	}
+	return newCont, cleanupErr
+}
+
+func (c *Cluster) commitForMigration(ctx context.Context, opts MigrateContainerOptions) (string, error) {
+	_, err := c.CommitContainer(docker.CommitContainerOptions{
+		Container:  opts.ID,
+		Repository: opts.CommitRepository,
+		Tag:        opts.CommitTag,
+		Context:    ctx,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = c.PushImage(docker.PushImageOptions{
+		Name:    opts.CommitRepository,
+		Tag:     opts.CommitTag,
+		Context: ctx,
+	}, opts.RegistryAuth)
+	if err != nil {
+		return "", err
+	}
+	return imageKey(opts.CommitRepository, opts.CommitTag), nil
+}
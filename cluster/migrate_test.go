@@ -0,0 +1,190 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+)
+
+func TestMigrateContainer(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}, Env: []string{"A=1"}}
+	_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Name: "web-1", Config: &config}, time.Minute, server1.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StartContainer(cont.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCont, err := c.MigrateContainer(cont.ID, server2.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newCont.ID == cont.ID {
+		t.Fatal("MigrateContainer: expected a new container")
+	}
+	if newCont.Name != "web-1" || !newCont.State.Running {
+		t.Errorf("MigrateContainer: unexpected container %#v", newCont)
+	}
+	if len(newCont.Config.Env) != 1 || newCont.Config.Env[0] != "A=1" {
+		t.Errorf("MigrateContainer: wrong config %#v", newCont.Config)
+	}
+	host, err := c.storage().RetrieveContainer(context.Background(), newCont.ID)
+	if err != nil || host != server2.URL() {
+		t.Errorf("MigrateContainer: wrong host in storage. Want %q. Got %q (%v).", server2.URL(), host, err)
+	}
+	_, err = c.storage().RetrieveContainer(context.Background(), cont.ID)
+	if err == nil {
+		t.Error("MigrateContainer: expected old container to be removed from storage")
+	}
+	client, _ := docker.NewClient(server1.URL())
+	containers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 0 {
+		t.Errorf("MigrateContainer: expected old container to be removed, got %#v", containers)
+	}
+}
+
+func TestMigrateContainerCleanupFailure(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}, Env: []string{"A=1"}}
+	_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Name: "web-1", Config: &config}, time.Minute, server1.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StartContainer(cont.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server1.PrepareFailure("remove failure", "/containers/"+cont.ID+"$")
+	newCont, err := c.MigrateContainer(cont.ID, server2.URL())
+	cleanupErr, ok := err.(*MigrationCleanupError)
+	if !ok {
+		t.Fatalf("MigrateContainer: expected *MigrationCleanupError, got %#v", err)
+	}
+	if cleanupErr.Container != cont.ID || cleanupErr.Node != server1.URL() {
+		t.Errorf("MigrateContainer: wrong cleanup error %#v", cleanupErr)
+	}
+	if newCont == nil || !newCont.State.Running {
+		t.Fatalf("MigrateContainer: expected new running container, got %#v", newCont)
+	}
+	host, err := c.storage().RetrieveContainer(context.Background(), newCont.ID)
+	if err != nil || host != server2.URL() {
+		t.Errorf("MigrateContainer: wrong host in storage. Want %q. Got %q (%v).", server2.URL(), host, err)
+	}
+	host, err = c.storage().RetrieveContainer(context.Background(), cont.ID)
+	if err != nil || host != server1.URL() {
+		t.Errorf("MigrateContainer: expected original container to stay tracked. Got %q (%v).", host, err)
+	}
+}
+
+func TestMigrateContainerSameNode(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}, Env: []string{"A=1"}}
+	_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Name: "web-1", Config: &config}, time.Minute, server1.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StartContainer(cont.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.MigrateContainer(cont.ID, server1.URL())
+	if err != errMigrateSameNode {
+		t.Errorf("MigrateContainer: expected errMigrateSameNode, got %#v", err)
+	}
+}
+
+func TestMigrateContainerRollback(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c := newMapCluster(t, server1.URL(), server2.URL())
+	config := docker.Config{Image: "myhost/somwhere/myimg", Cmd: []string{"tail", "-f"}, Env: []string{"A=1"}}
+	_, cont, err := c.CreateContainer(docker.CreateContainerOptions{Name: "web-1", Config: &config}, time.Minute, server1.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StartContainer(cont.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server2.PrepareFailure("start-error", "/containers/.*/start")
+	_, err = c.MigrateContainer(cont.ID, server2.URL())
+	if err == nil {
+		t.Fatal("MigrateContainer: expected error, got <nil>")
+	}
+	host, err := c.storage().RetrieveContainer(context.Background(), cont.ID)
+	if err != nil || host != server1.URL() {
+		t.Errorf("MigrateContainer: wrong host in storage. Want %q. Got %q (%v).", server1.URL(), host, err)
+	}
+	containers, err := c.storage().RetrieveContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 {
+		t.Errorf("MigrateContainer: expected only the original container in storage, got %#v", containers)
+	}
+	client, _ := docker.NewClient(server2.URL())
+	apiContainers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apiContainers) != 0 {
+		t.Errorf("MigrateContainer: expected new container to be removed, got %#v", apiContainers)
+	}
+	inspected, err := c.InspectContainer(cont.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inspected.State.Running {
+		t.Error("MigrateContainer: expected original container to be running again")
+	}
+}
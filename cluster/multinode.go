@@ -0,0 +1,101 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NodeFailures lists the nodes where an operation run in every node of the
+// cluster failed or wasn't run.
+type NodeFailures struct {
+	// Errors maps the address of the nodes where the operation failed to
+	// the error.
+	Errors map[string]error
+
+	// Skipped are the registered nodes where the operation wasn't run,
+	// because they're disabled or because the context was canceled before
+	// reaching them.
+	Skipped []string
+}
+
+// MultiNodeError is the error of an operation that failed in some of the
+// nodes of the cluster.
+type MultiNodeError struct {
+	Op     string
+	Errors map[string]error
+
+	// Nodes is the number of nodes where the operation was run.
+	Nodes int
+}
+
+func (e *MultiNodeError) Error() string {
+	addrs := make([]string, 0, len(e.Errors))
+	for addr := range e.Errors {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	msgs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		msgs[i] = fmt.Sprintf("%s: %s", addr, e.Errors[addr])
+	}
+	return fmt.Sprintf("Unable to %s in %d of %d nodes: %s", e.Op, len(e.Errors), e.Nodes, strings.Join(msgs, "; "))
+}
+
+func (f *NodeFailures) err(op string, succeeded int) error {
+	if len(f.Errors) == 0 {
+		return nil
+	}
+	return &MultiNodeError{Op: op, Errors: f.Errors, Nodes: succeeded + len(f.Errors)}
+}
+
+// runOnEachNode runs fn in parallel in every registered node, or only in the
+// enabled ones when skipDisabled is set, returning the values returned by fn
+// mapped to the node address. Unlike runOnNodes, it doesn't stop at the first
+// error: the errors of each node and the skipped nodes are returned in the
+// NodeFailures.
+func (c *Cluster) runOnEachNode(ctx context.Context, fn nodeFunc, skipDisabled bool) (map[string]interface{}, NodeFailures, error) {
+	nodes, err := c.UnfilteredNodesWithContext(ctx)
+	if err != nil {
+		return nil, NodeFailures{}, err
+	}
+	values := make(map[string]interface{}, len(nodes))
+	failures := NodeFailures{Errors: make(map[string]error)}
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	for i := range nodes {
+		addr := nodes[i].Address
+		if (skipDisabled && !nodes[i].isEnabled()) || ctx.Err() != nil {
+			failures.Skipped = append(failures.Skipped, addr)
+			continue
+		}
+		client, err := c.getNodeByAddr(ctx, addr)
+		if err != nil {
+			mut.Lock()
+			failures.Errors[addr] = err
+			mut.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(addr string, n node) {
+			defer wg.Done()
+			value, err := fn(n)
+			mut.Lock()
+			defer mut.Unlock()
+			if err != nil {
+				failures.Errors[addr] = wrapError(n, err)
+				return
+			}
+			values[addr] = value
+		}(addr, client)
+	}
+	wg.Wait()
+	sort.Strings(failures.Skipped)
+	return values, failures, nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+// Network is a network created in the cluster. The network exists
+// independently in each node, with a different ID, and is created in new
+// nodes when a container that requires it is created there.
+type Network struct {
+	Name       string `bson:"_id"`
+	Driver     string
+	Options    map[string]interface{}
+	Labels     map[string]string
+	IPAM       *docker.IPAMOptions
+	Internal   bool
+	EnableIPv6 bool
+	Attachable bool
+	Nodes      []NetworkNode
+}
+
+// NetworkNode is the ID of a network in a node.
+type NetworkNode struct {
+	Node string
+	ID   string
+}
+
+// IDInNode returns the ID of the network in the given node, if the network
+// exists there.
+func (n *Network) IDInNode(node string) (string, bool) {
+	for _, nn := range n.Nodes {
+		if nn.Node == node {
+			return nn.ID, true
+		}
+	}
+	return "", false
+}
+
+func (n *Network) createOptions() docker.CreateNetworkOptions {
+	return docker.CreateNetworkOptions{
+		Name:       n.Name,
+		Driver:     n.Driver,
+		Options:    n.Options,
+		Labels:     n.Labels,
+		IPAM:       n.IPAM,
+		Internal:   n.Internal,
+		EnableIPv6: n.EnableIPv6,
+		Attachable: n.Attachable,
+	}
+}
+
+// CreateNetwork creates a network in the given nodes, or in all the enabled
+// nodes if none is given, and stores its definition so it can be created in
+// other nodes later. Nodes where the creation fails are skipped, as the
+// network is created in them when needed, but an error is returned if it
+// can't be created in any node.
+func (c *Cluster) CreateNetwork(opts docker.CreateNetworkOptions, nodes ...string) (*Network, error) {
+	ctx := optsContext(opts.Context)
+	if opts.Name == "" {
+		return nil, errors.New("Invalid network name")
+	}
+	if len(nodes) == 0 {
+		enabled, err := c.NodesWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range enabled {
+			nodes = append(nodes, n.Address)
+		}
+	}
+	network := Network{
+		Name:       opts.Name,
+		Driver:     opts.Driver,
+		Options:    opts.Options,
+		Labels:     opts.Labels,
+		IPAM:       opts.IPAM,
+		Internal:   opts.Internal,
+		EnableIPv6: opts.EnableIPv6,
+		Attachable: opts.Attachable,
+	}
+	err := c.storage().StoreNetwork(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes))
+	for _, addr := range nodes {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := c.createNetworkInNode(ctx, &network, addr); err != nil {
+				log.Error("Error creating network, skipping node", log.Fields{"network": network.Name, "node": addr, "error": err})
+				errs <- err
+			}
+		}(addr)
+	}
+	wg.Wait()
+	close(errs)
+	if len(nodes) > 0 && len(errs) == len(nodes) {
+		c.storage().RemoveNetwork(context.Background(), network.Name)
+		return nil, fmt.Errorf("Unable to create network %q in any node: %s", network.Name, <-errs)
+	}
+	stored, err := c.storage().RetrieveNetwork(ctx, network.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (c *Cluster) createNetworkInNode(ctx context.Context, network *Network, addr string) error {
+	n, err := c.getNodeByAddr(ctx, addr)
+	if err != nil {
+		return err
+	}
+	created, err := n.CreateNetwork(network.createOptions())
+	if err == docker.ErrNetworkAlreadyExists {
+		created, err = n.NetworkInfo(network.Name)
+	}
+	if err != nil {
+		return wrapError(n, err)
+	}
+	return c.storage().AddNetworkNode(ctx, network.Name, created.ID, addr)
+}
+
+// ensureNetworksInNode creates the networks used by the given container
+// options in the node, when they're networks of the cluster that don't
+// exist there yet. Unknown networks are left for docker to handle.
+func (c *Cluster) ensureNetworksInNode(ctx context.Context, opts docker.CreateContainerOptions, addr string) error {
+	for _, name := range containerNetworks(opts) {
+		network, err := c.storage().RetrieveNetwork(ctx, name)
+		if err == storage.ErrNoSuchNetwork {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := network.IDInNode(addr); ok {
+			continue
+		}
+		err = c.createNetworkInNode(ctx, &network, addr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containerNetworks(opts docker.CreateContainerOptions) []string {
+	var names []string
+	if opts.HostConfig != nil {
+		mode := opts.HostConfig.NetworkMode
+		switch {
+		case mode == "", mode == "default", mode == "bridge", mode == "host", mode == "none":
+		case strings.HasPrefix(mode, "container:"):
+		default:
+			names = append(names, mode)
+		}
+	}
+	if opts.NetworkingConfig != nil {
+		for name := range opts.NetworkingConfig.EndpointsConfig {
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// RemoveNetwork removes the network from all the nodes where it exists and
+// then removes its definition. If the removal fails in any node, the network
+// is kept in the storage, with the nodes where it still exists.
+func (c *Cluster) RemoveNetwork(name string) error {
+	return c.RemoveNetworkWithContext(context.Background(), name)
+}
+
+// RemoveNetworkWithContext is like RemoveNetwork, but using ctx to cancel the operation.
+func (c *Cluster) RemoveNetworkWithContext(ctx context.Context, name string) error {
+	network, err := c.storage().RetrieveNetwork(ctx, name)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(network.Nodes))
+	for _, nn := range network.Nodes {
+		wg.Add(1)
+		go func(nn NetworkNode) {
+			defer wg.Done()
+			n, err := c.getNodeByAddr(ctx, nn.Node)
+			if err != nil {
+				errs <- err
+				return
+			}
+			err = n.RemoveNetwork(nn.ID)
+			if _, notFound := err.(*docker.NoSuchNetwork); err != nil && !notFound {
+				errs <- wrapError(n, err)
+				return
+			}
+			err = c.storage().RemoveNetworkNode(ctx, name, nn.Node)
+			if err != nil {
+				errs <- err
+			}
+		}(nn)
+	}
+	wg.Wait()
+	close(errs)
+	if err, ok := <-errs; ok {
+		return err
+	}
+	return c.storage().RemoveNetwork(ctx, name)
+}
+
+// ListNetworks returns the networks of the cluster.
+func (c *Cluster) ListNetworks() ([]Network, error) {
+	return c.ListNetworksWithContext(context.Background())
+}
+
+// ListNetworksWithContext is like ListNetworks, but using ctx to cancel the operation.
+func (c *Cluster) ListNetworksWithContext(ctx context.Context) ([]Network, error) {
+	return c.storage().RetrieveNetworks(ctx)
+}
+
+// ConnectNetwork connects a container to a network of the cluster, creating
+// the network in the node of the container if needed.
+func (c *Cluster) ConnectNetwork(name string, opts docker.NetworkConnectionOptions) error {
+	ctx := optsContext(opts.Context)
+	n, id, err := c.networkForContainer(ctx, name, opts.Container, true)
+	if err != nil {
+		return err
+	}
+	return wrapError(n, n.ConnectNetwork(id, opts))
+}
+
+// DisconnectNetwork disconnects a container from a network of the cluster.
+func (c *Cluster) DisconnectNetwork(name string, opts docker.NetworkConnectionOptions) error {
+	ctx := optsContext(opts.Context)
+	n, id, err := c.networkForContainer(ctx, name, opts.Container, false)
+	if err != nil {
+		return err
+	}
+	return wrapError(n, n.DisconnectNetwork(id, opts))
+}
+
+func (c *Cluster) networkForContainer(ctx context.Context, name, container string, create bool) (node, string, error) {
+	n, err := c.getNodeForContainer(ctx, container)
+	if err != nil {
+		return node{}, "", err
+	}
+	network, err := c.storage().RetrieveNetwork(ctx, name)
+	if err != nil {
+		return node{}, "", err
+	}
+	id, ok := network.IDInNode(n.addr)
+	if !ok {
+		if !create {
+			return node{}, "", &docker.NoSuchNetwork{ID: name}
+		}
+		err = c.createNetworkInNode(ctx, &network, n.addr)
+		if err != nil {
+			return node{}, "", err
+		}
+		network, err = c.storage().RetrieveNetwork(ctx, name)
+		if err != nil {
+			return node{}, "", err
+		}
+		id, _ = network.IDInNode(n.addr)
+	}
+	return n, id, nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+func networkNames(t *testing.T, addr string) []string {
+	client, err := docker.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	networks, err := client.ListNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, n := range networks {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+func TestCreateNetwork(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL()},
+		Node{Address: server2.URL()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network, err := c.CreateNetwork(docker.CreateNetworkOptions{Name: "net1", Driver: "bridge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network.Name != "net1" || network.Driver != "bridge" {
+		t.Errorf("CreateNetwork: wrong network %#v", network)
+	}
+	for _, addr := range []string{server1.URL(), server2.URL()} {
+		if id, ok := network.IDInNode(addr); !ok || id == "" {
+			t.Errorf("CreateNetwork: expected network ID in node %s, got %#v", addr, network.Nodes)
+		}
+		if names := networkNames(t, addr); !reflect.DeepEqual(names, []string{"net1"}) {
+			t.Errorf("CreateNetwork: wrong networks in node %s: %#v", addr, names)
+		}
+	}
+	networks, err := c.ListNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 || networks[0].Name != "net1" || len(networks[0].Nodes) != 2 {
+		t.Errorf("ListNetworks: wrong networks %#v", networks)
+	}
+}
+
+func TestCreateNetworkSkipsFailingNodes(t *testing.T) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	network, err := c.CreateNetwork(docker.CreateNetworkOptions{Name: "net1"}, server.URL(), "http://localhost:99999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NetworkNode{{Node: server.URL(), ID: network.Nodes[0].ID}}
+	if !reflect.DeepEqual(network.Nodes, expected) {
+		t.Errorf("CreateNetwork: wrong nodes. Want %#v. Got %#v", expected, network.Nodes)
+	}
+}
+
+func TestCreateNetworkFailsInAllNodes(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateNetwork(docker.CreateNetworkOptions{Name: "net1"}, "http://localhost:99999")
+	if err == nil {
+		t.Fatal("CreateNetwork: expected non-nil error, got <nil>")
+	}
+	_, err = c.storage().RetrieveNetwork(context.Background(), "net1")
+	if err != storage.ErrNoSuchNetwork {
+		t.Errorf("CreateNetwork: expected definition to be removed, got error %v", err)
+	}
+}
+
+func TestCreateNetworkInvalidName(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateNetwork(docker.CreateNetworkOptions{})
+	if err == nil {
+		t.Fatal("CreateNetwork: expected non-nil error, got <nil>")
+	}
+}
+
+func TestCreateContainerCreatesMissingNetwork(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server1.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateNetwork(docker.CreateNetworkOptions{Name: "net1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Register(Node{Address: server2.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{
+		Config:     &docker.Config{Image: "myimg"},
+		HostConfig: &docker.HostConfig{NetworkMode: "net1"},
+	}
+	_, _, err = c.CreateContainer(opts, 0, server2.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := networkNames(t, server2.URL()); !reflect.DeepEqual(names, []string{"net1"}) {
+		t.Errorf("CreateContainer: expected network to be created in the node, got %#v", names)
+	}
+	network, err := c.storage().RetrieveNetwork(context.Background(), "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []string
+	for _, nn := range network.Nodes {
+		nodes = append(nodes, nn.Node)
+	}
+	sort.Strings(nodes)
+	expected := []string{server1.URL(), server2.URL()}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("CreateContainer: wrong network nodes. Want %#v. Got %#v", expected, nodes)
+	}
+}
+
+func TestContainerNetworks(t *testing.T) {
+	var tests = []struct {
+		opts     docker.CreateContainerOptions
+		expected []string
+	}{
+		{docker.CreateContainerOptions{}, nil},
+		{docker.CreateContainerOptions{HostConfig: &docker.HostConfig{NetworkMode: "bridge"}}, nil},
+		{docker.CreateContainerOptions{HostConfig: &docker.HostConfig{NetworkMode: "container:abc"}}, nil},
+		{docker.CreateContainerOptions{HostConfig: &docker.HostConfig{NetworkMode: "net1"}}, []string{"net1"}},
+		{
+			docker.CreateContainerOptions{
+				HostConfig: &docker.HostConfig{NetworkMode: "net1"},
+				NetworkingConfig: &docker.NetworkingConfig{
+					EndpointsConfig: map[string]*docker.EndpointConfig{"net1": {}},
+				},
+			},
+			[]string{"net1"},
+		},
+	}
+	for _, tt := range tests {
+		if names := containerNetworks(tt.opts); !reflect.DeepEqual(names, tt.expected) {
+			t.Errorf("containerNetworks(%#v): want %#v, got %#v", tt.opts, tt.expected, names)
+		}
+	}
+}
+
+func TestConnectNetworkCreatesMissingNetwork(t *testing.T) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, container, err := c.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "myimg"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.storage().StoreNetwork(context.Background(), Network{Name: "net1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ConnectNetwork("net1", docker.NetworkConnectionOptions{Container: container.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	network, err := c.storage().RetrieveNetwork(context.Background(), "net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := network.IDInNode(server.URL()); !ok {
+		t.Errorf("ConnectNetwork: expected network to be created in the node, got %#v", network.Nodes)
+	}
+}
+
+func TestDisconnectNetworkNotInNode(t *testing.T) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, container, err := c.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "myimg"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.storage().StoreNetwork(context.Background(), Network{Name: "net1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.DisconnectNetwork("net1", docker.NetworkConnectionOptions{Container: container.ID})
+	if _, ok := err.(*docker.NoSuchNetwork); !ok {
+		t.Errorf("DisconnectNetwork: expected NoSuchNetwork error, got %#v", err)
+	}
+}
+
+func TestRemoveNetwork(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL()},
+		Node{Address: server2.URL()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateNetwork(docker.CreateNetworkOptions{Name: "net1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.RemoveNetwork("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{server1.URL(), server2.URL()} {
+		if names := networkNames(t, addr); len(names) != 0 {
+			t.Errorf("RemoveNetwork: expected no networks in node %s, got %#v", addr, names)
+		}
+	}
+	_, err = c.storage().RetrieveNetwork(context.Background(), "net1")
+	if err != storage.ErrNoSuchNetwork {
+		t.Errorf("RemoveNetwork: expected definition to be removed, got error %v", err)
+	}
+}
+
+func TestRemoveNetworkNotFound(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.RemoveNetwork("net1")
+	if err != storage.ErrNoSuchNetwork {
+		t.Errorf("RemoveNetwork: expected ErrNoSuchNetwork, got %v", err)
+	}
+}
@@ -46,6 +46,7 @@ const (
 	NodeStatusRetry               = "ready for retry"
 	NodeStatusTemporarilyDisabled = "temporarily disabled"
 	NodeStatusHealing             = "healing"
+	NodeStatusCordoned            = "cordoned"
 
 	NodeCreationStatusCreated  = "created"
 	NodeCreationStatusError    = "error"
@@ -77,6 +78,9 @@ func (n *Node) Status() string {
 	if n.isHealing() {
 		return NodeStatusHealing
 	}
+	if n.IsCordoned() {
+		return NodeStatusCordoned
+	}
 	if n.Metadata == nil {
 		return NodeStatusWaiting
 	}
@@ -148,8 +152,16 @@ func (n *Node) updateSuccess() {
 	n.Metadata["LastSuccess"] = time.Now().Format(time.RFC3339)
 }
 
+// IsCordoned returns whether the node was cordoned, so no new containers
+// are scheduled to it.
+func (n *Node) IsCordoned() bool {
+	return n.Metadata[cordonedMetadataKey] == "true"
+}
+
+const cordonedMetadataKey = "Cordoned"
+
 var extraMetadataKeys = []string{
-	"Failures", "DisabledUntil", "LastError", "LastSuccess",
+	"Failures", "DisabledUntil", "LastError", "LastSuccess", cordonedMetadataKey,
 }
 
 func isExtra(key string) bool {
@@ -210,6 +222,16 @@ func (nodes NodeList) filterDisabled() NodeList {
 	return filtered
 }
 
+func (nodes NodeList) filterCordoned() NodeList {
+	filtered := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !node.IsCordoned() {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
 func (n *Node) getTLSConfig() (*tls.Config, error) {
 	if n.nodeTLSConfig != nil {
 		return n.nodeTLSConfig, nil
@@ -0,0 +1,202 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+// ReconcileOptions are the options for Reconcile.
+type ReconcileOptions struct {
+	// DryRun makes Reconcile only report the differences between the
+	// storage and the nodes, without fixing them.
+	DryRun bool
+
+	// IgnoreUntracked makes Reconcile only report containers running in the
+	// nodes that are missing in the storage, instead of storing them.
+	// Containers stored in the wrong node are always fixed.
+	IgnoreUntracked bool
+
+	// Context, if set, cancels the reconciliation. The storage is left
+	// untouched if it's cancelled while listing the containers.
+	Context context.Context
+}
+
+// ReconcileReport lists the differences found by Reconcile.
+type ReconcileReport struct {
+	// Stale are the containers in the storage that are not in their node,
+	// including the ones whose node isn't registered anymore. Containers
+	// stored in the wrong node are reported both as Stale, with the stored
+	// node, and as Untracked, with the node where they are.
+	Stale []Container
+
+	// Untracked are the containers in the nodes that are not in the
+	// storage, or are stored in another node.
+	Untracked []Container
+
+	// UnreachableNodes are the nodes whose containers couldn't be listed.
+	// Stored containers in these nodes are left untouched.
+	UnreachableNodes []string
+}
+
+// Reconcile lists the containers in every node and compares them to the
+// containers in the storage, removing stale entries from the storage and
+// storing containers it doesn't know about, unless opts.DryRun is set.
+//
+// Nodes that can't be reached are reported and skipped, so a node that is
+// temporarily down doesn't have its containers removed from the storage.
+func (c *Cluster) Reconcile(opts ReconcileOptions) (*ReconcileReport, error) {
+	ctx := optsContext(opts.Context)
+	nodes, err := c.UnfilteredNodesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := c.storage().RetrieveContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	running, unreachable := c.containersInNodes(ctx, nodes)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	report := ReconcileReport{UnreachableNodes: unreachable}
+	skipped := make(map[string]bool, len(unreachable))
+	for _, addr := range unreachable {
+		skipped[addr] = true
+	}
+	storedHosts := make(map[string]string, len(stored))
+	for _, cont := range stored {
+		storedHosts[cont.Id] = cont.Host
+		if skipped[cont.Host] {
+			continue
+		}
+		if host, ok := running[cont.Id]; !ok || host != cont.Host {
+			report.Stale = append(report.Stale, cont)
+		}
+	}
+	for id, host := range running {
+		if storedHost, ok := storedHosts[id]; !ok || storedHost != host {
+			report.Untracked = append(report.Untracked, Container{Id: id, Host: host})
+		}
+	}
+	sortContainers(report.Stale)
+	sortContainers(report.Untracked)
+	if opts.DryRun {
+		return &report, nil
+	}
+	for _, cont := range report.Stale {
+		if host, ok := running[cont.Id]; ok {
+			err = c.storage().StoreContainer(ctx, cont.Id, host)
+		} else {
+			err = c.storage().RemoveContainer(ctx, cont.Id)
+		}
+		if err != nil {
+			return &report, err
+		}
+	}
+	if opts.IgnoreUntracked {
+		return &report, nil
+	}
+	for _, cont := range report.Untracked {
+		if _, ok := storedHosts[cont.Id]; ok {
+			// Stored in another node, already fixed above.
+			continue
+		}
+		err = c.storage().StoreContainer(ctx, cont.Id, cont.Host)
+		if err != nil {
+			return &report, err
+		}
+	}
+	return &report, nil
+}
+
+// containersInNodes returns the containers in the given nodes, mapped to
+// their node address, and the nodes where listing the containers failed.
+func (c *Cluster) containersInNodes(ctx context.Context, nodes []Node) (map[string]string, []string) {
+	type nodeContainers struct {
+		addr       string
+		containers []docker.APIContainers
+		err        error
+	}
+	var wg sync.WaitGroup
+	results := make(chan nodeContainers, len(nodes))
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			client, err := c.getNodeByAddr(ctx, addr)
+			if err != nil {
+				results <- nodeContainers{addr: addr, err: err}
+				return
+			}
+			containers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+			results <- nodeContainers{addr: addr, containers: containers, err: wrapError(client, err)}
+		}(n.Address)
+	}
+	wg.Wait()
+	close(results)
+	running := make(map[string]string)
+	var unreachable []string
+	for r := range results {
+		if r.err != nil {
+			log.Warn("[reconcile]: error listing containers, skipping node", log.Fields{"node": r.addr, "error": r.err})
+			unreachable = append(unreachable, r.addr)
+			continue
+		}
+		for _, cont := range r.containers {
+			running[cont.ID] = r.addr
+		}
+	}
+	sort.Strings(unreachable)
+	return running, unreachable
+}
+
+func sortContainers(containers []Container) {
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Id < containers[j].Id
+	})
+}
+
+// StartReconciling runs Reconcile with the given options every interval,
+// logging the differences found, until StopReconciling is called.
+func (c *Cluster) StartReconciling(interval time.Duration, opts ReconcileOptions) {
+	c.reconcileDone = make(chan bool)
+	go c.runReconcile(interval, opts)
+}
+
+func (c *Cluster) StopReconciling() {
+	if c.reconcileDone != nil {
+		c.reconcileDone <- true
+	}
+}
+
+func (c *Cluster) runReconcile(interval time.Duration, opts ReconcileOptions) {
+	log.Debug("[reconcile]: periodic reconciliation enabled", log.Fields{"interval": interval})
+	for {
+		report, err := c.Reconcile(opts)
+		if err != nil {
+			log.Error("[reconcile]: error reconciling containers", log.Fields{"error": err})
+		}
+		if report != nil {
+			for _, cont := range report.Stale {
+				log.Info("[reconcile]: stale container", log.Fields{"container": cont.Id, "node": cont.Host})
+			}
+			for _, cont := range report.Untracked {
+				log.Info("[reconcile]: untracked container", log.Fields{"container": cont.Id, "node": cont.Host})
+			}
+		}
+		select {
+		case <-c.reconcileDone:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
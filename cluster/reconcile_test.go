@@ -0,0 +1,163 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func containersServer(ids ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		entries := make([]string, len(ids))
+		for i, id := range ids {
+			entries[i] = fmt.Sprintf(`{"Id":%q}`, id)
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(entries, ","))
+	}))
+}
+
+func storedContainers(t *testing.T, c *Cluster) []Container {
+	containers, err := c.storage().RetrieveContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortContainers(containers)
+	return containers
+}
+
+func TestReconcile(t *testing.T) {
+	server1 := containersServer("c1", "c2")
+	defer server1.Close()
+	server2 := containersServer("c3", "c4")
+	defer server2.Close()
+	c := newMapCluster(t, server1.URL, server2.URL, "http://127.0.0.1:1")
+	for _, cont := range []Container{
+		{Id: "c1", Host: server1.URL},
+		{Id: "c3", Host: server1.URL},
+		{Id: "c5", Host: server2.URL},
+		{Id: "c6", Host: "http://127.0.0.1:1"},
+		{Id: "c7", Host: "http://removed:4243"},
+	} {
+		c.storage().StoreContainer(context.Background(), cont.Id, cont.Host)
+	}
+	report, err := c.Reconcile(ReconcileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ReconcileReport{
+		Stale: []Container{
+			{Id: "c3", Host: server1.URL},
+			{Id: "c5", Host: server2.URL},
+			{Id: "c7", Host: "http://removed:4243"},
+		},
+		Untracked: []Container{
+			{Id: "c2", Host: server1.URL},
+			{Id: "c3", Host: server2.URL},
+			{Id: "c4", Host: server2.URL},
+		},
+		UnreachableNodes: []string{"http://127.0.0.1:1"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Reconcile: wrong report.\nWant %#v.\nGot  %#v.", expected, report)
+	}
+	expectedStored := []Container{
+		{Id: "c1", Host: server1.URL},
+		{Id: "c2", Host: server1.URL},
+		{Id: "c3", Host: server2.URL},
+		{Id: "c4", Host: server2.URL},
+		{Id: "c6", Host: "http://127.0.0.1:1"},
+	}
+	if stored := storedContainers(t, c); !reflect.DeepEqual(stored, expectedStored) {
+		t.Errorf("Reconcile: wrong containers in storage.\nWant %#v.\nGot  %#v.", expectedStored, stored)
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	server1 := containersServer("c1", "c2")
+	defer server1.Close()
+	server2 := containersServer("c3", "c4")
+	defer server2.Close()
+	c := newMapCluster(t, server1.URL, server2.URL, "http://127.0.0.1:1")
+	for _, cont := range []Container{
+		{Id: "c1", Host: server1.URL},
+		{Id: "c3", Host: server1.URL},
+		{Id: "c5", Host: server2.URL},
+		{Id: "c6", Host: "http://127.0.0.1:1"},
+		{Id: "c7", Host: "http://removed:4243"},
+	} {
+		c.storage().StoreContainer(context.Background(), cont.Id, cont.Host)
+	}
+	before := storedContainers(t, c)
+	report, err := c.Reconcile(ReconcileOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Stale) != 3 || len(report.Untracked) != 3 {
+		t.Errorf("Reconcile: wrong report %#v", report)
+	}
+	if stored := storedContainers(t, c); !reflect.DeepEqual(stored, before) {
+		t.Errorf("Reconcile: dry run changed the storage.\nWant %#v.\nGot  %#v.", before, stored)
+	}
+}
+
+func TestReconcileIgnoreUntracked(t *testing.T) {
+	server1 := containersServer("c1", "c2")
+	defer server1.Close()
+	server2 := containersServer("c3", "c4")
+	defer server2.Close()
+	c := newMapCluster(t, server1.URL, server2.URL, "http://127.0.0.1:1")
+	for _, cont := range []Container{
+		{Id: "c1", Host: server1.URL},
+		{Id: "c3", Host: server1.URL},
+		{Id: "c5", Host: server2.URL},
+		{Id: "c6", Host: "http://127.0.0.1:1"},
+		{Id: "c7", Host: "http://removed:4243"},
+	} {
+		c.storage().StoreContainer(context.Background(), cont.Id, cont.Host)
+	}
+	_, err := c.Reconcile(ReconcileOptions{IgnoreUntracked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStored := []Container{
+		{Id: "c1", Host: server1.URL},
+		{Id: "c3", Host: server2.URL},
+		{Id: "c6", Host: "http://127.0.0.1:1"},
+	}
+	if stored := storedContainers(t, c); !reflect.DeepEqual(stored, expectedStored) {
+		t.Errorf("Reconcile: wrong containers in storage.\nWant %#v.\nGot  %#v.", expectedStored, stored)
+	}
+}
+
+func TestStartReconciling(t *testing.T) {
+	server := containersServer("c1")
+	defer server.Close()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.StartReconciling(10*time.Millisecond, ReconcileOptions{})
+	defer c.StopReconciling()
+	timeout := time.After(5 * time.Second)
+	for {
+		host, _ := c.storage().RetrieveContainer(context.Background(), "c1")
+		if host == server.URL {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("timeout waiting for container to be reconciled")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
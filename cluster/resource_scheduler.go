@@ -0,0 +1,406 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+// ErrNoCapacity is returned by ResourceScheduler when none of the available
+// nodes can fit the requested memory and CPU shares.
+var ErrNoCapacity = errors.New("No node has enough capacity")
+
+// cpuSharesPerCPU is the amount of CPU shares docker assigns by default to a
+// container, it's used as the capacity of a single CPU in a node.
+const cpuSharesPerCPU = 1024
+
+// ResourceStrategy defines how ResourceScheduler picks a node among the ones
+// with enough capacity for a container.
+type ResourceStrategy int
+
+const (
+	// StrategySpread places the container in the least used node.
+	StrategySpread ResourceStrategy = iota
+	// StrategyBinpack places the container in the most used node that is
+	// still able to fit it.
+	StrategyBinpack
+	// StrategyUsage places the container in the node whose containers are
+	// using the least memory and CPU, as measured by NodeStats, among the
+	// nodes with enough capacity for it. Nodes whose stats can't be read
+	// are ranked by the resources reserved in them.
+	StrategyUsage
+)
+
+// NodeResources holds the capacity of a node and the amount of resources
+// reserved by the containers tracked in the ContainerStorage.
+type NodeResources struct {
+	Address        string
+	MemoryTotal    int64
+	MemoryReserved int64
+	CPUTotal       int64
+	CPUReserved    int64
+}
+
+func (r *NodeResources) fits(memory, cpu int64) bool {
+	return r.MemoryReserved+memory <= r.MemoryTotal && r.CPUReserved+cpu <= r.CPUTotal
+}
+
+// usage returns the ratio of the most used resource in the node, after
+// placing a container with the given requirements.
+func (r *NodeResources) usage(memory, cpu int64) float64 {
+	var memUsage, cpuUsage float64
+	if r.MemoryTotal > 0 {
+		memUsage = float64(r.MemoryReserved+memory) / float64(r.MemoryTotal)
+	}
+	if r.CPUTotal > 0 {
+		cpuUsage = float64(r.CPUReserved+cpu) / float64(r.CPUTotal)
+	}
+	if memUsage > cpuUsage {
+		return memUsage
+	}
+	return cpuUsage
+}
+
+// measuredUsage is like usage, but using the memory and CPU measured in the
+// node. Reservations of containers still being created are added to the
+// measured values, as they're not running yet.
+func (r *NodeResources) measuredUsage(stats NodeStats, pending reservation, memory, cpu int64) float64 {
+	var memUsage, cpuUsage float64
+	if r.MemoryTotal > 0 {
+		memUsage = (float64(stats.MemoryUsage) + float64(pending.memory+memory)) / float64(r.MemoryTotal)
+	}
+	if r.CPUTotal > 0 {
+		cpuUsage = (stats.CPUPercent/100*cpuSharesPerCPU + float64(pending.cpu+cpu)) / float64(r.CPUTotal)
+	}
+	if memUsage > cpuUsage {
+		return memUsage
+	}
+	return cpuUsage
+}
+
+type reservation struct {
+	node   string
+	memory int64
+	cpu    int64
+}
+
+// ResourceScheduler is a Scheduler that takes into account the memory and
+// CPU shares reserved by containers in each node, never placing a container
+// in a node that doesn't have enough capacity for it.
+//
+// Resources of containers still being created are reserved in the scheduled
+// node until the cluster releases them, so concurrent calls to
+// CreateContainer don't overcommit nodes. Nodes are inspected without holding
+// the scheduler lock, so a slow node doesn't block other calls, and
+// reservations released meanwhile are still counted, as their containers may
+// not have been seen in the nodes.
+type ResourceScheduler struct {
+	Strategy ResourceStrategy
+	mut      sync.Mutex
+	pending  map[*docker.CreateContainerOptions]reservation
+	lastUsed int64
+}
+
+var (
+	_ Scheduler          = &ResourceScheduler{}
+	_ ReservingScheduler = &ResourceScheduler{}
+)
+
+func (s *ResourceScheduler) Schedule(c *Cluster, opts *docker.CreateContainerOptions, schedulerOpts SchedulerOptions) (Node, error) {
+	ctx := optsContext(opts.Context)
+	pending := s.pendingReservations()
+	nodes, err := c.SchedulableNodesWithContext(ctx)
+	if err != nil {
+		return Node{}, err
+	}
+	if len(nodes) == 0 {
+		return Node{}, errors.New("No nodes available")
+	}
+	resources, err := c.nodesResources(ctx, nodes)
+	if err != nil {
+		return Node{}, err
+	}
+	var measured map[string]NodeStats
+	if s.Strategy == StrategyUsage {
+		addresses := make([]string, len(resources))
+		for i := range resources {
+			addresses[i] = resources[i].Address
+		}
+		measured, err = c.nodesStats(ctx, addresses)
+		if err != nil {
+			return Node{}, err
+		}
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	// A previous reservation for the same options means the creation in
+	// that node has failed and the cluster is trying again.
+	previous, isRetry := s.pending[opts]
+	delete(s.pending, opts)
+	delete(pending, opts)
+	for o, r := range s.pending {
+		pending[o] = r
+	}
+	pendingByNode := make(map[string]reservation)
+	for _, r := range pending {
+		p := pendingByNode[r.node]
+		p.memory += r.memory
+		p.cpu += r.cpu
+		pendingByNode[r.node] = p
+	}
+	for i := range resources {
+		p := pendingByNode[resources[i].Address]
+		resources[i].MemoryReserved += p.memory
+		resources[i].CPUReserved += p.cpu
+	}
+	memory, cpu := containerResources(opts)
+	candidates := make([]NodeResources, 0, len(resources))
+	for _, r := range resources {
+		if r.fits(memory, cpu) {
+			candidates = append(candidates, r)
+		}
+	}
+	if isRetry && len(candidates) > 1 {
+		for i := range candidates {
+			if candidates[i].Address == previous.node {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return Node{}, ErrNoCapacity
+	}
+	usage := func(r NodeResources) float64 {
+		if stats, ok := measured[r.Address]; ok {
+			return r.measuredUsage(stats, pendingByNode[r.Address], memory, cpu)
+		}
+		return r.usage(memory, cpu)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		usageI, usageJ := usage(candidates[i]), usage(candidates[j])
+		if s.Strategy == StrategyBinpack {
+			return usageI > usageJ
+		}
+		return usageI < usageJ
+	})
+	best := 1
+	bestUsage := usage(candidates[0])
+	for best < len(candidates) && usage(candidates[best]) == bestUsage {
+		best++
+	}
+	chosen := candidates[s.lastUsed%int64(best)]
+	s.lastUsed++
+	for _, n := range nodes {
+		if n.Address == chosen.Address {
+			if s.pending == nil {
+				s.pending = make(map[*docker.CreateContainerOptions]reservation)
+			}
+			s.pending[opts] = reservation{node: n.Address, memory: memory, cpu: cpu}
+			return n, nil
+		}
+	}
+	return Node{}, ErrNoCapacity
+}
+
+// pendingReservations returns a copy of the reservations of the containers
+// being created.
+func (s *ResourceScheduler) pendingReservations() map[*docker.CreateContainerOptions]reservation {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	pending := make(map[*docker.CreateContainerOptions]reservation, len(s.pending))
+	for opts, r := range s.pending {
+		pending[opts] = r
+	}
+	return pending
+}
+
+// Release drops the reservation made when scheduling a container with the
+// given options. It's called by the cluster once the container is stored
+// or its creation has failed.
+func (s *ResourceScheduler) Release(opts *docker.CreateContainerOptions) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	delete(s.pending, opts)
+}
+
+// NodeResources returns the capacity of the node with the given address and
+// the resources reserved by containers tracked in it.
+func (c *Cluster) NodeResources(address string) (NodeResources, error) {
+	return c.NodeResourcesWithContext(context.Background(), address)
+}
+
+// NodeResourcesWithContext is like NodeResources, but using ctx to cancel the operation.
+func (c *Cluster) NodeResourcesWithContext(ctx context.Context, address string) (NodeResources, error) {
+	containers, err := c.containersByHost(ctx)
+	if err != nil {
+		return NodeResources{}, err
+	}
+	return c.nodeResources(ctx, address, containers[address])
+}
+
+// nodesResources returns the resources of the given nodes, ignoring nodes
+// that couldn't be reached, unless none of them could.
+func (c *Cluster) nodesResources(ctx context.Context, nodes []Node) ([]NodeResources, error) {
+	containers, err := c.containersByHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	resultChan := make(chan NodeResources, len(nodes))
+	errChan := make(chan error, len(nodes))
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			r, err := c.nodeResources(ctx, addr, containers[addr])
+			if err != nil {
+				log.Warn("Ignoring node when reading resources", log.Fields{"node": addr, "error": err})
+				errChan <- err
+				return
+			}
+			resultChan <- r
+		}(n.Address)
+	}
+	wg.Wait()
+	close(resultChan)
+	close(errChan)
+	var result []NodeResources
+	for r := range resultChan {
+		result = append(result, r)
+	}
+	if len(result) == 0 {
+		var msgs []string
+		for err := range errChan {
+			msgs = append(msgs, err.Error())
+		}
+		if len(msgs) > 0 {
+			return nil, fmt.Errorf("Unable to read resources from any node: %s", strings.Join(msgs, "; "))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Address < result[j].Address
+	})
+	var ids []string
+	for _, contIDs := range containers {
+		ids = append(ids, contIDs...)
+	}
+	c.reservations.prune(ids)
+	return result, nil
+}
+
+func (c *Cluster) containersByHost(ctx context.Context) (map[string][]string, error) {
+	containers, err := c.storage().RetrieveContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	for _, cont := range containers {
+		result[cont.Host] = append(result[cont.Host], cont.Id)
+	}
+	return result, nil
+}
+
+func (c *Cluster) nodeResources(ctx context.Context, address string, containerIDs []string) (NodeResources, error) {
+	n, err := c.getNodeByAddr(ctx, address)
+	if err != nil {
+		return NodeResources{}, err
+	}
+	info, err := n.Info()
+	if err != nil {
+		return NodeResources{}, wrapError(n, err)
+	}
+	r := NodeResources{
+		Address:     address,
+		MemoryTotal: info.MemTotal,
+		CPUTotal:    int64(info.NCPU) * cpuSharesPerCPU,
+	}
+	for _, id := range containerIDs {
+		res, ok := c.reservations.get(id)
+		if !ok {
+			cont, err := n.InspectContainer(id)
+			if err != nil {
+				if _, ok := err.(*docker.NoSuchContainer); ok {
+					continue
+				}
+				return NodeResources{}, wrapError(n, err)
+			}
+			res.memory, res.cpu = containerConfigResources(cont.Config, cont.HostConfig)
+			c.reservations.set(id, res)
+		}
+		r.MemoryReserved += res.memory
+		r.CPUReserved += res.cpu
+	}
+	return r, nil
+}
+
+// reservationCache keeps the resources reserved by existing containers, as
+// they can't change after the container is created.
+type reservationCache struct {
+	sync.Mutex
+	entries map[string]reservation
+}
+
+func (rc *reservationCache) get(id string) (reservation, bool) {
+	rc.Lock()
+	defer rc.Unlock()
+	r, ok := rc.entries[id]
+	return r, ok
+}
+
+func (rc *reservationCache) set(id string, r reservation) {
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.entries == nil {
+		rc.entries = make(map[string]reservation)
+	}
+	rc.entries[id] = r
+}
+
+// prune removes entries for containers no longer tracked in the storage.
+func (rc *reservationCache) prune(ids []string) {
+	tracked := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		tracked[id] = struct{}{}
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	for id := range rc.entries {
+		if _, ok := tracked[id]; !ok {
+			delete(rc.entries, id)
+		}
+	}
+}
+
+func containerResources(opts *docker.CreateContainerOptions) (int64, int64) {
+	if opts == nil {
+		return 0, 0
+	}
+	return containerConfigResources(opts.Config, opts.HostConfig)
+}
+
+func containerConfigResources(config *docker.Config, hostConfig *docker.HostConfig) (memory int64, cpu int64) {
+	if hostConfig != nil {
+		memory = hostConfig.Memory
+		cpu = hostConfig.CPUShares
+	}
+	if config != nil {
+		if memory == 0 {
+			memory = config.Memory
+		}
+		if cpu == 0 {
+			cpu = config.CPUShares
+		}
+	}
+	return memory, cpu
+}
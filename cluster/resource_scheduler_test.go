@@ -0,0 +1,405 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func resourceServer(ncpu int, memTotal int64, containerMemory map[string]int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/info") {
+			fmt.Fprintf(w, `{"NCPU":%d,"MemTotal":%d}`, ncpu, memTotal)
+			return
+		}
+		for id, memory := range containerMemory {
+			if strings.HasSuffix(r.URL.Path, "/containers/"+id+"/json") {
+				fmt.Fprintf(w, `{"Id":%q,"HostConfig":{"Memory":%d,"CpuShares":512}}`, id, memory)
+				return
+			}
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+}
+
+func TestResourceSchedulerSpread(t *testing.T) {
+	server1 := resourceServer(2, 1024, map[string]int64{"c1": 512})
+	defer server1.Close()
+	server2 := resourceServer(2, 1024, nil)
+	defer server2.Close()
+	scheduler := &ResourceScheduler{Strategy: StrategySpread}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL}, Node{Address: server2.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.storage().StoreContainer(context.Background(), "c1", server1.URL)
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}, HostConfig: &docker.HostConfig{Memory: 256}}
+	node, err := scheduler.Schedule(c, &opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Address != server2.URL {
+		t.Errorf("ResourceScheduler.Schedule(): wrong node. Want %q. Got %q.", server2.URL, node.Address)
+	}
+}
+
+func TestResourceSchedulerBinpack(t *testing.T) {
+	server1 := resourceServer(2, 1024, map[string]int64{"c1": 512})
+	defer server1.Close()
+	server2 := resourceServer(2, 1024, nil)
+	defer server2.Close()
+	scheduler := &ResourceScheduler{Strategy: StrategyBinpack}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL}, Node{Address: server2.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.storage().StoreContainer(context.Background(), "c1", server1.URL)
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}, HostConfig: &docker.HostConfig{Memory: 256}}
+	node, err := scheduler.Schedule(c, &opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Address != server1.URL {
+		t.Errorf("ResourceScheduler.Schedule(): wrong node. Want %q. Got %q.", server1.URL, node.Address)
+	}
+	opts.HostConfig.Memory = 768
+	node, err = scheduler.Schedule(c, &opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Address != server2.URL {
+		t.Errorf("ResourceScheduler.Schedule(): wrong node. Want %q. Got %q.", server2.URL, node.Address)
+	}
+}
+
+func TestResourceSchedulerUsage(t *testing.T) {
+	statsServer := func(id string, reserved int64, used uint64) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/info"):
+				fmt.Fprint(w, `{"NCPU":2,"MemTotal":1024}`)
+			case strings.HasSuffix(r.URL.Path, "/containers/"+id+"/json"):
+				fmt.Fprintf(w, `{"Id":%q,"HostConfig":{"Memory":%d}}`, id, reserved)
+			case strings.HasSuffix(r.URL.Path, "/containers/"+id+"/stats"):
+				fmt.Fprintf(w, `{"memory_stats":{"usage":%d}}`, used)
+			default:
+				http.Error(w, "not found", http.StatusNotFound)
+			}
+		}))
+	}
+	server1 := statsServer("c1", 128, 900)
+	defer server1.Close()
+	server2 := statsServer("c2", 512, 100)
+	defer server2.Close()
+	scheduler := &ResourceScheduler{Strategy: StrategyUsage}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL}, Node{Address: server2.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.storage().StoreContainer(context.Background(), "c1", server1.URL)
+	c.storage().StoreContainer(context.Background(), "c2", server2.URL)
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}, HostConfig: &docker.HostConfig{Memory: 64}}
+	node, err := scheduler.Schedule(c, &opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Address != server2.URL {
+		t.Errorf("ResourceScheduler.Schedule(): wrong node. Want %q. Got %q.", server2.URL, node.Address)
+	}
+	scheduler.Release(&opts)
+	scheduler.Strategy = StrategySpread
+	node, err = scheduler.Schedule(c, &opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Address != server1.URL {
+		t.Errorf("ResourceScheduler.Schedule(): wrong node with spread. Want %q. Got %q.", server1.URL, node.Address)
+	}
+}
+
+func TestResourceSchedulerNoCapacity(t *testing.T) {
+	server1 := resourceServer(1, 1024, map[string]int64{"c1": 1024})
+	defer server1.Close()
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.storage().StoreContainer(context.Background(), "c1", server1.URL)
+	opts := docker.CreateContainerOptions{Config: &docker.Config{Memory: 1}}
+	_, err = scheduler.Schedule(c, &opts, nil)
+	if err != ErrNoCapacity {
+		t.Fatalf("ResourceScheduler.Schedule(): expected ErrNoCapacity, got: %#v", err)
+	}
+}
+
+func TestResourceSchedulerNoNodes(t *testing.T) {
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	_, err = scheduler.Schedule(c, &opts, nil)
+	expected := "No nodes available"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Schedule(): wrong error message. Want %q. Got %q.", expected, err)
+	}
+}
+
+func TestClusterNodeResources(t *testing.T) {
+	server1 := resourceServer(4, 4096, map[string]int64{"c1": 512, "c2": 1024})
+	defer server1.Close()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server1.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.storage().StoreContainer(context.Background(), "c1", server1.URL)
+	c.storage().StoreContainer(context.Background(), "c2", server1.URL)
+	c.storage().StoreContainer(context.Background(), "c3", server1.URL)
+	r, err := c.NodeResources(server1.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NodeResources{
+		Address:        server1.URL,
+		MemoryTotal:    4096,
+		MemoryReserved: 1536,
+		CPUTotal:       4096,
+		CPUReserved:    1024,
+	}
+	if r != expected {
+		t.Errorf("NodeResources(): wrong result. Want %#v. Got %#v.", expected, r)
+	}
+}
+
+func TestResourceSchedulerConcurrentReservations(t *testing.T) {
+	server1 := resourceServer(4, 1024, nil)
+	defer server1.Close()
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const tasks = 8
+	var wg sync.WaitGroup
+	var scheduled int32
+	for i := 0; i < tasks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := docker.CreateContainerOptions{Config: &docker.Config{}, HostConfig: &docker.HostConfig{Memory: 256}}
+			_, err := scheduler.Schedule(c, &opts, nil)
+			if err == nil {
+				atomic.AddInt32(&scheduled, 1)
+			} else if err != ErrNoCapacity {
+				t.Errorf("ResourceScheduler.Schedule(): unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if scheduled != 4 {
+		t.Errorf("ResourceScheduler.Schedule(): expected 4 containers to be scheduled, got %d", scheduled)
+	}
+}
+
+func TestResourceSchedulerDoesNotSerializeNodeCalls(t *testing.T) {
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/info") {
+			arrived <- struct{}{}
+			<-release
+			fmt.Fprint(w, `{"NCPU":4,"MemTotal":1024}`)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server1.Close()
+	defer close(release)
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			opts := docker.CreateContainerOptions{Config: &docker.Config{}, HostConfig: &docker.HostConfig{Memory: 256}}
+			_, err := scheduler.Schedule(c, &opts, nil)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-arrived:
+		case <-time.After(5 * time.Second):
+			t.Fatal("ResourceScheduler.Schedule(): node calls were serialized")
+		}
+	}
+	release <- struct{}{}
+	release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("ResourceScheduler.Schedule(): unexpected error: %s", err)
+		}
+	}
+}
+
+func TestResourceSchedulerRelease(t *testing.T) {
+	server1 := resourceServer(1, 1024, nil)
+	defer server1.Close()
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts1 := docker.CreateContainerOptions{Config: &docker.Config{}, HostConfig: &docker.HostConfig{Memory: 1024}}
+	_, err = scheduler.Schedule(c, &opts1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts2 := docker.CreateContainerOptions{Config: &docker.Config{}, HostConfig: &docker.HostConfig{Memory: 1024}}
+	_, err = scheduler.Schedule(c, &opts2, nil)
+	if err != ErrNoCapacity {
+		t.Fatalf("ResourceScheduler.Schedule(): expected ErrNoCapacity, got: %#v", err)
+	}
+	scheduler.Release(&opts1)
+	_, err = scheduler.Schedule(c, &opts2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResourceSchedulerRotatesTiesAndSkipsFailedNode(t *testing.T) {
+	server1 := resourceServer(1, 1024, nil)
+	defer server1.Close()
+	server2 := resourceServer(1, 1024, nil)
+	defer server2.Close()
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL}, Node{Address: server2.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		node, err := scheduler.Schedule(c, &docker.CreateContainerOptions{Config: &docker.Config{}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, node.Address)
+	}
+	if addrs[0] == addrs[1] || addrs[0] != addrs[2] {
+		t.Errorf("ResourceScheduler.Schedule(): expected nodes with the same usage to rotate, got %v", addrs)
+	}
+	first, err := scheduler.Schedule(c, &opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		retry, err := scheduler.Schedule(c, &opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retry.Address == first.Address {
+			t.Errorf("ResourceScheduler.Schedule(): expected retry to use a different node than %q", first.Address)
+		}
+		first = retry
+	}
+}
+
+func TestResourceSchedulerUnreachableNodes(t *testing.T) {
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+	_, err = scheduler.Schedule(c, &opts, nil)
+	if err == nil || err == ErrNoCapacity {
+		t.Fatalf("ResourceScheduler.Schedule(): expected unreachable error, got: %#v", err)
+	}
+	if !strings.Contains(err.Error(), "Unable to read resources from any node") || !strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("ResourceScheduler.Schedule(): unexpected error message: %s", err)
+	}
+}
+
+func TestResourceSchedulerCachesContainerReservations(t *testing.T) {
+	var inspects int32
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/info") {
+			w.Write([]byte(`{"NCPU":2,"MemTotal":2048}`))
+			return
+		}
+		atomic.AddInt32(&inspects, 1)
+		w.Write([]byte(`{"Id":"c1","HostConfig":{"Memory":512}}`))
+	}))
+	defer server1.Close()
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.storage().StoreContainer(context.Background(), "c1", server1.URL)
+	for i := 0; i < 3; i++ {
+		opts := docker.CreateContainerOptions{Config: &docker.Config{}}
+		_, err = scheduler.Schedule(c, &opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inspects != 1 {
+		t.Errorf("ResourceScheduler.Schedule(): expected 1 inspect call, got %d", inspects)
+	}
+	c.storage().RemoveContainer(context.Background(), "c1")
+	_, err = c.nodesResources(context.Background(), []Node{{Address: server1.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.reservations.get("c1"); ok {
+		t.Error("Expected cached reservation to be pruned after container removal")
+	}
+}
+
+func TestCreateContainerReleasesReservation(t *testing.T) {
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/info") {
+			w.Write([]byte(`{"NCPU":1,"MemTotal":1024}`))
+			return
+		}
+		w.Write([]byte(`{"Id":"e90302"}`))
+	}))
+	defer server1.Close()
+	scheduler := &ResourceScheduler{}
+	c, err := New(scheduler, &MapStorage{}, "", Node{Address: server1.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := docker.Config{Image: "myimg"}
+	_, _, err = c.CreateContainer(docker.CreateContainerOptions{Config: &config, HostConfig: &docker.HostConfig{Memory: 512}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.mut.Lock()
+	defer scheduler.mut.Unlock()
+	if len(scheduler.pending) != 0 {
+		t.Errorf("CreateContainer: expected reservations to be released, got %#v", scheduler.pending)
+	}
+}
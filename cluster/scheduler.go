@@ -24,13 +24,21 @@ type Scheduler interface {
 	Schedule(c *Cluster, opts *docker.CreateContainerOptions, schedulerOpts SchedulerOptions) (Node, error)
 }
 
+// ReservingScheduler is a Scheduler that keeps state about containers being
+// created. The cluster calls Release with the same options given to Schedule
+// once the container is stored or its creation has failed.
+type ReservingScheduler interface {
+	Scheduler
+	Release(opts *docker.CreateContainerOptions)
+}
+
 type roundRobin struct {
 	lastUsed int64
 	once     sync.Once
 }
 
 func (s *roundRobin) Schedule(c *Cluster, opts *docker.CreateContainerOptions, schedulerOpts SchedulerOptions) (Node, error) {
-	nodes, _ := c.Nodes()
+	nodes, _ := c.SchedulableNodesWithContext(optsContext(opts.Context))
 	if len(nodes) == 0 {
 		return Node{}, errors.New("No nodes available")
 	}
@@ -0,0 +1,298 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+var signals = map[string]docker.Signal{
+	"HUP":   docker.SIGHUP,
+	"INT":   docker.SIGINT,
+	"QUIT":  docker.SIGQUIT,
+	"KILL":  docker.SIGKILL,
+	"USR1":  docker.SIGUSR1,
+	"USR2":  docker.SIGUSR2,
+	"TERM":  docker.SIGTERM,
+	"CONT":  docker.SIGCONT,
+	"STOP":  docker.SIGSTOP,
+	"WINCH": docker.SIGWINCH,
+}
+
+// createContainerRequest is the body of a container creation, where the
+// container config is sent along with the host and networking configs.
+type createContainerRequest struct {
+	*docker.Config
+	HostConfig       *docker.HostConfig       `json:"HostConfig,omitempty"`
+	NetworkingConfig *docker.NetworkingConfig `json:"NetworkingConfig,omitempty"`
+}
+
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) error {
+	limit, err := intValue(r, "limit", 0)
+	if err != nil {
+		return err
+	}
+	filters, err := filtersValue(r)
+	if err != nil {
+		return err
+	}
+	result, err := s.cluster.ListContainersPerNode(docker.ListContainersOptions{
+		All:     boolValue(r, "all", false),
+		Size:    boolValue(r, "size", false),
+		Limit:   limit,
+		Filters: filters,
+		Context: r.Context(),
+	})
+	if err != nil {
+		return err
+	}
+	if len(result.Containers) == 0 && result.Err() != nil {
+		return result.Err()
+	}
+	for addr, nodeErr := range result.Errors {
+		log.Warn("[server]: ignoring node in container list", log.Fields{"node": addr, "error": nodeErr})
+	}
+	containers := result.All()
+	sort.SliceStable(containers, func(i, j int) bool {
+		return containers[i].Created > containers[j].Created
+	})
+	if limit > 0 && len(containers) > limit {
+		containers = containers[:limit]
+	}
+	writeJSON(w, http.StatusOK, containers)
+	return nil
+}
+
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request) error {
+	req := createContainerRequest{Config: &docker.Config{}}
+	err := decodeBody(r, &req)
+	if err != nil {
+		return err
+	}
+	if req.Image == "" {
+		return &requestError{message: "Config.Image is required"}
+	}
+	opts := docker.CreateContainerOptions{
+		Name:             r.FormValue("name"),
+		Config:           req.Config,
+		HostConfig:       req.HostConfig,
+		NetworkingConfig: req.NetworkingConfig,
+		Context:          r.Context(),
+	}
+	addr, container, err := s.cluster.CreateContainer(opts, s.inactivityTimeout())
+	if err != nil {
+		return err
+	}
+	log.Debug("[server]: container created", log.Fields{"container": container.ID, "node": addr})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": container.ID, "Warnings": []string{}})
+	return nil
+}
+
+func (s *Server) inspectContainer(w http.ResponseWriter, r *http.Request) error {
+	container, err := s.cluster.InspectContainerWithContext(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, container)
+	return nil
+}
+
+func (s *Server) startContainer(w http.ResponseWriter, r *http.Request) error {
+	err := s.cluster.StartContainerWithContext(r.Context(), mux.Vars(r)["id"], nil)
+	if _, ok := baseError(err).(*docker.ContainerAlreadyRunning); ok {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) stopContainer(w http.ResponseWriter, r *http.Request) error {
+	timeout, err := intValue(r, "t", 10)
+	if err != nil {
+		return err
+	}
+	err = s.cluster.StopContainerWithContext(r.Context(), mux.Vars(r)["id"], uint(timeout))
+	if _, ok := baseError(err).(*docker.ContainerNotRunning); ok {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) restartContainer(w http.ResponseWriter, r *http.Request) error {
+	timeout, err := intValue(r, "t", 10)
+	if err != nil {
+		return err
+	}
+	err = s.cluster.RestartContainerWithContext(r.Context(), mux.Vars(r)["id"], uint(timeout))
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// parseSignal parses a signal given by number or by name, with or without
+// the SIG prefix. It defaults to SIGKILL, like docker.
+func parseSignal(value string) (docker.Signal, error) {
+	if value == "" {
+		return docker.SIGKILL, nil
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return docker.Signal(n), nil
+	}
+	signal, ok := signals[strings.TrimPrefix(strings.ToUpper(value), "SIG")]
+	if !ok {
+		return 0, &requestError{message: "Invalid signal: " + value}
+	}
+	return signal, nil
+}
+
+func (s *Server) killContainer(w http.ResponseWriter, r *http.Request) error {
+	signal, err := parseSignal(r.FormValue("signal"))
+	if err != nil {
+		return err
+	}
+	err = s.cluster.KillContainer(docker.KillContainerOptions{
+		ID:      mux.Vars(r)["id"],
+		Signal:  signal,
+		Context: r.Context(),
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) pauseContainer(w http.ResponseWriter, r *http.Request) error {
+	err := s.cluster.PauseContainerWithContext(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) unpauseContainer(w http.ResponseWriter, r *http.Request) error {
+	err := s.cluster.UnpauseContainerWithContext(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) waitContainer(w http.ResponseWriter, r *http.Request) error {
+	code, err := s.cluster.WaitContainerWithContext(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"StatusCode": code})
+	return nil
+}
+
+func (s *Server) resizeContainer(w http.ResponseWriter, r *http.Request) error {
+	height, err := intValue(r, "h", 0)
+	if err != nil {
+		return err
+	}
+	width, err := intValue(r, "w", 0)
+	if err != nil {
+		return err
+	}
+	err = s.cluster.ResizeContainerTTYWithContext(r.Context(), mux.Vars(r)["id"], height, width)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) topContainer(w http.ResponseWriter, r *http.Request) error {
+	top, err := s.cluster.TopContainerWithContext(r.Context(), mux.Vars(r)["id"], r.FormValue("ps_args"))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, top)
+	return nil
+}
+
+// containerLogs forwards the logs as sent by the node, multiplexed when the
+// container doesn't have a TTY, as docker clients expect.
+func (s *Server) containerLogs(w http.ResponseWriter, r *http.Request) error {
+	since, err := intValue(r, "since", 0)
+	if err != nil {
+		return err
+	}
+	tail := r.FormValue("tail")
+	if tail == "" {
+		tail = "all"
+	}
+	sw := newStreamWriter(w, "application/vnd.docker.raw-stream")
+	err = s.cluster.Logs(docker.LogsOptions{
+		Container:    mux.Vars(r)["id"],
+		OutputStream: sw,
+		ErrorStream:  sw,
+		Follow:       boolValue(r, "follow", false),
+		Stdout:       boolValue(r, "stdout", false),
+		Stderr:       boolValue(r, "stderr", false),
+		Timestamps:   boolValue(r, "timestamps", false),
+		Since:        int64(since),
+		Tail:         tail,
+		RawTerminal:  true,
+		Context:      r.Context(),
+	})
+	return sw.finish(r, err)
+}
+
+func (s *Server) containerStats(w http.ResponseWriter, r *http.Request) error {
+	statsChan := make(chan *docker.Stats)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.cluster.Stats(docker.StatsOptions{
+			ID:      mux.Vars(r)["id"],
+			Stats:   statsChan,
+			Stream:  boolValue(r, "stream", true),
+			Context: r.Context(),
+		})
+	}()
+	sw := newStreamWriter(w, "application/json")
+	encoder := json.NewEncoder(sw)
+	for stats := range statsChan {
+		encoder.Encode(stats)
+	}
+	return sw.finish(r, <-errChan)
+}
+
+func (s *Server) removeContainer(w http.ResponseWriter, r *http.Request) error {
+	err := s.cluster.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            mux.Vars(r)["id"],
+		RemoveVolumes: boolValue(r, "v", false),
+		Force:         boolValue(r, "force", false),
+		Context:       r.Context(),
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerLifecycle(t *testing.T) {
+	nodes := newTestNodes(t, 2)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL(), nodes[1].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	container, err := client.CreateContainer(docker.CreateContainerOptions{
+		Name:   "mycontainer",
+		Config: &docker.Config{Image: "tsuru/python", Cmd: []string{"python"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := c.InspectContainer(container.ID)
+	if err != nil {
+		t.Fatalf("Container %s not stored in the cluster: %s", container.ID, err)
+	}
+	if addr.Name != "mycontainer" {
+		t.Errorf("CreateContainer: wrong name. Want %q. Got %q.", "mycontainer", addr.Name)
+	}
+	err = client.StartContainer(container.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.StartContainer(container.ID, nil)
+	if _, ok := err.(*docker.ContainerAlreadyRunning); !ok {
+		t.Errorf("StartContainer: expected *docker.ContainerAlreadyRunning, got %#v", err)
+	}
+	inspected, err := client.InspectContainerWithOptions(docker.InspectContainerOptions{ID: container.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inspected.State.Running || inspected.Config.Image != "tsuru/python" {
+		t.Errorf("InspectContainer: wrong container: %#v", inspected)
+	}
+	err = client.KillContainer(docker.KillContainerOptions{ID: container.ID, Signal: docker.SIGTERM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.InspectContainerWithOptions(docker.InspectContainerOptions{ID: container.ID})
+	if _, ok := err.(*docker.NoSuchContainer); !ok {
+		t.Errorf("InspectContainer: expected *docker.NoSuchContainer after removal, got %#v", err)
+	}
+}
+
+func TestCreateContainerWithoutImage(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	client, server := newTestClient(t, newTestCluster(t, nodes[0].URL()))
+	defer server.Close()
+	_, err := client.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{}})
+	if e, ok := err.(*docker.Error); !ok || e.Status != 400 {
+		t.Errorf("CreateContainer: expected bad request error, got %#v", err)
+	}
+}
+
+func TestListContainersFromAllNodes(t *testing.T) {
+	nodes := newTestNodes(t, 2)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL(), nodes[1].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	var ids []string
+	for _, node := range nodes {
+		_, container, err := c.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "tsuru/python"}}, 0, node.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, container.ID)
+	}
+	containers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, container := range containers {
+		listed = append(listed, container.ID)
+	}
+	sort.Strings(ids)
+	sort.Strings(listed)
+	if strings.Join(listed, ",") != strings.Join(ids, ",") {
+		t.Errorf("ListContainers: Want %v. Got %v.", ids, listed)
+	}
+	containers, err = client.ListContainers(docker.ListContainersOptions{All: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 {
+		t.Errorf("ListContainers: expected limit to be applied to the cluster, got %d containers", len(containers))
+	}
+}
+
+func TestContainerLogs(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	_, container, err := c.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "tsuru/python"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = client.Logs(docker.LogsOptions{
+		Container:    container.ID,
+		OutputStream: &buf,
+		Stdout:       true,
+		RawTerminal:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Something happened") {
+		t.Errorf("Logs: wrong output: %q", buf.String())
+	}
+	err = client.Logs(docker.LogsOptions{Container: "unknown", OutputStream: &buf, Stdout: true})
+	if e, ok := err.(*docker.Error); !ok || e.Status != 404 {
+		t.Errorf("Logs: expected not found error, got %#v", err)
+	}
+}
+
+func TestContainerStats(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	_, container, err := c.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "tsuru/python"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].PrepareStats(container.ID, func(id string) docker.Stats {
+		var stats docker.Stats
+		stats.MemoryStats.Usage = 1024
+		return stats
+	})
+	statsChan := make(chan *docker.Stats, 1)
+	err = client.Stats(docker.StatsOptions{ID: container.ID, Stats: statsChan, Context: context.Background()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := <-statsChan
+	if stats == nil || stats.MemoryStats.Usage != 1024 {
+		t.Errorf("Stats: wrong stats: %#v", stats)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		value  string
+		signal docker.Signal
+	}{
+		{"", docker.SIGKILL},
+		{"15", docker.SIGTERM},
+		{"SIGHUP", docker.SIGHUP},
+		{"usr1", docker.SIGUSR1},
+	}
+	for _, tt := range tests {
+		signal, err := parseSignal(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signal != tt.signal {
+			t.Errorf("parseSignal(%q): Want %d. Got %d.", tt.value, tt.signal, signal)
+		}
+	}
+	_, err := parseSignal("SIGNOPE")
+	if _, ok := err.(*requestError); !ok {
+		t.Errorf("parseSignal: expected *requestError, got %#v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+func (s *Server) createExec(w http.ResponseWriter, r *http.Request) error {
+	var opts docker.CreateExecOptions
+	err := decodeBody(r, &opts)
+	if err != nil {
+		return err
+	}
+	opts.Container = mux.Vars(r)["id"]
+	opts.Context = r.Context()
+	exec, err := s.cluster.CreateExec(opts)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"Id": exec.ID})
+	return nil
+}
+
+// startExecRequest is the body of an exec start. go-dockerclient sends the
+// whole StartExecOptions, streams included, so only the flags are decoded.
+type startExecRequest struct {
+	Detach bool
+	Tty    bool
+}
+
+// startExec starts an exec in the node where its container is. Unless the
+// exec is detached, the connection is hijacked, as in docker, and the
+// streams are copied between the client and the node.
+func (s *Server) startExec(w http.ResponseWriter, r *http.Request) error {
+	var req startExecRequest
+	err := decodeBody(r, &req)
+	if err != nil {
+		return err
+	}
+	id := mux.Vars(r)["id"]
+	opts := docker.StartExecOptions{Detach: req.Detach, Tty: req.Tty}
+	if opts.Detach {
+		opts.Context = r.Context()
+		err = s.cluster.StartExec(id, opts)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	_, err = s.cluster.InspectExecWithContext(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("Unable to hijack the connection")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if r.Header.Get("Upgrade") != "" {
+		fmt.Fprint(buf, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	} else {
+		fmt.Fprint(buf, "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+	}
+	err = buf.Flush()
+	if err != nil {
+		log.Error("[server]: error starting exec session", log.Fields{"exec": id, "error": err})
+		return nil
+	}
+	opts.InputStream = &hijackedInput{Reader: buf.Reader, conn: conn}
+	opts.OutputStream = conn
+	opts.ErrorStream = conn
+	opts.RawTerminal = true
+	err = s.cluster.StartExec(id, opts)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Error("[server]: error in exec session", log.Fields{"exec": id, "error": err})
+	}
+	return nil
+}
+
+// hijackedInput is the input of a hijacked connection. go-dockerclient closes
+// it once the output of the exec ends, and closing the connection ends the
+// session even if the client never closes its input.
+type hijackedInput struct {
+	*bufio.Reader
+	conn net.Conn
+}
+
+func (i *hijackedInput) Close() error {
+	return i.conn.Close()
+}
+
+func (s *Server) resizeExec(w http.ResponseWriter, r *http.Request) error {
+	height, err := intValue(r, "h", 0)
+	if err != nil {
+		return err
+	}
+	width, err := intValue(r, "w", 0)
+	if err != nil {
+		return err
+	}
+	err = s.cluster.ResizeExecTTYWithContext(r.Context(), mux.Vars(r)["id"], height, width)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Server) inspectExec(w http.ResponseWriter, r *http.Request) error {
+	exec, err := s.cluster.InspectExecWithContext(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, exec)
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/cluster"
+)
+
+func TestExecDetached(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	_, container, err := c.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "tsuru/python"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.StartContainer(container.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container: container.ID,
+		Cmd:       []string{"ls"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var called bool
+	nodes[0].PrepareExec(exec.ID, func() { called = true })
+	err = client.StartExec(exec.ID, docker.StartExecOptions{Detach: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("StartExec: exec was not started in the node")
+	}
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspect.ID != exec.ID || inspect.ContainerID != container.ID {
+		t.Errorf("InspectExec: wrong exec: %#v", inspect)
+	}
+	_, err = client.InspectExec("unknown")
+	if _, ok := err.(*docker.NoSuchExec); !ok {
+		t.Errorf("InspectExec: expected *docker.NoSuchExec, got %#v", err)
+	}
+}
+
+func TestExecAttached(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/exec/myexec/json"):
+			json.NewEncoder(w).Encode(docker.ExecInspect{ID: "myexec", ContainerID: "mycontainer"})
+		case strings.HasSuffix(r.URL.Path, "/exec/myexec/start"):
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+			buf.Write([]byte{1, 0, 0, 0, 0, 0, 0, 5})
+			buf.WriteString("hello")
+			buf.Flush()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer node.Close()
+	stor := &cluster.MapStorage{}
+	ctx := context.Background()
+	err := stor.StoreContainer(ctx, "mycontainer", node.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stor.StoreExec(ctx, "myexec", "mycontainer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cluster.New(nil, stor, "", cluster.Node{Address: node.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	var stdout, stderr bytes.Buffer
+	err = client.StartExec("myexec", docker.StartExecOptions{
+		OutputStream: &stdout,
+		ErrorStream:  &stderr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "hello" {
+		t.Errorf("StartExec: wrong output. Want %q. Got %q.", "hello", stdout.String())
+	}
+	resp, err := http.Post(server.URL+"/exec/unknown/start", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("StartExec: wrong status. Want %d. Got %d.", http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+// listImages lists the images of all nodes, showing images present in more
+// than one node only once.
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) error {
+	filters, err := filtersValue(r)
+	if err != nil {
+		return err
+	}
+	result, err := s.cluster.ListImagesPerNode(docker.ListImagesOptions{
+		All:     boolValue(r, "all", false),
+		Digests: boolValue(r, "digests", false),
+		Filters: filters,
+		Context: r.Context(),
+	})
+	if err != nil {
+		return err
+	}
+	if len(result.Images) == 0 && result.Err() != nil {
+		return result.Err()
+	}
+	for addr, nodeErr := range result.Errors {
+		log.Warn("[server]: ignoring node in image list", log.Fields{"node": addr, "error": nodeErr})
+	}
+	seen := make(map[string]bool)
+	images := []docker.APIImages{}
+	for _, image := range result.All() {
+		if !seen[image.ID] {
+			seen[image.ID] = true
+			images = append(images, image)
+		}
+	}
+	writeJSON(w, http.StatusOK, images)
+	return nil
+}
+
+// pullImage pulls the image in every node. The output of the nodes isn't
+// forwarded, as their progress messages would be interleaved, so a single
+// status is sent when the pull finishes.
+func (s *Server) pullImage(w http.ResponseWriter, r *http.Request) error {
+	repository := r.FormValue("fromImage")
+	if repository == "" {
+		return &requestError{message: "Only pulling images with fromImage is supported"}
+	}
+	tag := r.FormValue("tag")
+	err := s.cluster.PullImage(docker.PullImageOptions{
+		Repository:        repository,
+		Tag:               tag,
+		InactivityTimeout: s.inactivityTimeout(),
+		Context:           r.Context(),
+	}, registryAuth(r))
+	if err != nil {
+		return err
+	}
+	image := repository
+	if tag != "" {
+		image += ":" + tag
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": fmt.Sprintf("Status: Pulled %s in the cluster nodes", image)})
+	return nil
+}
+
+func (s *Server) inspectImage(w http.ResponseWriter, r *http.Request) error {
+	image, err := s.cluster.InspectImageWithContext(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, image)
+	return nil
+}
+
+func (s *Server) imageHistory(w http.ResponseWriter, r *http.Request) error {
+	history, err := s.cluster.ImageHistoryWithContext(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, history)
+	return nil
+}
+
+func (s *Server) tagImage(w http.ResponseWriter, r *http.Request) error {
+	err := s.cluster.TagImage(mux.Vars(r)["name"], docker.TagImageOptions{
+		Repo:    r.FormValue("repo"),
+		Tag:     r.FormValue("tag"),
+		Force:   boolValue(r, "force", false),
+		Context: r.Context(),
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// pushImage pushes the image from the last node where it was pulled or
+// tagged, forwarding the output of the node.
+func (s *Server) pushImage(w http.ResponseWriter, r *http.Request) error {
+	sw := newStreamWriter(w, "application/json")
+	err := s.cluster.PushImage(docker.PushImageOptions{
+		Name:          mux.Vars(r)["name"],
+		Tag:           r.FormValue("tag"),
+		OutputStream:  sw,
+		RawJSONStream: true,
+		Context:       r.Context(),
+	}, registryAuth(r))
+	return sw.finish(r, err)
+}
+
+func (s *Server) removeImage(w http.ResponseWriter, r *http.Request) error {
+	name := mux.Vars(r)["name"]
+	err := s.cluster.RemoveImageWithContext(r.Context(), name)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, []map[string]string{{"Untagged": name}})
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestPullImageInAllNodes(t *testing.T) {
+	nodes := newTestNodes(t, 2)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL(), nodes[1].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	var buf bytes.Buffer
+	err := client.PullImage(docker.PullImageOptions{Repository: "tsuru/python", OutputStream: &buf}, docker.AuthConfiguration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Pulled tsuru/python") {
+		t.Errorf("PullImage: wrong output: %q", buf.String())
+	}
+	for _, node := range nodes {
+		nodeClient, err := docker.NewClient(node.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = nodeClient.InspectImage("tsuru/python")
+		if err != nil {
+			t.Errorf("PullImage: image not pulled in %s: %s", node.URL(), err)
+		}
+	}
+	resp, err := http.Post(server.URL+"/images/create", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("PullImage: wrong status. Want %d. Got %d.", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestListImages(t *testing.T) {
+	nodes := newTestNodes(t, 2)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL(), nodes[1].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	err := c.PullImage(docker.PullImageOptions{Repository: "tsuru/python"}, docker.AuthConfiguration{}, nodes[0].URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.PullImage(docker.PullImageOptions{Repository: "tsuru/ruby"}, docker.AuthConfiguration{}, nodes[1].URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := client.ListImages(docker.ListImagesOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, image := range images {
+		tags = append(tags, image.RepoTags...)
+	}
+	sort.Strings(tags)
+	expected := []string{"tsuru/python", "tsuru/ruby"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("ListImages: Want %#v. Got %#v.", expected, tags)
+	}
+}
+
+func TestImageInspectTagAndRemove(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	c := newTestCluster(t, nodes[0].URL())
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	err := c.PullImage(docker.PullImageOptions{Repository: "tsuru/python"}, docker.AuthConfiguration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := client.InspectImage("tsuru/python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.ID == "" {
+		t.Errorf("InspectImage: wrong image: %#v", image)
+	}
+	err = client.TagImage("tsuru/python", docker.TagImageOptions{Repo: "tsuru/python-tagged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.RemoveImage("tsuru/python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.InspectImage("tsuru/python")
+	if err != docker.ErrNoSuchImage {
+		t.Errorf("InspectImage: Want %#v. Got %#v.", docker.ErrNoSuchImage, err)
+	}
+}
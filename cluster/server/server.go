@@ -0,0 +1,307 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server serves a subset of the Docker Engine API backed by a
+// cluster.Cluster, so the docker CLI and other docker clients can use the
+// whole cluster as if it were a single daemon.
+//
+// New containers are created in the node chosen by the scheduler of the
+// cluster, and requests for existing containers and execs are sent to the
+// node where they are, according to the storage of the cluster. Images are
+// pulled in every node. The API version prefix of the paths, as in
+// /v1.40/containers/json, is accepted and ignored.
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/docker-cluster/log"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+const (
+	defaultInactivityTimeout = time.Minute
+
+	// apiVersion is the API version reported to the clients. Requests are
+	// forwarded to the nodes using the clients of the cluster, so it's
+	// only used by clients negotiating the version.
+	apiVersion    = "1.40"
+	minAPIVersion = "1.24"
+)
+
+// Server is an http.Handler serving the Docker Engine API for a cluster.
+type Server struct {
+	// InactivityTimeout is the inactivity timeout of the image pulls done
+	// when creating containers. Defaults to one minute.
+	InactivityTimeout time.Duration
+
+	cluster *cluster.Cluster
+	router  *mux.Router
+}
+
+// New creates a Server for the given cluster.
+func New(c *cluster.Cluster) *Server {
+	s := &Server{cluster: c, router: mux.NewRouter()}
+	s.routes()
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) routes() {
+	s.handle(http.MethodGet, "/_ping", s.ping)
+	s.handle(http.MethodHead, "/_ping", s.ping)
+	s.handle(http.MethodGet, "/version", s.version)
+	s.handle(http.MethodGet, "/info", s.info)
+
+	s.handle(http.MethodGet, "/containers/json", s.listContainers)
+	s.handle(http.MethodPost, "/containers/create", s.createContainer)
+	s.handle(http.MethodGet, "/containers/{id}/json", s.inspectContainer)
+	s.handle(http.MethodPost, "/containers/{id}/start", s.startContainer)
+	s.handle(http.MethodPost, "/containers/{id}/stop", s.stopContainer)
+	s.handle(http.MethodPost, "/containers/{id}/restart", s.restartContainer)
+	s.handle(http.MethodPost, "/containers/{id}/kill", s.killContainer)
+	s.handle(http.MethodPost, "/containers/{id}/pause", s.pauseContainer)
+	s.handle(http.MethodPost, "/containers/{id}/unpause", s.unpauseContainer)
+	s.handle(http.MethodPost, "/containers/{id}/wait", s.waitContainer)
+	s.handle(http.MethodPost, "/containers/{id}/resize", s.resizeContainer)
+	s.handle(http.MethodGet, "/containers/{id}/top", s.topContainer)
+	s.handle(http.MethodGet, "/containers/{id}/logs", s.containerLogs)
+	s.handle(http.MethodGet, "/containers/{id}/stats", s.containerStats)
+	s.handle(http.MethodDelete, "/containers/{id}", s.removeContainer)
+
+	s.handle(http.MethodPost, "/containers/{id}/exec", s.createExec)
+	s.handle(http.MethodPost, "/exec/{id}/start", s.startExec)
+	s.handle(http.MethodPost, "/exec/{id}/resize", s.resizeExec)
+	s.handle(http.MethodGet, "/exec/{id}/json", s.inspectExec)
+
+	s.handle(http.MethodGet, "/images/json", s.listImages)
+	s.handle(http.MethodPost, "/images/create", s.pullImage)
+	s.handle(http.MethodGet, "/images/{name:.+}/json", s.inspectImage)
+	s.handle(http.MethodGet, "/images/{name:.+}/history", s.imageHistory)
+	s.handle(http.MethodPost, "/images/{name:.+}/tag", s.tagImage)
+	s.handle(http.MethodPost, "/images/{name:.+}/push", s.pushImage)
+	s.handle(http.MethodDelete, "/images/{name:.+}", s.removeImage)
+}
+
+// handlerFunc is an HTTP handler that returns the error to be sent to the
+// client, if it didn't write a response.
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (s *Server) handle(method, path string, h handlerFunc) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		err := h(w, r)
+		if err != nil {
+			writeError(w, r, err)
+		}
+	}
+	s.router.Path(path).Methods(method).HandlerFunc(fn)
+	s.router.Path("/v{version:[0-9.]+}" + path).Methods(method).HandlerFunc(fn)
+}
+
+func (s *Server) inactivityTimeout() time.Duration {
+	if s.InactivityTimeout <= 0 {
+		return defaultInactivityTimeout
+	}
+	return s.InactivityTimeout
+}
+
+// requestError is an error caused by an invalid request.
+type requestError struct {
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// baseError returns the error returned by the node, if err came from one.
+func baseError(err error) error {
+	if nodeErr, ok := err.(cluster.DockerNodeError); ok {
+		return nodeErr.BaseError()
+	}
+	return err
+}
+
+// errorStatus returns the HTTP status code for err, which may come from
+// the cluster, its storage or one of the nodes.
+func errorStatus(err error) int {
+	err = baseError(err)
+	switch e := err.(type) {
+	case *requestError:
+		return http.StatusBadRequest
+	case *docker.Error:
+		return e.Status
+	case *docker.NoSuchContainer, *docker.NoSuchExec:
+		return http.StatusNotFound
+	}
+	switch err {
+	case storage.ErrNoSuchContainer, storage.ErrNoSuchExec, storage.ErrNoSuchImage, docker.ErrNoSuchImage:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// errorMessage returns the message of err. Errors returned by the nodes hold
+// the JSON body of their response, so only the message in it is used.
+func errorMessage(err error) string {
+	if dockerErr, ok := baseError(err).(*docker.Error); ok {
+		var body struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal([]byte(dockerErr.Message), &body) == nil && body.Message != "" {
+			return body.Message
+		}
+	}
+	return err.Error()
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Error("[server]: error handling request", log.Fields{"method": r.Method, "path": r.URL.Path, "error": err})
+	}
+	writeJSON(w, status, map[string]string{"message": errorMessage(err)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func decodeBody(r *http.Request, value interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(value)
+	if err != nil {
+		return &requestError{message: "Invalid request body: " + err.Error()}
+	}
+	return nil
+}
+
+func boolValue(r *http.Request, name string, def bool) bool {
+	value := r.FormValue(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+func intValue(r *http.Request, name string, def int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &requestError{message: "Invalid value for " + name + ": " + value}
+	}
+	return n, nil
+}
+
+// filtersValue parses the filters parameter, in the current format or in the
+// one sent by older clients, where each filter maps values to true.
+func filtersValue(r *http.Request) (map[string][]string, error) {
+	value := r.FormValue("filters")
+	if value == "" {
+		return nil, nil
+	}
+	var filters map[string][]string
+	if json.Unmarshal([]byte(value), &filters) == nil {
+		return filters, nil
+	}
+	var legacy map[string]map[string]bool
+	if err := json.Unmarshal([]byte(value), &legacy); err != nil {
+		return nil, &requestError{message: "Invalid filters: " + value}
+	}
+	filters = make(map[string][]string, len(legacy))
+	for name, values := range legacy {
+		for v, set := range values {
+			if set {
+				filters[name] = append(filters[name], v)
+			}
+		}
+	}
+	return filters, nil
+}
+
+// registryAuth decodes the credentials sent by docker clients in the
+// X-Registry-Auth header. Like in docker, invalid credentials are ignored.
+func registryAuth(r *http.Request) docker.AuthConfiguration {
+	var auth docker.AuthConfiguration
+	header := r.Header.Get("X-Registry-Auth")
+	if header == "" {
+		return auth
+	}
+	data, err := base64.URLEncoding.DecodeString(header)
+	if err != nil {
+		data, err = base64.StdEncoding.DecodeString(header)
+	}
+	if err == nil {
+		json.Unmarshal(data, &auth)
+	}
+	return auth
+}
+
+// streamWriter writes a streamed response. The headers are only sent on the
+// first write, so errors happening before any output can still be returned
+// with the right status.
+type streamWriter struct {
+	mut         sync.Mutex
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func newStreamWriter(w http.ResponseWriter, contentType string) *streamWriter {
+	return &streamWriter{w: w, contentType: contentType}
+}
+
+func (sw *streamWriter) start() {
+	if !sw.started {
+		sw.w.Header().Set("Content-Type", sw.contentType)
+		sw.w.WriteHeader(http.StatusOK)
+		sw.started = true
+	}
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.mut.Lock()
+	defer sw.mut.Unlock()
+	sw.start()
+	n, err := sw.w.Write(p)
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// finish ends the response, returning err to be sent to the client if
+// nothing was written yet. Errors after that are only logged.
+func (sw *streamWriter) finish(r *http.Request, err error) error {
+	sw.mut.Lock()
+	defer sw.mut.Unlock()
+	if err == nil {
+		sw.start()
+		return nil
+	}
+	if !sw.started {
+		return err
+	}
+	log.Error("[server]: error streaming response", log.Fields{"method": r.Method, "path": r.URL.Path, "error": err})
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+func newTestNodes(t *testing.T, n int) []*dtesting.DockerServer {
+	nodes := make([]*dtesting.DockerServer, n)
+	for i := range nodes {
+		var err error
+		nodes[i], err = dtesting.NewServer("127.0.0.1:0", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nodes
+}
+
+func stopNodes(nodes []*dtesting.DockerServer) {
+	for _, n := range nodes {
+		n.Stop()
+	}
+}
+
+func newTestCluster(t *testing.T, addrs ...string) *cluster.Cluster {
+	nodes := make([]cluster.Node, len(addrs))
+	for i, addr := range addrs {
+		nodes[i] = cluster.Node{Address: addr}
+	}
+	c, err := cluster.New(nil, &cluster.MapStorage{}, "", nodes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestClient(t *testing.T, c *cluster.Cluster) (*docker.Client, *httptest.Server) {
+	server := httptest.NewServer(New(c))
+	client, err := docker.NewVersionedClient(server.URL, "1.40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestPing(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	client, server := newTestClient(t, newTestCluster(t, nodes[0].URL()))
+	defer server.Close()
+	err := client.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Head(server.URL + "/_ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("HEAD /_ping: wrong status. Want %d. Got %d.", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	client, server := newTestClient(t, newTestCluster(t, nodes[0].URL()))
+	defer server.Close()
+	version, err := client.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Get("ApiVersion") != apiVersion {
+		t.Errorf("Version: wrong API version. Want %q. Got %q.", apiVersion, version.Get("ApiVersion"))
+	}
+}
+
+func TestInfo(t *testing.T) {
+	nodes := newTestNodes(t, 2)
+	defer stopNodes(nodes)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal failure", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	c := newTestCluster(t, nodes[0].URL(), nodes[1].URL(), failing.URL)
+	client, server := newTestClient(t, c)
+	defer server.Close()
+	err := c.PullImage(docker.PullImageOptions{Repository: "tsuru/python"}, docker.AuthConfiguration{}, nodes[0].URL(), nodes[1].URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := client.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Images != 2 {
+		t.Errorf("Info: wrong number of images. Want 2. Got %d.", info.Images)
+	}
+	if info.Name != "docker-cluster" {
+		t.Errorf("Info: wrong name. Want %q. Got %q.", "docker-cluster", info.Name)
+	}
+	if len(info.SystemStatus) != 4 || info.SystemStatus[0] != [2]string{"Nodes", "3"} {
+		t.Fatalf("Info: wrong system status: %#v", info.SystemStatus)
+	}
+	statuses := map[string]string{}
+	for _, status := range info.SystemStatus[1:] {
+		statuses[status[0]] = status[1]
+	}
+	if statuses[" "+nodes[0].URL()] != "Healthy" || statuses[" "+nodes[1].URL()] != "Healthy" {
+		t.Errorf("Info: expected nodes to be healthy: %#v", statuses)
+	}
+	if statuses[" "+failing.URL] == "Healthy" {
+		t.Errorf("Info: expected failing node to be unhealthy: %#v", statuses)
+	}
+}
+
+func TestVersionedPaths(t *testing.T) {
+	nodes := newTestNodes(t, 1)
+	defer stopNodes(nodes)
+	_, server := newTestClient(t, newTestCluster(t, nodes[0].URL()))
+	defer server.Close()
+	for _, path := range []string{"/_ping", "/v1.24/_ping", "/v1.40/containers/json"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: wrong status. Want %d. Got %d.", path, http.StatusOK, resp.StatusCode)
+		}
+	}
+	resp, err := http.Get(server.URL + "/vlatest/_ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /vlatest/_ping: wrong status. Want %d. Got %d.", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&requestError{message: "invalid"}, http.StatusBadRequest},
+		{storage.ErrNoSuchContainer, http.StatusNotFound},
+		{storage.ErrNoSuchExec, http.StatusNotFound},
+		{docker.ErrNoSuchImage, http.StatusNotFound},
+		{&docker.NoSuchContainer{ID: "abc"}, http.StatusNotFound},
+		{&docker.Error{Status: http.StatusConflict}, http.StatusConflict},
+		{errors.New("something went wrong"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if status := errorStatus(tt.err); status != tt.status {
+			t.Errorf("errorStatus(%#v): Want %d. Got %d.", tt.err, tt.status, status)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &docker.Error{Status: http.StatusConflict, Message: `{"message":"container is in use"}`}
+	if msg := errorMessage(err); msg != "container is in use" {
+		t.Errorf("errorMessage: Want %q. Got %q.", "container is in use", msg)
+	}
+	err = &docker.Error{Status: http.StatusConflict, Message: "plain text"}
+	if msg := errorMessage(err); msg != err.Error() {
+		t.Errorf("errorMessage: Want %q. Got %q.", err.Error(), msg)
+	}
+}
+
+func TestFiltersValue(t *testing.T) {
+	tests := []string{
+		`{"status":["running","paused"]}`,
+		`{"status":{"running":true,"paused":true,"exited":false}}`,
+	}
+	for _, filters := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/containers/json?filters="+url.QueryEscape(filters), nil)
+		value, err := filtersValue(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(value["status"])
+		expected := map[string][]string{"status": {"paused", "running"}}
+		if !reflect.DeepEqual(value, expected) {
+			t.Errorf("filtersValue(%s): Want %#v. Got %#v.", filters, expected, value)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/containers/json?filters=invalid", nil)
+	_, err := filtersValue(r)
+	if _, ok := err.(*requestError); !ok {
+		t.Errorf("filtersValue: expected *requestError. Got %#v.", err)
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/images/create", nil)
+	r.Header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString([]byte(`{"username":"user","password":"secret"}`)))
+	auth := registryAuth(r)
+	if auth.Username != "user" || auth.Password != "secret" {
+		t.Errorf("registryAuth: wrong credentials: %#v", auth)
+	}
+	r.Header.Set("X-Registry-Auth", "invalid")
+	auth = registryAuth(r)
+	if auth != (docker.AuthConfiguration{}) {
+		t.Errorf("registryAuth: expected empty credentials, got %#v", auth)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write([]byte("OK"))
+	}
+	return nil
+}
+
+func (s *Server) version(w http.ResponseWriter, r *http.Request) error {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"Version":       "docker-cluster",
+		"ApiVersion":    apiVersion,
+		"MinAPIVersion": minAPIVersion,
+		"GoVersion":     runtime.Version(),
+		"Os":            runtime.GOOS,
+		"Arch":          runtime.GOARCH,
+	})
+	return nil
+}
+
+// info sums the containers, images, CPUs and memory of the enabled nodes,
+// listing the status of each node in SystemStatus.
+func (s *Server) info(w http.ResponseWriter, r *http.Request) error {
+	result, err := s.cluster.InfoPerNodeWithContext(r.Context())
+	if err != nil {
+		return err
+	}
+	if len(result.Info) == 0 && result.Err() != nil {
+		return result.Err()
+	}
+	info := docker.DockerInfo{
+		Name:            "docker-cluster",
+		OperatingSystem: "docker-cluster",
+	}
+	status := make(map[string]string)
+	for addr, nodeInfo := range result.Info {
+		info.Containers += nodeInfo.Containers
+		info.ContainersRunning += nodeInfo.ContainersRunning
+		info.ContainersPaused += nodeInfo.ContainersPaused
+		info.ContainersStopped += nodeInfo.ContainersStopped
+		info.Images += nodeInfo.Images
+		info.NCPU += nodeInfo.NCPU
+		info.MemTotal += nodeInfo.MemTotal
+		status[addr] = "Healthy"
+	}
+	for addr, nodeErr := range result.Errors {
+		log.Warn("[server]: ignoring node in info", log.Fields{"node": addr, "error": nodeErr})
+		status[addr] = "Unhealthy: " + nodeErr.Error()
+	}
+	for _, addr := range result.Skipped {
+		status[addr] = "Disabled"
+	}
+	addrs := make([]string, 0, len(status))
+	for addr := range status {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	info.SystemStatus = [][2]string{{"Nodes", strconv.Itoa(len(addrs))}}
+	for _, addr := range addrs {
+		info.SystemStatus = append(info.SystemStatus, [2]string{" " + addr, status[addr]})
+	}
+	writeJSON(w, http.StatusOK, info)
+	return nil
+}
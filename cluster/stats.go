@@ -0,0 +1,184 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+)
+
+// Stats streams the resource usage statistics of a container, from the node
+// where it's running, to opts.Stats. Like in docker, opts.Stats is closed
+// when Stats returns, even if the container can't be found.
+func (c *Cluster) Stats(opts docker.StatsOptions) error {
+	ctx := optsContext(opts.Context)
+	node, err := c.getNodeForContainer(ctx, opts.ID)
+	if err != nil {
+		if opts.Stats != nil {
+			close(opts.Stats)
+		}
+		return err
+	}
+	node.setPersistentClient()
+	return wrapError(node, node.Stats(opts))
+}
+
+// NodeStats holds the resource usage of the containers tracked in a node, as
+// returned by NodeStats.
+type NodeStats struct {
+	Address string
+
+	// Containers is the number of containers whose statistics were
+	// collected. Containers tracked in the storage but missing in the node
+	// are ignored.
+	Containers int
+
+	// CPUPercent is the CPU usage of the containers, where 100 means a full
+	// CPU of the node.
+	CPUPercent float64
+
+	// MemoryUsage is the memory used by the containers, in bytes.
+	MemoryUsage uint64
+
+	// NetworkRx and NetworkTx are the bytes received and sent by the
+	// containers, in all their networks.
+	NetworkRx uint64
+	NetworkTx uint64
+}
+
+func (s *NodeStats) add(stats *docker.Stats) {
+	s.Containers++
+	s.CPUPercent += cpuPercent(stats)
+	s.MemoryUsage += stats.MemoryStats.Usage
+	if len(stats.Networks) == 0 {
+		s.NetworkRx += stats.Network.RxBytes
+		s.NetworkTx += stats.Network.TxBytes
+	}
+	for _, net := range stats.Networks {
+		s.NetworkRx += net.RxBytes
+		s.NetworkTx += net.TxBytes
+	}
+}
+
+// cpuPercent calculates the CPU usage of a container between the two samples
+// in stats, the same way the docker CLI does.
+func cpuPercent(stats *docker.Stats) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage) - float64(stats.PreCPUStats.SystemCPUUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// NodeStats returns the sum of the resource usage of all containers tracked
+// in the node with the given address. It's used by ResourceScheduler to rank
+// nodes when using StrategyUsage.
+func (c *Cluster) NodeStats(address string) (NodeStats, error) {
+	return c.NodeStatsWithContext(context.Background(), address)
+}
+
+// NodeStatsWithContext is like NodeStats, but using ctx to cancel the operation.
+func (c *Cluster) NodeStatsWithContext(ctx context.Context, address string) (NodeStats, error) {
+	_, err := c.GetNodeWithContext(ctx, address)
+	if err != nil {
+		return NodeStats{}, err
+	}
+	containers, err := c.containersByHost(ctx)
+	if err != nil {
+		return NodeStats{}, err
+	}
+	return c.nodeStats(ctx, address, containers[address])
+}
+
+// nodesStats returns the stats of the given nodes, by address, ignoring
+// nodes whose stats couldn't be read.
+func (c *Cluster) nodesStats(ctx context.Context, addresses []string) (map[string]NodeStats, error) {
+	containers, err := c.containersByHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var mut sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]NodeStats, len(addresses))
+	for _, addr := range addresses {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			stats, err := c.nodeStats(ctx, addr, containers[addr])
+			if err != nil {
+				log.Warn("Ignoring node when reading stats", log.Fields{"node": addr, "error": err})
+				return
+			}
+			mut.Lock()
+			result[addr] = stats
+			mut.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return result, nil
+}
+
+func (c *Cluster) nodeStats(ctx context.Context, address string, ids []string) (NodeStats, error) {
+	n, err := c.getNodeByAddr(ctx, address)
+	if err != nil {
+		return NodeStats{}, err
+	}
+	var wg sync.WaitGroup
+	statsChan := make(chan *docker.Stats, len(ids))
+	errChan := make(chan error, len(ids))
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			stats, err := containerStats(ctx, n, id)
+			if err != nil {
+				if _, ok := err.(*docker.NoSuchContainer); !ok {
+					errChan <- wrapError(n, err)
+				}
+				return
+			}
+			if stats != nil {
+				statsChan <- stats
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(statsChan)
+	close(errChan)
+	if err := <-errChan; err != nil {
+		return NodeStats{}, err
+	}
+	result := NodeStats{Address: address}
+	for stats := range statsChan {
+		result.add(stats)
+	}
+	return result, nil
+}
+
+// containerStats returns a single sample of the statistics of a container,
+// or nil if docker didn't send any.
+func containerStats(ctx context.Context, n node, id string) (*docker.Stats, error) {
+	statsChan := make(chan *docker.Stats)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- n.Stats(docker.StatsOptions{ID: id, Stats: statsChan, Context: ctx})
+	}()
+	var last *docker.Stats
+	for stats := range statsChan {
+		last = stats
+	}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	return last, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+func statsServer(stats map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for id, body := range stats {
+			if strings.HasSuffix(r.URL.Path, "/containers/"+id+"/stats") {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body))
+				return
+			}
+		}
+		http.Error(w, "No such container", http.StatusNotFound)
+	}))
+}
+
+func TestStats(t *testing.T) {
+	server1 := statsServer(nil)
+	defer server1.Close()
+	server2 := statsServer(map[string]string{
+		"abc123": `{"memory_stats":{"usage":100}}` + "\n" + `{"memory_stats":{"usage":200}}`,
+	})
+	defer server2.Close()
+	storage := &MapStorage{}
+	err := storage.StoreContainer(context.Background(), "abc123", server2.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := New(nil, storage, "", Node{Address: server1.URL}, Node{Address: server2.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statsChan := make(chan *docker.Stats, 2)
+	err = cluster.Stats(docker.StatsOptions{ID: "abc123", Stats: statsChan, Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usage []uint64
+	for stats := range statsChan {
+		usage = append(usage, stats.MemoryStats.Usage)
+	}
+	if len(usage) != 2 || usage[0] != 100 || usage[1] != 200 {
+		t.Errorf("Stats: wrong memory usage. Want [100 200]. Got %v.", usage)
+	}
+}
+
+func TestStatsContainerNotFound(t *testing.T) {
+	cluster, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statsChan := make(chan *docker.Stats)
+	err = cluster.Stats(docker.StatsOptions{ID: "abc123", Stats: statsChan})
+	if err != storage.ErrNoSuchContainer {
+		t.Errorf("Stats: wrong error. Want %#v. Got %#v.", storage.ErrNoSuchContainer, err)
+	}
+	if _, ok := <-statsChan; ok {
+		t.Error("Stats: stats channel should be closed")
+	}
+}
+
+func TestNodeStats(t *testing.T) {
+	server := statsServer(map[string]string{
+		"c1": `{"cpu_stats":{"cpu_usage":{"total_usage":300},"system_cpu_usage":2000,"online_cpus":2},` +
+			`"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":1000},` +
+			`"memory_stats":{"usage":1024},` +
+			`"networks":{"eth0":{"rx_bytes":10,"tx_bytes":20},"eth1":{"rx_bytes":1,"tx_bytes":2}}}`,
+		"c2": `{"cpu_stats":{"cpu_usage":{"total_usage":150,"percpu_usage":[100,50]},"system_cpu_usage":2000},` +
+			`"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":1000},` +
+			`"memory_stats":{"usage":512},` +
+			`"networks":{"eth0":{"rx_bytes":5,"tx_bytes":5}}}`,
+	})
+	defer server.Close()
+	otherServer := statsServer(map[string]string{"c4": `{"memory_stats":{"usage":4096}}`})
+	defer otherServer.Close()
+	storage := &MapStorage{}
+	for id, host := range map[string]string{"c1": server.URL, "c2": server.URL, "c3": server.URL, "c4": otherServer.URL} {
+		err := storage.StoreContainer(context.Background(), id, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cluster, err := New(nil, storage, "", Node{Address: server.URL}, Node{Address: otherServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := cluster.NodeStats(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NodeStats{
+		Address:     server.URL,
+		Containers:  2,
+		CPUPercent:  50,
+		MemoryUsage: 1536,
+		NetworkRx:   16,
+		NetworkTx:   27,
+	}
+	if stats != expected {
+		t.Errorf("NodeStats: wrong result. Want %#v. Got %#v.", expected, stats)
+	}
+}
+
+func TestNodeStatsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	storage := &MapStorage{}
+	err := storage.StoreContainer(context.Background(), "c1", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := New(nil, storage, "", Node{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.NodeStats(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "something went wrong") {
+		t.Errorf("NodeStats: expected node error. Got %v.", err)
+	}
+	if e, ok := err.(DockerNodeError); !ok || e.node.addr != server.URL {
+		t.Errorf("NodeStats: expected DockerNodeError for %s. Got %#v.", server.URL, err)
+	}
+}
+
+func TestNodeStatsUnknownNode(t *testing.T) {
+	cluster, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.NodeStats("http://localhost:4243")
+	if err != storage.ErrNoSuchNode {
+		t.Errorf("NodeStats: wrong error. Want %#v. Got %#v.", storage.ErrNoSuchNode, err)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tsuru/docker-cluster/log"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+// ErrStorageNotEmpty is returned by CopyStorage when the destination storage
+// already holds data and the copy is not being resumed.
+var ErrStorageNotEmpty = errors.New("Destination storage is not empty")
+
+// CopyStorageOptions are the options for CopyStorage.
+type CopyStorageOptions struct {
+	// Resume allows copying into a destination that already holds data,
+	// usually left behind by an interrupted copy. Entries that are already
+	// in the destination are updated with the data in the source.
+	Resume bool
+
+	// Context, if set, cancels the copy, which may be resumed later.
+	Context context.Context
+}
+
+// CopyStorageResult holds the number of entries of each kind copied by
+// CopyStorage.
+type CopyStorageResult struct {
+	Nodes      int
+	Containers int
+	Images     int
+	Execs      int
+	Networks   int
+	Volumes    int
+}
+
+// CopyStorage copies all nodes, containers, images, execs, networks and
+// volumes from src to dst, and then verifies that both storages hold the same
+// number of entries of each kind.
+//
+// Unless opts.Resume is set, the destination must be empty. Every step of the
+// copy can be safely repeated, so an interrupted copy may be resumed by
+// calling CopyStorage again with opts.Resume.
+func CopyStorage(src, dst Storage, opts CopyStorageOptions) (*CopyStorageResult, error) {
+	ctx := optsContext(opts.Context)
+	if !opts.Resume {
+		counts, err := countStorage(ctx, dst)
+		if err != nil {
+			return nil, err
+		}
+		if *counts != (CopyStorageResult{}) {
+			return nil, ErrStorageNotEmpty
+		}
+	}
+	var result CopyStorageResult
+	steps := []struct {
+		kind string
+		copy func(ctx context.Context, src, dst Storage) (int, error)
+		dest *int
+	}{
+		{"nodes", copyNodes, &result.Nodes},
+		{"containers", copyContainers, &result.Containers},
+		{"images", copyImages, &result.Images},
+		{"execs", copyExecs, &result.Execs},
+		{"networks", copyNetworks, &result.Networks},
+		{"volumes", copyVolumes, &result.Volumes},
+	}
+	for _, step := range steps {
+		n, err := step.copy(ctx, src, dst)
+		if err != nil {
+			return nil, fmt.Errorf("Error copying %s: %s", step.kind, err)
+		}
+		*step.dest = n
+		log.Debug("[storage-copy]: entries copied", log.Fields{"kind": step.kind, "count": n})
+	}
+	counts, err := countStorage(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	if *counts != result {
+		return nil, fmt.Errorf("Storage copy verification failed: copied %+v, destination holds %+v", result, *counts)
+	}
+	return &result, nil
+}
+
+func countStorage(ctx context.Context, stor Storage) (*CopyStorageResult, error) {
+	nodes, err := stor.RetrieveNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := stor.RetrieveContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	images, err := stor.RetrieveImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execs, err := stor.RetrieveExecs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	networks, err := stor.RetrieveNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := stor.RetrieveVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &CopyStorageResult{
+		Nodes:      len(nodes),
+		Containers: len(containers),
+		Images:     len(images),
+		Execs:      len(execs),
+		Networks:   len(networks),
+		Volumes:    len(volumes),
+	}, nil
+}
+
+func copyNodes(ctx context.Context, src, dst Storage) (int, error) {
+	nodes, err := src.RetrieveNodes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, node := range nodes {
+		err = dst.StoreNode(ctx, node)
+		if err == storage.ErrDuplicatedNodeAddress {
+			err = dst.UpdateNode(ctx, node)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(nodes), nil
+}
+
+func copyContainers(ctx context.Context, src, dst Storage) (int, error) {
+	containers, err := src.RetrieveContainers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, container := range containers {
+		err = dst.StoreContainer(ctx, container.Id, container.Host)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(containers), nil
+}
+
+func copyImages(ctx context.Context, src, dst Storage) (int, error) {
+	images, err := src.RetrieveImages(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, img := range images {
+		err = copyImage(ctx, img, dst)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(images), nil
+}
+
+// copyImage stores the history of img in dst, keeping its last node, id and
+// digest, and drops history entries in dst that aren't in img.
+func copyImage(ctx context.Context, img Image, dst Storage) error {
+	inHistory := func(history []ImageHistory, id, node string) bool {
+		for _, entry := range history {
+			if entry.ImageId == id && entry.Node == node {
+				return true
+			}
+		}
+		return false
+	}
+	current, err := dst.RetrieveImage(ctx, img.Repository)
+	if err != nil && err != storage.ErrNoSuchImage {
+		return err
+	}
+	for _, entry := range img.History {
+		err = dst.StoreImage(ctx, img.Repository, entry.ImageId, entry.Node)
+		if err != nil {
+			return err
+		}
+	}
+	if img.LastId != "" || img.LastNode != "" {
+		err = dst.StoreImage(ctx, img.Repository, img.LastId, img.LastNode)
+		if err != nil {
+			return err
+		}
+		if !inHistory(img.History, img.LastId, img.LastNode) {
+			current.History = append(current.History, ImageHistory{Node: img.LastNode, ImageId: img.LastId})
+		}
+	}
+	for _, entry := range current.History {
+		if !inHistory(img.History, entry.ImageId, entry.Node) {
+			err = dst.RemoveImage(ctx, img.Repository, entry.ImageId, entry.Node)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if img.LastDigest != "" {
+		return dst.SetImageDigest(ctx, img.Repository, img.LastDigest)
+	}
+	return nil
+}
+
+func copyExecs(ctx context.Context, src, dst Storage) (int, error) {
+	execs, err := src.RetrieveExecs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, exec := range execs {
+		containerID, err := dst.RetrieveExec(ctx, exec.Id)
+		if err == nil && containerID == exec.Container {
+			continue
+		}
+		if err != nil && err != storage.ErrNoSuchExec {
+			return 0, err
+		}
+		err = dst.StoreExec(ctx, exec.Id, exec.Container)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(execs), nil
+}
+
+func copyNetworks(ctx context.Context, src, dst Storage) (int, error) {
+	networks, err := src.RetrieveNetworks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, network := range networks {
+		err = dst.StoreNetwork(ctx, network)
+		if err == storage.ErrDuplicatedNetwork {
+			err = nil
+			for _, n := range network.Nodes {
+				err = dst.AddNetworkNode(ctx, network.Name, n.ID, n.Node)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(networks), nil
+}
+
+func copyVolumes(ctx context.Context, src, dst Storage) (int, error) {
+	volumes, err := src.RetrieveVolumes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, volume := range volumes {
+		err = dst.StoreVolume(ctx, volume)
+		if err != nil && err != storage.ErrDuplicatedVolume {
+			return 0, err
+		}
+	}
+	return len(volumes), nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+func populatedStorage(t *testing.T) *MapStorage {
+	stor := &MapStorage{}
+	steps := []error{
+		stor.StoreNode(context.Background(), Node{Address: "http://n1:4243", Metadata: map[string]string{"pool": "p1"}}),
+		stor.StoreNode(context.Background(), Node{Address: "http://n2:4243", CaCert: []byte("ca")}),
+		stor.StoreContainer(context.Background(), "c1", "http://n1:4243"),
+		stor.StoreContainer(context.Background(), "c2", "http://n2:4243"),
+		stor.StoreImage(context.Background(), "tsuru/python", "id1", "http://n1:4243"),
+		stor.StoreImage(context.Background(), "tsuru/python", "id2", "http://n2:4243"),
+		stor.StoreImage(context.Background(), "tsuru/python", "id1", "http://n1:4243"),
+		stor.SetImageDigest(context.Background(), "tsuru/python", "sha256:abc"),
+		stor.StoreImage(context.Background(), "tsuru/ruby", "id3", "http://n2:4243"),
+		stor.RemoveImage(context.Background(), "tsuru/ruby", "id3", "http://n2:4243"),
+		stor.StoreExec(context.Background(), "e1", "c1"),
+		stor.StoreExec(context.Background(), "e2", "c2"),
+		stor.StoreNetwork(context.Background(), Network{Name: "net1", Driver: "bridge"}),
+		stor.AddNetworkNode(context.Background(), "net1", "nid1", "http://n1:4243"),
+		stor.StoreVolume(context.Background(), Volume{Name: "vol1", Node: "http://n2:4243", Driver: "local"}),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stor
+}
+
+func assertSameStorage(t *testing.T, expected, got Storage) {
+	for _, retrieve := range []func(Storage) (interface{}, error){
+		func(s Storage) (interface{}, error) { return s.RetrieveNodes(context.Background()) },
+		func(s Storage) (interface{}, error) {
+			containers, err := s.RetrieveContainers(context.Background())
+			sort.Slice(containers, func(i, j int) bool { return containers[i].Id < containers[j].Id })
+			return containers, err
+		},
+		func(s Storage) (interface{}, error) {
+			images, err := s.RetrieveImages(context.Background())
+			sort.Slice(images, func(i, j int) bool { return images[i].Repository < images[j].Repository })
+			return images, err
+		},
+		func(s Storage) (interface{}, error) {
+			execs, err := s.RetrieveExecs(context.Background())
+			sort.Slice(execs, func(i, j int) bool { return execs[i].Id < execs[j].Id })
+			return execs, err
+		},
+		func(s Storage) (interface{}, error) { return s.RetrieveNetworks(context.Background()) },
+		func(s Storage) (interface{}, error) { return s.RetrieveVolumes(context.Background()) },
+	} {
+		want, err := retrieve(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := retrieve(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("CopyStorage: wrong data.\nWant %#v.\nGot  %#v.", want, data)
+		}
+	}
+}
+
+func TestCopyStorage(t *testing.T) {
+	src := populatedStorage(t)
+	dst := &MapStorage{}
+	result, err := CopyStorage(src, dst, CopyStorageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CopyStorageResult{Nodes: 2, Containers: 2, Images: 2, Execs: 2, Networks: 1, Volumes: 1}
+	if *result != expected {
+		t.Errorf("CopyStorage: want %+v, got %+v", expected, *result)
+	}
+	assertSameStorage(t, src, dst)
+	img, err := dst.RetrieveImage(context.Background(), "tsuru/python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.LastId != "id1" || img.LastNode != "http://n1:4243" || img.LastDigest != "sha256:abc" {
+		t.Errorf("CopyStorage: wrong image %#v", img)
+	}
+	_, err = dst.RetrieveImage(context.Background(), "tsuru/ruby")
+	if err != storage.ErrNoSuchImage {
+		t.Errorf("CopyStorage: want %#v, got %#v", storage.ErrNoSuchImage, err)
+	}
+}
+
+func TestCopyStorageDestinationNotEmpty(t *testing.T) {
+	src := populatedStorage(t)
+	dst := &MapStorage{}
+	err := dst.StoreContainer(context.Background(), "c3", "http://n3:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CopyStorage(src, dst, CopyStorageOptions{})
+	if err != ErrStorageNotEmpty {
+		t.Fatalf("CopyStorage: want %#v, got %#v", ErrStorageNotEmpty, err)
+	}
+	containers, _ := dst.RetrieveContainers(context.Background())
+	if len(containers) != 1 {
+		t.Errorf("CopyStorage: destination should not be changed, got %#v", containers)
+	}
+}
+
+func TestCopyStorageResume(t *testing.T) {
+	src := populatedStorage(t)
+	dst := &MapStorage{}
+	steps := []error{
+		dst.StoreNode(context.Background(), Node{Address: "http://n1:4243"}),
+		dst.StoreContainer(context.Background(), "c1", "http://n1:4243"),
+		dst.StoreImage(context.Background(), "tsuru/python", "id0", "http://n1:4243"),
+		dst.StoreExec(context.Background(), "e1", "c1"),
+		dst.StoreNetwork(context.Background(), Network{Name: "net1", Driver: "bridge"}),
+		dst.StoreVolume(context.Background(), Volume{Name: "vol1", Node: "http://n2:4243", Driver: "local"}),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := CopyStorage(src, dst, CopyStorageOptions{Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CopyStorageResult{Nodes: 2, Containers: 2, Images: 2, Execs: 2, Networks: 1, Volumes: 1}
+	if *result != expected {
+		t.Errorf("CopyStorage: want %+v, got %+v", expected, *result)
+	}
+	assertSameStorage(t, src, dst)
+}
+
+func TestCopyStorageVerificationFailure(t *testing.T) {
+	src := populatedStorage(t)
+	dst := &MapStorage{}
+	err := dst.StoreContainer(context.Background(), "c3", "http://n3:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CopyStorage(src, dst, CopyStorageOptions{Resume: true})
+	if err == nil {
+		t.Fatal("CopyStorage: expected verification error, got <nil>")
+	}
+}
+
+func TestCopyStorageSourceError(t *testing.T) {
+	_, err := CopyStorage(failingStorage{}, &MapStorage{}, CopyStorageOptions{})
+	if err == nil {
+		t.Fatal("CopyStorage: expected non-nil error, got <nil>")
+	}
+}
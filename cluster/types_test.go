@@ -5,6 +5,7 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -28,69 +29,102 @@ func (l containerList) Swap(i, j int) {
 
 type failingStorage struct{}
 
-func (failingStorage) StoreContainer(container, host string) error {
+func (failingStorage) StoreContainer(ctx context.Context, container, host string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) RetrieveContainer(container string) (string, error) {
+func (failingStorage) RetrieveContainer(ctx context.Context, container string) (string, error) {
 	return "", errors.New("storage error")
 }
-func (failingStorage) RemoveContainer(container string) error {
+func (failingStorage) RemoveContainer(ctx context.Context, container string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) RetrieveContainers() ([]Container, error) {
+func (failingStorage) RetrieveContainers(ctx context.Context) ([]Container, error) {
 	return nil, errors.New("storage error")
 }
-func (failingStorage) StoreImage(repository, id, host string) error {
+func (failingStorage) StoreImage(ctx context.Context, repository, id, host string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) SetImageDigest(repository, digest string) error {
+func (failingStorage) SetImageDigest(ctx context.Context, repository, digest string) error {
 	return errors.New("digest error")
 }
-func (failingStorage) RetrieveImage(repository string) (Image, error) {
+func (failingStorage) RetrieveImage(ctx context.Context, repository string) (Image, error) {
 	return Image{}, errors.New("storage error")
 }
-func (failingStorage) RemoveImage(repository, id, host string) error {
+func (failingStorage) RemoveImage(ctx context.Context, repository, id, host string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) RetrieveImages() ([]Image, error) {
+func (failingStorage) RetrieveImages(ctx context.Context) ([]Image, error) {
 	return nil, errors.New("storage error")
 }
-func (failingStorage) StoreNode(node Node) error {
+func (failingStorage) StoreNode(ctx context.Context, node Node) error {
 	return errors.New("storage error")
 }
-func (failingStorage) RetrieveNodesByMetadata(metadata map[string]string) ([]Node, error) {
+func (failingStorage) RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]Node, error) {
 	return nil, errors.New("storage error")
 }
-func (failingStorage) RetrieveNodes() ([]Node, error) {
+func (failingStorage) RetrieveNodes(ctx context.Context) ([]Node, error) {
 	return nil, errors.New("storage error")
 }
-func (failingStorage) RetrieveNode(addr string) (Node, error) {
+func (failingStorage) RetrieveNode(ctx context.Context, addr string) (Node, error) {
 	return Node{}, errors.New("storage error")
 }
-func (failingStorage) UpdateNode(node Node) error {
+func (failingStorage) UpdateNode(ctx context.Context, node Node) error {
 	return errors.New("storage error")
 }
-func (failingStorage) RemoveNode(address string) error {
+func (failingStorage) RemoveNode(ctx context.Context, address string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) RemoveNodes(addresses []string) error {
+func (failingStorage) RemoveNodes(ctx context.Context, addresses []string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) LockNodeForHealing(address string, isFailure bool, timeout time.Duration) (bool, error) {
+func (failingStorage) LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error) {
 	return false, errors.New("storage error")
 }
-func (failingStorage) ExtendNodeLock(address string, timeout time.Duration) error {
+func (failingStorage) ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error {
 	return errors.New("storage error")
 }
-func (failingStorage) UnlockNode(address string) error {
+func (failingStorage) UnlockNode(ctx context.Context, address string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) StoreExec(execID, containerID string) error {
+func (failingStorage) StoreExec(ctx context.Context, execID, containerID string) error {
 	return errors.New("storage error")
 }
-func (failingStorage) RetrieveExec(execID string) (string, error) {
+func (failingStorage) RetrieveExec(ctx context.Context, execID string) (string, error) {
 	return "", errors.New("storage error")
 }
+func (failingStorage) RetrieveExecs(ctx context.Context) ([]Exec, error) {
+	return nil, errors.New("storage error")
+}
+func (failingStorage) StoreNetwork(ctx context.Context, network Network) error {
+	return errors.New("storage error")
+}
+func (failingStorage) RetrieveNetwork(ctx context.Context, name string) (Network, error) {
+	return Network{}, errors.New("storage error")
+}
+func (failingStorage) RetrieveNetworks(ctx context.Context) ([]Network, error) {
+	return nil, errors.New("storage error")
+}
+func (failingStorage) RemoveNetwork(ctx context.Context, name string) error {
+	return errors.New("storage error")
+}
+func (failingStorage) AddNetworkNode(ctx context.Context, name, id, host string) error {
+	return errors.New("storage error")
+}
+func (failingStorage) RemoveNetworkNode(ctx context.Context, name, host string) error {
+	return errors.New("storage error")
+}
+func (failingStorage) StoreVolume(ctx context.Context, volume Volume) error {
+	return errors.New("storage error")
+}
+func (failingStorage) RetrieveVolume(ctx context.Context, name string) (Volume, error) {
+	return Volume{}, errors.New("storage error")
+}
+func (failingStorage) RetrieveVolumes(ctx context.Context) ([]Volume, error) {
+	return nil, errors.New("storage error")
+}
+func (failingStorage) RemoveVolume(ctx context.Context, name string) error {
+	return errors.New("storage error")
+}
 
 type fakeScheduler struct{}
 
@@ -0,0 +1,170 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/docker-cluster/log"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+// Volume is a named volume created in the cluster. Named volumes are local to
+// the node where they're created, so containers that mount them are always
+// created in that node.
+type Volume struct {
+	Name    string `bson:"_id"`
+	Node    string
+	Driver  string
+	Labels  map[string]string
+	Options map[string]string
+}
+
+// CreateVolume creates a named volume in one of the given nodes, or in one of
+// the schedulable nodes if none is given, and stores the node that holds it.
+func (c *Cluster) CreateVolume(opts docker.CreateVolumeOptions, nodes ...string) (*Volume, error) {
+	ctx := optsContext(opts.Context)
+	if opts.Name != "" {
+		_, err := c.storage().RetrieveVolume(ctx, opts.Name)
+		if err == nil {
+			return nil, storage.ErrDuplicatedVolume
+		}
+		if err != storage.ErrNoSuchVolume {
+			return nil, err
+		}
+	}
+	if len(nodes) == 0 {
+		enabled, err := c.SchedulableNodesWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range enabled {
+			nodes = append(nodes, n.Address)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("No nodes available")
+	}
+	n, err := c.getNodeByAddr(ctx, nodes[rand.Intn(len(nodes))])
+	if err != nil {
+		return nil, err
+	}
+	created, err := n.CreateVolume(opts)
+	if err != nil {
+		return nil, wrapError(n, err)
+	}
+	volume := Volume{
+		Name:    created.Name,
+		Node:    n.addr,
+		Driver:  created.Driver,
+		Labels:  opts.Labels,
+		Options: opts.DriverOpts,
+	}
+	err = c.storage().StoreVolume(ctx, volume)
+	if err != nil {
+		if rmErr := n.RemoveVolume(volume.Name); rmErr != nil {
+			log.Error("Unable to remove volume after storage failure", log.Fields{"volume": volume.Name, "node": n.addr, "error": rmErr})
+		}
+		return nil, err
+	}
+	return &volume, nil
+}
+
+// RemoveVolume removes the named volume from its node and from the storage.
+func (c *Cluster) RemoveVolume(name string) error {
+	return c.RemoveVolumeWithContext(context.Background(), name)
+}
+
+// RemoveVolumeWithContext is like RemoveVolume, but using ctx to cancel the operation.
+func (c *Cluster) RemoveVolumeWithContext(ctx context.Context, name string) error {
+	volume, err := c.storage().RetrieveVolume(ctx, name)
+	if err != nil {
+		return err
+	}
+	n, err := c.getNodeByAddr(ctx, volume.Node)
+	if err != nil {
+		return err
+	}
+	err = n.RemoveVolume(name)
+	if err != nil && err != docker.ErrNoSuchVolume {
+		return wrapError(n, err)
+	}
+	return c.storage().RemoveVolume(ctx, name)
+}
+
+// ListVolumes returns the named volumes of the cluster.
+func (c *Cluster) ListVolumes() ([]Volume, error) {
+	return c.ListVolumesWithContext(context.Background())
+}
+
+// ListVolumesWithContext is like ListVolumes, but using ctx to cancel the operation.
+func (c *Cluster) ListVolumesWithContext(ctx context.Context) ([]Volume, error) {
+	return c.storage().RetrieveVolumes(ctx)
+}
+
+// InspectVolume returns the details of the named volume, as reported by the
+// node that holds it.
+func (c *Cluster) InspectVolume(name string) (*docker.Volume, error) {
+	return c.InspectVolumeWithContext(context.Background(), name)
+}
+
+// InspectVolumeWithContext is like InspectVolume, but using ctx to cancel the operation.
+func (c *Cluster) InspectVolumeWithContext(ctx context.Context, name string) (*docker.Volume, error) {
+	volume, err := c.storage().RetrieveVolume(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	n, err := c.getNodeByAddr(ctx, volume.Node)
+	if err != nil {
+		return nil, err
+	}
+	info, err := n.InspectVolume(name)
+	return info, wrapError(n, err)
+}
+
+// volumeNode returns the node that holds the named volumes of the cluster
+// mounted by the container, or an empty string if it doesn't mount any.
+// Volumes unknown to the cluster are left for docker to handle.
+func (c *Cluster) volumeNode(ctx context.Context, opts docker.CreateContainerOptions) (string, error) {
+	var addr, pinnedBy string
+	for _, name := range containerVolumes(opts) {
+		volume, err := c.storage().RetrieveVolume(ctx, name)
+		if err == storage.ErrNoSuchVolume {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if addr != "" && addr != volume.Node {
+			return "", fmt.Errorf("Volumes %q and %q are in different nodes", pinnedBy, name)
+		}
+		addr, pinnedBy = volume.Node, name
+	}
+	return addr, nil
+}
+
+func containerVolumes(opts docker.CreateContainerOptions) []string {
+	if opts.HostConfig == nil {
+		return nil
+	}
+	var names []string
+	for _, bind := range opts.HostConfig.Binds {
+		source := strings.SplitN(bind, ":", 2)[0]
+		if strings.Contains(bind, ":") && !strings.HasPrefix(source, "/") && !containsString(names, source) {
+			names = append(names, source)
+		}
+	}
+	for _, m := range opts.HostConfig.Mounts {
+		if m.Type == "volume" && m.Source != "" && !containsString(names, m.Source) {
+			names = append(names, m.Source)
+		}
+	}
+	return names
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+func TestCreateVolume(t *testing.T) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	volume, err := c.CreateVolume(docker.CreateVolumeOptions{
+		Name:   "vol1",
+		Labels: map[string]string{"app": "myapp"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Volume{Name: "vol1", Node: server.URL(), Driver: "local", Labels: map[string]string{"app": "myapp"}}
+	if !reflect.DeepEqual(*volume, expected) {
+		t.Errorf("CreateVolume: wrong volume. Want %#v. Got %#v", expected, *volume)
+	}
+	volumes, err := c.ListVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(volumes, []Volume{expected}) {
+		t.Errorf("ListVolumes: wrong volumes. Want %#v. Got %#v", []Volume{expected}, volumes)
+	}
+	info, err := c.InspectVolume("vol1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "vol1" {
+		t.Errorf("InspectVolume: wrong volume %#v", info)
+	}
+	_, err = c.CreateVolume(docker.CreateVolumeOptions{Name: "vol1"})
+	if err != storage.ErrDuplicatedVolume {
+		t.Errorf("CreateVolume: expected ErrDuplicatedVolume, got %v", err)
+	}
+}
+
+func TestCreateVolumeNoNodes(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateVolume(docker.CreateVolumeOptions{Name: "vol1"})
+	if err == nil {
+		t.Fatal("CreateVolume: expected non-nil error, got <nil>")
+	}
+}
+
+func TestRemoveVolume(t *testing.T) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	c, err := New(nil, &MapStorage{}, "", Node{Address: server.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateVolume(docker.CreateVolumeOptions{Name: "vol1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.RemoveVolume("vol1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.storage().RetrieveVolume(context.Background(), "vol1")
+	if err != storage.ErrNoSuchVolume {
+		t.Errorf("RemoveVolume: expected volume to be removed from storage, got error %v", err)
+	}
+	client, _ := docker.NewClient(server.URL())
+	_, err = client.InspectVolume("vol1")
+	if err != docker.ErrNoSuchVolume {
+		t.Errorf("RemoveVolume: expected volume to be removed from the node, got error %v", err)
+	}
+}
+
+func TestCreateContainerPinnedToVolumeNode(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL()},
+		Node{Address: server2.URL()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateVolume(docker.CreateVolumeOptions{Name: "data"}, server2.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := docker.CreateContainerOptions{
+		Config:     &docker.Config{Image: "myimg"},
+		HostConfig: &docker.HostConfig{Binds: []string{"data:/var/lib/data"}},
+	}
+	for i := 0; i < 4; i++ {
+		addr, _, err := c.CreateContainer(opts, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != server2.URL() {
+			t.Errorf("CreateContainer: expected container in the volume node %s, got %s", server2.URL(), addr)
+		}
+	}
+	_, _, err = c.CreateContainer(opts, 0, server1.URL())
+	if err == nil {
+		t.Error("CreateContainer: expected error when the volume node is not one of the given nodes")
+	}
+}
+
+func TestCreateContainerVolumesInDifferentNodes(t *testing.T) {
+	c, err := New(nil, &MapStorage{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.storage().StoreVolume(context.Background(), Volume{Name: "vol1", Node: "http://n1:4243"})
+	c.storage().StoreVolume(context.Background(), Volume{Name: "vol2", Node: "http://n2:4243"})
+	opts := docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "myimg"},
+		HostConfig: &docker.HostConfig{
+			Binds:  []string{"vol1:/data1"},
+			Mounts: []docker.HostMount{{Type: "volume", Source: "vol2", Target: "/data2"}},
+		},
+	}
+	_, _, err = c.CreateContainer(opts, 0)
+	if err == nil {
+		t.Fatal("CreateContainer: expected non-nil error, got <nil>")
+	}
+}
+
+func TestContainerVolumes(t *testing.T) {
+	opts := docker.CreateContainerOptions{
+		HostConfig: &docker.HostConfig{
+			Binds: []string{"/host/path:/data", "vol1:/data1:ro", "vol1:/data2", "/anonymous"},
+			Mounts: []docker.HostMount{
+				{Type: "bind", Source: "/host", Target: "/host"},
+				{Type: "volume", Source: "vol2", Target: "/data3"},
+				{Type: "volume", Target: "/anonymous2"},
+			},
+		},
+	}
+	expected := []string{"vol1", "vol2"}
+	if names := containerVolumes(opts); !reflect.DeepEqual(names, expected) {
+		t.Errorf("containerVolumes: want %#v, got %#v", expected, names)
+	}
+	if names := containerVolumes(docker.CreateContainerOptions{}); names != nil {
+		t.Errorf("containerVolumes: expected no volumes, got %#v", names)
+	}
+}
+
+func TestMigrateContainerWithVolume(t *testing.T) {
+	server1, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server1.Stop()
+	server2, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server2.Stop()
+	c, err := New(nil, &MapStorage{}, "",
+		Node{Address: server1.URL()},
+		Node{Address: server2.URL()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateVolume(docker.CreateVolumeOptions{Name: "data"}, server1.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, container, err := c.CreateContainer(docker.CreateContainerOptions{
+		Config:     &docker.Config{Image: "myimg"},
+		HostConfig: &docker.HostConfig{Binds: []string{"data:/data"}},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.MigrateContainer(container.ID, server2.URL())
+	if err != errMigrateVolume {
+		t.Errorf("MigrateContainer: expected errMigrateVolume, got %v", err)
+	}
+}
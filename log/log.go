@@ -12,7 +12,7 @@ import (
 )
 
 var (
-	logger *log.Logger
+	logger Logger
 	lock   sync.Mutex
 	debug  bool
 )
@@ -21,29 +21,71 @@ func init() {
 	SetLogger(nil)
 }
 
+// SetLogger makes the package log to the given standard library logger, in
+// the format of NewStdLogger. A nil logger writes to the standard error.
 func SetLogger(l *log.Logger) {
-	lock.Lock()
-	defer lock.Unlock()
 	if l == nil {
 		l = log.New(os.Stderr, "", log.LstdFlags)
 	}
+	SetStructuredLogger(NewStdLogger(l))
+}
+
+// SetStructuredLogger makes the package log to the given Logger. A nil
+// logger restores the default one.
+func SetStructuredLogger(l Logger) {
+	if l == nil {
+		SetLogger(nil)
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
 	logger = l
 }
 
 func SetDebug(d bool) {
+	lock.Lock()
+	defer lock.Unlock()
 	debug = d
 }
 
-func Debugf(format string, args ...interface{}) {
+func logWith(level Level, msg string, fields Fields) {
 	lock.Lock()
 	defer lock.Unlock()
-	if debug {
-		logger.Printf(fmt.Sprintf("[docker-cluster][debug] %s", format), args...)
+	if level == LevelDebug && !debug {
+		return
 	}
+	logger.Log(level, msg, fields)
 }
 
-func Errorf(format string, args ...interface{}) {
+// Debug logs a message with the given fields, if debug is enabled.
+func Debug(msg string, fields Fields) {
+	logWith(LevelDebug, msg, fields)
+}
+
+// Info logs a message with the given fields.
+func Info(msg string, fields Fields) {
+	logWith(LevelInfo, msg, fields)
+}
+
+// Warn logs a message with the given fields.
+func Warn(msg string, fields Fields) {
+	logWith(LevelWarn, msg, fields)
+}
+
+// Error logs a message with the given fields.
+func Error(msg string, fields Fields) {
+	logWith(LevelError, msg, fields)
+}
+
+func Debugf(format string, args ...interface{}) {
 	lock.Lock()
-	defer lock.Unlock()
-	logger.Printf(fmt.Sprintf("[docker-cluster][error] %s", format), args...)
+	enabled := debug
+	lock.Unlock()
+	if enabled {
+		logWith(LevelDebug, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func Errorf(format string, args ...interface{}) {
+	logWith(LevelError, fmt.Sprintf(format, args...), nil)
 }
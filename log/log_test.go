@@ -6,7 +6,10 @@ package log
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"log"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -62,3 +65,78 @@ func TestErrorf(t *testing.T) {
 		t.Fatalf("Expected log to be %q, got: %q", expected, buf.String())
 	}
 }
+
+func TestErrorWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(log.New(&buf, "", 0))
+	Error("error creating container", Fields{"node": "http://n1:2375", "error": errors.New("no space left"), "attempt": 2})
+	expected := `[docker-cluster][error] error creating container attempt=2 error="no space left" node=http://n1:2375` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected log to be %q, got: %q", expected, buf.String())
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(log.New(&buf, "", 0))
+	SetDebug(false)
+	Debug("debug", nil)
+	Info("info", nil)
+	Warn("warn", Fields{"node": "n1"})
+	expected := "[docker-cluster][info] info\n[docker-cluster][warn] warn node=n1\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected log to be %q, got: %q", expected, buf.String())
+	}
+}
+
+type recordingLogger struct {
+	levels []Level
+	msgs   []string
+}
+
+func (l *recordingLogger) Log(level Level, msg string, fields Fields) {
+	l.levels = append(l.levels, level)
+	l.msgs = append(l.msgs, msg)
+}
+
+func TestSetStructuredLogger(t *testing.T) {
+	l := &recordingLogger{}
+	SetStructuredLogger(l)
+	defer SetStructuredLogger(nil)
+	SetDebug(true)
+	defer SetDebug(false)
+	Debugf("%s - %d", "foo", 1)
+	Info("bar", nil)
+	if !reflect.DeepEqual(l.levels, []Level{LevelDebug, LevelInfo}) {
+		t.Errorf("Expected debug and info levels, got %v", l.levels)
+	}
+	if !reflect.DeepEqual(l.msgs, []string{"foo - 1", "bar"}) {
+		t.Errorf("Expected formatted messages, got %q", l.msgs)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetStructuredLogger(NewJSONLogger(&buf))
+	defer SetStructuredLogger(nil)
+	Error("error pulling image", Fields{"image": "tsuru/python", "node": "http://n1:2375", "error": errors.New("timeout")})
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Errorf("Expected time in entry, got %#v", entry)
+	}
+	delete(entry, "time")
+	expected := map[string]interface{}{
+		"level": "error",
+		"msg":   "error pulling image",
+		"image": "tsuru/python",
+		"node":  "http://n1:2375",
+		"error": "timeout",
+	}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Errorf("Expected entry %#v, got %#v", expected, entry)
+	}
+}
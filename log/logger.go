@@ -0,0 +1,126 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Fields are the key/value pairs attached to a log entry, like the node or
+// the container involved in the operation.
+type Fields map[string]interface{}
+
+// Logger is the interface of structured loggers. Log is called with the
+// package lock held, so implementations don't need to be safe for concurrent
+// use, but shouldn't call functions of this package.
+type Logger interface {
+	Log(level Level, msg string, fields Fields)
+}
+
+type stdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger returns a Logger that writes to the given standard library
+// logger, one line per entry, in the format:
+//
+//	[docker-cluster][<level>] <msg> key1=value1 key2=value2
+//
+// with the fields sorted by key.
+func NewStdLogger(l *log.Logger) Logger {
+	return &stdLogger{logger: l}
+}
+
+func (l *stdLogger) Log(level Level, msg string, fields Fields) {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "[docker-cluster][%s] %s", level, msg)
+	for _, k := range sortedKeys(fields) {
+		fmt.Fprintf(&buf, " %s=%s", k, formatValue(fields[k]))
+	}
+	l.logger.Print(buf.String())
+}
+
+func formatValue(v interface{}) string {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	str := fmt.Sprint(v)
+	if str == "" || strings.ContainsAny(str, " \t\n\"=") {
+		return fmt.Sprintf("%q", str)
+	}
+	return str
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type jsonLogger struct {
+	w io.Writer
+}
+
+// NewJSONLogger returns a Logger that writes one JSON object per entry to
+// the given writer, with the keys "time", "level" and "msg" plus the
+// fields. Errors in fields are written as their messages.
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+
+func (l *jsonLogger) Log(level Level, msg string, fields Fields) {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{
+			"time":  entry["time"],
+			"level": LevelError.String(),
+			"msg":   fmt.Sprintf("unable to encode log entry %q: %s", msg, err),
+		})
+	}
+	l.w.Write(append(data, '\n'))
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics provides implementations of cluster.Metrics.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tsuru/docker-cluster/cluster"
+)
+
+var _ cluster.Metrics = &PrometheusCollector{}
+var _ prometheus.Collector = &PrometheusCollector{}
+
+// PrometheusCollector is a cluster.Metrics that is also a
+// prometheus.Collector, so it can be set as the Metrics of a cluster and
+// registered in a Prometheus registry:
+//
+//	collector := metrics.NewPrometheusCollector("docker_cluster")
+//	prometheus.MustRegister(collector)
+//	c.Metrics = collector
+type PrometheusCollector struct {
+	createContainerRetries *prometheus.CounterVec
+	pullImageDuration      *prometheus.HistogramVec
+	nodeFailures           *prometheus.CounterVec
+	nodeSuccesses          *prometheus.CounterVec
+	healerLockContention   *prometheus.CounterVec
+	runOnNodesDuration     prometheus.Histogram
+	runOnNodesFanOut       prometheus.Histogram
+}
+
+// NewPrometheusCollector creates a PrometheusCollector, prefixing the name
+// of all metrics with the given namespace.
+func NewPrometheusCollector(namespace string) *PrometheusCollector {
+	return &PrometheusCollector{
+		createContainerRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "create_container_retries_total",
+			Help:      "The number of times creating a container failed in a node and was retried.",
+		}, []string{"node"}),
+		pullImageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "pull_image_duration_seconds",
+			Help:      "The time spent pulling images in each node.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+		}, []string{"node", "result"}),
+		nodeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_failures_total",
+			Help:      "The number of errors registered in each node, by whether the node was disabled.",
+		}, []string{"node", "disabled"}),
+		nodeSuccesses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_successes_total",
+			Help:      "The number of successes registered in each node, by whether the node was healed.",
+		}, []string{"node", "healed"}),
+		healerLockContention: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "healer_lock_contention_total",
+			Help:      "The number of times the healing lock of a node was already taken.",
+		}, []string{"node"}),
+		runOnNodesDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "run_on_nodes_duration_seconds",
+			Help:      "The time spent running operations in a set of nodes.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		runOnNodesFanOut: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "run_on_nodes_fan_out",
+			Help:      "The number of nodes each operation ran on.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+		}),
+	}
+}
+
+func (p *PrometheusCollector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		p.createContainerRetries,
+		p.pullImageDuration,
+		p.nodeFailures,
+		p.nodeSuccesses,
+		p.healerLockContention,
+		p.runOnNodesDuration,
+		p.runOnNodesFanOut,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (p *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (p *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range p.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (p *PrometheusCollector) CreateContainerRetry(node string) {
+	p.createContainerRetries.WithLabelValues(node).Inc()
+}
+
+func (p *PrometheusCollector) PullImageDuration(node string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	p.pullImageDuration.WithLabelValues(node, result).Observe(duration.Seconds())
+}
+
+func (p *PrometheusCollector) NodeFailure(node string, disabled bool) {
+	p.nodeFailures.WithLabelValues(node, strconv.FormatBool(disabled)).Inc()
+}
+
+func (p *PrometheusCollector) NodeSuccess(node string, healed bool) {
+	p.nodeSuccesses.WithLabelValues(node, strconv.FormatBool(healed)).Inc()
+}
+
+func (p *PrometheusCollector) HealerLockContention(node string) {
+	p.healerLockContention.WithLabelValues(node).Inc()
+}
+
+func (p *PrometheusCollector) RunOnNodesDuration(nodes int, duration time.Duration) {
+	p.runOnNodesDuration.Observe(duration.Seconds())
+	p.runOnNodesFanOut.Observe(float64(nodes))
+}
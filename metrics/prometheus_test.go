@@ -0,0 +1,59 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPrometheusCollector(t *testing.T) {
+	collector := NewPrometheusCollector("docker_cluster")
+	registry := prometheus.NewRegistry()
+	err := registry.Register(collector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.CreateContainerRetry("http://n1:2375")
+	collector.CreateContainerRetry("http://n1:2375")
+	collector.PullImageDuration("http://n1:2375", time.Second, nil)
+	collector.PullImageDuration("http://n1:2375", time.Second, errors.New("pull failed"))
+	collector.NodeFailure("http://n1:2375", true)
+	collector.NodeSuccess("http://n2:2375", false)
+	collector.HealerLockContention("http://n1:2375")
+	collector.RunOnNodesDuration(2, time.Millisecond)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, f := range families {
+		counts[f.GetName()] = len(f.GetMetric())
+	}
+	expected := map[string]int{
+		"docker_cluster_create_container_retries_total": 1,
+		"docker_cluster_pull_image_duration_seconds":    2,
+		"docker_cluster_node_failures_total":            1,
+		"docker_cluster_node_successes_total":           1,
+		"docker_cluster_healer_lock_contention_total":   1,
+		"docker_cluster_run_on_nodes_duration_seconds":  1,
+		"docker_cluster_run_on_nodes_fan_out":           1,
+	}
+	for name, n := range expected {
+		if counts[name] != n {
+			t.Errorf("Gather: expected %d series of %q, got %d", n, name, counts[name])
+		}
+	}
+	for _, f := range families {
+		if f.GetName() == "docker_cluster_create_container_retries_total" {
+			if v := f.GetMetric()[0].GetCounter().GetValue(); v != 2 {
+				t.Errorf("Gather: expected 2 retries, got %v", v)
+			}
+		}
+	}
+}
@@ -0,0 +1,540 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bolt provides a cluster.Storage backed by a local bbolt file, for
+// installs with a single controller that need persistence without an
+// external database.
+//
+// Each entity is stored in its own bucket, with JSON values. Every write
+// runs in a bbolt read-write transaction, which are serialized, so node
+// healing locks are atomic. The file is locked while it's open, so only one
+// process can use it at a time.
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/docker-cluster/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	containersBucket     = []byte("containers")
+	execsBucket          = []byte("execs")
+	containerExecsBucket = []byte("container-execs")
+	imagesBucket         = []byte("images")
+	nodesBucket          = []byte("nodes")
+	networksBucket       = []byte("networks")
+	volumesBucket        = []byte("volumes")
+)
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// Bolt returns a storage backed by the bbolt file in the given path, creating
+// it if needed. The returned storage implements io.Closer, which closes the
+// file.
+func Bolt(path string) (cluster.Storage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{containersBucket, execsBucket, containerExecsBucket, imagesBucket, nodesBucket, networksBucket, volumesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStorage{db: db}, nil
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltStorage) StoreContainer(ctx context.Context, container, host string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(containersBucket).Put([]byte(container), []byte(host))
+	})
+}
+
+func (s *boltStorage) RetrieveContainer(ctx context.Context, container string) (string, error) {
+	var host string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(containersBucket).Get([]byte(container))
+		if value == nil {
+			return storage.ErrNoSuchContainer
+		}
+		host = string(value)
+		return nil
+	})
+	return host, err
+}
+
+func (s *boltStorage) RemoveContainer(ctx context.Context, container string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(containersBucket).Delete([]byte(container))
+		if err != nil {
+			return err
+		}
+		containerExecs := tx.Bucket(containerExecsBucket)
+		execs := containerExecs.Bucket([]byte(container))
+		if execs == nil {
+			return nil
+		}
+		err = execs.ForEach(func(execID, _ []byte) error {
+			return tx.Bucket(execsBucket).Delete(execID)
+		})
+		if err != nil {
+			return err
+		}
+		return containerExecs.DeleteBucket([]byte(container))
+	})
+}
+
+func (s *boltStorage) RetrieveContainers(ctx context.Context) ([]cluster.Container, error) {
+	var containers []cluster.Container
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(containersBucket).ForEach(func(id, host []byte) error {
+			containers = append(containers, cluster.Container{Id: string(id), Host: string(host)})
+			return nil
+		})
+	})
+	return containers, err
+}
+
+func (s *boltStorage) StoreImage(ctx context.Context, repo, id, host string) error {
+	return s.updateImage(repo, true, func(image *cluster.Image) {
+		for _, entry := range image.History {
+			if entry.ImageId == id && entry.Node == host {
+				image.LastNode, image.LastId = host, id
+				return
+			}
+		}
+		image.History = append(image.History, cluster.ImageHistory{Node: host, ImageId: id})
+		image.LastNode, image.LastId = host, id
+	})
+}
+
+func (s *boltStorage) SetImageDigest(ctx context.Context, repo, digest string) error {
+	return s.updateImage(repo, true, func(image *cluster.Image) {
+		image.LastDigest = digest
+	})
+}
+
+func (s *boltStorage) RetrieveImage(ctx context.Context, repo string) (cluster.Image, error) {
+	var image cluster.Image
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(imagesBucket), repo, &image, storage.ErrNoSuchImage)
+	})
+	if err != nil {
+		return cluster.Image{}, err
+	}
+	if len(image.History) == 0 {
+		return cluster.Image{}, storage.ErrNoSuchImage
+	}
+	return image, nil
+}
+
+func (s *boltStorage) RemoveImage(ctx context.Context, repo, id, host string) error {
+	return s.updateImage(repo, false, func(image *cluster.Image) {
+		history := []cluster.ImageHistory{}
+		for _, entry := range image.History {
+			if entry.ImageId != id || entry.Node != host {
+				history = append(history, entry)
+			}
+		}
+		image.History = history
+	})
+}
+
+func (s *boltStorage) updateImage(repo string, create bool, fn func(image *cluster.Image)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imagesBucket)
+		image := cluster.Image{Repository: repo, History: []cluster.ImageHistory{}}
+		err := get(bucket, repo, &image, storage.ErrNoSuchImage)
+		if err != nil && (err != storage.ErrNoSuchImage || !create) {
+			return err
+		}
+		fn(&image)
+		return put(bucket, repo, image)
+	})
+}
+
+func (s *boltStorage) RetrieveImages(ctx context.Context) ([]cluster.Image, error) {
+	var images []cluster.Image
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(_, data []byte) error {
+			var image cluster.Image
+			if err := json.Unmarshal(data, &image); err != nil {
+				return err
+			}
+			images = append(images, image)
+			return nil
+		})
+	})
+	return images, err
+}
+
+// dbNode is the stored representation of a node, as the JSON encoding of
+// cluster.Node is meant for API responses and omits most fields. Seq keeps
+// the order in which nodes were stored, as keys are sorted by address.
+type dbNode struct {
+	Seq            uint64
+	Address        string
+	Healing        cluster.HealingData
+	Metadata       map[string]string
+	CreationStatus string
+	CaCert         []byte
+	ClientCert     []byte
+	ClientKey      []byte
+}
+
+func (n *dbNode) node() cluster.Node {
+	metadata := n.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return cluster.Node{
+		Address:        n.Address,
+		Healing:        n.Healing,
+		Metadata:       metadata,
+		CreationStatus: n.CreationStatus,
+		CaCert:         n.CaCert,
+		ClientCert:     n.ClientCert,
+		ClientKey:      n.ClientKey,
+	}
+}
+
+func (n *dbNode) set(node cluster.Node) {
+	n.Address = node.Address
+	n.Healing = node.Healing
+	n.Metadata = node.Metadata
+	n.CreationStatus = node.CreationStatus
+	n.CaCert = node.CaCert
+	n.ClientCert = node.ClientCert
+	n.ClientKey = node.ClientKey
+}
+
+func (s *boltStorage) StoreNode(ctx context.Context, node cluster.Node) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(nodesBucket)
+		if bucket.Get([]byte(node.Address)) != nil {
+			return storage.ErrDuplicatedNodeAddress
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		n := dbNode{Seq: seq}
+		n.set(node)
+		return put(bucket, node.Address, n)
+	})
+}
+
+func (s *boltStorage) RetrieveNodes(ctx context.Context) ([]cluster.Node, error) {
+	return s.retrieveNodes(nil)
+}
+
+func (s *boltStorage) RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]cluster.Node, error) {
+	return s.retrieveNodes(metadata)
+}
+
+func (s *boltStorage) retrieveNodes(metadata map[string]string) ([]cluster.Node, error) {
+	var dbNodes []dbNode
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(nodesBucket).ForEach(func(_, data []byte) error {
+			var n dbNode
+			if err := json.Unmarshal(data, &n); err != nil {
+				return err
+			}
+			for key, value := range metadata {
+				if n.Metadata[key] != value {
+					return nil
+				}
+			}
+			dbNodes = append(dbNodes, n)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dbNodes, func(i, j int) bool { return dbNodes[i].Seq < dbNodes[j].Seq })
+	nodes := make([]cluster.Node, len(dbNodes))
+	for i := range dbNodes {
+		nodes[i] = dbNodes[i].node()
+	}
+	return nodes, nil
+}
+
+func (s *boltStorage) RetrieveNode(ctx context.Context, address string) (cluster.Node, error) {
+	var n dbNode
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(nodesBucket), address, &n, storage.ErrNoSuchNode)
+	})
+	if err != nil {
+		return cluster.Node{}, err
+	}
+	return n.node(), nil
+}
+
+func (s *boltStorage) UpdateNode(ctx context.Context, node cluster.Node) error {
+	return s.updateNode(node.Address, func(n *dbNode) bool {
+		n.set(node)
+		return true
+	})
+}
+
+// updateNode applies fn to the stored node in a transaction. The node is
+// only written if fn returns true.
+func (s *boltStorage) updateNode(address string, fn func(n *dbNode) bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(nodesBucket)
+		var n dbNode
+		err := get(bucket, address, &n, storage.ErrNoSuchNode)
+		if err != nil {
+			return err
+		}
+		if !fn(&n) {
+			return nil
+		}
+		return put(bucket, address, n)
+	})
+}
+
+func (s *boltStorage) RemoveNode(ctx context.Context, address string) error {
+	return s.RemoveNodes(ctx, []string{address})
+}
+
+func (s *boltStorage) RemoveNodes(ctx context.Context, addresses []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(nodesBucket)
+		removed := 0
+		for _, address := range addresses {
+			if bucket.Get([]byte(address)) == nil {
+				continue
+			}
+			if err := bucket.Delete([]byte(address)); err != nil {
+				return err
+			}
+			removed++
+		}
+		if removed == 0 {
+			return storage.ErrNoSuchNode
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error) {
+	var locked bool
+	err := s.updateNode(address, func(n *dbNode) bool {
+		now := time.Now().UTC()
+		locked = !n.Healing.LockedUntil.After(now)
+		if locked {
+			n.Healing = cluster.HealingData{LockedUntil: now.Add(timeout), IsFailure: isFailure}
+		}
+		return locked
+	})
+	return locked, err
+}
+
+func (s *boltStorage) ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error {
+	return s.updateNode(address, func(n *dbNode) bool {
+		n.Healing.LockedUntil = time.Now().UTC().Add(timeout)
+		return true
+	})
+}
+
+func (s *boltStorage) UnlockNode(ctx context.Context, address string) error {
+	return s.updateNode(address, func(n *dbNode) bool {
+		n.Healing = cluster.HealingData{}
+		return true
+	})
+}
+
+func (s *boltStorage) StoreExec(ctx context.Context, execID, containerID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(execsBucket).Put([]byte(execID), []byte(containerID))
+		if err != nil {
+			return err
+		}
+		execs, err := tx.Bucket(containerExecsBucket).CreateBucketIfNotExists([]byte(containerID))
+		if err != nil {
+			return err
+		}
+		return execs.Put([]byte(execID), nil)
+	})
+}
+
+func (s *boltStorage) RetrieveExec(ctx context.Context, execID string) (string, error) {
+	var containerID string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(execsBucket).Get([]byte(execID))
+		if value == nil {
+			return storage.ErrNoSuchExec
+		}
+		containerID = string(value)
+		return nil
+	})
+	return containerID, err
+}
+
+func (s *boltStorage) RetrieveExecs(ctx context.Context) ([]cluster.Exec, error) {
+	var execs []cluster.Exec
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(execsBucket).ForEach(func(id, containerID []byte) error {
+			execs = append(execs, cluster.Exec{Id: string(id), Container: string(containerID)})
+			return nil
+		})
+	})
+	return execs, err
+}
+
+func (s *boltStorage) StoreNetwork(ctx context.Context, network cluster.Network) error {
+	return s.storeNew(networksBucket, network.Name, network, storage.ErrDuplicatedNetwork)
+}
+
+func (s *boltStorage) RetrieveNetwork(ctx context.Context, name string) (cluster.Network, error) {
+	var network cluster.Network
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(networksBucket), name, &network, storage.ErrNoSuchNetwork)
+	})
+	return network, err
+}
+
+func (s *boltStorage) RetrieveNetworks(ctx context.Context) ([]cluster.Network, error) {
+	networks := []cluster.Network{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(networksBucket).ForEach(func(_, data []byte) error {
+			var network cluster.Network
+			if err := json.Unmarshal(data, &network); err != nil {
+				return err
+			}
+			networks = append(networks, network)
+			return nil
+		})
+	})
+	return networks, err
+}
+
+func (s *boltStorage) RemoveNetwork(ctx context.Context, name string) error {
+	return s.remove(networksBucket, name, storage.ErrNoSuchNetwork)
+}
+
+func (s *boltStorage) AddNetworkNode(ctx context.Context, name, id, host string) error {
+	return s.updateNetwork(name, func(network *cluster.Network) {
+		for i := range network.Nodes {
+			if network.Nodes[i].Node == host {
+				network.Nodes[i].ID = id
+				return
+			}
+		}
+		network.Nodes = append(network.Nodes, cluster.NetworkNode{Node: host, ID: id})
+	})
+}
+
+func (s *boltStorage) RemoveNetworkNode(ctx context.Context, name, host string) error {
+	return s.updateNetwork(name, func(network *cluster.Network) {
+		nodes := []cluster.NetworkNode{}
+		for _, nn := range network.Nodes {
+			if nn.Node != host {
+				nodes = append(nodes, nn)
+			}
+		}
+		network.Nodes = nodes
+	})
+}
+
+func (s *boltStorage) updateNetwork(name string, fn func(network *cluster.Network)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(networksBucket)
+		var network cluster.Network
+		err := get(bucket, name, &network, storage.ErrNoSuchNetwork)
+		if err != nil {
+			return err
+		}
+		fn(&network)
+		return put(bucket, name, network)
+	})
+}
+
+func (s *boltStorage) StoreVolume(ctx context.Context, volume cluster.Volume) error {
+	return s.storeNew(volumesBucket, volume.Name, volume, storage.ErrDuplicatedVolume)
+}
+
+func (s *boltStorage) RetrieveVolume(ctx context.Context, name string) (cluster.Volume, error) {
+	var volume cluster.Volume
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(volumesBucket), name, &volume, storage.ErrNoSuchVolume)
+	})
+	return volume, err
+}
+
+func (s *boltStorage) RetrieveVolumes(ctx context.Context) ([]cluster.Volume, error) {
+	volumes := []cluster.Volume{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(volumesBucket).ForEach(func(_, data []byte) error {
+			var volume cluster.Volume
+			if err := json.Unmarshal(data, &volume); err != nil {
+				return err
+			}
+			volumes = append(volumes, volume)
+			return nil
+		})
+	})
+	return volumes, err
+}
+
+func (s *boltStorage) RemoveVolume(ctx context.Context, name string) error {
+	return s.remove(volumesBucket, name, storage.ErrNoSuchVolume)
+}
+
+func (s *boltStorage) storeNew(bucketName []byte, key string, value interface{}, dupErr error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket.Get([]byte(key)) != nil {
+			return dupErr
+		}
+		return put(bucket, key, value)
+	})
+}
+
+func (s *boltStorage) remove(bucketName []byte, key string, notFound error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket.Get([]byte(key)) == nil {
+			return notFound
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+func get(bucket *bolt.Bucket, key string, value interface{}, notFound error) error {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return notFound
+	}
+	return json.Unmarshal(data, value)
+}
+
+func put(bucket *bolt.Bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
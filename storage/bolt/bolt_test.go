@@ -0,0 +1,79 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bolt
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tsuru/docker-cluster/cluster"
+	storageTesting "github.com/tsuru/docker-cluster/storage/testing"
+)
+
+func TestBoltStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-cluster-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stor, err := Bolt(filepath.Join(dir, "cluster.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stor.(io.Closer).Close()
+	storageTesting.RunTestsForStorage(stor, t)
+}
+
+func TestBoltStoragePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-cluster-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cluster.db")
+	stor, err := Bolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := cluster.Node{Address: "http://n1:4243", Metadata: map[string]string{"pool": "p1"}}
+	err = stor.StoreNode(context.Background(), node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stor.StoreNode(context.Background(), cluster.Node{Address: "http://a0:4243"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stor.StoreContainer(context.Background(), "cont1", "http://n1:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stor.(io.Closer).Close()
+	stor, err = Bolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stor.(io.Closer).Close()
+	nodes, err := stor.RetrieveNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []cluster.Node{node, {Address: "http://a0:4243", Metadata: map[string]string{}}}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("RetrieveNodes: want %#v, got %#v", expected, nodes)
+	}
+	host, err := stor.RetrieveContainer(context.Background(), "cont1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "http://n1:4243" {
+		t.Errorf("RetrieveContainer: want %q, got %q", "http://n1:4243", host)
+	}
+}
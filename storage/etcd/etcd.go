@@ -0,0 +1,603 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package etcd provides a cluster.Storage backed by etcd v3.
+//
+// Every entity is stored as a key under the given prefix, with JSON values.
+// Updates use compare-and-swap transactions on the revision of the key, so
+// concurrent writers, like two controllers trying to lock the same node for
+// healing, never overwrite each other.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/docker-cluster/storage"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const defaultTimeout = 10 * time.Second
+
+type etcdStorage struct {
+	client *clientv3.Client
+	prefix string
+}
+
+// Etcd returns a storage connected to the etcd cluster described by config,
+// storing keys under prefix.
+func Etcd(config clientv3.Config, prefix string) (cluster.Storage, error) {
+	client, err := clientv3.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdStorage{
+		client: client,
+		prefix: strings.TrimSuffix(prefix, "/") + "/",
+	}, nil
+}
+
+func (s *etcdStorage) key(kind string, parts ...string) string {
+	return s.prefix + kind + "/" + strings.Join(parts, "/")
+}
+
+// context bounds ctx by the default timeout of etcd operations.
+func (s *etcdStorage) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, defaultTimeout)
+}
+
+func (s *etcdStorage) StoreContainer(ctx context.Context, container, host string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	_, err := s.client.Put(ctx, s.key("containers", container), host)
+	return err
+}
+
+func (s *etcdStorage) RetrieveContainer(ctx context.Context, container string) (string, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Get(ctx, s.key("containers", container))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", storage.ErrNoSuchContainer
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (s *etcdStorage) RemoveContainer(ctx context.Context, container string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	execsPrefix := s.key("container-execs", container, "")
+	resp, err := s.client.Get(ctx, execsPrefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+	ops := []clientv3.Op{
+		clientv3.OpDelete(s.key("containers", container)),
+		clientv3.OpDelete(execsPrefix, clientv3.WithPrefix()),
+	}
+	for _, kv := range resp.Kvs {
+		execID := strings.TrimPrefix(string(kv.Key), execsPrefix)
+		ops = append(ops, clientv3.OpDelete(s.key("execs", execID)))
+	}
+	_, err = s.client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+func (s *etcdStorage) RetrieveContainers(ctx context.Context) ([]cluster.Container, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	prefix := s.key("containers", "")
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	containers := make([]cluster.Container, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		containers[i] = cluster.Container{
+			Id:   strings.TrimPrefix(string(kv.Key), prefix),
+			Host: string(kv.Value),
+		}
+	}
+	return containers, nil
+}
+
+func (s *etcdStorage) StoreImage(ctx context.Context, repo, id, host string) error {
+	return s.update(ctx, s.key("images", repo), nil, func(data []byte) (interface{}, error) {
+		image := cluster.Image{Repository: repo}
+		if data != nil {
+			if err := json.Unmarshal(data, &image); err != nil {
+				return nil, err
+			}
+		}
+		hasID := false
+		for _, entry := range image.History {
+			if entry.ImageId == id && entry.Node == host {
+				hasID = true
+				break
+			}
+		}
+		if !hasID {
+			image.History = append(image.History, cluster.ImageHistory{Node: host, ImageId: id})
+		}
+		image.LastNode = host
+		image.LastId = id
+		return image, nil
+	})
+}
+
+func (s *etcdStorage) SetImageDigest(ctx context.Context, repo, digest string) error {
+	return s.update(ctx, s.key("images", repo), nil, func(data []byte) (interface{}, error) {
+		image := cluster.Image{Repository: repo}
+		if data != nil {
+			if err := json.Unmarshal(data, &image); err != nil {
+				return nil, err
+			}
+		}
+		image.LastDigest = digest
+		return image, nil
+	})
+}
+
+func (s *etcdStorage) RetrieveImage(ctx context.Context, repo string) (cluster.Image, error) {
+	var image cluster.Image
+	err := s.get(ctx, s.key("images", repo), &image, storage.ErrNoSuchImage)
+	if err != nil {
+		return cluster.Image{}, err
+	}
+	if len(image.History) == 0 {
+		return cluster.Image{}, storage.ErrNoSuchImage
+	}
+	return image, nil
+}
+
+func (s *etcdStorage) RemoveImage(ctx context.Context, repo, id, host string) error {
+	return s.update(ctx, s.key("images", repo), storage.ErrNoSuchImage, func(data []byte) (interface{}, error) {
+		var image cluster.Image
+		if err := json.Unmarshal(data, &image); err != nil {
+			return nil, err
+		}
+		history := []cluster.ImageHistory{}
+		for _, entry := range image.History {
+			if entry.ImageId != id || entry.Node != host {
+				history = append(history, entry)
+			}
+		}
+		image.History = history
+		return image, nil
+	})
+}
+
+func (s *etcdStorage) RetrieveImages(ctx context.Context) ([]cluster.Image, error) {
+	var images []cluster.Image
+	err := s.list(ctx, "images", func(data []byte) error {
+		var image cluster.Image
+		if err := json.Unmarshal(data, &image); err != nil {
+			return err
+		}
+		images = append(images, image)
+		return nil
+	})
+	return images, err
+}
+
+// dbNode is the stored representation of a node, as the JSON encoding of
+// cluster.Node is meant for API responses and omits most fields.
+type dbNode struct {
+	Address        string
+	Healing        cluster.HealingData
+	Metadata       map[string]string
+	CreationStatus string
+	CaCert         []byte
+	ClientCert     []byte
+	ClientKey      []byte
+}
+
+func newDBNode(node cluster.Node) dbNode {
+	return dbNode{
+		Address:        node.Address,
+		Healing:        node.Healing,
+		Metadata:       node.Metadata,
+		CreationStatus: node.CreationStatus,
+		CaCert:         node.CaCert,
+		ClientCert:     node.ClientCert,
+		ClientKey:      node.ClientKey,
+	}
+}
+
+func decodeNode(data []byte) (cluster.Node, error) {
+	var n dbNode
+	err := json.Unmarshal(data, &n)
+	if n.Metadata == nil {
+		n.Metadata = map[string]string{}
+	}
+	return cluster.Node{
+		Address:        n.Address,
+		Healing:        n.Healing,
+		Metadata:       n.Metadata,
+		CreationStatus: n.CreationStatus,
+		CaCert:         n.CaCert,
+		ClientCert:     n.ClientCert,
+		ClientKey:      n.ClientKey,
+	}, err
+}
+
+func (s *etcdStorage) StoreNode(ctx context.Context, node cluster.Node) error {
+	return s.create(ctx, s.key("nodes", node.Address), newDBNode(node), storage.ErrDuplicatedNodeAddress)
+}
+
+func (s *etcdStorage) RetrieveNodes(ctx context.Context) ([]cluster.Node, error) {
+	return s.retrieveNodes(ctx, nil)
+}
+
+func (s *etcdStorage) RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]cluster.Node, error) {
+	return s.retrieveNodes(ctx, metadata)
+}
+
+func (s *etcdStorage) retrieveNodes(ctx context.Context, metadata map[string]string) ([]cluster.Node, error) {
+	nodes := []cluster.Node{}
+	err := s.list(ctx, "nodes", func(data []byte) error {
+		node, err := decodeNode(data)
+		if err != nil {
+			return err
+		}
+		for key, value := range metadata {
+			if node.Metadata[key] != value {
+				return nil
+			}
+		}
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (s *etcdStorage) RetrieveNode(ctx context.Context, address string) (cluster.Node, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Get(ctx, s.key("nodes", address))
+	if err != nil {
+		return cluster.Node{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return cluster.Node{}, storage.ErrNoSuchNode
+	}
+	return decodeNode(resp.Kvs[0].Value)
+}
+
+func (s *etcdStorage) UpdateNode(ctx context.Context, node cluster.Node) error {
+	data, err := json.Marshal(newDBNode(node))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	key := s.key("nodes", node.Address)
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(clientv3.OpPut(key, string(data))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *etcdStorage) RemoveNode(ctx context.Context, address string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Delete(ctx, s.key("nodes", address))
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *etcdStorage) RemoveNodes(ctx context.Context, addresses []string) error {
+	var removed int64
+	for _, address := range addresses {
+		err := s.RemoveNode(ctx, address)
+		if err == storage.ErrNoSuchNode {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed == 0 {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *etcdStorage) LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error) {
+	var locked bool
+	err := s.update(ctx, s.key("nodes", address), storage.ErrNoSuchNode, func(data []byte) (interface{}, error) {
+		node, err := decodeNode(data)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		locked = !node.Healing.LockedUntil.After(now)
+		if !locked {
+			return nil, nil
+		}
+		node.Healing = cluster.HealingData{LockedUntil: now.Add(timeout), IsFailure: isFailure}
+		return newDBNode(node), nil
+	})
+	return locked, err
+}
+
+func (s *etcdStorage) ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error {
+	return s.update(ctx, s.key("nodes", address), storage.ErrNoSuchNode, func(data []byte) (interface{}, error) {
+		node, err := decodeNode(data)
+		if err != nil {
+			return nil, err
+		}
+		node.Healing.LockedUntil = time.Now().UTC().Add(timeout)
+		return newDBNode(node), nil
+	})
+}
+
+func (s *etcdStorage) UnlockNode(ctx context.Context, address string) error {
+	return s.update(ctx, s.key("nodes", address), storage.ErrNoSuchNode, func(data []byte) (interface{}, error) {
+		node, err := decodeNode(data)
+		if err != nil {
+			return nil, err
+		}
+		node.Healing = cluster.HealingData{}
+		return newDBNode(node), nil
+	})
+}
+
+func (s *etcdStorage) StoreExec(ctx context.Context, execID, containerID string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	_, err := s.client.Txn(ctx).Then(
+		clientv3.OpPut(s.key("execs", execID), containerID),
+		clientv3.OpPut(s.key("container-execs", containerID, execID), ""),
+	).Commit()
+	return err
+}
+
+func (s *etcdStorage) RetrieveExec(ctx context.Context, execID string) (string, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Get(ctx, s.key("execs", execID))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", storage.ErrNoSuchExec
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (s *etcdStorage) RetrieveExecs(ctx context.Context) ([]cluster.Exec, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	prefix := s.key("execs", "")
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	execs := make([]cluster.Exec, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		execs[i] = cluster.Exec{
+			Id:        strings.TrimPrefix(string(kv.Key), prefix),
+			Container: string(kv.Value),
+		}
+	}
+	return execs, nil
+}
+
+func (s *etcdStorage) StoreNetwork(ctx context.Context, network cluster.Network) error {
+	return s.create(ctx, s.key("networks", network.Name), network, storage.ErrDuplicatedNetwork)
+}
+
+func (s *etcdStorage) RetrieveNetwork(ctx context.Context, name string) (cluster.Network, error) {
+	var network cluster.Network
+	err := s.get(ctx, s.key("networks", name), &network, storage.ErrNoSuchNetwork)
+	return network, err
+}
+
+func (s *etcdStorage) RetrieveNetworks(ctx context.Context) ([]cluster.Network, error) {
+	networks := []cluster.Network{}
+	err := s.list(ctx, "networks", func(data []byte) error {
+		var network cluster.Network
+		if err := json.Unmarshal(data, &network); err != nil {
+			return err
+		}
+		networks = append(networks, network)
+		return nil
+	})
+	return networks, err
+}
+
+func (s *etcdStorage) RemoveNetwork(ctx context.Context, name string) error {
+	return s.remove(ctx, s.key("networks", name), storage.ErrNoSuchNetwork)
+}
+
+func (s *etcdStorage) AddNetworkNode(ctx context.Context, name, id, host string) error {
+	return s.update(ctx, s.key("networks", name), storage.ErrNoSuchNetwork, func(data []byte) (interface{}, error) {
+		var network cluster.Network
+		if err := json.Unmarshal(data, &network); err != nil {
+			return nil, err
+		}
+		for i := range network.Nodes {
+			if network.Nodes[i].Node == host {
+				network.Nodes[i].ID = id
+				return network, nil
+			}
+		}
+		network.Nodes = append(network.Nodes, cluster.NetworkNode{Node: host, ID: id})
+		return network, nil
+	})
+}
+
+func (s *etcdStorage) RemoveNetworkNode(ctx context.Context, name, host string) error {
+	return s.update(ctx, s.key("networks", name), storage.ErrNoSuchNetwork, func(data []byte) (interface{}, error) {
+		var network cluster.Network
+		if err := json.Unmarshal(data, &network); err != nil {
+			return nil, err
+		}
+		nodes := []cluster.NetworkNode{}
+		for _, nn := range network.Nodes {
+			if nn.Node != host {
+				nodes = append(nodes, nn)
+			}
+		}
+		network.Nodes = nodes
+		return network, nil
+	})
+}
+
+func (s *etcdStorage) StoreVolume(ctx context.Context, volume cluster.Volume) error {
+	return s.create(ctx, s.key("volumes", volume.Name), volume, storage.ErrDuplicatedVolume)
+}
+
+func (s *etcdStorage) RetrieveVolume(ctx context.Context, name string) (cluster.Volume, error) {
+	var volume cluster.Volume
+	err := s.get(ctx, s.key("volumes", name), &volume, storage.ErrNoSuchVolume)
+	return volume, err
+}
+
+func (s *etcdStorage) RetrieveVolumes(ctx context.Context) ([]cluster.Volume, error) {
+	volumes := []cluster.Volume{}
+	err := s.list(ctx, "volumes", func(data []byte) error {
+		var volume cluster.Volume
+		if err := json.Unmarshal(data, &volume); err != nil {
+			return err
+		}
+		volumes = append(volumes, volume)
+		return nil
+	})
+	return volumes, err
+}
+
+func (s *etcdStorage) RemoveVolume(ctx context.Context, name string) error {
+	return s.remove(ctx, s.key("volumes", name), storage.ErrNoSuchVolume)
+}
+
+// create stores value in key, returning dupErr if the key already exists.
+func (s *etcdStorage) create(ctx context.Context, key string, value interface{}, dupErr error) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(data))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return dupErr
+	}
+	return nil
+}
+
+func (s *etcdStorage) get(ctx context.Context, key string, value interface{}, notFound error) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		return notFound
+	}
+	return json.Unmarshal(resp.Kvs[0].Value, value)
+}
+
+// list calls fn with the value of every key of the given kind, in the order
+// they were created.
+func (s *etcdStorage) list(ctx context.Context, kind string, fn func(data []byte) error) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Get(ctx, s.key(kind, ""), clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.Kvs {
+		if err := fn(kv.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *etcdStorage) remove(ctx context.Context, key string, notFound error) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	resp, err := s.client.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// update applies fn to the current value of key and stores the result,
+// retrying when the key is changed concurrently. fn is called with nil data
+// when the key doesn't exist, unless notFound is set, in which case notFound
+// is returned. A nil value returned by fn leaves the key untouched.
+func (s *etcdStorage) update(ctx context.Context, key string, notFound error, fn func(data []byte) (interface{}, error)) error {
+	for {
+		opCtx, cancel := s.context(ctx)
+		resp, err := s.client.Get(opCtx, key)
+		cancel()
+		if err != nil {
+			return err
+		}
+		var (
+			data []byte
+			cmp  = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		)
+		if len(resp.Kvs) > 0 {
+			data = resp.Kvs[0].Value
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+		} else if notFound != nil {
+			return notFound
+		}
+		value, err := fn(data)
+		if err != nil || value == nil {
+			return err
+		}
+		newData, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		opCtx, cancel = s.context(ctx)
+		txnResp, err := s.client.Txn(opCtx).If(cmp).Then(clientv3.OpPut(key, string(newData))).Commit()
+		cancel()
+		if err != nil {
+			return err
+		}
+		if txnResp.Succeeded {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcd
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	storageTesting "github.com/tsuru/docker-cluster/storage/testing"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func localURL(t *testing.T) *url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func startEtcd(t *testing.T) (*embed.Etcd, string) {
+	dir, err := ioutil.TempDir("", "docker-cluster-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	clientURL, peerURL := localURL(t), localURL(t)
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		server.Close()
+		os.RemoveAll(dir)
+		t.Fatal("timeout waiting for etcd to start")
+	}
+	return server, dir
+}
+
+func TestEtcdStorage(t *testing.T) {
+	server, dir := startEtcd(t)
+	defer os.RemoveAll(dir)
+	defer server.Close()
+	stor, err := Etcd(clientv3.Config{
+		Endpoints:   []string{server.Config().AdvertiseClientUrls[0].String()},
+		DialTimeout: 5 * time.Second,
+	}, "/test-docker-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageTesting.RunTestsForStorage(stor, t)
+}
@@ -5,6 +5,8 @@
 package mongodb
 
 import (
+	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/globalsign/mgo"
@@ -18,14 +20,14 @@ type mongodbStorage struct {
 	dbName  string
 }
 
-func (s *mongodbStorage) StoreContainer(container, host string) error {
+func (s *mongodbStorage) StoreContainer(ctx context.Context, container, host string) error {
 	coll := s.getColl("containers")
 	defer coll.Database.Session.Close()
 	_, err := coll.UpsertId(container, bson.M{"$set": bson.M{"host": host}})
 	return err
 }
 
-func (s *mongodbStorage) RetrieveContainer(container string) (string, error) {
+func (s *mongodbStorage) RetrieveContainer(ctx context.Context, container string) (string, error) {
 	coll := s.getColl("containers")
 	defer coll.Database.Session.Close()
 	dbContainer := struct {
@@ -41,7 +43,7 @@ func (s *mongodbStorage) RetrieveContainer(container string) (string, error) {
 	return dbContainer.Host, nil
 }
 
-func (s *mongodbStorage) RemoveContainer(container string) error {
+func (s *mongodbStorage) RemoveContainer(ctx context.Context, container string) error {
 	coll := s.getColl("containers")
 	defer coll.Database.Session.Close()
 	err1 := coll.Remove(bson.M{"_id": container})
@@ -54,7 +56,7 @@ func (s *mongodbStorage) RemoveContainer(container string) error {
 	return err2
 }
 
-func (s *mongodbStorage) RetrieveContainers() ([]cluster.Container, error) {
+func (s *mongodbStorage) RetrieveContainers(ctx context.Context) ([]cluster.Container, error) {
 	coll := s.getColl("containers")
 	defer coll.Database.Session.Close()
 	var containers []cluster.Container
@@ -62,7 +64,7 @@ func (s *mongodbStorage) RetrieveContainers() ([]cluster.Container, error) {
 	return containers, err
 }
 
-func (s *mongodbStorage) StoreImage(repo, id, host string) error {
+func (s *mongodbStorage) StoreImage(ctx context.Context, repo, id, host string) error {
 	coll := s.getColl("images_history")
 	defer coll.Database.Session.Close()
 	_, err := coll.UpsertId(repo, bson.M{
@@ -75,13 +77,13 @@ func (s *mongodbStorage) StoreImage(repo, id, host string) error {
 	return err
 }
 
-func (s *mongodbStorage) SetImageDigest(repo, digest string) error {
+func (s *mongodbStorage) SetImageDigest(ctx context.Context, repo, digest string) error {
 	coll := s.getColl("images_history")
 	defer coll.Database.Session.Close()
 	return coll.UpdateId(repo, bson.M{"$set": bson.M{"lastdigest": digest}})
 }
 
-func (s *mongodbStorage) RetrieveImage(repo string) (cluster.Image, error) {
+func (s *mongodbStorage) RetrieveImage(ctx context.Context, repo string) (cluster.Image, error) {
 	coll := s.getColl("images_history")
 	defer coll.Database.Session.Close()
 	var image cluster.Image
@@ -98,13 +100,13 @@ func (s *mongodbStorage) RetrieveImage(repo string) (cluster.Image, error) {
 	return image, nil
 }
 
-func (s *mongodbStorage) RemoveImage(repo, id, host string) error {
+func (s *mongodbStorage) RemoveImage(ctx context.Context, repo, id, host string) error {
 	coll := s.getColl("images_history")
 	defer coll.Database.Session.Close()
 	return coll.UpdateId(repo, bson.M{"$pull": bson.M{"history": bson.M{"node": host, "imageid": id}}})
 }
 
-func (s *mongodbStorage) RetrieveImages() ([]cluster.Image, error) {
+func (s *mongodbStorage) RetrieveImages(ctx context.Context) ([]cluster.Image, error) {
 	coll := s.getColl("images_history")
 	defer coll.Database.Session.Close()
 	var images []cluster.Image
@@ -112,7 +114,7 @@ func (s *mongodbStorage) RetrieveImages() ([]cluster.Image, error) {
 	return images, err
 }
 
-func (s *mongodbStorage) StoreNode(node cluster.Node) error {
+func (s *mongodbStorage) StoreNode(ctx context.Context, node cluster.Node) error {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	err := coll.Insert(node)
@@ -122,7 +124,7 @@ func (s *mongodbStorage) StoreNode(node cluster.Node) error {
 	return err
 }
 
-func (s *mongodbStorage) LockNodeForHealing(address string, isFailure bool, timeout time.Duration) (bool, error) {
+func (s *mongodbStorage) LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error) {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	now := time.Now().UTC()
@@ -148,7 +150,7 @@ func (s *mongodbStorage) LockNodeForHealing(address string, isFailure bool, time
 	return err == nil, err
 }
 
-func (s *mongodbStorage) ExtendNodeLock(address string, timeout time.Duration) error {
+func (s *mongodbStorage) ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	now := time.Now().UTC()
@@ -158,7 +160,7 @@ func (s *mongodbStorage) ExtendNodeLock(address string, timeout time.Duration) e
 		bson.M{"$set": bson.M{"healing.lockeduntil": until}})
 }
 
-func (s *mongodbStorage) UnlockNode(address string) error {
+func (s *mongodbStorage) UnlockNode(ctx context.Context, address string) error {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	return coll.Update(
@@ -166,7 +168,7 @@ func (s *mongodbStorage) UnlockNode(address string) error {
 		bson.M{"$set": bson.M{"healing": nil}})
 }
 
-func (s *mongodbStorage) RetrieveNodesByMetadata(metadata map[string]string) ([]cluster.Node, error) {
+func (s *mongodbStorage) RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]cluster.Node, error) {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	query := bson.M{}
@@ -181,7 +183,7 @@ func (s *mongodbStorage) RetrieveNodesByMetadata(metadata map[string]string) ([]
 	return nodes, nil
 }
 
-func (s *mongodbStorage) RetrieveNodes() ([]cluster.Node, error) {
+func (s *mongodbStorage) RetrieveNodes(ctx context.Context) ([]cluster.Node, error) {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	var nodes []cluster.Node
@@ -192,7 +194,7 @@ func (s *mongodbStorage) RetrieveNodes() ([]cluster.Node, error) {
 	return nodes, nil
 }
 
-func (s *mongodbStorage) RetrieveNode(address string) (cluster.Node, error) {
+func (s *mongodbStorage) RetrieveNode(ctx context.Context, address string) (cluster.Node, error) {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	var node cluster.Node
@@ -203,7 +205,7 @@ func (s *mongodbStorage) RetrieveNode(address string) (cluster.Node, error) {
 	return node, err
 }
 
-func (s *mongodbStorage) UpdateNode(node cluster.Node) error {
+func (s *mongodbStorage) UpdateNode(ctx context.Context, node cluster.Node) error {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	err := coll.UpdateId(node.Address, node)
@@ -213,7 +215,7 @@ func (s *mongodbStorage) UpdateNode(node cluster.Node) error {
 	return err
 }
 
-func (s *mongodbStorage) RemoveNode(address string) error {
+func (s *mongodbStorage) RemoveNode(ctx context.Context, address string) error {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	err := coll.Remove(bson.M{"_id": address})
@@ -223,7 +225,7 @@ func (s *mongodbStorage) RemoveNode(address string) error {
 	return err
 }
 
-func (s *mongodbStorage) RemoveNodes(addresses []string) error {
+func (s *mongodbStorage) RemoveNodes(ctx context.Context, addresses []string) error {
 	coll := s.getColl("nodes")
 	defer coll.Database.Session.Close()
 	change, err := coll.RemoveAll(bson.M{"_id": bson.M{"$in": addresses}})
@@ -236,13 +238,13 @@ func (s *mongodbStorage) RemoveNodes(addresses []string) error {
 	return nil
 }
 
-func (s *mongodbStorage) StoreExec(execID, containerID string) error {
+func (s *mongodbStorage) StoreExec(ctx context.Context, execID, containerID string) error {
 	coll := s.getColl("execs")
 	defer coll.Database.Session.Close()
 	return coll.Insert(bson.M{"_id": execID, "container": containerID})
 }
 
-func (s *mongodbStorage) RetrieveExec(execID string) (string, error) {
+func (s *mongodbStorage) RetrieveExec(ctx context.Context, execID string) (string, error) {
 	coll := s.getColl("execs")
 	defer coll.Database.Session.Close()
 	dbExec := struct {
@@ -255,6 +257,194 @@ func (s *mongodbStorage) RetrieveExec(execID string) (string, error) {
 	return dbExec.Container, err
 }
 
+func (s *mongodbStorage) RetrieveExecs(ctx context.Context) ([]cluster.Exec, error) {
+	coll := s.getColl("execs")
+	defer coll.Database.Session.Close()
+	var execs []cluster.Exec
+	err := coll.Find(nil).All(&execs)
+	return execs, err
+}
+
+// dbNetwork stores the definition of the network as JSON, as its options
+// and labels usually have dots in their keys, which aren't allowed in
+// MongoDB documents.
+type dbNetwork struct {
+	Name       string `bson:"_id"`
+	Definition string
+	Nodes      []cluster.NetworkNode
+}
+
+func (n *dbNetwork) network() (cluster.Network, error) {
+	var network cluster.Network
+	err := json.Unmarshal([]byte(n.Definition), &network)
+	if err != nil {
+		return network, err
+	}
+	network.Name = n.Name
+	network.Nodes = n.Nodes
+	return network, nil
+}
+
+func (s *mongodbStorage) StoreNetwork(ctx context.Context, network cluster.Network) error {
+	nodes := network.Nodes
+	network.Nodes = nil
+	definition, err := json.Marshal(network)
+	if err != nil {
+		return err
+	}
+	coll := s.getColl("networks")
+	defer coll.Database.Session.Close()
+	err = coll.Insert(dbNetwork{Name: network.Name, Definition: string(definition), Nodes: nodes})
+	if mgo.IsDup(err) {
+		return storage.ErrDuplicatedNetwork
+	}
+	return err
+}
+
+func (s *mongodbStorage) RetrieveNetwork(ctx context.Context, name string) (cluster.Network, error) {
+	coll := s.getColl("networks")
+	defer coll.Database.Session.Close()
+	var dbNet dbNetwork
+	err := coll.FindId(name).One(&dbNet)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return cluster.Network{}, storage.ErrNoSuchNetwork
+		}
+		return cluster.Network{}, err
+	}
+	return dbNet.network()
+}
+
+func (s *mongodbStorage) RetrieveNetworks(ctx context.Context) ([]cluster.Network, error) {
+	coll := s.getColl("networks")
+	defer coll.Database.Session.Close()
+	var dbNets []dbNetwork
+	err := coll.Find(nil).All(&dbNets)
+	if err != nil {
+		return nil, err
+	}
+	networks := make([]cluster.Network, len(dbNets))
+	for i := range dbNets {
+		networks[i], err = dbNets[i].network()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return networks, nil
+}
+
+func (s *mongodbStorage) RemoveNetwork(ctx context.Context, name string) error {
+	coll := s.getColl("networks")
+	defer coll.Database.Session.Close()
+	err := coll.RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return storage.ErrNoSuchNetwork
+	}
+	return err
+}
+
+func (s *mongodbStorage) AddNetworkNode(ctx context.Context, name, id, host string) error {
+	coll := s.getColl("networks")
+	defer coll.Database.Session.Close()
+	err := coll.Update(bson.M{"_id": name, "nodes.node": host}, bson.M{"$set": bson.M{"nodes.$.id": id}})
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	err = coll.Update(
+		bson.M{"_id": name, "nodes.node": bson.M{"$ne": host}},
+		bson.M{"$push": bson.M{"nodes": cluster.NetworkNode{Node: host, ID: id}}},
+	)
+	if err == mgo.ErrNotFound {
+		return storage.ErrNoSuchNetwork
+	}
+	return err
+}
+
+func (s *mongodbStorage) RemoveNetworkNode(ctx context.Context, name, host string) error {
+	coll := s.getColl("networks")
+	defer coll.Database.Session.Close()
+	err := coll.UpdateId(name, bson.M{"$pull": bson.M{"nodes": bson.M{"node": host}}})
+	if err == mgo.ErrNotFound {
+		return storage.ErrNoSuchNetwork
+	}
+	return err
+}
+
+// dbVolume stores the labels and options of the volume as JSON, for the
+// same reason as dbNetwork.
+type dbVolume struct {
+	Name       string `bson:"_id"`
+	Node       string
+	Definition string
+}
+
+func (v *dbVolume) volume() (cluster.Volume, error) {
+	var volume cluster.Volume
+	err := json.Unmarshal([]byte(v.Definition), &volume)
+	if err != nil {
+		return volume, err
+	}
+	volume.Name = v.Name
+	volume.Node = v.Node
+	return volume, nil
+}
+
+func (s *mongodbStorage) StoreVolume(ctx context.Context, volume cluster.Volume) error {
+	definition, err := json.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	coll := s.getColl("volumes")
+	defer coll.Database.Session.Close()
+	err = coll.Insert(dbVolume{Name: volume.Name, Node: volume.Node, Definition: string(definition)})
+	if mgo.IsDup(err) {
+		return storage.ErrDuplicatedVolume
+	}
+	return err
+}
+
+func (s *mongodbStorage) RetrieveVolume(ctx context.Context, name string) (cluster.Volume, error) {
+	coll := s.getColl("volumes")
+	defer coll.Database.Session.Close()
+	var dbVol dbVolume
+	err := coll.FindId(name).One(&dbVol)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return cluster.Volume{}, storage.ErrNoSuchVolume
+		}
+		return cluster.Volume{}, err
+	}
+	return dbVol.volume()
+}
+
+func (s *mongodbStorage) RetrieveVolumes(ctx context.Context) ([]cluster.Volume, error) {
+	coll := s.getColl("volumes")
+	defer coll.Database.Session.Close()
+	var dbVols []dbVolume
+	err := coll.Find(nil).All(&dbVols)
+	if err != nil {
+		return nil, err
+	}
+	volumes := make([]cluster.Volume, len(dbVols))
+	for i := range dbVols {
+		volumes[i], err = dbVols[i].volume()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (s *mongodbStorage) RemoveVolume(ctx context.Context, name string) error {
+	coll := s.getColl("volumes")
+	defer coll.Database.Session.Close()
+	err := coll.RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return storage.ErrNoSuchVolume
+	}
+	return err
+}
+
 func (s *mongodbStorage) getColl(name string) *mgo.Collection {
 	session := s.session.Copy()
 	return session.DB(s.dbName).C(name)
@@ -0,0 +1,707 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package redis provides a cluster.Storage backed by Redis.
+//
+// All keys are stored under the given prefix:
+//
+//	<prefix>:containers              hash of container ID to node address
+//	<prefix>:execs                   hash of exec ID to container ID
+//	<prefix>:container-execs:<id>    set of the exec IDs of a container
+//	<prefix>:images                  set of image repositories
+//	<prefix>:image:<repo>            hash with the last node, ID and digest
+//	<prefix>:image-history:<repo>    sorted set of the image history entries,
+//	                                 in the order they were stored
+//	<prefix>:nodes                   sorted set of node addresses
+//	<prefix>:node:<address>          hash with the node and its healing lock
+//	<prefix>:networks                hash of network name to definition
+//	<prefix>:volumes                 hash of volume name to definition
+//
+// Node healing locks are taken and extended by Lua scripts, so they're
+// atomic even with many controllers sharing the same Redis.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+const defaultTimeout = 10 * time.Second
+
+var (
+	storeNodeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'node', ARGV[2], 'lockeduntil', ARGV[3], 'isfailure', ARGV[4])
+redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[3]), ARGV[1])
+return 1
+`)
+
+	updateNodeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'node', ARGV[1], 'lockeduntil', ARGV[2], 'isfailure', ARGV[3])
+return 1
+`)
+
+	lockNodeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+local lockedUntil = tonumber(redis.call('HGET', KEYS[1], 'lockeduntil') or '0')
+if lockedUntil > tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'lockeduntil', ARGV[2], 'isfailure', ARGV[3])
+return 1
+`)
+
+	setNodeLockScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'lockeduntil', ARGV[1])
+if ARGV[2] ~= '' then
+	redis.call('HSET', KEYS[1], 'isfailure', ARGV[2])
+end
+return 1
+`)
+
+	storeImageScript = redis.NewScript(`
+redis.call('SADD', KEYS[1], ARGV[1])
+if not redis.call('ZSCORE', KEYS[2], ARGV[2]) then
+	redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[3]), ARGV[2])
+end
+redis.call('HSET', KEYS[4], 'lastnode', ARGV[3], 'lastid', ARGV[4])
+return 1
+`)
+)
+
+type redisStorage struct {
+	client *redis.Client
+	prefix string
+}
+
+// Redis returns a storage connected to the Redis server described by opts,
+// storing keys under prefix.
+func Redis(opts *redis.Options, prefix string) (cluster.Storage, error) {
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisStorage{client: client, prefix: prefix}, nil
+}
+
+func (s *redisStorage) key(parts ...string) string {
+	key := s.prefix
+	for _, p := range parts {
+		key += ":" + p
+	}
+	return key
+}
+
+func (s *redisStorage) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, defaultTimeout)
+}
+
+func (s *redisStorage) StoreContainer(ctx context.Context, container, host string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	return s.client.HSet(ctx, s.key("containers"), container, host).Err()
+}
+
+func (s *redisStorage) RetrieveContainer(ctx context.Context, container string) (string, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	host, err := s.client.HGet(ctx, s.key("containers"), container).Result()
+	if err == redis.Nil {
+		return "", storage.ErrNoSuchContainer
+	}
+	return host, err
+}
+
+func (s *redisStorage) RemoveContainer(ctx context.Context, container string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	execsKey := s.key("container-execs", container)
+	execs, err := s.client.SMembers(ctx, execsKey).Result()
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.key("containers"), container)
+		if len(execs) > 0 {
+			pipe.HDel(ctx, s.key("execs"), execs...)
+		}
+		pipe.Del(ctx, execsKey)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) RetrieveContainers(ctx context.Context) ([]cluster.Container, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	entries, err := s.client.HGetAll(ctx, s.key("containers")).Result()
+	if err != nil {
+		return nil, err
+	}
+	containers := make([]cluster.Container, 0, len(entries))
+	for id, host := range entries {
+		containers = append(containers, cluster.Container{Id: id, Host: host})
+	}
+	return containers, nil
+}
+
+func historyEntry(id, host string) string {
+	data, _ := json.Marshal(cluster.ImageHistory{Node: host, ImageId: id})
+	return string(data)
+}
+
+func (s *redisStorage) StoreImage(ctx context.Context, repo, id, host string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	keys := []string{s.key("images"), s.key("image-history", repo), s.key("image-history-seq"), s.key("image", repo)}
+	return storeImageScript.Run(ctx, s.client, keys, repo, historyEntry(id, host), host, id).Err()
+}
+
+func (s *redisStorage) SetImageDigest(ctx context.Context, repo, digest string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, s.key("images"), repo)
+		pipe.HSet(ctx, s.key("image", repo), "lastdigest", digest)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) RetrieveImage(ctx context.Context, repo string) (cluster.Image, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	image, err := s.retrieveImage(ctx, repo)
+	if err != nil {
+		return cluster.Image{}, err
+	}
+	if len(image.History) == 0 {
+		return cluster.Image{}, storage.ErrNoSuchImage
+	}
+	return image, nil
+}
+
+func (s *redisStorage) retrieveImage(ctx context.Context, repo string) (cluster.Image, error) {
+	var (
+		fields  *redis.MapStringStringCmd
+		history *redis.StringSliceCmd
+	)
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HGetAll(ctx, s.key("image", repo))
+		history = pipe.ZRange(ctx, s.key("image-history", repo), 0, -1)
+		return nil
+	})
+	if err != nil {
+		return cluster.Image{}, err
+	}
+	image := cluster.Image{
+		Repository: repo,
+		LastNode:   fields.Val()["lastnode"],
+		LastId:     fields.Val()["lastid"],
+		LastDigest: fields.Val()["lastdigest"],
+		History:    []cluster.ImageHistory{},
+	}
+	for _, entry := range history.Val() {
+		var h cluster.ImageHistory
+		if err := json.Unmarshal([]byte(entry), &h); err != nil {
+			return cluster.Image{}, err
+		}
+		image.History = append(image.History, h)
+	}
+	return image, nil
+}
+
+func (s *redisStorage) RemoveImage(ctx context.Context, repo, id, host string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	exists, err := s.client.SIsMember(ctx, s.key("images"), repo).Result()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return storage.ErrNoSuchImage
+	}
+	return s.client.ZRem(ctx, s.key("image-history", repo), historyEntry(id, host)).Err()
+}
+
+func (s *redisStorage) RetrieveImages(ctx context.Context) ([]cluster.Image, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	repos, err := s.client.SMembers(ctx, s.key("images")).Result()
+	if err != nil {
+		return nil, err
+	}
+	images := make([]cluster.Image, len(repos))
+	for i, repo := range repos {
+		images[i], err = s.retrieveImage(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// dbNode is the stored representation of a node, as the JSON encoding of
+// cluster.Node is meant for API responses and omits most fields. The healing
+// lock is stored in its own fields, so the Lua scripts can handle it.
+type dbNode struct {
+	Address        string
+	Metadata       map[string]string
+	CreationStatus string
+	CaCert         []byte
+	ClientCert     []byte
+	ClientKey      []byte
+}
+
+func encodeNode(node cluster.Node) (data, lockedUntil, isFailure string, err error) {
+	encoded, err := json.Marshal(dbNode{
+		Address:        node.Address,
+		Metadata:       node.Metadata,
+		CreationStatus: node.CreationStatus,
+		CaCert:         node.CaCert,
+		ClientCert:     node.ClientCert,
+		ClientKey:      node.ClientKey,
+	})
+	if err != nil {
+		return "", "", "", err
+	}
+	return string(encoded), encodeTime(node.Healing.LockedUntil), encodeBool(node.Healing.IsFailure), nil
+}
+
+func decodeNode(fields map[string]string) (cluster.Node, error) {
+	var n dbNode
+	err := json.Unmarshal([]byte(fields["node"]), &n)
+	if err != nil {
+		return cluster.Node{}, err
+	}
+	if n.Metadata == nil {
+		n.Metadata = map[string]string{}
+	}
+	lockedUntil, err := decodeTime(fields["lockeduntil"])
+	if err != nil {
+		return cluster.Node{}, err
+	}
+	return cluster.Node{
+		Address:        n.Address,
+		Healing:        cluster.HealingData{LockedUntil: lockedUntil, IsFailure: fields["isfailure"] == "1"},
+		Metadata:       n.Metadata,
+		CreationStatus: n.CreationStatus,
+		CaCert:         n.CaCert,
+		ClientCert:     n.ClientCert,
+		ClientKey:      n.ClientKey,
+	}, nil
+}
+
+// encodeTime encodes t as microseconds since the epoch, which fit in the
+// numbers used by Lua scripts. The zero time is encoded as 0.
+func encodeTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10)
+}
+
+func decodeTime(value string) (time.Time, error) {
+	if value == "" || value == "0" {
+		return time.Time{}, nil
+	}
+	usec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, usec*int64(time.Microsecond)).UTC(), nil
+}
+
+func encodeBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (s *redisStorage) StoreNode(ctx context.Context, node cluster.Node) error {
+	data, lockedUntil, isFailure, err := encodeNode(node)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	keys := []string{s.key("node", node.Address), s.key("nodes"), s.key("nodes-seq")}
+	stored, err := storeNodeScript.Run(ctx, s.client, keys, node.Address, data, lockedUntil, isFailure).Int()
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return storage.ErrDuplicatedNodeAddress
+	}
+	return nil
+}
+
+func (s *redisStorage) RetrieveNodes(ctx context.Context) ([]cluster.Node, error) {
+	return s.retrieveNodes(ctx, nil)
+}
+
+func (s *redisStorage) RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]cluster.Node, error) {
+	return s.retrieveNodes(ctx, metadata)
+}
+
+func (s *redisStorage) retrieveNodes(ctx context.Context, metadata map[string]string) ([]cluster.Node, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	addresses, err := s.client.ZRange(ctx, s.key("nodes"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.MapStringStringCmd, len(addresses))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, addr := range addresses {
+			cmds[i] = pipe.HGetAll(ctx, s.key("node", addr))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	nodes := []cluster.Node{}
+nodesLoop:
+	for _, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		node, err := decodeNode(cmd.Val())
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range metadata {
+			if node.Metadata[key] != value {
+				continue nodesLoop
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (s *redisStorage) RetrieveNode(ctx context.Context, address string) (cluster.Node, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	fields, err := s.client.HGetAll(ctx, s.key("node", address)).Result()
+	if err != nil {
+		return cluster.Node{}, err
+	}
+	if len(fields) == 0 {
+		return cluster.Node{}, storage.ErrNoSuchNode
+	}
+	return decodeNode(fields)
+}
+
+func (s *redisStorage) UpdateNode(ctx context.Context, node cluster.Node) error {
+	data, lockedUntil, isFailure, err := encodeNode(node)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	keys := []string{s.key("node", node.Address)}
+	updated, err := updateNodeScript.Run(ctx, s.client, keys, data, lockedUntil, isFailure).Int()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *redisStorage) RemoveNode(ctx context.Context, address string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	var del *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		del = pipe.Del(ctx, s.key("node", address))
+		pipe.ZRem(ctx, s.key("nodes"), address)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *redisStorage) RemoveNodes(ctx context.Context, addresses []string) error {
+	removed := 0
+	for _, address := range addresses {
+		err := s.RemoveNode(ctx, address)
+		if err == storage.ErrNoSuchNode {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed == 0 {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *redisStorage) LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	now := time.Now().UTC()
+	keys := []string{s.key("node", address)}
+	result, err := lockNodeScript.Run(ctx, s.client, keys, encodeTime(now), encodeTime(now.Add(timeout)), encodeBool(isFailure)).Int()
+	if err != nil {
+		return false, err
+	}
+	if result < 0 {
+		return false, storage.ErrNoSuchNode
+	}
+	return result == 1, nil
+}
+
+func (s *redisStorage) ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error {
+	return s.setNodeLock(ctx, address, encodeTime(time.Now().UTC().Add(timeout)), "")
+}
+
+func (s *redisStorage) UnlockNode(ctx context.Context, address string) error {
+	return s.setNodeLock(ctx, address, encodeTime(time.Time{}), encodeBool(false))
+}
+
+func (s *redisStorage) setNodeLock(ctx context.Context, address, lockedUntil, isFailure string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	keys := []string{s.key("node", address)}
+	updated, err := setNodeLockScript.Run(ctx, s.client, keys, lockedUntil, isFailure).Int()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *redisStorage) StoreExec(ctx context.Context, execID, containerID string) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.key("execs"), execID, containerID)
+		pipe.SAdd(ctx, s.key("container-execs", containerID), execID)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) RetrieveExec(ctx context.Context, execID string) (string, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	containerID, err := s.client.HGet(ctx, s.key("execs"), execID).Result()
+	if err == redis.Nil {
+		return "", storage.ErrNoSuchExec
+	}
+	return containerID, err
+}
+
+func (s *redisStorage) RetrieveExecs(ctx context.Context) ([]cluster.Exec, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	entries, err := s.client.HGetAll(ctx, s.key("execs")).Result()
+	if err != nil {
+		return nil, err
+	}
+	execs := make([]cluster.Exec, 0, len(entries))
+	for id, containerID := range entries {
+		execs = append(execs, cluster.Exec{Id: id, Container: containerID})
+	}
+	return execs, nil
+}
+
+func (s *redisStorage) StoreNetwork(ctx context.Context, network cluster.Network) error {
+	return s.storeNew(ctx, s.key("networks"), network.Name, network, storage.ErrDuplicatedNetwork)
+}
+
+func (s *redisStorage) RetrieveNetwork(ctx context.Context, name string) (cluster.Network, error) {
+	var network cluster.Network
+	err := s.retrieve(ctx, s.key("networks"), name, &network, storage.ErrNoSuchNetwork)
+	return network, err
+}
+
+func (s *redisStorage) RetrieveNetworks(ctx context.Context) ([]cluster.Network, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	entries, err := s.client.HVals(ctx, s.key("networks")).Result()
+	if err != nil {
+		return nil, err
+	}
+	networks := make([]cluster.Network, len(entries))
+	for i, data := range entries {
+		if err := json.Unmarshal([]byte(data), &networks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return networks, nil
+}
+
+func (s *redisStorage) RemoveNetwork(ctx context.Context, name string) error {
+	return s.remove(ctx, s.key("networks"), name, storage.ErrNoSuchNetwork)
+}
+
+func (s *redisStorage) AddNetworkNode(ctx context.Context, name, id, host string) error {
+	return s.updateNetwork(ctx, name, func(network *cluster.Network) {
+		for i := range network.Nodes {
+			if network.Nodes[i].Node == host {
+				network.Nodes[i].ID = id
+				return
+			}
+		}
+		network.Nodes = append(network.Nodes, cluster.NetworkNode{Node: host, ID: id})
+	})
+}
+
+func (s *redisStorage) RemoveNetworkNode(ctx context.Context, name, host string) error {
+	return s.updateNetwork(ctx, name, func(network *cluster.Network) {
+		nodes := []cluster.NetworkNode{}
+		for _, nn := range network.Nodes {
+			if nn.Node != host {
+				nodes = append(nodes, nn)
+			}
+		}
+		network.Nodes = nodes
+	})
+}
+
+// updateNetwork applies fn to the stored network in an optimistic
+// transaction, retrying when the networks are changed concurrently.
+func (s *redisStorage) updateNetwork(ctx context.Context, name string, fn func(network *cluster.Network)) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	key := s.key("networks")
+	for {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.HGet(ctx, key, name).Bytes()
+			if err == redis.Nil {
+				return storage.ErrNoSuchNetwork
+			}
+			if err != nil {
+				return err
+			}
+			var network cluster.Network
+			if err = json.Unmarshal(data, &network); err != nil {
+				return err
+			}
+			fn(&network)
+			if data, err = json.Marshal(network); err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, name, data)
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+func (s *redisStorage) StoreVolume(ctx context.Context, volume cluster.Volume) error {
+	return s.storeNew(ctx, s.key("volumes"), volume.Name, volume, storage.ErrDuplicatedVolume)
+}
+
+func (s *redisStorage) RetrieveVolume(ctx context.Context, name string) (cluster.Volume, error) {
+	var volume cluster.Volume
+	err := s.retrieve(ctx, s.key("volumes"), name, &volume, storage.ErrNoSuchVolume)
+	return volume, err
+}
+
+func (s *redisStorage) RetrieveVolumes(ctx context.Context) ([]cluster.Volume, error) {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	entries, err := s.client.HVals(ctx, s.key("volumes")).Result()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make([]cluster.Volume, len(entries))
+	for i, data := range entries {
+		if err := json.Unmarshal([]byte(data), &volumes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (s *redisStorage) RemoveVolume(ctx context.Context, name string) error {
+	return s.remove(ctx, s.key("volumes"), name, storage.ErrNoSuchVolume)
+}
+
+// storeNew stores value in the field of the hash, returning dupErr if the
+// field is already set.
+func (s *redisStorage) storeNew(ctx context.Context, key, field string, value interface{}, dupErr error) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	stored, err := s.client.HSetNX(ctx, key, field, data).Result()
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return dupErr
+	}
+	return nil
+}
+
+func (s *redisStorage) retrieve(ctx context.Context, key, field string, value interface{}, notFound error) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	data, err := s.client.HGet(ctx, key, field).Bytes()
+	if err == redis.Nil {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (s *redisStorage) remove(ctx context.Context, key, field string, notFound error) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+	removed, err := s.client.HDel(ctx, key, field).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redis
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	storageTesting "github.com/tsuru/docker-cluster/storage/testing"
+)
+
+func TestRedisStorage(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	stor, err := Redis(&redis.Options{Addr: server.Addr()}, "test-docker-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageTesting.RunTestsForStorage(stor, t)
+}
+
+func TestRedisStorageConnectionError(t *testing.T) {
+	_, err := Redis(&redis.Options{Addr: "127.0.0.1:1"}, "test-docker-cluster")
+	if err == nil {
+		t.Fatal("Expected non-nil error, got <nil>")
+	}
+}
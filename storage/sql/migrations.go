@@ -0,0 +1,110 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sql
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// migrations are the statements that create the schema, applied in order.
+// The version of a migration is its index plus one, and applied versions are
+// recorded in the schema_migrations table, so new migrations must only be
+// appended. {{blob}} is replaced by the binary type of the dialect.
+var migrations = []string{
+	`CREATE TABLE containers (
+		id VARCHAR(255) PRIMARY KEY,
+		host TEXT NOT NULL
+	)`,
+	`CREATE TABLE execs (
+		id VARCHAR(255) PRIMARY KEY,
+		container VARCHAR(255) NOT NULL
+	)`,
+	`CREATE INDEX execs_container ON execs (container)`,
+	`CREATE TABLE images (
+		repository VARCHAR(255) PRIMARY KEY,
+		last_node TEXT NOT NULL,
+		last_id TEXT NOT NULL,
+		last_digest TEXT NOT NULL
+	)`,
+	`CREATE TABLE image_history (
+		repository VARCHAR(255) NOT NULL,
+		node VARCHAR(255) NOT NULL,
+		image_id VARCHAR(255) NOT NULL,
+		PRIMARY KEY (repository, node, image_id)
+	)`,
+	`CREATE TABLE nodes (
+		address VARCHAR(255) PRIMARY KEY,
+		seq BIGINT NOT NULL,
+		creation_status TEXT NOT NULL,
+		ca_cert {{blob}},
+		client_cert {{blob}},
+		client_key {{blob}},
+		locked_until BIGINT NOT NULL,
+		is_failure BOOLEAN NOT NULL
+	)`,
+	`CREATE TABLE node_metadata (
+		address VARCHAR(255) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (address, name)
+	)`,
+	`CREATE INDEX node_metadata_name_value ON node_metadata (name, value)`,
+	`CREATE TABLE networks (
+		name VARCHAR(255) PRIMARY KEY,
+		definition TEXT NOT NULL
+	)`,
+	`CREATE TABLE network_nodes (
+		network VARCHAR(255) NOT NULL,
+		node VARCHAR(255) NOT NULL,
+		id TEXT NOT NULL,
+		seq BIGINT NOT NULL,
+		PRIMARY KEY (network, node)
+	)`,
+	`CREATE TABLE volumes (
+		name VARCHAR(255) PRIMARY KEY,
+		node TEXT NOT NULL,
+		definition TEXT NOT NULL
+	)`,
+	// seq keeps the image history in the order it was stored. Entries
+	// stored before it was added get 0, as their order is unknown.
+	`ALTER TABLE image_history ADD COLUMN seq BIGINT NOT NULL DEFAULT 0`,
+}
+
+func migrate(db *sql.DB, d *dialect) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	for i := current; i < len(migrations); i++ {
+		err = applyMigration(db, d, i+1, strings.Replace(migrations[i], "{{blob}}", d.blob, -1))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, d *dialect, version int, statement string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(statement)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(d.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,732 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sql provides a cluster.Storage backed by a PostgreSQL or SQLite
+// database, through database/sql.
+//
+// The schema is created and upgraded by migrations applied when the storage
+// is created. Node metadata is stored in its own table, indexed by name and
+// value, so RetrieveNodesByMetadata runs as an indexed query.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/docker-cluster/storage"
+)
+
+type dialect struct {
+	name          string
+	blob          string
+	numberedBinds bool
+}
+
+var (
+	postgresDialect = dialect{name: "postgres", blob: "BYTEA", numberedBinds: true}
+	sqliteDialect   = dialect{name: "sqlite", blob: "BLOB"}
+)
+
+// rebind replaces the ? placeholders of query by the ones of the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.numberedBinds {
+		return query
+	}
+	var buf strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+type sqlStorage struct {
+	db      *sql.DB
+	dialect *dialect
+}
+
+// SQL returns a storage backed by the database of the given driver, applying
+// pending schema migrations. The driver must be registered by the caller, by
+// importing it. Supported drivers are "postgres" and "pgx", for PostgreSQL,
+// and "sqlite3" and "sqlite", for SQLite.
+func SQL(driverName, dataSourceName string) (cluster.Storage, error) {
+	var d *dialect
+	switch driverName {
+	case "postgres", "pgx":
+		d = &postgresDialect
+	case "sqlite3", "sqlite":
+		d = &sqliteDialect
+	default:
+		return nil, fmt.Errorf("Unsupported SQL driver %q", driverName)
+	}
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if d == &sqliteDialect {
+		// SQLite allows a single writer, concurrent transactions would fail
+		// with "database is locked".
+		db.SetMaxOpenConns(1)
+	}
+	err = migrate(db, d)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStorage{db: db, dialect: d}, nil
+}
+
+func (s *sqlStorage) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStorage) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *sqlStorage) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// inTx runs fn in a transaction, committing it if fn returns nil.
+func (s *sqlStorage) inTx(ctx context.Context, fn func(tx *sqlTx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = fn(&sqlTx{ctx: ctx, tx: tx, dialect: s.dialect})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type sqlTx struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	dialect *dialect
+}
+
+func (t *sqlTx) exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(t.ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) queryRow(query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRowContext(t.ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *sqlTx) exists(query string, args ...interface{}) (bool, error) {
+	var one int
+	err := t.queryRow(query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func rowsAffected(result sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqlStorage) StoreContainer(ctx context.Context, container, host string) error {
+	_, err := s.exec(ctx, `INSERT INTO containers (id, host) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET host = excluded.host`, container, host)
+	return err
+}
+
+func (s *sqlStorage) RetrieveContainer(ctx context.Context, container string) (string, error) {
+	var host string
+	err := s.queryRow(ctx, `SELECT host FROM containers WHERE id = ?`, container).Scan(&host)
+	if err == sql.ErrNoRows {
+		return "", storage.ErrNoSuchContainer
+	}
+	return host, err
+}
+
+func (s *sqlStorage) RemoveContainer(ctx context.Context, container string) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		_, err := tx.exec(`DELETE FROM containers WHERE id = ?`, container)
+		if err != nil {
+			return err
+		}
+		_, err = tx.exec(`DELETE FROM execs WHERE container = ?`, container)
+		return err
+	})
+}
+
+func (s *sqlStorage) RetrieveContainers(ctx context.Context) ([]cluster.Container, error) {
+	rows, err := s.query(ctx, `SELECT id, host FROM containers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var containers []cluster.Container
+	for rows.Next() {
+		var c cluster.Container
+		if err := rows.Scan(&c.Id, &c.Host); err != nil {
+			return nil, err
+		}
+		containers = append(containers, c)
+	}
+	return containers, rows.Err()
+}
+
+func (s *sqlStorage) StoreImage(ctx context.Context, repo, id, host string) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		_, err := tx.exec(`INSERT INTO images (repository, last_node, last_id, last_digest) VALUES (?, ?, ?, '')
+			ON CONFLICT (repository) DO UPDATE SET last_node = excluded.last_node, last_id = excluded.last_id`,
+			repo, host, id)
+		if err != nil {
+			return err
+		}
+		var seq int64
+		err = tx.queryRow(`SELECT COALESCE(MAX(seq), 0) + 1 FROM image_history WHERE repository = ?`, repo).Scan(&seq)
+		if err != nil {
+			return err
+		}
+		_, err = tx.exec(`INSERT INTO image_history (repository, node, image_id, seq) VALUES (?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`, repo, host, id, seq)
+		return err
+	})
+}
+
+func (s *sqlStorage) SetImageDigest(ctx context.Context, repo, digest string) error {
+	_, err := s.exec(ctx, `INSERT INTO images (repository, last_node, last_id, last_digest) VALUES (?, '', '', ?)
+		ON CONFLICT (repository) DO UPDATE SET last_digest = excluded.last_digest`, repo, digest)
+	return err
+}
+
+func (s *sqlStorage) RetrieveImage(ctx context.Context, repo string) (cluster.Image, error) {
+	images, err := s.retrieveImages(ctx, repo)
+	if err != nil {
+		return cluster.Image{}, err
+	}
+	if len(images) == 0 || len(images[0].History) == 0 {
+		return cluster.Image{}, storage.ErrNoSuchImage
+	}
+	return images[0], nil
+}
+
+func (s *sqlStorage) RemoveImage(ctx context.Context, repo, id, host string) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		exists, err := tx.exists(`SELECT 1 FROM images WHERE repository = ?`, repo)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return storage.ErrNoSuchImage
+		}
+		_, err = tx.exec(`DELETE FROM image_history WHERE repository = ? AND node = ? AND image_id = ?`, repo, host, id)
+		return err
+	})
+}
+
+func (s *sqlStorage) RetrieveImages(ctx context.Context) ([]cluster.Image, error) {
+	return s.retrieveImages(ctx, "")
+}
+
+// retrieveImages returns the given image, or all of them if repo is empty.
+func (s *sqlStorage) retrieveImages(ctx context.Context, repo string) ([]cluster.Image, error) {
+	filter, args := "", []interface{}{}
+	if repo != "" {
+		filter, args = ` WHERE repository = ?`, []interface{}{repo}
+	}
+	rows, err := s.query(ctx, `SELECT repository, last_node, last_id, last_digest FROM images`+filter+` ORDER BY repository`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var images []cluster.Image
+	indexes := map[string]int{}
+	for rows.Next() {
+		image := cluster.Image{History: []cluster.ImageHistory{}}
+		if err := rows.Scan(&image.Repository, &image.LastNode, &image.LastId, &image.LastDigest); err != nil {
+			return nil, err
+		}
+		indexes[image.Repository] = len(images)
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = s.query(ctx, `SELECT repository, node, image_id FROM image_history`+filter+` ORDER BY repository, seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			repository string
+			entry      cluster.ImageHistory
+		)
+		if err := rows.Scan(&repository, &entry.Node, &entry.ImageId); err != nil {
+			return nil, err
+		}
+		if i, ok := indexes[repository]; ok {
+			images[i].History = append(images[i].History, entry)
+		}
+	}
+	return images, rows.Err()
+}
+
+func encodeTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func decodeTime(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec).UTC()
+}
+
+func (s *sqlStorage) StoreNode(ctx context.Context, node cluster.Node) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		var seq int64
+		err := tx.queryRow(`SELECT COALESCE(MAX(seq), 0) + 1 FROM nodes`).Scan(&seq)
+		if err != nil {
+			return err
+		}
+		inserted, err := rowsAffected(tx.exec(`INSERT INTO nodes
+			(address, seq, creation_status, ca_cert, client_cert, client_key, locked_until, is_failure)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (address) DO NOTHING`,
+			node.Address, seq, node.CreationStatus, node.CaCert, node.ClientCert, node.ClientKey,
+			encodeTime(node.Healing.LockedUntil), node.Healing.IsFailure))
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return storage.ErrDuplicatedNodeAddress
+		}
+		return tx.storeMetadata(node)
+	})
+}
+
+func (t *sqlTx) storeMetadata(node cluster.Node) error {
+	_, err := t.exec(`DELETE FROM node_metadata WHERE address = ?`, node.Address)
+	if err != nil {
+		return err
+	}
+	for name, value := range node.Metadata {
+		_, err = t.exec(`INSERT INTO node_metadata (address, name, value) VALUES (?, ?, ?)`, node.Address, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStorage) RetrieveNodes(ctx context.Context) ([]cluster.Node, error) {
+	return s.retrieveNodes(ctx, "")
+}
+
+func (s *sqlStorage) RetrieveNodesByMetadata(ctx context.Context, metadata map[string]string) ([]cluster.Node, error) {
+	if len(metadata) == 0 {
+		return s.retrieveNodes(ctx, "")
+	}
+	conditions := make([]string, 0, len(metadata))
+	args := make([]interface{}, 0, len(metadata)*2)
+	for name, value := range metadata {
+		conditions = append(conditions, `(name = ? AND value = ?)`)
+		args = append(args, name, value)
+	}
+	filter := fmt.Sprintf(` WHERE address IN (SELECT address FROM node_metadata WHERE %s GROUP BY address HAVING COUNT(*) = %d)`,
+		strings.Join(conditions, " OR "), len(metadata))
+	return s.retrieveNodes(ctx, filter, args...)
+}
+
+// retrieveNodes returns the nodes matching filter, a WHERE clause on the
+// address, in the order they were stored.
+func (s *sqlStorage) retrieveNodes(ctx context.Context, filter string, args ...interface{}) ([]cluster.Node, error) {
+	rows, err := s.query(ctx, `SELECT address, creation_status, ca_cert, client_cert, client_key, locked_until, is_failure
+		FROM nodes`+filter+` ORDER BY seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	nodes := []cluster.Node{}
+	indexes := map[string]int{}
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, err
+		}
+		indexes[node.Address] = len(nodes)
+		nodes = append(nodes, node)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = s.query(ctx, `SELECT address, name, value FROM node_metadata`+filter, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var address, name, value string
+		if err := rows.Scan(&address, &name, &value); err != nil {
+			return nil, err
+		}
+		if i, ok := indexes[address]; ok {
+			nodes[i].Metadata[name] = value
+		}
+	}
+	return nodes, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNode(row scanner) (cluster.Node, error) {
+	node := cluster.Node{Metadata: map[string]string{}}
+	var lockedUntil int64
+	err := row.Scan(&node.Address, &node.CreationStatus, &node.CaCert, &node.ClientCert, &node.ClientKey,
+		&lockedUntil, &node.Healing.IsFailure)
+	node.Healing.LockedUntil = decodeTime(lockedUntil)
+	return node, err
+}
+
+func (s *sqlStorage) RetrieveNode(ctx context.Context, address string) (cluster.Node, error) {
+	nodes, err := s.retrieveNodes(ctx, ` WHERE address = ?`, address)
+	if err != nil {
+		return cluster.Node{}, err
+	}
+	if len(nodes) == 0 {
+		return cluster.Node{}, storage.ErrNoSuchNode
+	}
+	return nodes[0], nil
+}
+
+func (s *sqlStorage) UpdateNode(ctx context.Context, node cluster.Node) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		updated, err := rowsAffected(tx.exec(`UPDATE nodes SET creation_status = ?, ca_cert = ?, client_cert = ?,
+			client_key = ?, locked_until = ?, is_failure = ? WHERE address = ?`,
+			node.CreationStatus, node.CaCert, node.ClientCert, node.ClientKey,
+			encodeTime(node.Healing.LockedUntil), node.Healing.IsFailure, node.Address))
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return storage.ErrNoSuchNode
+		}
+		return tx.storeMetadata(node)
+	})
+}
+
+func (s *sqlStorage) RemoveNode(ctx context.Context, address string) error {
+	return s.RemoveNodes(ctx, []string{address})
+}
+
+func (s *sqlStorage) RemoveNodes(ctx context.Context, addresses []string) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		var removed int64
+		for _, address := range addresses {
+			n, err := rowsAffected(tx.exec(`DELETE FROM nodes WHERE address = ?`, address))
+			if err != nil {
+				return err
+			}
+			_, err = tx.exec(`DELETE FROM node_metadata WHERE address = ?`, address)
+			if err != nil {
+				return err
+			}
+			removed += n
+		}
+		if removed == 0 {
+			return storage.ErrNoSuchNode
+		}
+		return nil
+	})
+}
+
+func (s *sqlStorage) LockNodeForHealing(ctx context.Context, address string, isFailure bool, timeout time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	locked, err := rowsAffected(s.exec(ctx, `UPDATE nodes SET locked_until = ?, is_failure = ?
+		WHERE address = ? AND locked_until <= ?`,
+		encodeTime(now.Add(timeout)), isFailure, address, encodeTime(now)))
+	if err != nil {
+		return false, err
+	}
+	if locked == 0 {
+		_, err = s.RetrieveNode(ctx, address)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *sqlStorage) ExtendNodeLock(ctx context.Context, address string, timeout time.Duration) error {
+	return s.setNodeLock(ctx, `UPDATE nodes SET locked_until = ? WHERE address = ?`,
+		encodeTime(time.Now().UTC().Add(timeout)), address)
+}
+
+func (s *sqlStorage) UnlockNode(ctx context.Context, address string) error {
+	return s.setNodeLock(ctx, `UPDATE nodes SET locked_until = 0, is_failure = ? WHERE address = ?`, false, address)
+}
+
+func (s *sqlStorage) setNodeLock(ctx context.Context, query string, args ...interface{}) error {
+	updated, err := rowsAffected(s.exec(ctx, query, args...))
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return storage.ErrNoSuchNode
+	}
+	return nil
+}
+
+func (s *sqlStorage) StoreExec(ctx context.Context, execID, containerID string) error {
+	_, err := s.exec(ctx, `INSERT INTO execs (id, container) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET container = excluded.container`, execID, containerID)
+	return err
+}
+
+func (s *sqlStorage) RetrieveExec(ctx context.Context, execID string) (string, error) {
+	var containerID string
+	err := s.queryRow(ctx, `SELECT container FROM execs WHERE id = ?`, execID).Scan(&containerID)
+	if err == sql.ErrNoRows {
+		return "", storage.ErrNoSuchExec
+	}
+	return containerID, err
+}
+
+func (s *sqlStorage) RetrieveExecs(ctx context.Context) ([]cluster.Exec, error) {
+	rows, err := s.query(ctx, `SELECT id, container FROM execs`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var execs []cluster.Exec
+	for rows.Next() {
+		var e cluster.Exec
+		if err := rows.Scan(&e.Id, &e.Container); err != nil {
+			return nil, err
+		}
+		execs = append(execs, e)
+	}
+	return execs, rows.Err()
+}
+
+func (s *sqlStorage) StoreNetwork(ctx context.Context, network cluster.Network) error {
+	nodes := network.Nodes
+	network.Nodes = nil
+	definition, err := json.Marshal(network)
+	if err != nil {
+		return err
+	}
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		inserted, err := rowsAffected(tx.exec(`INSERT INTO networks (name, definition) VALUES (?, ?)
+			ON CONFLICT (name) DO NOTHING`, network.Name, string(definition)))
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return storage.ErrDuplicatedNetwork
+		}
+		for i, nn := range nodes {
+			_, err = tx.exec(`INSERT INTO network_nodes (network, node, id, seq) VALUES (?, ?, ?, ?)`,
+				network.Name, nn.Node, nn.ID, i+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStorage) RetrieveNetwork(ctx context.Context, name string) (cluster.Network, error) {
+	networks, err := s.retrieveNetworks(ctx, name)
+	if err != nil {
+		return cluster.Network{}, err
+	}
+	if len(networks) == 0 {
+		return cluster.Network{}, storage.ErrNoSuchNetwork
+	}
+	return networks[0], nil
+}
+
+func (s *sqlStorage) RetrieveNetworks(ctx context.Context) ([]cluster.Network, error) {
+	return s.retrieveNetworks(ctx, "")
+}
+
+// retrieveNetworks returns the given network, or all of them if name is
+// empty.
+func (s *sqlStorage) retrieveNetworks(ctx context.Context, name string) ([]cluster.Network, error) {
+	var filter, nodesFilter string
+	var args []interface{}
+	if name != "" {
+		filter, nodesFilter, args = ` WHERE name = ?`, ` WHERE network = ?`, []interface{}{name}
+	}
+	rows, err := s.query(ctx, `SELECT definition FROM networks`+filter+` ORDER BY name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	networks := []cluster.Network{}
+	indexes := map[string]int{}
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			return nil, err
+		}
+		var network cluster.Network
+		if err := json.Unmarshal([]byte(definition), &network); err != nil {
+			return nil, err
+		}
+		indexes[network.Name] = len(networks)
+		networks = append(networks, network)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = s.query(ctx, `SELECT network, node, id FROM network_nodes`+nodesFilter+` ORDER BY network, seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var network string
+		var nn cluster.NetworkNode
+		if err := rows.Scan(&network, &nn.Node, &nn.ID); err != nil {
+			return nil, err
+		}
+		if i, ok := indexes[network]; ok {
+			networks[i].Nodes = append(networks[i].Nodes, nn)
+		}
+	}
+	return networks, rows.Err()
+}
+
+func (s *sqlStorage) RemoveNetwork(ctx context.Context, name string) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		_, err := tx.exec(`DELETE FROM network_nodes WHERE network = ?`, name)
+		if err != nil {
+			return err
+		}
+		removed, err := rowsAffected(tx.exec(`DELETE FROM networks WHERE name = ?`, name))
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return storage.ErrNoSuchNetwork
+		}
+		return nil
+	})
+}
+
+func (s *sqlStorage) AddNetworkNode(ctx context.Context, name, id, host string) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		exists, err := tx.exists(`SELECT 1 FROM networks WHERE name = ?`, name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return storage.ErrNoSuchNetwork
+		}
+		updated, err := rowsAffected(tx.exec(`UPDATE network_nodes SET id = ? WHERE network = ? AND node = ?`, id, name, host))
+		if err != nil || updated > 0 {
+			return err
+		}
+		var seq int64
+		err = tx.queryRow(`SELECT COALESCE(MAX(seq), 0) + 1 FROM network_nodes WHERE network = ?`, name).Scan(&seq)
+		if err != nil {
+			return err
+		}
+		_, err = tx.exec(`INSERT INTO network_nodes (network, node, id, seq) VALUES (?, ?, ?, ?)`, name, host, id, seq)
+		return err
+	})
+}
+
+func (s *sqlStorage) RemoveNetworkNode(ctx context.Context, name, host string) error {
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		exists, err := tx.exists(`SELECT 1 FROM networks WHERE name = ?`, name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return storage.ErrNoSuchNetwork
+		}
+		_, err = tx.exec(`DELETE FROM network_nodes WHERE network = ? AND node = ?`, name, host)
+		return err
+	})
+}
+
+func (s *sqlStorage) StoreVolume(ctx context.Context, volume cluster.Volume) error {
+	definition, err := json.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	inserted, err := rowsAffected(s.exec(ctx, `INSERT INTO volumes (name, node, definition) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO NOTHING`, volume.Name, volume.Node, string(definition)))
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return storage.ErrDuplicatedVolume
+	}
+	return nil
+}
+
+func (s *sqlStorage) RetrieveVolume(ctx context.Context, name string) (cluster.Volume, error) {
+	var definition string
+	err := s.queryRow(ctx, `SELECT definition FROM volumes WHERE name = ?`, name).Scan(&definition)
+	if err == sql.ErrNoRows {
+		return cluster.Volume{}, storage.ErrNoSuchVolume
+	}
+	if err != nil {
+		return cluster.Volume{}, err
+	}
+	var volume cluster.Volume
+	err = json.Unmarshal([]byte(definition), &volume)
+	return volume, err
+}
+
+func (s *sqlStorage) RetrieveVolumes(ctx context.Context) ([]cluster.Volume, error) {
+	rows, err := s.query(ctx, `SELECT definition FROM volumes ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	volumes := []cluster.Volume{}
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			return nil, err
+		}
+		var volume cluster.Volume
+		if err := json.Unmarshal([]byte(definition), &volume); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, rows.Err()
+}
+
+func (s *sqlStorage) RemoveVolume(ctx context.Context, name string) error {
+	removed, err := rowsAffected(s.exec(ctx, `DELETE FROM volumes WHERE name = ?`, name))
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return storage.ErrNoSuchVolume
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 docker-cluster authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sql
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tsuru/docker-cluster/cluster"
+	storageTesting "github.com/tsuru/docker-cluster/storage/testing"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-cluster-sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stor, err := SQL("sqlite3", filepath.Join(dir, "cluster.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageTesting.RunTestsForStorage(stor, t)
+}
+
+func TestSQLiteStorageMigrationsAreIdempotent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-cluster-sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cluster.db")
+	stor, err := SQL("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stor.StoreContainer(context.Background(), "cont1", "http://n1:4243")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stor.(*sqlStorage).db.Close()
+	stor, err = SQL("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	err = stor.(*sqlStorage).db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("expected schema version %d, got %d", len(migrations), version)
+	}
+	host, err := stor.RetrieveContainer(context.Background(), "cont1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "http://n1:4243" {
+		t.Errorf("RetrieveContainer: want %q, got %q", "http://n1:4243", host)
+	}
+}
+
+func TestSQLiteStorageCanceledContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-cluster-sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stor, err := SQL("sqlite3", filepath.Join(dir, "cluster.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = stor.StoreContainer(ctx, "cont1", "http://n1:4243")
+	if err != context.Canceled {
+		t.Errorf("StoreContainer: want %#v, got %#v", context.Canceled, err)
+	}
+	err = stor.StoreNode(ctx, cluster.Node{Address: "http://n1:4243"})
+	if err != context.Canceled {
+		t.Errorf("StoreNode: want %#v, got %#v", context.Canceled, err)
+	}
+	containers, err := stor.RetrieveContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 0 {
+		t.Errorf("RetrieveContainers: expected no containers, got %#v", containers)
+	}
+}
+
+// TestPostgresStorage runs against the database in DOCKER_CLUSTER_POSTGRES,
+// which is dropped and recreated, e.g.
+// "postgres://localhost/test_docker_cluster?sslmode=disable".
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("DOCKER_CLUSTER_POSTGRES")
+	if dsn == "" {
+		t.Skip("DOCKER_CLUSTER_POSTGRES not set")
+	}
+	stor, err := SQL("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := stor.(*sqlStorage).db
+	_, err = db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrate(db, &postgresDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageTesting.RunTestsForStorage(stor, t)
+}
+
+func TestSQLUnsupportedDriver(t *testing.T) {
+	_, err := SQL("mysql", "")
+	if err == nil {
+		t.Fatal("Expected non-nil error, got <nil>")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := `SELECT a FROM t WHERE b = ? AND c = ?`
+	if q := postgresDialect.rebind(query); q != `SELECT a FROM t WHERE b = $1 AND c = $2` {
+		t.Errorf("rebind: wrong postgres query %q", q)
+	}
+	if q := sqliteDialect.rebind(query); q != query {
+		t.Errorf("rebind: wrong sqlite query %q", q)
+	}
+}
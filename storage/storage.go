@@ -15,5 +15,9 @@ var (
 	ErrNoSuchContainer       = errors.New("No such container in storage")
 	ErrNoSuchImage           = errors.New("No such image in storage")
 	ErrNoSuchExec            = errors.New("No such exec in storage")
+	ErrNoSuchNetwork         = errors.New("No such network in storage")
+	ErrNoSuchVolume          = errors.New("No such volume in storage")
 	ErrDuplicatedNodeAddress = errors.New("Node address shouldn't repeat")
+	ErrDuplicatedNetwork     = errors.New("Network name shouldn't repeat")
+	ErrDuplicatedVolume      = errors.New("Volume name shouldn't repeat")
 )
@@ -5,6 +5,7 @@
 package testing
 
 import (
+	"context"
 	"reflect"
 	"runtime"
 	"runtime/debug"
@@ -14,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/docker-cluster/cluster"
 	cstorage "github.com/tsuru/docker-cluster/storage"
 )
@@ -26,26 +28,26 @@ func assertIsNil(val interface{}, t *testing.T) {
 }
 
 func testStorageStoreRetrieveContainer(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveContainer("container-1")
-	defer storage.RemoveContainer("container-2")
-	defer storage.RemoveContainer("container-3")
-	err := storage.StoreContainer("container-1", "host-1")
+	defer storage.RemoveContainer(context.Background(), "container-1")
+	defer storage.RemoveContainer(context.Background(), "container-2")
+	defer storage.RemoveContainer(context.Background(), "container-3")
+	err := storage.StoreContainer(context.Background(), "container-1", "host-1")
 	assertIsNil(err, t)
-	err = storage.StoreContainer("container-2", "host-1")
+	err = storage.StoreContainer(context.Background(), "container-2", "host-1")
 	assertIsNil(err, t)
-	err = storage.StoreContainer("container-3", "host-2")
+	err = storage.StoreContainer(context.Background(), "container-3", "host-2")
 	assertIsNil(err, t)
-	host, err := storage.RetrieveContainer("container-1")
+	host, err := storage.RetrieveContainer(context.Background(), "container-1")
 	assertIsNil(err, t)
 	if host != "host-1" {
 		t.Errorf("Unexpected hostname %s - expected %s", host, "host-1")
 	}
-	host, err = storage.RetrieveContainer("container-2")
+	host, err = storage.RetrieveContainer(context.Background(), "container-2")
 	assertIsNil(err, t)
 	if host != "host-1" {
 		t.Errorf("Unexpected hostname %s - expected %s", host, "host-1")
 	}
-	host, err = storage.RetrieveContainer("container-3")
+	host, err = storage.RetrieveContainer(context.Background(), "container-3")
 	assertIsNil(err, t)
 	if host != "host-2" {
 		t.Errorf("Unexpected hostname %s - expected %s", host, "host-2")
@@ -53,11 +55,11 @@ func testStorageStoreRetrieveContainer(storage cluster.Storage, t *testing.T) {
 }
 
 func testStorageStoreRemoveContainer(storage cluster.Storage, t *testing.T) {
-	err := storage.StoreContainer("container-1", "host-9")
+	err := storage.StoreContainer(context.Background(), "container-1", "host-9")
 	assertIsNil(err, t)
-	err = storage.RemoveContainer("container-1")
+	err = storage.RemoveContainer(context.Background(), "container-1")
 	assertIsNil(err, t)
-	_, err = storage.RetrieveContainer("container-1")
+	_, err = storage.RetrieveContainer(context.Background(), "container-1")
 	if err != cstorage.ErrNoSuchContainer {
 		t.Errorf("Error should be cstorage.ErrNoSuchContainer, received: %s", err)
 	}
@@ -84,14 +86,14 @@ func compareImage(img, expected cluster.Image, t *testing.T) {
 }
 
 func testStorageStoreRetrieveImage(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveImage("img-1", "id1", "host-1.something")
-	defer storage.RemoveImage("img-1", "id1", "host-2")
-	defer storage.RemoveImage("img-1", "id2", "host-2")
-	err := storage.StoreImage("img-1", "id1", "host-1.something")
+	defer storage.RemoveImage(context.Background(), "img-1", "id1", "host-1.something")
+	defer storage.RemoveImage(context.Background(), "img-1", "id1", "host-2")
+	defer storage.RemoveImage(context.Background(), "img-1", "id2", "host-2")
+	err := storage.StoreImage(context.Background(), "img-1", "id1", "host-1.something")
 	assertIsNil(err, t)
-	err = storage.StoreImage("img-1", "id1", "host-2")
+	err = storage.StoreImage(context.Background(), "img-1", "id1", "host-2")
 	assertIsNil(err, t)
-	img, err := storage.RetrieveImage("img-1")
+	img, err := storage.RetrieveImage(context.Background(), "img-1")
 	assertIsNil(err, t)
 	expected := cluster.Image{Repository: "img-1", LastId: "id1", LastNode: "host-2", History: []cluster.ImageHistory{{
 		Node:    "host-1.something",
@@ -101,22 +103,50 @@ func testStorageStoreRetrieveImage(storage cluster.Storage, t *testing.T) {
 		ImageId: "id1",
 	}}}
 	compareImage(img, expected, t)
-	err = storage.StoreImage("img-1", "id2", "host-2")
+	err = storage.StoreImage(context.Background(), "img-1", "id2", "host-2")
 	assertIsNil(err, t)
 	expected.History = append(expected.History, cluster.ImageHistory{Node: "host-2", ImageId: "id2"})
 	expected.LastId = "id2"
-	img, err = storage.RetrieveImage("img-1")
+	img, err = storage.RetrieveImage(context.Background(), "img-1")
 	assertIsNil(err, t)
 	compareImage(img, expected, t)
 }
 
+func testStorageImageHistoryOrder(storage cluster.Storage, t *testing.T) {
+	defer storage.RemoveImage(context.Background(), "img-1", "id3", "host-1")
+	defer storage.RemoveImage(context.Background(), "img-1", "id1", "host-1")
+	defer storage.RemoveImage(context.Background(), "img-1", "id2", "host-1")
+	defer storage.RemoveImage(context.Background(), "img-1", "id1", "host-2")
+	for _, entry := range []cluster.ImageHistory{
+		{Node: "host-1", ImageId: "id3"},
+		{Node: "host-1", ImageId: "id1"},
+		{Node: "host-1", ImageId: "id2"},
+		{Node: "host-2", ImageId: "id1"},
+		{Node: "host-1", ImageId: "id3"},
+	} {
+		err := storage.StoreImage(context.Background(), "img-1", entry.ImageId, entry.Node)
+		assertIsNil(err, t)
+	}
+	img, err := storage.RetrieveImage(context.Background(), "img-1")
+	assertIsNil(err, t)
+	expected := []cluster.ImageHistory{
+		{Node: "host-1", ImageId: "id3"},
+		{Node: "host-1", ImageId: "id1"},
+		{Node: "host-1", ImageId: "id2"},
+		{Node: "host-2", ImageId: "id1"},
+	}
+	if !reflect.DeepEqual(img.History, expected) {
+		t.Errorf("unexpected history order:\ngot: %#v\nexp: %#v", img.History, expected)
+	}
+}
+
 func testStorageSetImageDigest(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveImage("img-y", "id5", "host-1.something")
-	err := storage.StoreImage("img-y", "id5", "host-1.something")
+	defer storage.RemoveImage(context.Background(), "img-y", "id5", "host-1.something")
+	err := storage.StoreImage(context.Background(), "img-y", "id5", "host-1.something")
 	assertIsNil(err, t)
-	err = storage.SetImageDigest("img-y", "digest")
+	err = storage.SetImageDigest(context.Background(), "img-y", "digest")
 	assertIsNil(err, t)
-	img, err := storage.RetrieveImage("img-y")
+	img, err := storage.RetrieveImage(context.Background(), "img-y")
 	assertIsNil(err, t)
 	expected := cluster.Image{
 		Repository: "img-y",
@@ -132,12 +162,12 @@ func testStorageSetImageDigest(storage cluster.Storage, t *testing.T) {
 }
 
 func testStorageStoreImageIgnoreDups(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveImage("img-x", "id1", "host-1")
-	err := storage.StoreImage("img-x", "id1", "host-1")
+	defer storage.RemoveImage(context.Background(), "img-x", "id1", "host-1")
+	err := storage.StoreImage(context.Background(), "img-x", "id1", "host-1")
 	assertIsNil(err, t)
-	err = storage.StoreImage("img-x", "id1", "host-1")
+	err = storage.StoreImage(context.Background(), "img-x", "id1", "host-1")
 	assertIsNil(err, t)
-	img, err := storage.RetrieveImage("img-x")
+	img, err := storage.RetrieveImage(context.Background(), "img-x")
 	assertIsNil(err, t)
 	expected := cluster.Image{Repository: "img-x", LastId: "id1", LastNode: "host-1", History: []cluster.ImageHistory{{
 		Node:    "host-1",
@@ -147,11 +177,11 @@ func testStorageStoreImageIgnoreDups(storage cluster.Storage, t *testing.T) {
 }
 
 func testStorageStoreRemoveImage(storage cluster.Storage, t *testing.T) {
-	err := storage.StoreImage("img-1", "id1", "host-1")
+	err := storage.StoreImage(context.Background(), "img-1", "id1", "host-1")
 	assertIsNil(err, t)
-	err = storage.StoreImage("img-1", "id1", "host-2")
+	err = storage.StoreImage(context.Background(), "img-1", "id1", "host-2")
 	assertIsNil(err, t)
-	err = storage.StoreImage("img-1", "id2", "host-2")
+	err = storage.StoreImage(context.Background(), "img-1", "id2", "host-2")
 	assertIsNil(err, t)
 	expected := cluster.Image{Repository: "img-1", LastId: "id2", LastNode: "host-2", History: []cluster.ImageHistory{{
 		Node:    "host-1",
@@ -163,10 +193,10 @@ func testStorageStoreRemoveImage(storage cluster.Storage, t *testing.T) {
 		Node:    "host-2",
 		ImageId: "id2",
 	}}}
-	img, err := storage.RetrieveImage("img-1")
+	img, err := storage.RetrieveImage(context.Background(), "img-1")
 	assertIsNil(err, t)
 	compareImage(img, expected, t)
-	err = storage.RemoveImage("img-1", "id1", "host-1")
+	err = storage.RemoveImage(context.Background(), "img-1", "id1", "host-1")
 	assertIsNil(err, t)
 	expected.History = []cluster.ImageHistory{{
 		Node:    "host-2",
@@ -175,20 +205,20 @@ func testStorageStoreRemoveImage(storage cluster.Storage, t *testing.T) {
 		Node:    "host-2",
 		ImageId: "id2",
 	}}
-	img, err = storage.RetrieveImage("img-1")
+	img, err = storage.RetrieveImage(context.Background(), "img-1")
 	assertIsNil(err, t)
 	compareImage(img, expected, t)
-	err = storage.RemoveImage("img-1", "id1", "host-2")
+	err = storage.RemoveImage(context.Background(), "img-1", "id1", "host-2")
 	assertIsNil(err, t)
 	expected.History = []cluster.ImageHistory{{
 		Node:    "host-2",
 		ImageId: "id2",
 	}}
-	img, err = storage.RetrieveImage("img-1")
+	img, err = storage.RetrieveImage(context.Background(), "img-1")
 	assertIsNil(err, t)
 	compareImage(img, expected, t)
-	err = storage.RemoveImage("img-1", "id2", "host-2")
-	_, err = storage.RetrieveImage("img-1")
+	err = storage.RemoveImage(context.Background(), "img-1", "id2", "host-2")
+	_, err = storage.RetrieveImage(context.Background(), "img-1")
 	if err != cstorage.ErrNoSuchImage {
 		t.Fatalf("Expected error to be ErrNoSuchImage, got %s", err)
 	}
@@ -196,14 +226,14 @@ func testStorageStoreRemoveImage(storage cluster.Storage, t *testing.T) {
 
 func testStorageStoreRetrieveNodes(storage cluster.Storage, t *testing.T) {
 	node1 := cluster.Node{Address: "my-addr-1"}
-	defer storage.RemoveNode("my-addr-1")
-	err := storage.StoreNode(node1)
+	defer storage.RemoveNode(context.Background(), "my-addr-1")
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
 	node2 := cluster.Node{Address: "my-addr-2", Metadata: map[string]string{"foo": "bar"}}
-	defer storage.RemoveNode("my-addr-2")
-	err = storage.StoreNode(node2)
+	defer storage.RemoveNode(context.Background(), "my-addr-2")
+	err = storage.StoreNode(context.Background(), node2)
 	assertIsNil(err, t)
-	nodes, err := storage.RetrieveNodes()
+	nodes, err := storage.RetrieveNodes(context.Background())
 	assertIsNil(err, t)
 	if len(nodes) != 2 {
 		t.Fatalf("unexpected number of nodes, expected 2, got: %d", len(nodes))
@@ -228,15 +258,15 @@ func testStorageStoreRetrieveNode(storage cluster.Storage, t *testing.T) {
 		ClientCert: []byte("client cert"),
 		ClientKey:  []byte("client key"),
 	}
-	defer storage.RemoveNode("my-addr-1")
-	err := storage.StoreNode(node1)
+	defer storage.RemoveNode(context.Background(), "my-addr-1")
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
-	nd, err := storage.RetrieveNode("my-addr-1")
+	nd, err := storage.RetrieveNode(context.Background(), "my-addr-1")
 	assertIsNil(err, t)
 	if !reflect.DeepEqual(nd, node1) {
 		t.Errorf("unexpected node, expected: %#v, got: %#v", node1, nd)
 	}
-	_, err = storage.RetrieveNode("my-addr-xxxx")
+	_, err = storage.RetrieveNode(context.Background(), "my-addr-xxxx")
 	if err != cstorage.ErrNoSuchNode {
 		t.Errorf("Expected ErrNoSuchNode got: %#v", err)
 	}
@@ -250,30 +280,30 @@ func testStorageStoreUpdateNode(storage cluster.Storage, t *testing.T) {
 		ClientCert: []byte("client cert"),
 		ClientKey:  []byte("client key"),
 	}
-	defer storage.RemoveNode("my-addr-1")
-	err := storage.StoreNode(node1)
+	defer storage.RemoveNode(context.Background(), "my-addr-1")
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
 	delete(node1.Metadata, "x")
 	node1.Metadata["ahoy"] = "foo"
-	err = storage.UpdateNode(node1)
+	err = storage.UpdateNode(context.Background(), node1)
 	assertIsNil(err, t)
-	nd, err := storage.RetrieveNode("my-addr-1")
+	nd, err := storage.RetrieveNode(context.Background(), "my-addr-1")
 	if !reflect.DeepEqual(nd, node1) {
 		t.Errorf("unexpected node, expected: %#v, got: %#v", node1, nd)
 	}
 	node1.Address = "my-addr-xxxxxx"
-	err = storage.UpdateNode(node1)
+	err = storage.UpdateNode(context.Background(), node1)
 	if err != cstorage.ErrNoSuchNode {
 		t.Errorf("Expected ErrNoSuchNode got: %#v", err)
 	}
 }
 
 func testStorageStoreEmptyMetadata(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveNode("my-addr-1")
+	defer storage.RemoveNode(context.Background(), "my-addr-1")
 	node1 := cluster.Node{Address: "my-addr-1", Metadata: map[string]string{}}
-	err := storage.StoreNode(node1)
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
-	nodes, err := storage.RetrieveNodes()
+	nodes, err := storage.RetrieveNodes(context.Background())
 	assertIsNil(err, t)
 	if len(nodes) != 1 || nodes[0].Address != node1.Address {
 		t.Errorf("unexpected nodes: %#v", nodes)
@@ -281,24 +311,24 @@ func testStorageStoreEmptyMetadata(storage cluster.Storage, t *testing.T) {
 }
 
 func testStorageStoreRepeatedNodes(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveNode("my-addr-1")
-	err := storage.StoreNode(cluster.Node{Address: "my-addr-1"})
+	defer storage.RemoveNode(context.Background(), "my-addr-1")
+	err := storage.StoreNode(context.Background(), cluster.Node{Address: "my-addr-1"})
 	assertIsNil(err, t)
-	err = storage.StoreNode(cluster.Node{Address: "my-addr-1"})
+	err = storage.StoreNode(context.Background(), cluster.Node{Address: "my-addr-1"})
 	if err != cstorage.ErrDuplicatedNodeAddress {
 		t.Fatalf("Expected error cstorage.ErrDuplicatedNodeAddress, got: %#v", err)
 	}
 }
 
 func testStorageStoreClearMetadata(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveNode("my-addr-1")
-	err := storage.StoreNode(cluster.Node{Address: "my-addr-1", Metadata: map[string]string{"pool": "p1"}})
+	defer storage.RemoveNode(context.Background(), "my-addr-1")
+	err := storage.StoreNode(context.Background(), cluster.Node{Address: "my-addr-1", Metadata: map[string]string{"pool": "p1"}})
 	assertIsNil(err, t)
-	err = storage.RemoveNode("my-addr-1")
+	err = storage.RemoveNode(context.Background(), "my-addr-1")
 	assertIsNil(err, t)
-	err = storage.StoreNode(cluster.Node{Address: "my-addr-1"})
+	err = storage.StoreNode(context.Background(), cluster.Node{Address: "my-addr-1"})
 	assertIsNil(err, t)
-	nodes, err := storage.RetrieveNodes()
+	nodes, err := storage.RetrieveNodes(context.Background())
 	assertIsNil(err, t)
 	if len(nodes) != 1 || nodes[0].Address != "my-addr-1" {
 		t.Errorf("unexpected nodes: %#v", nodes)
@@ -313,17 +343,17 @@ func testStorageStoreRetrieveNodesForMetadata(storage cluster.Storage, t *testin
 		"region": "reg1",
 		"foo":    "bar",
 	}}
-	err := storage.StoreNode(node1)
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
-	defer storage.RemoveNode("my-addr-1")
+	defer storage.RemoveNode(context.Background(), "my-addr-1")
 	node2 := cluster.Node{Address: "my-addr-2", Metadata: map[string]string{
 		"region": "reg2",
 		"foo":    "bar",
 	}}
-	err = storage.StoreNode(node2)
+	err = storage.StoreNode(context.Background(), node2)
 	assertIsNil(err, t)
-	defer storage.RemoveNode("my-addr-2")
-	nodes, err := storage.RetrieveNodesByMetadata(map[string]string{"region": "reg2"})
+	defer storage.RemoveNode(context.Background(), "my-addr-2")
+	nodes, err := storage.RetrieveNodesByMetadata(context.Background(), map[string]string{"region": "reg2"})
 	assertIsNil(err, t)
 	if len(nodes) != 1 {
 		t.Fatalf("unexpected nodes len: %d", len(nodes))
@@ -334,7 +364,7 @@ func testStorageStoreRetrieveNodesForMetadata(storage cluster.Storage, t *testin
 	if !reflect.DeepEqual(node2.Metadata, nodes[0].Metadata) {
 		t.Errorf("unexpected node metadata. expected: %#v got: %#v", node2.Metadata, nodes[0].Metadata)
 	}
-	nodes, err = storage.RetrieveNodesByMetadata(map[string]string{"foo": "bar"})
+	nodes, err = storage.RetrieveNodesByMetadata(context.Background(), map[string]string{"foo": "bar"})
 	assertIsNil(err, t)
 	if len(nodes) != 2 {
 		t.Fatalf("unexpected nodes len: %d", len(nodes))
@@ -349,7 +379,7 @@ func testStorageStoreRetrieveNodesForMetadata(storage cluster.Storage, t *testin
 	if !reflect.DeepEqual(node2.Metadata, nodes[1].Metadata) {
 		t.Errorf("unexpected node metadata. expected: %#v got: %#v", node2.Metadata, nodes[1].Metadata)
 	}
-	nodes, err = storage.RetrieveNodesByMetadata(map[string]string{"foo": "bar", "region": "reg1"})
+	nodes, err = storage.RetrieveNodesByMetadata(context.Background(), map[string]string{"foo": "bar", "region": "reg1"})
 	assertIsNil(err, t)
 	if len(nodes) != 1 {
 		t.Fatalf("unexpected nodes len: %d", len(nodes))
@@ -360,7 +390,7 @@ func testStorageStoreRetrieveNodesForMetadata(storage cluster.Storage, t *testin
 	if !reflect.DeepEqual(node1.Metadata, nodes[0].Metadata) {
 		t.Errorf("unexpected node metadata. expected: %#v got: %#v", node1.Metadata, nodes[0].Metadata)
 	}
-	nodes, err = storage.RetrieveNodesByMetadata(map[string]string{"foo": "bar", "region": "reg3"})
+	nodes, err = storage.RetrieveNodesByMetadata(context.Background(), map[string]string{"foo": "bar", "region": "reg3"})
 	assertIsNil(err, t)
 	if len(nodes) != 0 {
 		t.Fatalf("unexpected nodes len: %d", len(nodes))
@@ -369,15 +399,15 @@ func testStorageStoreRetrieveNodesForMetadata(storage cluster.Storage, t *testin
 
 func testStorageStoreRemoveNode(storage cluster.Storage, t *testing.T) {
 	node1 := cluster.Node{Address: "my-addr-1"}
-	err := storage.StoreNode(node1)
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
-	err = storage.RemoveNode("my-addr-1")
+	err = storage.RemoveNode(context.Background(), "my-addr-1")
 	assertIsNil(err, t)
-	err = storage.RemoveNode("my-addr-1")
+	err = storage.RemoveNode(context.Background(), "my-addr-1")
 	if err != cstorage.ErrNoSuchNode {
 		t.Errorf("cstorage.ErrNoSuchNode was expected, got: %s", err)
 	}
-	nodes, err := storage.RetrieveNodes()
+	nodes, err := storage.RetrieveNodes(context.Background())
 	assertIsNil(err, t)
 	if len(nodes) > 0 {
 		t.Errorf("nodes should be empty, found: %#v", nodes)
@@ -386,18 +416,18 @@ func testStorageStoreRemoveNode(storage cluster.Storage, t *testing.T) {
 
 func testStorageStoreRemoveNodes(storage cluster.Storage, t *testing.T) {
 	node1 := cluster.Node{Address: "my-addr-1"}
-	err := storage.StoreNode(node1)
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
 	node2 := cluster.Node{Address: "my-addr-2"}
-	err = storage.StoreNode(node2)
+	err = storage.StoreNode(context.Background(), node2)
 	assertIsNil(err, t)
-	err = storage.RemoveNodes([]string{"my-addr-1", "my-addr-2", "my-addr-3"})
+	err = storage.RemoveNodes(context.Background(), []string{"my-addr-1", "my-addr-2", "my-addr-3"})
 	assertIsNil(err, t)
-	err = storage.RemoveNodes([]string{"my-addr-1", "my-addr-2"})
+	err = storage.RemoveNodes(context.Background(), []string{"my-addr-1", "my-addr-2"})
 	if err != cstorage.ErrNoSuchNode {
 		t.Errorf("cstorage.ErrNoSuchNode was expected, got: %s", err)
 	}
-	nodes, err := storage.RetrieveNodes()
+	nodes, err := storage.RetrieveNodes(context.Background())
 	assertIsNil(err, t)
 	if len(nodes) > 0 {
 		t.Errorf("nodes should be empty, found: %#v", nodes)
@@ -407,8 +437,8 @@ func testStorageStoreRemoveNodes(storage cluster.Storage, t *testing.T) {
 func testStorageLockNodeHealing(storage cluster.Storage, t *testing.T) {
 	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(100))
 	node := cluster.Node{Address: "addr-xyz"}
-	defer storage.RemoveNode("addr-xyz")
-	err := storage.StoreNode(node)
+	defer storage.RemoveNode(context.Background(), "addr-xyz")
+	err := storage.StoreNode(context.Background(), node)
 	assertIsNil(err, t)
 	successCount := 0
 	wg := sync.WaitGroup{}
@@ -416,7 +446,7 @@ func testStorageLockNodeHealing(storage cluster.Storage, t *testing.T) {
 	for i := 0; i < 50; i++ {
 		go func() {
 			defer wg.Done()
-			locked, err := storage.LockNodeForHealing("addr-xyz", true, 5*time.Second)
+			locked, err := storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 5*time.Second)
 			assertIsNil(err, t)
 			if locked {
 				successCount++
@@ -427,7 +457,7 @@ func testStorageLockNodeHealing(storage cluster.Storage, t *testing.T) {
 	if successCount != 1 {
 		t.Fatalf("Expected success in only one goroutine, got: %d", successCount)
 	}
-	dbNode, err := storage.RetrieveNode("addr-xyz")
+	dbNode, err := storage.RetrieveNode(context.Background(), "addr-xyz")
 	assertIsNil(err, t)
 	if dbNode.Healing.LockedUntil.IsZero() {
 		t.Fatal("Expected node Healing.LockedUntil not to be zero")
@@ -436,9 +466,9 @@ func testStorageLockNodeHealing(storage cluster.Storage, t *testing.T) {
 		t.Fatal("Expected node healing.isFailure to be true")
 	}
 	dbNode.Healing = cluster.HealingData{}
-	err = storage.UpdateNode(dbNode)
+	err = storage.UpdateNode(context.Background(), dbNode)
 	assertIsNil(err, t)
-	dbNode, err = storage.RetrieveNode("addr-xyz")
+	dbNode, err = storage.RetrieveNode(context.Background(), "addr-xyz")
 	assertIsNil(err, t)
 	if !dbNode.Healing.LockedUntil.IsZero() {
 		t.Fatal("Expected node Healing.LockedUntil to be zero")
@@ -454,10 +484,10 @@ func testStorageStoreAlreadyLocked(storage cluster.Storage, t *testing.T) {
 		Metadata: map[string]string{},
 		Healing:  cluster.HealingData{LockedUntil: time.Now().UTC().Add(5 * time.Second), IsFailure: true},
 	}
-	defer storage.RemoveNode("my-addr-locked")
-	err := storage.StoreNode(node1)
+	defer storage.RemoveNode(context.Background(), "my-addr-locked")
+	err := storage.StoreNode(context.Background(), node1)
 	assertIsNil(err, t)
-	nd, err := storage.RetrieveNode("my-addr-locked")
+	nd, err := storage.RetrieveNode(context.Background(), "my-addr-locked")
 	assertIsNil(err, t)
 	duration := nd.Healing.LockedUntil.Sub(node1.Healing.LockedUntil)
 	if duration < 0 {
@@ -471,12 +501,12 @@ func testStorageStoreAlreadyLocked(storage cluster.Storage, t *testing.T) {
 func testStorageLockNodeHealingAfterTimeout(storage cluster.Storage, t *testing.T) {
 	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(100))
 	node := cluster.Node{Address: "addr-xyz"}
-	defer storage.RemoveNode("addr-xyz")
-	err := storage.StoreNode(node)
+	defer storage.RemoveNode(context.Background(), "addr-xyz")
+	err := storage.StoreNode(context.Background(), node)
 	assertIsNil(err, t)
-	locked, err := storage.LockNodeForHealing("addr-xyz", true, 200*time.Millisecond)
+	locked, err := storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 200*time.Millisecond)
 	assertIsNil(err, t)
-	locked, err = storage.LockNodeForHealing("addr-xyz", true, 200*time.Millisecond)
+	locked, err = storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 200*time.Millisecond)
 	assertIsNil(err, t)
 	if locked {
 		t.Fatal("Expected LockNodeForHealing to return false before timeout")
@@ -488,7 +518,7 @@ func testStorageLockNodeHealingAfterTimeout(storage cluster.Storage, t *testing.
 	for i := 0; i < 50; i++ {
 		go func() {
 			defer wg.Done()
-			locked, err := storage.LockNodeForHealing("addr-xyz", true, 5*time.Second)
+			locked, err := storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 5*time.Second)
 			assertIsNil(err, t)
 			if locked {
 				atomic.AddInt32(&successCount, 1)
@@ -503,15 +533,15 @@ func testStorageLockNodeHealingAfterTimeout(storage cluster.Storage, t *testing.
 
 func testStorageExtendNodeLock(storage cluster.Storage, t *testing.T) {
 	node := cluster.Node{Address: "addr-xyz"}
-	defer storage.RemoveNode("addr-xyz")
-	err := storage.StoreNode(node)
+	defer storage.RemoveNode(context.Background(), "addr-xyz")
+	err := storage.StoreNode(context.Background(), node)
 	assertIsNil(err, t)
-	locked, err := storage.LockNodeForHealing("addr-xyz", true, 200*time.Millisecond)
+	locked, err := storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 200*time.Millisecond)
 	assertIsNil(err, t)
 	time.Sleep(300 * time.Millisecond)
-	err = storage.ExtendNodeLock("addr-xyz", 200*time.Millisecond)
+	err = storage.ExtendNodeLock(context.Background(), "addr-xyz", 200*time.Millisecond)
 	assertIsNil(err, t)
-	locked, err = storage.LockNodeForHealing("addr-xyz", true, 200*time.Millisecond)
+	locked, err = storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 200*time.Millisecond)
 	assertIsNil(err, t)
 	if locked {
 		t.Fatal("Expected LockNodeForHealing to return false after extending timeout")
@@ -520,14 +550,14 @@ func testStorageExtendNodeLock(storage cluster.Storage, t *testing.T) {
 
 func testStorageUnlockNode(storage cluster.Storage, t *testing.T) {
 	node := cluster.Node{Address: "addr-xyz"}
-	defer storage.RemoveNode("addr-xyz")
-	err := storage.StoreNode(node)
+	defer storage.RemoveNode(context.Background(), "addr-xyz")
+	err := storage.StoreNode(context.Background(), node)
 	assertIsNil(err, t)
-	locked, err := storage.LockNodeForHealing("addr-xyz", true, 200*time.Millisecond)
+	locked, err := storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 200*time.Millisecond)
 	assertIsNil(err, t)
-	err = storage.UnlockNode("addr-xyz")
+	err = storage.UnlockNode(context.Background(), "addr-xyz")
 	assertIsNil(err, t)
-	locked, err = storage.LockNodeForHealing("addr-xyz", true, 200*time.Millisecond)
+	locked, err = storage.LockNodeForHealing(context.Background(), "addr-xyz", true, 200*time.Millisecond)
 	assertIsNil(err, t)
 	if !locked {
 		t.Fatal("Expected LockNodeForHealing to return true after unlocking")
@@ -535,16 +565,16 @@ func testStorageUnlockNode(storage cluster.Storage, t *testing.T) {
 }
 
 func testRetrieveContainers(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveContainer("container-1")
-	defer storage.RemoveContainer("container-2")
-	defer storage.RemoveContainer("container-3")
-	err := storage.StoreContainer("container-1", "host-1")
+	defer storage.RemoveContainer(context.Background(), "container-1")
+	defer storage.RemoveContainer(context.Background(), "container-2")
+	defer storage.RemoveContainer(context.Background(), "container-3")
+	err := storage.StoreContainer(context.Background(), "container-1", "host-1")
 	assertIsNil(err, t)
-	err = storage.StoreContainer("container-2", "host-1")
+	err = storage.StoreContainer(context.Background(), "container-2", "host-1")
 	assertIsNil(err, t)
-	err = storage.StoreContainer("container-3", "host-2")
+	err = storage.StoreContainer(context.Background(), "container-3", "host-2")
 	assertIsNil(err, t)
-	containers, err := storage.RetrieveContainers()
+	containers, err := storage.RetrieveContainers(context.Background())
 	assertIsNil(err, t)
 	if len(containers) != 3 {
 		t.Errorf("Unexpected len %d - expected %d", len(containers), 3)
@@ -552,14 +582,14 @@ func testRetrieveContainers(storage cluster.Storage, t *testing.T) {
 }
 
 func testRetrieveImages(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveImage("img-1", "id1", "host-1.something")
-	defer storage.RemoveImage("img-1", "id1", "host-2")
-	defer storage.RemoveImage("img-1", "id2", "host-2")
-	err := storage.StoreImage("img-1", "id1", "host-1.something")
+	defer storage.RemoveImage(context.Background(), "img-1", "id1", "host-1.something")
+	defer storage.RemoveImage(context.Background(), "img-1", "id1", "host-2")
+	defer storage.RemoveImage(context.Background(), "img-1", "id2", "host-2")
+	err := storage.StoreImage(context.Background(), "img-1", "id1", "host-1.something")
 	assertIsNil(err, t)
-	err = storage.StoreImage("img-1", "id1", "host-2")
+	err = storage.StoreImage(context.Background(), "img-1", "id1", "host-2")
 	assertIsNil(err, t)
-	imgs, err := storage.RetrieveImages()
+	imgs, err := storage.RetrieveImages(context.Background())
 	assertIsNil(err, t)
 	if len(imgs) != 3 {
 		t.Errorf("Unexpected len %d - expected %d", len(imgs), 3)
@@ -567,25 +597,25 @@ func testRetrieveImages(storage cluster.Storage, t *testing.T) {
 }
 
 func testStoreRetrieveExec(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveContainer("cont1")
-	defer storage.RemoveContainer("cont2")
-	err := storage.StoreExec("exec1", "cont1")
+	defer storage.RemoveContainer(context.Background(), "cont1")
+	defer storage.RemoveContainer(context.Background(), "cont2")
+	err := storage.StoreExec(context.Background(), "exec1", "cont1")
 	assertIsNil(err, t)
-	err = storage.StoreExec("exec2", "cont1")
+	err = storage.StoreExec(context.Background(), "exec2", "cont1")
 	assertIsNil(err, t)
-	err = storage.StoreExec("exec3", "cont2")
+	err = storage.StoreExec(context.Background(), "exec3", "cont2")
 	assertIsNil(err, t)
-	containerID, err := storage.RetrieveExec("exec1")
+	containerID, err := storage.RetrieveExec(context.Background(), "exec1")
 	assertIsNil(err, t)
 	if containerID != "cont1" {
 		t.Errorf("expected cont1, got %v", containerID)
 	}
-	containerID, err = storage.RetrieveExec("exec2")
+	containerID, err = storage.RetrieveExec(context.Background(), "exec2")
 	assertIsNil(err, t)
 	if containerID != "cont1" {
 		t.Errorf("expected cont1, got %v", containerID)
 	}
-	containerID, err = storage.RetrieveExec("exec3")
+	containerID, err = storage.RetrieveExec(context.Background(), "exec3")
 	assertIsNil(err, t)
 	if containerID != "cont2" {
 		t.Errorf("expected cont2, got %v", containerID)
@@ -593,35 +623,191 @@ func testStoreRetrieveExec(storage cluster.Storage, t *testing.T) {
 }
 
 func testExecDeleteOnContainer(storage cluster.Storage, t *testing.T) {
-	defer storage.RemoveContainer("cont1")
-	defer storage.RemoveContainer("cont2")
-	err := storage.StoreExec("exec1", "cont1")
+	defer storage.RemoveContainer(context.Background(), "cont1")
+	defer storage.RemoveContainer(context.Background(), "cont2")
+	err := storage.StoreExec(context.Background(), "exec1", "cont1")
 	assertIsNil(err, t)
-	err = storage.StoreExec("exec2", "cont1")
+	err = storage.StoreExec(context.Background(), "exec2", "cont1")
 	assertIsNil(err, t)
-	err = storage.StoreExec("exec3", "cont2")
+	err = storage.StoreExec(context.Background(), "exec3", "cont2")
 	assertIsNil(err, t)
-	err = storage.StoreContainer("cont1", "h1")
+	err = storage.StoreContainer(context.Background(), "cont1", "h1")
 	assertIsNil(err, t)
-	err = storage.StoreContainer("cont2", "h1")
+	err = storage.StoreContainer(context.Background(), "cont2", "h1")
 	assertIsNil(err, t)
-	err = storage.RemoveContainer("cont1")
+	err = storage.RemoveContainer(context.Background(), "cont1")
 	assertIsNil(err, t)
-	_, err = storage.RetrieveExec("exec1")
+	_, err = storage.RetrieveExec(context.Background(), "exec1")
 	if err != cstorage.ErrNoSuchExec {
 		t.Fatalf("expected no such exec, got: %v", err)
 	}
-	_, err = storage.RetrieveExec("exec2")
+	_, err = storage.RetrieveExec(context.Background(), "exec2")
 	if err != cstorage.ErrNoSuchExec {
 		t.Fatalf("expected no such exec, got: %v", err)
 	}
-	containerID, err := storage.RetrieveExec("exec3")
+	containerID, err := storage.RetrieveExec(context.Background(), "exec3")
 	assertIsNil(err, t)
 	if containerID != "cont2" {
 		t.Errorf("expected cont2, got %v", containerID)
 	}
 }
 
+func testRetrieveExecs(storage cluster.Storage, t *testing.T) {
+	defer storage.RemoveContainer(context.Background(), "cont-execs-1")
+	defer storage.RemoveContainer(context.Background(), "cont-execs-2")
+	err := storage.StoreContainer(context.Background(), "cont-execs-1", "h1")
+	assertIsNil(err, t)
+	err = storage.StoreContainer(context.Background(), "cont-execs-2", "h1")
+	assertIsNil(err, t)
+	err = storage.StoreExec(context.Background(), "exec-list-1", "cont-execs-1")
+	assertIsNil(err, t)
+	err = storage.StoreExec(context.Background(), "exec-list-2", "cont-execs-1")
+	assertIsNil(err, t)
+	err = storage.StoreExec(context.Background(), "exec-list-3", "cont-execs-2")
+	assertIsNil(err, t)
+	execs, err := storage.RetrieveExecs(context.Background())
+	assertIsNil(err, t)
+	var found []cluster.Exec
+	for _, e := range execs {
+		if e.Container == "cont-execs-1" || e.Container == "cont-execs-2" {
+			found = append(found, e)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Id < found[j].Id })
+	expected := []cluster.Exec{
+		{Id: "exec-list-1", Container: "cont-execs-1"},
+		{Id: "exec-list-2", Container: "cont-execs-1"},
+		{Id: "exec-list-3", Container: "cont-execs-2"},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("RetrieveExecs: expected %#v, got %#v", expected, found)
+	}
+}
+
+func testStorageStoreRetrieveNetwork(storage cluster.Storage, t *testing.T) {
+	defer storage.RemoveNetwork(context.Background(), "net-1")
+	network := cluster.Network{
+		Name:    "net-1",
+		Driver:  "bridge",
+		Options: map[string]interface{}{"com.docker.network.bridge.name": "br-net1"},
+		Labels:  map[string]string{"app.name": "myapp"},
+		IPAM: &docker.IPAMOptions{
+			Config: []docker.IPAMConfig{{Subnet: "10.10.0.0/16"}},
+		},
+	}
+	err := storage.StoreNetwork(context.Background(), network)
+	assertIsNil(err, t)
+	err = storage.StoreNetwork(context.Background(), network)
+	if err != cstorage.ErrDuplicatedNetwork {
+		t.Errorf("Error should be cstorage.ErrDuplicatedNetwork, received: %s", err)
+	}
+	dbNetwork, err := storage.RetrieveNetwork(context.Background(), "net-1")
+	assertIsNil(err, t)
+	if !reflect.DeepEqual(dbNetwork, network) {
+		t.Errorf("Unexpected network %#v - expected %#v", dbNetwork, network)
+	}
+	networks, err := storage.RetrieveNetworks(context.Background())
+	assertIsNil(err, t)
+	if len(networks) != 1 || !reflect.DeepEqual(networks[0], network) {
+		t.Errorf("Unexpected networks %#v - expected %#v", networks, []cluster.Network{network})
+	}
+	_, err = storage.RetrieveNetwork(context.Background(), "net-2")
+	if err != cstorage.ErrNoSuchNetwork {
+		t.Errorf("Error should be cstorage.ErrNoSuchNetwork, received: %s", err)
+	}
+}
+
+func testStorageNetworkNodes(storage cluster.Storage, t *testing.T) {
+	defer storage.RemoveNetwork(context.Background(), "net-1")
+	err := storage.StoreNetwork(context.Background(), cluster.Network{Name: "net-1"})
+	assertIsNil(err, t)
+	err = storage.AddNetworkNode(context.Background(), "net-1", "id1", "host-1.something")
+	assertIsNil(err, t)
+	err = storage.AddNetworkNode(context.Background(), "net-1", "id2", "host-2")
+	assertIsNil(err, t)
+	err = storage.AddNetworkNode(context.Background(), "net-1", "id3", "host-2")
+	assertIsNil(err, t)
+	network, err := storage.RetrieveNetwork(context.Background(), "net-1")
+	assertIsNil(err, t)
+	expected := []cluster.NetworkNode{{Node: "host-1.something", ID: "id1"}, {Node: "host-2", ID: "id3"}}
+	if !reflect.DeepEqual(network.Nodes, expected) {
+		t.Errorf("Unexpected network nodes %#v - expected %#v", network.Nodes, expected)
+	}
+	err = storage.RemoveNetworkNode(context.Background(), "net-1", "host-1.something")
+	assertIsNil(err, t)
+	network, err = storage.RetrieveNetwork(context.Background(), "net-1")
+	assertIsNil(err, t)
+	expected = []cluster.NetworkNode{{Node: "host-2", ID: "id3"}}
+	if !reflect.DeepEqual(network.Nodes, expected) {
+		t.Errorf("Unexpected network nodes %#v - expected %#v", network.Nodes, expected)
+	}
+	err = storage.AddNetworkNode(context.Background(), "net-2", "id1", "host-1")
+	if err != cstorage.ErrNoSuchNetwork {
+		t.Errorf("Error should be cstorage.ErrNoSuchNetwork, received: %s", err)
+	}
+}
+
+func testStorageRemoveNetwork(storage cluster.Storage, t *testing.T) {
+	err := storage.StoreNetwork(context.Background(), cluster.Network{Name: "net-1"})
+	assertIsNil(err, t)
+	err = storage.RemoveNetwork(context.Background(), "net-1")
+	assertIsNil(err, t)
+	_, err = storage.RetrieveNetwork(context.Background(), "net-1")
+	if err != cstorage.ErrNoSuchNetwork {
+		t.Errorf("Error should be cstorage.ErrNoSuchNetwork, received: %s", err)
+	}
+	err = storage.RemoveNetwork(context.Background(), "net-1")
+	if err != cstorage.ErrNoSuchNetwork {
+		t.Errorf("Error should be cstorage.ErrNoSuchNetwork, received: %s", err)
+	}
+}
+
+func testStorageStoreRetrieveVolume(storage cluster.Storage, t *testing.T) {
+	defer storage.RemoveVolume(context.Background(), "vol-1")
+	volume := cluster.Volume{
+		Name:    "vol-1",
+		Node:    "host-1.something",
+		Driver:  "local",
+		Labels:  map[string]string{"app.name": "myapp"},
+		Options: map[string]string{"o": "size=100m"},
+	}
+	err := storage.StoreVolume(context.Background(), volume)
+	assertIsNil(err, t)
+	err = storage.StoreVolume(context.Background(), volume)
+	if err != cstorage.ErrDuplicatedVolume {
+		t.Errorf("Error should be cstorage.ErrDuplicatedVolume, received: %s", err)
+	}
+	dbVolume, err := storage.RetrieveVolume(context.Background(), "vol-1")
+	assertIsNil(err, t)
+	if !reflect.DeepEqual(dbVolume, volume) {
+		t.Errorf("Unexpected volume %#v - expected %#v", dbVolume, volume)
+	}
+	volumes, err := storage.RetrieveVolumes(context.Background())
+	assertIsNil(err, t)
+	if len(volumes) != 1 || !reflect.DeepEqual(volumes[0], volume) {
+		t.Errorf("Unexpected volumes %#v - expected %#v", volumes, []cluster.Volume{volume})
+	}
+	_, err = storage.RetrieveVolume(context.Background(), "vol-2")
+	if err != cstorage.ErrNoSuchVolume {
+		t.Errorf("Error should be cstorage.ErrNoSuchVolume, received: %s", err)
+	}
+}
+
+func testStorageRemoveVolume(storage cluster.Storage, t *testing.T) {
+	err := storage.StoreVolume(context.Background(), cluster.Volume{Name: "vol-1", Node: "host-1"})
+	assertIsNil(err, t)
+	err = storage.RemoveVolume(context.Background(), "vol-1")
+	assertIsNil(err, t)
+	_, err = storage.RetrieveVolume(context.Background(), "vol-1")
+	if err != cstorage.ErrNoSuchVolume {
+		t.Errorf("Error should be cstorage.ErrNoSuchVolume, received: %s", err)
+	}
+	err = storage.RemoveVolume(context.Background(), "vol-1")
+	if err != cstorage.ErrNoSuchVolume {
+		t.Errorf("Error should be cstorage.ErrNoSuchVolume, received: %s", err)
+	}
+}
+
 func RunTestsForStorage(storage cluster.Storage, t *testing.T) {
 	testStorageStoreRetrieveContainer(storage, t)
 	testRetrieveContainers(storage, t)
@@ -629,6 +815,7 @@ func RunTestsForStorage(storage cluster.Storage, t *testing.T) {
 	testStorageStoreRetrieveImage(storage, t)
 	testStorageSetImageDigest(storage, t)
 	testStorageStoreImageIgnoreDups(storage, t)
+	testStorageImageHistoryOrder(storage, t)
 	testStorageStoreRemoveImage(storage, t)
 	testStorageStoreRetrieveNodes(storage, t)
 	testStorageStoreRepeatedNodes(storage, t)
@@ -647,4 +834,10 @@ func RunTestsForStorage(storage cluster.Storage, t *testing.T) {
 	testRetrieveImages(storage, t)
 	testStoreRetrieveExec(storage, t)
 	testExecDeleteOnContainer(storage, t)
+	testRetrieveExecs(storage, t)
+	testStorageStoreRetrieveNetwork(storage, t)
+	testStorageNetworkNodes(storage, t)
+	testStorageRemoveNetwork(storage, t)
+	testStorageStoreRetrieveVolume(storage, t)
+	testStorageRemoveVolume(storage, t)
 }